@@ -0,0 +1,219 @@
+// Package admin provides an http.Handler to inspect and control the
+// breakers stored in a breaker.Map at runtime.
+//
+// The handler is expected to be mounted under a prefix of your choice,
+// using http.StripPrefix:
+//
+//	mux.Handle("/breakers/", http.StripPrefix("/breakers", admin.NewHandler(m)))
+//
+// The following requests are handled:
+//
+//	GET  /                 lists all breakers
+//	GET  /{name}           shows a single breaker
+//	GET  /{name}/requests  lists the last requests sent through a
+//	                       breaker, if a recorder was given with
+//	                       WithRecorder
+//	POST /{name}/{action}  performs an action on a breaker, where action
+//	                       is one of break, disable, enable, reset,
+//	                       reset_counters or trip
+//	POST /{name}/reconfigure
+//	                       changes the settings of a breaker, given as
+//	                       a JSON encoded config.BreakerConfig in the
+//	                       request body
+//
+// Responses are encoded in JSON.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/config"
+	"github.com/pkg/errors"
+)
+
+// NewHandler creates a new Handler for the breakers in m
+//
+// Possible optional parameters:
+// * WithRecorder: specify the recorder of the requests sent through the breakers
+func NewHandler(m breaker.Map, options ...Option) *Handler {
+	var cfg handlerConfig
+	for _, option := range options {
+		option.apply(&cfg)
+	}
+
+	return &Handler{
+		breakers: m,
+		recorder: cfg.recorder,
+	}
+}
+
+// NewStatus creates a Status object from the current values in
+// the given breaker
+func NewStatus(cb breaker.Breaker) Status {
+	s := cb.Snapshot()
+	st := Status{
+		Name:           cb.Name(),
+		State:          s.State.String(),
+		Labels:         cb.Labels(),
+		Tripped:        s.State != breaker.Closed,
+		Disabled:       cb.Disabled(),
+		WouldTrip:      cb.WouldTrip(),
+		Timeout:        cb.DefaultTimeout().Seconds(),
+		Failures:       s.Failures,
+		Successes:      s.Successes,
+		ConsecFailures: s.ConsecFailures,
+		InFlight:       cb.InFlight(),
+		FailureClasses: cb.FailuresByClass(),
+		ErrorRate:      s.ErrorRate,
+		Trips:          s.Trips,
+		OpenTime:       s.OpenTime.Seconds(),
+		LongestOpen:    s.LongestOpen.Seconds(),
+	}
+	if !s.LastTrip.IsZero() {
+		st.LastTrip = &s.LastTrip
+	}
+	if !s.LastFailure.IsZero() {
+		st.LastFailure = &s.LastFailure
+	}
+	if !s.NextRetry.IsZero() {
+		st.NextRetry = &s.NextRetry
+	}
+	for _, t := range cb.Transitions() {
+		tr := Transition{
+			From: t.From.String(),
+			To:   t.To.String(),
+			Time: t.Time,
+		}
+		if t.Err != nil {
+			tr.Error = t.Err.Error()
+		}
+		st.Transitions = append(st.Transitions, tr)
+	}
+	return st
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if path == "" {
+			h.list(w)
+			return
+		}
+		if name := strings.TrimSuffix(path, "/requests"); name != path && h.recorder != nil {
+			if _, ok := h.breakers.Get(path); !ok {
+				h.requests(w, name)
+				return
+			}
+		}
+		h.show(w, path)
+	case http.MethodPost:
+		i := strings.LastIndexByte(path, '/')
+		if i <= 0 {
+			http.Error(w, "action not specified", http.StatusNotFound)
+			return
+		}
+		h.perform(w, r, path[:i], path[i+1:])
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) list(w http.ResponseWriter) {
+	list := make(map[string]Status)
+	h.breakers.Range(func(name string, cb breaker.Breaker) bool {
+		list[name] = NewStatus(cb)
+		return true
+	})
+	writeJSON(w, list)
+}
+
+func (h *Handler) show(w http.ResponseWriter, name string) {
+	cb, ok := h.breakers.Get(name)
+	if !ok {
+		http.Error(w, "breaker not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, NewStatus(cb))
+}
+
+func (h *Handler) requests(w http.ResponseWriter, name string) {
+	if _, ok := h.breakers.Get(name); !ok {
+		http.Error(w, "breaker not found", http.StatusNotFound)
+		return
+	}
+
+	list := []Request{}
+	for _, rec := range h.recorder.Requests(name) {
+		r := Request{
+			Time:       rec.Time,
+			Method:     rec.Method,
+			URL:        rec.URL,
+			StatusCode: rec.StatusCode,
+			Latency:    rec.Latency.Seconds(),
+			Decision:   rec.Decision.String(),
+		}
+		if rec.Err != nil {
+			r.Error = rec.Err.Error()
+		}
+		list = append(list, r)
+	}
+	writeJSON(w, list)
+}
+
+func (h *Handler) perform(w http.ResponseWriter, r *http.Request, name, action string) {
+	cb, ok := h.breakers.Get(name)
+	if !ok {
+		http.Error(w, "breaker not found", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case ActionBreak:
+		cb.Break()
+	case ActionDisable:
+		cb.Disable()
+	case ActionEnable:
+		cb.Enable()
+	case ActionReset:
+		cb.Reset()
+	case ActionResetCounters:
+		cb.ResetCounters()
+	case ActionTrip:
+		cb.Trip()
+	case ActionReconfigure:
+		if err := reconfigure(cb, r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "unknown action", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, NewStatus(cb))
+}
+
+// reconfigure changes the settings of the breaker according to the
+// configuration in the request body
+func reconfigure(cb breaker.Breaker, r *http.Request) error {
+	var bc config.BreakerConfig
+	if err := json.NewDecoder(r.Body).Decode(&bc); err != nil {
+		return errors.Wrap(err, "failed to decode configuration")
+	}
+
+	options, err := bc.Options()
+	if err != nil {
+		return err
+	}
+	return cb.Reconfigure(options...)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
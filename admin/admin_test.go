@@ -0,0 +1,187 @@
+package admin_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/admin"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	httpb "github.com/lestrrat/go-circuit-breaker/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	m := breaker.NewMap()
+	m.Set("example.com", breaker.New(breaker.WithEventHistory(10), breaker.WithTimeout(3*time.Second)))
+	m.Set("example.org", breaker.New(breaker.WithLabels(map[string]string{"service": "web"})))
+
+	srv := httptest.NewServer(admin.NewHandler(m))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/")
+	if !assert.NoError(t, err, "GET / should succeed") {
+		return
+	}
+	var list map[string]admin.Status
+	err = json.NewDecoder(res.Body).Decode(&list)
+	res.Body.Close()
+	if !assert.NoError(t, err, "decoding the list should succeed") {
+		return
+	}
+	if !assert.Len(t, list, 2, "all breakers should be listed") {
+		return
+	}
+	if !assert.Equal(t, 3.0, list["example.com"].Timeout, "the timeout should be reported") {
+		return
+	}
+	if !assert.Equal(t, map[string]string{"service": "web"}, list["example.org"].Labels, "the labels should be reported") {
+		return
+	}
+
+	res, err = http.Post(srv.URL+"/example.com/trip", "", nil)
+	if !assert.NoError(t, err, "POST /example.com/trip should succeed") {
+		return
+	}
+	var st admin.Status
+	err = json.NewDecoder(res.Body).Decode(&st)
+	res.Body.Close()
+	if !assert.NoError(t, err, "decoding the status should succeed") {
+		return
+	}
+	if !assert.True(t, st.Tripped, "breaker should be tripped") {
+		return
+	}
+	if !assert.Len(t, st.Transitions, 1, "the trip should be listed") {
+		return
+	}
+	if !assert.Equal(t, breaker.Open.String(), st.Transitions[0].To, "breaker should have transitioned to open") {
+		return
+	}
+	if cb, _ := m.Get("example.com"); !assert.True(t, cb.Tripped(), "breaker should be tripped") {
+		return
+	}
+
+	res, err = http.Post(srv.URL+"/example.com/reset", "", nil)
+	if !assert.NoError(t, err, "POST /example.com/reset should succeed") {
+		return
+	}
+	res.Body.Close()
+	if cb, _ := m.Get("example.com"); !assert.False(t, cb.Tripped(), "breaker should be reset") {
+		return
+	}
+
+	res, err = http.Post(srv.URL+"/example.org/reconfigure", "application/json", strings.NewReader(`{"tripper": {"type": "threshold", "threshold": 1}}`))
+	if !assert.NoError(t, err, "POST /example.org/reconfigure should succeed") {
+		return
+	}
+	res.Body.Close()
+	if !assert.Equal(t, http.StatusOK, res.StatusCode, "reconfiguration should be accepted") {
+		return
+	}
+	cb, _ := m.Get("example.org")
+	cb.Call(breaker.CircuitFunc(func() error { return errors.New("error") }))
+	if !assert.True(t, cb.Tripped(), "breaker should use the new tripper") {
+		return
+	}
+
+	res, err = http.Post(srv.URL+"/example.org/disable", "", nil)
+	if !assert.NoError(t, err, "POST /example.org/disable should succeed") {
+		return
+	}
+	err = json.NewDecoder(res.Body).Decode(&st)
+	res.Body.Close()
+	if !assert.NoError(t, err, "decoding the status should succeed") {
+		return
+	}
+	if !assert.True(t, st.Disabled, "breaker should be disabled") {
+		return
+	}
+	if !assert.NoError(t, cb.Call(breaker.CircuitFunc(func() error { return nil })), "disabled breaker should let calls through") {
+		return
+	}
+
+	for body, code := range map[string]int{
+		`{"window_size": 10}`:            http.StatusBadRequest,
+		`{"tripper": {"type": "magic"}}`: http.StatusBadRequest,
+		`not json`:                       http.StatusBadRequest,
+	} {
+		res, err := http.Post(srv.URL+"/example.org/reconfigure", "application/json", strings.NewReader(body))
+		if !assert.NoError(t, err, "POST /example.org/reconfigure should succeed") {
+			return
+		}
+		res.Body.Close()
+		if !assert.Equal(t, code, res.StatusCode, "reconfiguration with %s should be rejected", body) {
+			return
+		}
+	}
+
+	for path, code := range map[string]int{
+		"/example.net/trip":  http.StatusNotFound,
+		"/example.com/bogus": http.StatusBadRequest,
+		"/example.com":       http.StatusNotFound,
+	} {
+		res, err := http.Post(srv.URL+path, "", nil)
+		if !assert.NoError(t, err, "POST %s should succeed", path) {
+			return
+		}
+		res.Body.Close()
+		if !assert.Equal(t, code, res.StatusCode, "POST %s should fail", path) {
+			return
+		}
+	}
+}
+
+func TestRequests(t *testing.T) {
+	m := breaker.NewMap()
+	cb := breaker.New()
+	m.Set("example.com", cb)
+	rec := httpb.NewRecorder(10)
+	rec.Record("example.com", httpb.RequestRecord{
+		Method:     http.MethodGet,
+		URL:        "http://example.com/foo",
+		StatusCode: http.StatusInternalServerError,
+		Err:        errors.New("received bad status 500"),
+		Latency:    time.Second,
+	})
+
+	srv := httptest.NewServer(admin.NewHandler(m, admin.WithRecorder(rec)))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/example.com/requests")
+	if !assert.NoError(t, err, "GET /example.com/requests should succeed") {
+		return
+	}
+	var list []admin.Request
+	err = json.NewDecoder(res.Body).Decode(&list)
+	res.Body.Close()
+	if !assert.NoError(t, err, "decoding the requests should succeed") {
+		return
+	}
+	if !assert.Len(t, list, 1, "recorded requests should be listed") {
+		return
+	}
+	if !assert.Equal(t, admin.Request{
+		Method:     http.MethodGet,
+		URL:        "http://example.com/foo",
+		StatusCode: http.StatusInternalServerError,
+		Error:      "received bad status 500",
+		Latency:    1,
+		Decision:   "allowed",
+	}, list[0], "request should be reported") {
+		return
+	}
+
+	res, err = http.Get(srv.URL + "/example.net/requests")
+	if !assert.NoError(t, err, "GET /example.net/requests should succeed") {
+		return
+	}
+	res.Body.Close()
+	if !assert.Equal(t, http.StatusNotFound, res.StatusCode, "unknown breakers should not be found") {
+		return
+	}
+}
@@ -0,0 +1,81 @@
+package admin
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	httpb "github.com/lestrrat/go-circuit-breaker/http"
+)
+
+// Actions that can be performed on a breaker via POST requests
+const (
+	ActionBreak         = "break"
+	ActionDisable       = "disable"
+	ActionEnable        = "enable"
+	ActionReconfigure   = "reconfigure"
+	ActionReset         = "reset"
+	ActionResetCounters = "reset_counters"
+	ActionTrip          = "trip"
+)
+
+// Handler is an http.Handler that exposes the breakers in a
+// breaker.Map, and allows operators to change their state
+type Handler struct {
+	breakers breaker.Map
+	recorder *httpb.Recorder
+}
+
+// Option is an option that can be passed to NewHandler
+type Option interface {
+	apply(*handlerConfig)
+}
+
+type optionFunc func(*handlerConfig)
+
+// handlerConfig holds the values given by the options
+type handlerConfig struct {
+	recorder *httpb.Recorder
+}
+
+// Status is the JSON representation of a breaker
+type Status struct {
+	Name           string            `json:"name,omitempty"`
+	State          string            `json:"state"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Tripped        bool              `json:"tripped"`
+	Disabled       bool              `json:"disabled"`
+	WouldTrip      bool              `json:"would_trip"`
+	Timeout        float64           `json:"timeout_seconds,omitempty"`
+	Failures       int64             `json:"failures"`
+	Successes      int64             `json:"successes"`
+	ConsecFailures int64             `json:"consecutive_failures"`
+	InFlight       int64             `json:"in_flight"`
+	FailureClasses map[string]int64  `json:"failures_by_class,omitempty"`
+	ErrorRate      float64           `json:"error_rate"`
+	LastFailure    *time.Time        `json:"last_failure,omitempty"`
+	NextRetry      *time.Time        `json:"next_retry,omitempty"`
+	Trips          int64             `json:"trips"`
+	LastTrip       *time.Time        `json:"last_trip,omitempty"`
+	OpenTime       float64           `json:"open_time_seconds"`
+	LongestOpen    float64           `json:"longest_open_seconds"`
+	Transitions    []Transition      `json:"transitions,omitempty"`
+}
+
+// Transition is the JSON representation of a breaker.Transition
+type Transition struct {
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Time  time.Time `json:"time"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Request is the JSON representation of an http.RequestRecord
+type Request struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	StatusCode int       `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Latency    float64   `json:"latency_seconds"`
+	Decision   string    `json:"decision"`
+}
@@ -0,0 +1,18 @@
+package admin
+
+import (
+	httpb "github.com/lestrrat/go-circuit-breaker/http"
+)
+
+func (f optionFunc) apply(c *handlerConfig) {
+	f(c)
+}
+
+// WithRecorder specifies the recorder given to the http Clients and
+// Transports using the breakers, so that the last requests of each
+// breaker can be listed
+func WithRecorder(r *httpb.Recorder) Option {
+	return optionFunc(func(c *handlerConfig) {
+		c.recorder = r
+	})
+}
@@ -0,0 +1,177 @@
+// Package backoff provides the retry policies used by breakers to
+// decide when an open breaker lets the next probe through, and by
+// breaker.CallWithRetry to space out retries.
+package backoff
+
+import (
+	"math/rand"
+	"time"
+)
+
+// NewExponentialBackOff creates an ExponentialBackOff with the default
+// settings
+func NewExponentialBackOff() *ExponentialBackOff {
+	b := &ExponentialBackOff{
+		InitialInterval:     DefaultInitialInterval,
+		RandomizationFactor: DefaultRandomizationFactor,
+		Multiplier:          DefaultMultiplier,
+		MaxInterval:         DefaultMaxInterval,
+		MaxElapsedTime:      DefaultMaxElapsedTime,
+		Clock:               SystemClock,
+	}
+	b.Reset()
+	return b
+}
+
+// NewConstantBackOff creates a ConstantBackOff with the given interval
+func NewConstantBackOff(d time.Duration) *ConstantBackOff {
+	return &ConstantBackOff{Interval: d}
+}
+
+// NewFibonacciBackOff creates a FibonacciBackOff that starts at initial
+// and never exceeds max, unless max is 0
+func NewFibonacciBackOff(initial, max time.Duration) *FibonacciBackOff {
+	b := &FibonacciBackOff{
+		InitialInterval: initial,
+		MaxInterval:     max,
+	}
+	b.Reset()
+	return b
+}
+
+// WithMaxRetries wraps the policy so that it returns Stop once
+// NextBackOff has been called max times since the last Reset. The
+// number of retries is not limited if max is 0.
+func WithMaxRetries(b BackOff, max uint64) BackOff {
+	return &maxRetries{policy: b, max: max}
+}
+
+// Permanent wraps err in a *PermanentError, so that it is not retried
+func Permanent(err error) *PermanentError {
+	return &PermanentError{Err: err}
+}
+
+func (c systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Reset restarts the intervals at InitialInterval, and the elapsed time
+// at zero
+func (b *ExponentialBackOff) Reset() {
+	b.currentInterval = b.InitialInterval
+	b.startTime = b.clock().Now()
+}
+
+// NextBackOff returns the next randomized interval, or Stop if
+// MaxElapsedTime has passed since the last Reset
+func (b *ExponentialBackOff) NextBackOff() time.Duration {
+	if b.MaxElapsedTime != 0 && b.GetElapsedTime() > b.MaxElapsedTime {
+		return Stop
+	}
+
+	next := randomize(b.currentInterval, b.RandomizationFactor)
+
+	// Grow the interval without overflowing
+	if float64(b.currentInterval) >= float64(b.MaxInterval)/b.Multiplier {
+		b.currentInterval = b.MaxInterval
+	} else {
+		b.currentInterval = time.Duration(float64(b.currentInterval) * b.Multiplier)
+	}
+	return next
+}
+
+// GetElapsedTime returns the time elapsed since the last Reset
+func (b *ExponentialBackOff) GetElapsedTime() time.Duration {
+	return b.clock().Now().Sub(b.startTime)
+}
+
+func (b *ExponentialBackOff) clock() Clock {
+	if b.Clock == nil {
+		return SystemClock
+	}
+	return b.Clock
+}
+
+// randomize returns a random duration within factor of d
+func randomize(d time.Duration, factor float64) time.Duration {
+	if factor == 0 {
+		return d
+	}
+	delta := factor * float64(d)
+	min := float64(d) - delta
+	max := float64(d) + delta
+	return time.Duration(min + rand.Float64()*(max-min+1))
+}
+
+// Reset does nothing, as the policy has no state
+func (b *ConstantBackOff) Reset() {}
+
+// NextBackOff returns Interval
+func (b *ConstantBackOff) NextBackOff() time.Duration {
+	return b.Interval
+}
+
+// Reset restarts the sequence at InitialInterval
+func (b *FibonacciBackOff) Reset() {
+	b.current = b.InitialInterval
+	b.next = b.InitialInterval
+}
+
+// NextBackOff returns the next interval of the sequence
+func (b *FibonacciBackOff) NextBackOff() time.Duration {
+	d := b.current
+	if b.MaxInterval > 0 && d >= b.MaxInterval {
+		return b.MaxInterval
+	}
+	b.current, b.next = b.next, b.current+b.next
+	if b.next < b.current {
+		// Stay at the largest interval instead of overflowing
+		b.next = b.current
+	}
+	return d
+}
+
+func (b *maxRetries) Reset() {
+	b.retries = 0
+	b.policy.Reset()
+}
+
+func (b *maxRetries) NextBackOff() time.Duration {
+	if b.max > 0 {
+		if b.retries >= b.max {
+			return Stop
+		}
+		b.retries++
+	}
+	return b.policy.NextBackOff()
+}
+
+// Reset does nothing
+func (b *StopBackOff) Reset() {}
+
+// NextBackOff always returns Stop
+func (b *StopBackOff) NextBackOff() time.Duration {
+	return Stop
+}
+
+// Reset does nothing
+func (b *ZeroBackOff) Reset() {}
+
+// NextBackOff always returns 0
+func (b *ZeroBackOff) NextBackOff() time.Duration {
+	return 0
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the wrapped error
+func (e *PermanentError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the wrapped error
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
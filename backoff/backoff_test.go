@@ -0,0 +1,80 @@
+package backoff_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+)
+
+func TestExponentialBackOff(t *testing.T) {
+	c := clock.NewMock()
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = time.Second
+	bo.RandomizationFactor = 0
+	bo.Multiplier = 2
+	bo.MaxInterval = 5 * time.Second
+	bo.MaxElapsedTime = time.Minute
+	bo.Clock = c
+	bo.Reset()
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for _, d := range expected {
+		if !assert.Equal(t, d, bo.NextBackOff(), "intervals should grow up to MaxInterval") {
+			return
+		}
+	}
+
+	c.Add(2 * time.Minute)
+	if !assert.Equal(t, backoff.Stop, bo.NextBackOff(), "policy should stop after MaxElapsedTime") {
+		return
+	}
+
+	bo.Reset()
+	if !assert.Equal(t, time.Second, bo.NextBackOff(), "Reset should start over") {
+		return
+	}
+}
+
+func TestExponentialBackOffRandomization(t *testing.T) {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = time.Second
+	bo.RandomizationFactor = 0.5
+	bo.Reset()
+
+	for i := 0; i < 100; i++ {
+		bo.Reset()
+		d := bo.NextBackOff()
+		if !assert.True(t, d >= 500*time.Millisecond && d <= 1500*time.Millisecond, "interval should be within the randomization factor (got %s)", d) {
+			return
+		}
+	}
+}
+
+func TestFibonacciBackOff(t *testing.T) {
+	bo := backoff.NewFibonacciBackOff(time.Second, 6*time.Second)
+
+	expected := []time.Duration{1, 1, 2, 3, 5, 6, 6}
+	for _, d := range expected {
+		if !assert.Equal(t, d*time.Second, bo.NextBackOff(), "intervals should follow the Fibonacci sequence up to MaxInterval") {
+			return
+		}
+	}
+
+	bo.Reset()
+	if !assert.Equal(t, time.Second, bo.NextBackOff(), "Reset should start over") {
+		return
+	}
+}
+
+func TestConstantBackOff(t *testing.T) {
+	bo := backoff.NewConstantBackOff(time.Second)
+	for i := 0; i < 3; i++ {
+		if !assert.Equal(t, time.Second, bo.NextBackOff(), "interval should not change") {
+			return
+		}
+	}
+}
@@ -0,0 +1,36 @@
+// Package cenkbackoff adapts github.com/cenk/backoff, which breakers
+// used to depend on, to the backoff package.
+//
+// Policies from github.com/cenk/backoff already satisfy backoff.BackOff
+// and can be passed to breaker.WithBackOff as they are. This package
+// converts the errors returned by circuits written for that library.
+package cenkbackoff
+
+import (
+	cenk "github.com/cenk/backoff"
+
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// New returns the policy as a backoff.BackOff
+func New(b cenk.BackOff) backoff.BackOff {
+	return b
+}
+
+// Error converts a *cenk.PermanentError into a *backoff.PermanentError.
+// Other errors are returned as is.
+func Error(err error) error {
+	if permanent, ok := err.(*cenk.PermanentError); ok {
+		return backoff.Permanent(permanent.Err)
+	}
+	return err
+}
+
+// Circuit wraps a circuit that returns *cenk.PermanentError to stop
+// retries, so that breaker.CallWithRetry recognizes those errors
+func Circuit(c breaker.Circuit) breaker.Circuit {
+	return breaker.CircuitFunc(func() error {
+		return Error(c.Execute())
+	})
+}
@@ -0,0 +1,30 @@
+package cenkbackoff_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	cenk "github.com/cenk/backoff"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lestrrat/go-circuit-breaker/backoff/cenkbackoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func TestCircuit(t *testing.T) {
+	cb := breaker.New()
+	permanent := errors.New("permanent")
+
+	calls := 0
+	err := breaker.CallWithRetry(cb, cenkbackoff.Circuit(breaker.CircuitFunc(func() error {
+		calls++
+		return cenk.Permanent(permanent)
+	})), cenkbackoff.New(cenk.NewConstantBackOff(time.Millisecond)))
+	if !assert.Equal(t, permanent, err, "the permanent error should be returned") {
+		return
+	}
+	if !assert.Equal(t, 1, calls, "permanent errors should not be retried") {
+		return
+	}
+}
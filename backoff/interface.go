@@ -0,0 +1,97 @@
+package backoff
+
+import "time"
+
+// Stop is returned by NextBackOff when no more retries should be made
+const Stop time.Duration = -1
+
+// Default values used by NewExponentialBackOff
+const (
+	DefaultInitialInterval     = 500 * time.Millisecond
+	DefaultRandomizationFactor = 0.5
+	DefaultMultiplier          = 1.5
+	DefaultMaxInterval         = 60 * time.Second
+	DefaultMaxElapsedTime      = 15 * time.Minute
+)
+
+// BackOff is a policy that determines how long to wait before the next
+// retry. Policies from github.com/cenk/backoff satisfy this interface
+// too, so they can be used wherever a BackOff is expected.
+type BackOff interface {
+	// NextBackOff returns the duration to wait before the next retry,
+	// or Stop if no more retries should be made
+	NextBackOff() time.Duration
+
+	// Reset puts the policy back in its initial state
+	Reset()
+}
+
+// Clock is the source of the current time used by ExponentialBackOff.
+// breaker.Clock satisfies this interface.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+// SystemClock is the Clock used by default, which uses the time package
+var SystemClock Clock = systemClock{}
+
+// ExponentialBackOff is a policy whose intervals grow exponentially.
+// Each interval is randomized within RandomizationFactor of its nominal
+// value, i.e. for a factor of 0.5 and a nominal interval of 2 seconds
+// the actual interval is between 1 and 3 seconds.
+//
+// An ExponentialBackOff must not be used concurrently. Reset must be
+// called after changing its fields.
+type ExponentialBackOff struct {
+	InitialInterval     time.Duration
+	RandomizationFactor float64
+	Multiplier          float64
+	MaxInterval         time.Duration
+	// MaxElapsedTime is the time after which NextBackOff returns
+	// Stop, counted from the last call to Reset. It never stops if
+	// MaxElapsedTime is 0
+	MaxElapsedTime time.Duration
+	Clock          Clock
+
+	currentInterval time.Duration
+	startTime       time.Time
+}
+
+// ConstantBackOff is a policy that always returns the same interval
+type ConstantBackOff struct {
+	Interval time.Duration
+}
+
+// FibonacciBackOff is a policy whose intervals follow the Fibonacci
+// sequence, i.e. 1, 1, 2, 3, 5, 8... times the initial interval. It
+// grows more slowly than an ExponentialBackOff with a multiplier of 2.
+//
+// A FibonacciBackOff must not be used concurrently.
+type FibonacciBackOff struct {
+	InitialInterval time.Duration
+	// MaxInterval caps the intervals. They are not capped if
+	// MaxInterval is 0
+	MaxInterval time.Duration
+
+	current time.Duration
+	next    time.Duration
+}
+
+type maxRetries struct {
+	policy  BackOff
+	max     uint64
+	retries uint64
+}
+
+// StopBackOff is a policy that never retries
+type StopBackOff struct{}
+
+// ZeroBackOff is a policy that always retries immediately
+type ZeroBackOff struct{}
+
+// PermanentError wraps an error that should not be retried
+type PermanentError struct {
+	Err error
+}
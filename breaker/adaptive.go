@@ -0,0 +1,125 @@
+package breaker
+
+import (
+	"context"
+	"math"
+	"time"
+
+	pdebug "github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// NewAdaptiveBreaker creates an AdaptiveBreaker that limits the calls
+// made through cb. Calls beyond the current limit are rejected with
+// ErrTooManyConcurrent, without reaching cb, so its fallback is not
+// used for them.
+//
+// The limit grows by one every time a whole limit's worth of calls
+// succeed while the limit is in use, and is multiplied by the decrease
+// factor whenever a call fails, or takes longer than the latency
+// threshold. Calls rejected by cb do not change the limit.
+//
+// Possible optional parameters:
+// * WithInitialLimit: specify the limit to start with
+// * WithLimitBounds: specify the range of the limit
+// * WithDecreaseFactor: specify how much the limit shrinks on congestion
+// * WithLatencyThreshold: specify the latency that indicates congestion
+func NewAdaptiveBreaker(cb Breaker, options ...AdaptiveOption) *AdaptiveBreaker {
+	a := &AdaptiveBreaker{
+		Breaker:  cb,
+		clock:    SystemClock,
+		decrease: DefaultDecreaseFactor,
+		limit:    DefaultInitialLimit,
+		maxLimit: DefaultMaxLimit,
+		minLimit: DefaultMinLimit,
+	}
+	for _, option := range options {
+		option.applyAdaptive(a)
+	}
+	a.limit = math.Max(a.minLimit, math.Min(a.maxLimit, a.limit))
+	return a
+}
+
+// Limit returns the current concurrency limit
+func (a *AdaptiveBreaker) Limit() int {
+	a.limitLock.Lock()
+	defer a.limitLock.Unlock()
+	return int(a.limit)
+}
+
+// acquire takes a slot if the limit has not been reached
+func (a *AdaptiveBreaker) acquire() bool {
+	a.limitLock.Lock()
+	defer a.limitLock.Unlock()
+	if float64(a.inFlight) >= math.Floor(a.limit) {
+		return false
+	}
+	a.inFlight++
+	return true
+}
+
+// release gives back the slot taken by acquire, without changing the
+// limit
+func (a *AdaptiveBreaker) release() {
+	a.limitLock.Lock()
+	a.inFlight--
+	a.limitLock.Unlock()
+}
+
+// adapt gives back the slot taken by acquire, and adapts the limit
+// according to the outcome of the call
+func (a *AdaptiveBreaker) adapt(failed bool, latency time.Duration) {
+	a.limitLock.Lock()
+	defer a.limitLock.Unlock()
+
+	// The limit is only raised if it was being used, so that it
+	// doesn't grow without bounds while the traffic is low
+	inUse := float64(a.inFlight) >= a.limit/2
+	a.inFlight--
+
+	switch {
+	case failed || (a.latency > 0 && latency > a.latency):
+		if pdebug.Enabled {
+			pdebug.Printf("Congestion detected, decreasing limit from %f", a.limit)
+		}
+		a.limit = math.Max(a.minLimit, a.limit*a.decrease)
+	case inUse:
+		a.limit = math.Min(a.maxLimit, a.limit+1/a.limit)
+	}
+}
+
+func (a *AdaptiveBreaker) Allow() (func(bool), error) {
+	if !a.acquire() {
+		return nil, errors.WithMessage(ErrTooManyConcurrent, "failed to execute circuit")
+	}
+
+	done, err := a.Breaker.Allow()
+	if err != nil {
+		a.release()
+		return nil, err
+	}
+
+	start := a.clock.Now()
+	return func(success bool) {
+		done(success)
+		a.adapt(!success, a.clock.Now().Sub(start))
+	}, nil
+}
+
+func (a *AdaptiveBreaker) Call(circuit Circuit, options ...CallOption) error {
+	if !a.acquire() {
+		return errors.WithMessage(ErrTooManyConcurrent, "failed to execute circuit")
+	}
+
+	start := a.clock.Now()
+	err := a.Breaker.Call(circuit, options...)
+	switch {
+	case err != nil && (IsOpen(err) || IsTooManyConcurrent(err) || errors.Cause(err) == context.Canceled):
+		// Rejections and cancellations say nothing about the capacity
+		// of the service
+		a.release()
+	default:
+		a.adapt(err != nil, a.clock.Now().Sub(start))
+	}
+	return err
+}
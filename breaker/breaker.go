@@ -19,11 +19,13 @@
 package breaker
 
 import (
+	"context"
+	"math/rand"
 	"strconv"
 	"sync/atomic"
 	"time"
 
-	"github.com/cenk/backoff"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
 	"github.com/lestrrat/go-circuit-breaker/breaker/internal/window"
 	pdebug "github.com/lestrrat/go-pdebug"
 	"github.com/pkg/errors"
@@ -42,30 +44,10 @@ func (s State) String() string {
 }
 
 // New creates a base breaker with a specified backoff, clock and TripFunc
-func New(options ...Option) *breaker {
+func New(options ...BreakerOption) *breaker {
 	var b breaker
-	var windowTime time.Duration
-	var windowBuckets int
-
 	for _, option := range options {
-		switch option.Name() {
-		case "Clock":
-			b.clock = option.Get().(Clock)
-		case "Backoff":
-			b.backoff = option.Get().(backoff.BackOff)
-		case "Timeout":
-			b.defaultTimeout = option.Get().(time.Duration)
-		case "Tripper":
-			b.tripper = option.Get().(Tripper)
-		case "WindowTime":
-			windowTime = option.Get().(time.Duration)
-		case "WindowBuckets":
-			windowBuckets = option.Get().(int)
-		}
-	}
-
-	if b.tripper == nil {
-		b.tripper = NilTripper
+		option.applyBreaker(&b)
 	}
 
 	if b.clock == nil {
@@ -81,48 +63,209 @@ func New(options ...Option) *breaker {
 		b.backoff = bo
 	}
 
-	if windowTime == 0 {
-		windowTime = DefaultWindowTime
+	if b.windowTime == 0 {
+		b.windowTime = DefaultWindowTime
+	}
+
+	if b.windowBuckets == 0 {
+		b.windowBuckets = DefaultWindowBuckets
+	}
+
+	if b.storageInterval == 0 {
+		b.storageInterval = DefaultStorageInterval
+	}
+
+	if b.storage != nil {
+		b.saver = &stateSaver{storage: b.storage}
+	}
+
+	if b.logger != nil {
+		b.addListener(newLogListener(b.logger, b.name))
 	}
 
-	if windowBuckets == 0 {
-		windowBuckets = DefaultWindowBuckets
+	if b.historySize > 0 {
+		b.transitions = &transitionLog{size: b.historySize}
 	}
 
-	b.nextBackOff = b.backoff.NextBackOff()
-	b.counts = window.New(b.clock, windowTime, windowBuckets)
+	if b.warmup > 0 {
+		b.warmUntil = b.clock.Now().Add(b.warmup)
+	}
+
+	b.publish(b.settings)
+	b.setNextBackOff()
+	if b.windowSize > 0 {
+		b.counts = window.NewCount(b.windowSize)
+	} else {
+		b.counts = window.New(b.clock, b.windowTime, b.windowBuckets)
+	}
+	b.restore()
 	return &b
 }
 
+// reconfigurable lists the options that are accepted by Reconfigure
+var reconfigurable = map[string]struct{}{
+	"Backoff":          {},
+	"ErrorCategorizer": {},
+	"ErrorClassifier":  {},
+	"Fallback":         {},
+	"GradualRecovery":  {},
+	"HalfOpenRequests": {},
+	"Jitter":           {},
+	"MinSamples":       {},
+	"ProbeWait":        {},
+	"Queueing":         {},
+	"ShadowMode":       {},
+	"SuccessThreshold": {},
+	"Timeout":          {},
+	"Tripper":          {},
+}
+
+func (cb *breaker) Reconfigure(options ...BreakerOption) error {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Breaker.Reconfigure")
+		defer g.End()
+	}
+
+	for _, option := range options {
+		if _, ok := reconfigurable[option.Name()]; !ok {
+			return errors.Errorf("option %s cannot be changed once the breaker is created", option.Name())
+		}
+	}
+
+	cb.reconfigureLock.Lock()
+	defer cb.reconfigureLock.Unlock()
+
+	// Apply the options to a scratch breaker holding the current
+	// settings, so that the breaker itself is never written to while
+	// calls are reading from it
+	var scratch breaker
+	scratch.settings = *cb.config()
+	for _, option := range options {
+		option.applyBreaker(&scratch)
+	}
+
+	// Publish the settings first, so that the new backoff is given
+	// the new jitter
+	cb.publish(scratch.settings)
+	if scratch.backoff != nil {
+		cb.backoffLock.Lock()
+		cb.backoff = scratch.backoff
+		cb.backoff.Reset()
+		cb.setNextBackOff()
+		atomic.StoreInt32(&cb.backoffAdvanced, 0)
+		cb.backoffLock.Unlock()
+	}
+	return nil
+}
+
+// config returns the current settings of the breaker
+func (cb *breaker) config() *settings {
+	return cb.current.Load().(*settings)
+}
+
+// publish makes s the current settings of the breaker
+func (cb *breaker) publish(s settings) {
+	if s.tripper == nil {
+		s.tripper = NilTripper
+	}
+	if s.halfOpenRequests <= 0 {
+		s.halfOpenRequests = 1
+	}
+	cb.current.Store(&s)
+}
+
+func (cb *breaker) Backoff() backoff.BackOff {
+	cb.backoffLock.Lock()
+	defer cb.backoffLock.Unlock()
+	return cb.backoff
+}
+
 func (cb *breaker) Break() {
 	atomic.StoreInt32(&cb.broken, 1)
+	wasTripped := cb.Tripped()
 	cb.Trip()
+	if wasTripped {
+		// Trip() only saves the state when the breaker trips
+		cb.save(true)
+	}
 }
 
-func (cb *breaker) Call(circuit Circuit, options ...Option) (err error) {
+func (cb *breaker) Call(circuit Circuit, options ...CallOption) (err error) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Breaker.Call").BindError(&err)
 		defer g.End()
 	}
 
-	timeout := cb.defaultTimeout
+	s := cb.config()
+	config := callConfig{
+		ctx:      context.Background(),
+		fallback: s.fallback,
+		timeout:  s.defaultTimeout,
+	}
 	for _, option := range options {
-		switch option.Name() {
-		case "Timeout":
-			timeout = option.Get().(time.Duration)
+		option.applyCall(&config)
+	}
+	ctx, fallback, timeout := config.ctx, config.fallback, config.timeout
+
+	// Don't bother checking the breaker state if the caller has
+	// already given up
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var st State
+	if config.force {
+		st = cb.admitForced()
+	} else {
+		st, err = cb.admit(ctx)
+		if err != nil {
+			if fallback != nil {
+				return fallback.Execute()
+			}
+			return err
 		}
 	}
 
-	ready, st := cb.Ready()
-	if !ready {
-		if pdebug.Enabled {
-			pdebug.Printf("Breaker not ready")
+	// Circuits that take a context are told to give up when the call
+	// times out, so that they don't keep running (and holding on to a
+	// goroutine) after Call has returned
+	execCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		execCtx, cancel = context.WithCancel(ctx)
+		defer cancel()
+	}
+	if cc, ok := circuit.(ContextCircuit); ok {
+		circuit = CircuitFunc(func() error {
+			return cc.ExecuteContext(execCtx)
+		})
+	}
+
+	// The call is in flight, and holds its slot, until the circuit
+	// completes, which may be after Call returns if the call timed out
+	atomic.AddInt64(&cb.inFlight, 1)
+	inner := circuit
+	circuit = CircuitFunc(func() error {
+		defer atomic.AddInt64(&cb.inFlight, -1)
+		defer cb.release()
+		return inner.Execute()
+	})
+
+	// A deadline on the context that comes before the breaker timeout
+	// becomes the effective timeout, so that the caller can tell which
+	// of the two limits was reached
+	var deadline bool
+	if d, ok := ctx.Deadline(); ok {
+		if remaining := d.Sub(cb.clock.Now()); timeout == 0 || remaining < timeout {
+			timeout = remaining
+			deadline = true
 		}
-		return errors.Wrap(ErrBreakerOpen, "failed to execute circuit")
 	}
 
-	switch timeout {
-	case 0:
+	start := cb.clock.Now()
+	done := ctx.Done()
+	switch {
+	case timeout == 0 && done == nil:
 		err = circuit.Execute()
 	default:
 		c := make(chan error)
@@ -139,35 +282,445 @@ func (cb *breaker) Call(circuit Circuit, options ...Option) (err error) {
 			}
 		}()
 
+		var timeoutC <-chan time.Time
+		if deadline && timeout <= 0 {
+			expired := make(chan time.Time, 1)
+			expired <- cb.clock.Now()
+			timeoutC = expired
+		} else if timeout > 0 {
+			t := NewTimer(cb.clock, timeout)
+			defer t.Stop()
+			timeoutC = t.C()
+		}
+
 		select {
 		case err = <-c:
-		case <-cb.clock.After(timeout):
-			err = errors.Wrap(ErrBreakerTimeout, "timeout reached while executing circuit")
+		case <-timeoutC:
+			if deadline {
+				err = errors.Wrap(ErrDeadlineExceeded, "context deadline reached while executing circuit")
+			} else {
+				err = errors.Wrap(ErrBreakerTimeout, "timeout reached while executing circuit")
+			}
+		case <-done:
+			err = ctx.Err()
+			if err == context.DeadlineExceeded {
+				err = errors.Wrap(ErrDeadlineExceeded, "context deadline reached while executing circuit")
+			}
 		}
 	}
 
-	switch err {
-	case nil:
+	switch {
+	case err != nil && ctx.Err() == context.Canceled:
+		// The caller canceled the call, which tells us nothing
+		// about the health of the circuit. Don't record anything
+		if pdebug.Enabled {
+			pdebug.Printf("Context canceled, not recording result")
+		}
+		cb.ignore(st)
+	case err != nil && !cb.isFailureErr(err):
+		// The error is not the circuit's fault, and tells us nothing
+		// about its health either
+		cb.counts.Observe(cb.clock.Now().Sub(start))
+		cb.ignore(st)
+	case err == nil:
+		cb.counts.Observe(cb.clock.Now().Sub(start))
 		cb.success(st)
 	default:
-		cb.fail()
+		cb.counts.Observe(cb.clock.Now().Sub(start))
+		cb.failWith(ctx, st, err)
+		if fallback != nil {
+			return fallback.Execute()
+		}
 	}
 
 	return err
 }
 
+// admit decides whether a call may go ahead. If it may, the state in
+// which the call was admitted is returned, and a concurrency slot is
+// held until release is called. Calls rejected while the breaker is
+// open wait for it to let calls through again if WithQueueing was
+// given, and calls rejected while a half-open probe is running wait
+// for its outcome if WithProbeWait was given, unless ctx is done first
+func (cb *breaker) admit(ctx context.Context) (State, error) {
+	var timer Timer
+	var queued bool
+	defer func() {
+		if queued {
+			atomic.AddInt64(&cb.queued, -1)
+		}
+	}()
+
+	for {
+		// Take a slot before checking the state, so that a half-open
+		// probe is not wasted on a call that is rejected anyway
+		if !cb.acquire() {
+			if pdebug.Enabled {
+				pdebug.Printf("Too many concurrent calls")
+			}
+			return Closed, errors.WithMessage(ErrTooManyConcurrent, "failed to execute circuit")
+		}
+
+		ready, st := cb.Ready()
+		switch {
+		case ready:
+			return st, nil
+		case cb.config().shadow || cb.Disabled():
+			// Pretend that the breaker is closed, but keep recording
+			// the results so that the state can be observed
+			if pdebug.Enabled {
+				pdebug.Printf("Breaker not ready, executing circuit in shadow mode")
+			}
+			return st, nil
+		}
+		cb.release()
+
+		s := cb.config()
+		probe := cb.pendingProbe()
+		var changed <-chan struct{}
+		var retry Timer
+		var wait time.Duration
+		switch {
+		case queued || cb.enqueue(s.queueSize):
+			queued = true
+			wait = s.queueWait
+			changed = cb.nextChange()
+			retryAt := cb.RetryAt()
+			d := retryAt.Sub(cb.clock.Now())
+			switch {
+			case !cb.Tripped():
+				// The breaker was reset in the meantime
+				continue
+			case d > 0:
+				// Check again once a half-open probe would be allowed
+				retry = NewTimer(cb.clock, d)
+			case probe == nil && !retryAt.IsZero():
+				// A probe may be allowed already
+				continue
+			}
+		case probe != nil:
+			wait = s.probeWait
+		}
+
+		if wait > 0 {
+			if timer == nil {
+				timer = NewTimer(cb.clock, wait)
+				defer timer.Stop()
+			}
+			if pdebug.Enabled {
+				pdebug.Printf("Breaker not ready, waiting")
+			}
+			if cb.await(ctx, timer, retry, changed, probe) {
+				continue
+			}
+		}
+
+		if pdebug.Enabled {
+			pdebug.Printf("Breaker not ready")
+		}
+		// Rejections are expected to be frequent while the breaker
+		// is open, so don't bother recording a stack trace
+		return st, errors.WithMessage(cb.openError(), "failed to execute circuit")
+	}
+}
+
+// openError creates the error used to reject calls while the breaker
+// is open
+func (cb *breaker) openError() *OpenError {
+	e := &OpenError{
+		Name:    cb.name,
+		RetryAt: cb.RetryAt(),
+	}
+	if v, ok := cb.lastError.Load().(lastError); ok {
+		e.LastError = v.err
+	}
+	if last := atomic.LoadInt64(&cb.lastTrip); last != 0 {
+		e.Since = time.Unix(0, last)
+		e.OpenFor = cb.clock.Now().Sub(e.Since)
+	}
+	return e
+}
+
+// await blocks until the state of the breaker should be checked again,
+// in which case it returns true, or until the call should be rejected
+func (cb *breaker) await(ctx context.Context, timer, retry Timer, changed, probe <-chan struct{}) bool {
+	var retryC <-chan time.Time
+	if retry != nil {
+		defer retry.Stop()
+		retryC = retry.C()
+	}
+
+	select {
+	case <-changed:
+	case <-probe:
+	case <-retryC:
+	case <-timer.C():
+		return false
+	case <-ctx.Done():
+		return false
+	}
+	return true
+}
+
+// enqueue takes a place in the queue of calls waiting for the breaker
+// to let calls through. It returns false if the queue is full
+func (cb *breaker) enqueue(size int64) bool {
+	for {
+		n := atomic.LoadInt64(&cb.queued)
+		if n >= size {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&cb.queued, n, n+1) {
+			return true
+		}
+	}
+}
+
+// nextChange returns a channel that is closed on the next state
+// transition of the breaker
+func (cb *breaker) nextChange() <-chan struct{} {
+	cb.changeLock.Lock()
+	defer cb.changeLock.Unlock()
+	if cb.changed == nil {
+		cb.changed = make(chan struct{})
+	}
+	return cb.changed
+}
+
+// startProbe records that a half-open probe is running, so that
+// rejected calls can wait for its outcome
+func (cb *breaker) startProbe(now time.Time) {
+	cb.probeLock.Lock()
+	if cb.probe == nil {
+		cb.probe = make(chan struct{})
+	}
+	cb.probeStart = now
+	cb.probeLock.Unlock()
+}
+
+// probeRunning returns true if a probe started less than wait ago is
+// still running. Probes whose outcome is never reported are given up
+// on after wait, so that the breaker can start a new probe
+func (cb *breaker) probeRunning(now time.Time, wait time.Duration) bool {
+	cb.probeLock.Lock()
+	defer cb.probeLock.Unlock()
+	if cb.probe == nil {
+		return false
+	}
+	if now.Before(cb.probeStart.Add(wait)) {
+		return true
+	}
+	close(cb.probe)
+	cb.probe = nil
+	return false
+}
+
+// finishProbe wakes up the calls waiting for the outcome of the
+// running probe, if any
+func (cb *breaker) finishProbe() {
+	cb.probeLock.Lock()
+	if cb.probe != nil {
+		close(cb.probe)
+		cb.probe = nil
+	}
+	cb.probeLock.Unlock()
+}
+
+// pendingProbe returns a channel that is closed once the outcome of
+// the running probe is known, or nil if no probe is running
+func (cb *breaker) pendingProbe() <-chan struct{} {
+	cb.probeLock.Lock()
+	defer cb.probeLock.Unlock()
+	if cb.probe == nil {
+		return nil
+	}
+	return cb.probe
+}
+
+// admitForced admits a call that bypasses the breaker, and returns
+// the state in which the call is recorded. A concurrency slot is held
+// until release is called, even if the limit has been reached
+func (cb *breaker) admitForced() State {
+	if cb.maxConcurrent > 0 {
+		atomic.AddInt64(&cb.concurrent, 1)
+	}
+
+	switch {
+	case !cb.Tripped():
+		return Closed
+	case atomic.LoadInt32(&cb.broken) == 1:
+		// Only Reset() may close a broken breaker
+		return Open
+	default:
+		return Halfopen
+	}
+}
+
+func (cb *breaker) Allow() (func(bool), error) {
+	st, err := cb.admit(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&cb.inFlight, 1)
+	start := cb.clock.Now()
+	var reported int32
+	return func(success bool) {
+		// Only the first report counts
+		if !atomic.CompareAndSwapInt32(&reported, 0, 1) {
+			return
+		}
+
+		atomic.AddInt64(&cb.inFlight, -1)
+		cb.release()
+		cb.counts.Observe(cb.clock.Now().Sub(start))
+		if success {
+			cb.success(st)
+		} else {
+			cb.failWith(context.Background(), st, nil)
+		}
+	}, nil
+}
+
+func (cb *breaker) AverageLatency() time.Duration {
+	return cb.counts.AverageLatency()
+}
+
 func (cb *breaker) ConsecFailures() int64 {
 	return atomic.LoadInt64(&cb.consecFailures)
 }
 
+func (cb *breaker) CountsSince(d time.Duration) (failures, successes int64) {
+	return cb.counts.CountsSince(d)
+}
+
+func (cb *breaker) DefaultTimeout() time.Duration {
+	return cb.config().defaultTimeout
+}
+
+func (cb *breaker) Disable() {
+	atomic.StoreInt32(&cb.disabled, 1)
+}
+
+func (cb *breaker) Disabled() bool {
+	return atomic.LoadInt32(&cb.disabled) == 1
+}
+
+func (cb *breaker) Enable() {
+	atomic.StoreInt32(&cb.disabled, 0)
+}
+
 func (cb *breaker) ErrorRate() float64 {
 	return cb.counts.ErrorRate()
 }
 
+func (cb *breaker) ErrorRateSince(d time.Duration) float64 {
+	failures, successes := cb.counts.CountsSince(d)
+	if failures+successes == 0 {
+		return 0.0
+	}
+	return float64(failures) / float64(failures+successes)
+}
+
 func (cb *breaker) Failures() int64 {
 	return cb.counts.Failures()
 }
 
+func (cb *breaker) FailuresByClass() map[string]int64 {
+	cb.classesLock.Lock()
+	defer cb.classesLock.Unlock()
+
+	list := make(map[string]int64, len(cb.classes))
+	for class, n := range cb.classes {
+		list[class] = n
+	}
+	return list
+}
+
+// countClass counts a failure under the class of err
+func (cb *breaker) countClass(err error) {
+	var class string
+	if categorize := cb.config().categorize; categorize != nil && err != nil {
+		class = categorize(err)
+	}
+	if class == "" {
+		class = failureClass(err)
+	}
+
+	cb.classesLock.Lock()
+	if cb.classes == nil {
+		cb.classes = make(map[string]int64)
+	}
+	cb.classes[class]++
+	cb.classesLock.Unlock()
+}
+
+func (cb *breaker) History() []Bucket {
+	stats := cb.counts.Buckets()
+	list := make([]Bucket, len(stats))
+	for i, s := range stats {
+		list[i] = Bucket{
+			Start:     s.Start,
+			Duration:  s.Duration,
+			Failures:  s.Failures,
+			Successes: s.Successes,
+		}
+	}
+	return list
+}
+
+func (cb *breaker) InFlight() int64 {
+	return atomic.LoadInt64(&cb.inFlight)
+}
+
+func (cb *breaker) LastFailure() time.Time {
+	last := atomic.LoadInt64(&cb.lastFailure)
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+func (cb *breaker) Name() string {
+	return cb.name
+}
+
+func (cb *breaker) Labels() map[string]string {
+	return copyLabels(cb.labels)
+}
+
+// copyLabels returns a copy of labels, or nil if there are none
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
+
+// MarkFailure and MarkSuccess record outcomes of operations that were
+// not admitted by the breaker, so they are never counted as probes
+func (cb *breaker) MarkFailure(err error) {
+	st := trippedState(cb)
+	if err != nil && !cb.isFailureErr(err) {
+		cb.ignore(st)
+		return
+	}
+	cb.failWith(context.Background(), st, err)
+}
+
+func (cb *breaker) MarkSuccess() {
+	st := trippedState(cb)
+	cb.success(st)
+}
+
+func (cb *breaker) Percentile(p float64) time.Duration {
+	return cb.counts.Percentile(p)
+}
+
 func (cb *breaker) Ready() (isReady bool, st State) {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Breaker.Ready")
@@ -179,7 +732,6 @@ func (cb *breaker) Ready() (isReady bool, st State) {
 		if pdebug.Enabled {
 			pdebug.Printf("state is halfopen")
 		}
-		atomic.StoreInt64(&cb.halfOpens, 0)
 		fallthrough
 	case Closed:
 		return true, st
@@ -187,21 +739,55 @@ func (cb *breaker) Ready() (isReady bool, st State) {
 	return false, st
 }
 
+func (cb *breaker) RetryAt() time.Time {
+	_, t := cb.peekState()
+	return t
+}
+
 func (cb *breaker) Reset() {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Breaker.Reset")
 		defer g.End()
 	}
 
+	cb.reset(Open)
+}
+
+// reset closes the breaker. from is the state reported to the
+// listeners if the breaker was tripped
+func (cb *breaker) reset(from State) {
 	atomic.StoreInt32(&cb.broken, 0)
-	atomic.StoreInt32(&cb.tripped, 0)
+	wasTripped := atomic.SwapInt32(&cb.tripped, 0) == 1
 	atomic.StoreInt64(&cb.halfOpens, 0)
+	atomic.StoreInt64(&cb.halfOpenSuccesses, 0)
+	atomic.StoreInt32(&cb.recovering, 0)
+	atomic.StoreInt64(&cb.retryAfter, 0)
+	cb.lastError.Store(lastError{})
 	cb.ResetCounters()
+	cb.ResetBackoff()
+	defer cb.finishProbe()
+
+	if wasTripped {
+		cb.recordOpen(cb.clock.Now())
+		cb.save(true)
+		cb.notifyStateChange(from, Closed, nil)
+	}
+}
+
+func (cb *breaker) ResetBackoff() {
+	cb.backoffLock.Lock()
+	cb.backoff.Reset()
+	cb.setNextBackOff()
+	atomic.StoreInt32(&cb.backoffAdvanced, 0)
+	cb.backoffLock.Unlock()
 }
 
 func (cb *breaker) ResetCounters() {
 	atomic.StoreInt64(&cb.consecFailures, 0)
 	cb.counts.Reset()
+	cb.classesLock.Lock()
+	cb.classes = nil
+	cb.classesLock.Unlock()
 }
 
 func (cb *breaker) State() State {
@@ -213,78 +799,609 @@ func (cb *breaker) State() State {
 		return Open
 	}
 
-	last := atomic.LoadInt64(&cb.lastFailure)
-	since := cb.clock.Now().Sub(time.Unix(last, 0))
-
-	cb.backoffLock.Lock()
-	defer cb.backoffLock.Unlock()
+	now := cb.clock.Now()
 
+	// Only take the lock if it looks like it's time to retry
+	next := cb.getNextBackOff()
 	if pdebug.Enabled {
-		pdebug.Printf("nextBackOff %s, backoff.Stop %s, since %s", cb.nextBackOff, backoff.Stop, since)
+		pdebug.Printf("nextBackOff %s, backoff.Stop %s, now %s", next, backoff.Stop, now)
 	}
-	if cb.nextBackOff != backoff.Stop && since > cb.nextBackOff {
+	if next != backoff.Stop && now.After(cb.retryTime(next)) {
+		s := cb.config()
+		if s.recovery > 0 {
+			ratio := float64(now.Sub(cb.retryTime(next))) / float64(s.recovery)
+			return cb.recoveryState(ratio)
+		}
+
+		cb.backoffLock.Lock()
+		// The backoff may have advanced while waiting for the lock
+		if next = cb.getNextBackOff(); next == backoff.Stop || !now.After(cb.retryTime(next)) {
+			cb.backoffLock.Unlock()
+			return Open
+		}
+
 		if pdebug.Enabled {
 			pdebug.Printf("halfOpens %d", atomic.LoadInt64(&cb.halfOpens))
 		}
-		if atomic.CompareAndSwapInt64(&cb.halfOpens, 0, 1) {
-			cb.nextBackOff = cb.backoff.NextBackOff()
-			if pdebug.Enabled {
-				pdebug.Printf("returning halfopen")
+		// Don't start a new round of probes while the probes of the
+		// previous round are running, as callers wait for their outcome
+		if s.probeWait > 0 && atomic.LoadInt64(&cb.halfOpens) == 0 && cb.probeRunning(now, s.probeWait) {
+			cb.backoffLock.Unlock()
+			return Open
+		}
+
+		// Hand out up to halfOpenRequests probes. Once all of them
+		// have been handed out, wait for the next backoff
+		n := atomic.AddInt64(&cb.halfOpens, 1)
+		if n >= s.halfOpenRequests {
+			atomic.StoreInt64(&cb.halfOpens, 0)
+			cb.advanceBackOff()
+		}
+		cb.backoffLock.Unlock()
+
+		// The first probe of each round moves the breaker to half open
+		if n == 1 {
+			if s.probeWait > 0 {
+				cb.startProbe(now)
 			}
-			return Halfopen
+			cb.notifyStateChange(Open, Halfopen, nil)
+		}
+		if pdebug.Enabled {
+			pdebug.Printf("returning halfopen")
 		}
+		return Halfopen
 	}
+
 	if pdebug.Enabled {
 		pdebug.Printf("returning open")
 	}
 	return Open
 }
+func (cb *breaker) Snapshot() Snapshot {
+	failures, successes := cb.counts.Counts()
+	s := Snapshot{
+		ConsecFailures: atomic.LoadInt64(&cb.consecFailures),
+		Failures:       failures,
+		Successes:      successes,
+		Trips:          atomic.LoadInt64(&cb.trips),
+	}
+	if total := failures + successes; total > 0 {
+		s.ErrorRate = float64(failures) / float64(total)
+	}
+	s.LastFailure = cb.LastFailure()
+	s.State, s.NextRetry = cb.peekState()
+	s.LastTrip, s.OpenTime, s.LongestOpen = cb.openStats()
+	return s
+}
+
+// openStats returns the time of the last trip, the total time spent
+// open and the longest open streak, counting the current streak if
+// the breaker is open
+func (cb *breaker) openStats() (time.Time, time.Duration, time.Duration) {
+	last := atomic.LoadInt64(&cb.lastTrip)
+	total := atomic.LoadInt64(&cb.openTime)
+	longest := atomic.LoadInt64(&cb.longestOpen)
+	if last == 0 {
+		return time.Time{}, time.Duration(total), time.Duration(longest)
+	}
+
+	if cb.Tripped() {
+		if current := cb.clock.Now().UnixNano() - last; current > 0 {
+			total += current
+			if current > longest {
+				longest = current
+			}
+		}
+	}
+	return time.Unix(0, last), time.Duration(total), time.Duration(longest)
+}
+
+// recordOpen adds the streak that started with the last trip to the
+// open time statistics, once the breaker closes
+func (cb *breaker) recordOpen(now time.Time) {
+	last := atomic.LoadInt64(&cb.lastTrip)
+	if last == 0 {
+		return
+	}
+	d := now.UnixNano() - last
+	if d <= 0 {
+		return
+	}
+
+	atomic.AddInt64(&cb.openTime, d)
+	for {
+		longest := atomic.LoadInt64(&cb.longestOpen)
+		if d <= longest || atomic.CompareAndSwapInt64(&cb.longestOpen, longest, d) {
+			return
+		}
+	}
+}
+
+// peekState returns the state of the breaker and the time when the
+// next probe is allowed, without handing out a probe
+func (cb *breaker) peekState() (State, time.Time) {
+	if !cb.Tripped() {
+		return Closed, time.Time{}
+	}
+
+	if atomic.LoadInt32(&cb.broken) == 1 {
+		return Open, time.Time{}
+	}
+
+	next := cb.getNextBackOff()
+	if next == backoff.Stop {
+		return Open, time.Time{}
+	}
+
+	retry := cb.retryTime(next)
+	if cb.clock.Now().After(retry) {
+		return Halfopen, retry
+	}
+	return Open, retry
+}
+
+// retryTime returns the time after which a probe is allowed, given
+// the current backoff interval. The time set by TripUntil takes
+// precedence if it is later than the end of the interval
+func (cb *breaker) retryTime(next time.Duration) time.Time {
+	retry := time.Unix(0, atomic.LoadInt64(&cb.lastFailure)).Add(next)
+	if after := atomic.LoadInt64(&cb.retryAfter); after > retry.UnixNano() {
+		return time.Unix(0, after)
+	}
+	return retry
+}
+
+// recoveryState returns the state of a breaker that is gradually
+// recovering, where ratio is the fraction of calls to be let through.
+// Calls that are let through are treated as half-open probes
+func (cb *breaker) recoveryState(ratio float64) State {
+	if ratio < 1 && rand.Float64() >= ratio {
+		if pdebug.Enabled {
+			pdebug.Printf("shedding call (ratio %f)", ratio)
+		}
+		return Open
+	}
+
+	if atomic.CompareAndSwapInt32(&cb.recovering, 0, 1) {
+		cb.notifyStateChange(Open, Halfopen, nil)
+	}
+	return Halfopen
+}
+
+// recoveryRatio returns the fraction of calls that a gradually
+// recovering breaker lets through
+func (cb *breaker) recoveryRatio() float64 {
+	since := cb.clock.Now().Sub(cb.retryTime(cb.getNextBackOff()))
+	return float64(since) / float64(cb.config().recovery)
+}
+
 func (cb *breaker) Successes() int64 {
 	return cb.counts.Successes()
 }
 
+func (cb *breaker) Transitions() []Transition {
+	if cb.transitions == nil {
+		return nil
+	}
+	return cb.transitions.list()
+}
+
 func (cb *breaker) Trip() {
 	if pdebug.Enabled {
 		g := pdebug.Marker("Breaker.Trip")
 		defer g.End()
 	}
-	atomic.StoreInt32(&cb.tripped, 1)
+	cb.trip(nil)
+}
+
+func (cb *breaker) TripUntil(t time.Time) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("Breaker.TripUntil")
+		defer g.End()
+	}
+	atomic.StoreInt64(&cb.retryAfter, t.UnixNano())
+	cb.trip(nil)
+}
+
+// trip opens the breaker. err is the error that caused the breaker
+// to trip, if any, and is reported to the listeners
+func (cb *breaker) trip(err error) {
+	wasTripped := atomic.SwapInt32(&cb.tripped, 1) == 1
 	now := cb.clock.Now()
-	atomic.StoreInt64(&cb.lastFailure, now.Unix())
+	atomic.StoreInt64(&cb.lastFailure, now.UnixNano())
+
+	if !wasTripped {
+		atomic.StoreInt64(&cb.lastTrip, now.UnixNano())
+		atomic.AddInt64(&cb.trips, 1)
+		cb.save(true)
+		cb.notifyStateChange(Closed, Open, err)
+	}
 }
 
 func (cb *breaker) Tripped() bool {
 	return atomic.LoadInt32(&cb.tripped) == 1
 }
 
+func (cb *breaker) Tripper() Tripper {
+	return cb.config().tripper
+}
+
+func (cb *breaker) WouldTrip() bool {
+	s := cb.config()
+	failures, successes := cb.counts.Counts()
+	if cb.clock.Now().Before(cb.warmUntil) || failures+successes < s.minSamples {
+		return false
+	}
+	return tripContext(dryRunContext, s.tripper, cb)
+}
+
+// acquire takes a slot for a circuit execution. It returns false if
+// the maximum number of concurrent executions has been reached
+func (cb *breaker) acquire() bool {
+	if cb.maxConcurrent <= 0 {
+		return true
+	}
+	if atomic.AddInt64(&cb.concurrent, 1) > cb.maxConcurrent {
+		atomic.AddInt64(&cb.concurrent, -1)
+		return false
+	}
+	return true
+}
+
+// release releases a slot taken by acquire
+func (cb *breaker) release() {
+	if cb.maxConcurrent <= 0 {
+		return
+	}
+	atomic.AddInt64(&cb.concurrent, -1)
+}
+
+// ignore is called for outcomes that are recorded neither as successes
+// nor as failures, such as canceled calls or errors rejected by the
+// ErrorClassifier. Nothing is recorded, but if the call was a half-open
+// probe, the calls waiting for its outcome are let go
+func (cb *breaker) ignore(st State) {
+	if st == Halfopen {
+		cb.finishProbe()
+	}
+}
+
+// isFailureErr returns true if the given error should be recorded
+// as a failure.
+func (cb *breaker) isFailureErr(err error) bool {
+	isFailure := cb.config().isFailure
+	if isFailure == nil {
+		return true
+	}
+	return isFailure(err)
+}
+
 // fail is used to indicate a failure condition the Breaker should record.
 // It will increment the failure counters and store the time of the last
 // failure. If the breaker has a TripFunc it will be called, tripping the
 // breaker if necessary.
 func (cb *breaker) fail() {
+	cb.failWith(context.Background(), trippedState(cb), nil)
+}
+
+// failWith records a failure that occurred while the breaker was in
+// state st. err is the error that caused the failure, if any, and is
+// reported to the listeners. ctx is the context of the call that
+// failed, and is passed to the tripper
+func (cb *breaker) failWith(ctx context.Context, st State, err error) {
+	if st == Halfopen {
+		// Wake up the calls waiting for the probe once the breaker
+		// has been tripped again
+		defer cb.finishProbe()
+	}
+	atomic.StoreInt64(&cb.halfOpenSuccesses, 0)
 	cb.counts.Fail()
+	cb.countClass(err)
 	atomic.AddInt64(&cb.consecFailures, 1)
 	now := cb.clock.Now()
-	atomic.StoreInt64(&cb.lastFailure, now.Unix())
-	if cb.tripper.Trip(cb) {
-		cb.Trip()
+	atomic.StoreInt64(&cb.lastFailure, now.UnixNano())
+	if err != nil {
+		cb.lastError.Store(lastError{err: err})
+	}
+	cb.notifyFail(st, err)
+
+	s := cb.config()
+
+	// A failed probe sends the breaker back to the open state
+	if st == Halfopen && cb.Tripped() {
+		if s.recovery > 0 {
+			// Wait longer before recovering again
+			cb.backoffLock.Lock()
+			cb.advanceBackOff()
+			cb.backoffLock.Unlock()
+			atomic.StoreInt32(&cb.recovering, 0)
+		}
+		cb.notifyStateChange(Halfopen, Open, err)
+	}
+
+	failures, successes := cb.counts.Counts()
+	switch {
+	case now.Before(cb.warmUntil):
+		// Failures are expected while caches and connection pools
+		// are being filled after startup
+		if pdebug.Enabled {
+			pdebug.Printf("Warming up until %s, not tripping", cb.warmUntil)
+		}
+	case failures+successes < s.minSamples:
+		// Too few calls don't say much about the health of the
+		// circuit, so don't let the tripper decide on them
+		if pdebug.Enabled {
+			pdebug.Printf("Only %d samples in the window, not tripping", failures+successes)
+		}
+	case tripContext(ctx, s.tripper, cb):
+		cb.trip(err)
 	}
+	cb.save(false)
 }
 
 // success is used to indicate a success condition the Breaker should record.
-// If the success was triggered by a retry attempt, the breaker will be Reset().
+// If the success was triggered by a retry attempt, the breaker will be Reset()
+// once the required number of consecutive half-open probes have succeeded.
 func (cb *breaker) success(st State) {
-	cb.backoffLock.Lock()
-	cb.backoff.Reset()
-	cb.nextBackOff = cb.backoff.NextBackOff()
-	cb.backoffLock.Unlock()
+	if st == Halfopen {
+		defer cb.finishProbe()
+
+		s := cb.config()
+		var wait bool
+		if s.recovery > 0 {
+			// Wait until all calls are let through before closing
+			wait = cb.recoveryRatio() < 1
+		} else {
+			// Wait for more probes to succeed before deciding to close
+			threshold := s.successThreshold
+			if threshold <= 0 {
+				threshold = s.halfOpenRequests
+			}
+			wait = atomic.AddInt64(&cb.halfOpenSuccesses, 1) < threshold
+		}
+		if atomic.LoadInt64(&cb.retryAfter) > cb.clock.Now().UnixNano() {
+			// The breaker was asked to stay open while the probe was running
+			wait = true
+		}
+		if wait {
+			atomic.StoreInt64(&cb.consecFailures, 0)
+			cb.counts.Success()
+			cb.notifySuccess(st)
+			cb.save(false)
+			return
+		}
+	}
+
+	// Only touch the backoff if it has advanced since the last reset,
+	// so that successes in the closed state do not contend for the lock
+	if atomic.CompareAndSwapInt32(&cb.backoffAdvanced, 1, 0) {
+		cb.backoffLock.Lock()
+		cb.backoff.Reset()
+		cb.setNextBackOff()
+		cb.backoffLock.Unlock()
+	}
+
+	cb.notifySuccess(st)
 
 	if st == Halfopen {
 		if pdebug.Enabled {
 			pdebug.Printf("Breaker is in halfopen state, calling Reset")
 		}
-		cb.Reset()
+		cb.reset(Halfopen)
 	}
 	atomic.StoreInt64(&cb.consecFailures, 0)
 	cb.counts.Success()
+	cb.save(false)
+}
+
+func (cb *breaker) getNextBackOff() time.Duration {
+	return time.Duration(atomic.LoadInt64(&cb.nextBackOff))
+}
+
+// advanceBackOff moves on to the next backoff interval. advanceBackOff
+// assumes that the caller has locked the backoffLock
+func (cb *breaker) advanceBackOff() {
+	cb.setNextBackOff()
+	atomic.StoreInt32(&cb.backoffAdvanced, 1)
+}
+
+// setNextBackOff stores the next interval of the backoff policy, moved
+// randomly by up to the jitter fraction. setNextBackOff assumes that
+// the caller has locked the backoffLock
+func (cb *breaker) setNextBackOff() {
+	next := cb.backoff.NextBackOff()
+	if jitter := cb.config().jitter; jitter > 0 && next != backoff.Stop {
+		next = time.Duration(float64(next) * (1 + jitter*(2*rand.Float64()-1)))
+	}
+	atomic.StoreInt64(&cb.nextBackOff, int64(next))
+}
+
+// restore loads the state saved in the storage, if any
+func (cb *breaker) restore() {
+	if cb.storage == nil {
+		return
+	}
+
+	st, err := cb.storage.Load()
+	if err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to load breaker state: %s", err)
+		}
+		return
+	}
+	if st == nil {
+		return
+	}
+
+	if st.Broken {
+		atomic.StoreInt32(&cb.broken, 1)
+	}
+	if st.Tripped {
+		atomic.StoreInt32(&cb.tripped, 1)
+	}
+	atomic.StoreInt64(&cb.consecFailures, st.ConsecFailures)
+	if !st.LastFailure.IsZero() {
+		atomic.StoreInt64(&cb.lastFailure, st.LastFailure.UnixNano())
+	}
+	if !st.LastTrip.IsZero() {
+		atomic.StoreInt64(&cb.lastTrip, st.LastTrip.UnixNano())
+	}
+	atomic.StoreInt64(&cb.longestOpen, int64(st.LongestOpen))
+	atomic.StoreInt64(&cb.openTime, int64(st.OpenTime))
+	atomic.StoreInt64(&cb.trips, st.Trips)
+	cb.counts.Restore(st.Failures, st.Successes)
+}
+
+// save queues the state of the breaker to be saved to the storage.
+// Unless force is true, the state is only saved if the storage
+// interval has elapsed since the last save
+func (cb *breaker) save(force bool) {
+	if cb.storage == nil {
+		return
+	}
+
+	now := cb.clock.Now()
+	last := atomic.LoadInt64(&cb.lastSave)
+	if !force && now.Sub(time.Unix(0, last)) < cb.storageInterval {
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&cb.lastSave, last, now.UnixNano()) && !force {
+		// Somebody else is saving
+		return
+	}
+
+	failures, successes := cb.counts.Counts()
+	st := StoredState{
+		Broken:         atomic.LoadInt32(&cb.broken) == 1,
+		ConsecFailures: atomic.LoadInt64(&cb.consecFailures),
+		Failures:       failures,
+		LastFailure:    cb.LastFailure(),
+		LongestOpen:    time.Duration(atomic.LoadInt64(&cb.longestOpen)),
+		OpenTime:       time.Duration(atomic.LoadInt64(&cb.openTime)),
+		Successes:      successes,
+		Tripped:        cb.Tripped(),
+		Trips:          atomic.LoadInt64(&cb.trips),
+	}
+	if last := atomic.LoadInt64(&cb.lastTrip); last != 0 {
+		st.LastTrip = time.Unix(0, last)
+	}
+	cb.saver.save(&st)
+}
+
+// save queues the state, and starts saving in the background unless
+// a save is already in progress
+func (s *stateSaver) save(st *StoredState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending = st
+	if !s.running {
+		s.running = true
+		go s.run()
+	}
+}
+
+// run saves the queued states until there is none left
+func (s *stateSaver) run() {
+	for {
+		s.mutex.Lock()
+		st := s.pending
+		s.pending = nil
+		if st == nil {
+			s.running = false
+			s.mutex.Unlock()
+			return
+		}
+		s.mutex.Unlock()
+
+		if err := s.storage.Save(st); err != nil {
+			if pdebug.Enabled {
+				pdebug.Printf("failed to save breaker state: %s", err)
+			}
+		}
+	}
+}
+
+func (cb *breaker) addListener(l listener) {
+	cb.listenersLock.Lock()
+	cb.listeners = append(cb.listeners, l)
+	cb.listenersLock.Unlock()
+}
+
+func (cb *breaker) removeListener(l listener) {
+	cb.listenersLock.Lock()
+	defer cb.listenersLock.Unlock()
+	for i, x := range cb.listeners {
+		if x == l {
+			// Copy so that notifications in progress are not affected
+			listeners := make([]listener, 0, len(cb.listeners)-1)
+			listeners = append(listeners, cb.listeners[:i]...)
+			cb.listeners = append(listeners, cb.listeners[i+1:]...)
+			return
+		}
+	}
+}
+
+func (cb *breaker) notifyFail(st State, err error) {
+	cb.listenersLock.RLock()
+	defer cb.listenersLock.RUnlock()
+	for _, l := range cb.listeners {
+		l.onFail(st, err)
+	}
+}
+
+func (cb *breaker) notifySuccess(st State) {
+	cb.listenersLock.RLock()
+	defer cb.listenersLock.RUnlock()
+	for _, l := range cb.listeners {
+		l.onSuccess(st)
+	}
+}
+
+func (cb *breaker) notifyStateChange(from, to State, err error) {
+	cb.changeLock.Lock()
+	if cb.changed != nil {
+		close(cb.changed)
+		cb.changed = nil
+	}
+	cb.changeLock.Unlock()
+
+	if cb.transitions != nil {
+		cb.transitions.add(Transition{
+			From: from,
+			To:   to,
+			Time: cb.clock.Now(),
+			Err:  err,
+		})
+	}
+
+	cb.listenersLock.RLock()
+	defer cb.listenersLock.RUnlock()
+	for _, l := range cb.listeners {
+		l.onStateChange(from, to, err)
+	}
+}
+
+// add records a transition, overwriting the oldest one if the log
+// is full
+func (l *transitionLog) add(t Transition) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.entries) < l.size {
+		l.entries = append(l.entries, t)
+		return
+	}
+	l.entries[l.next] = t
+	l.next = (l.next + 1) % l.size
+}
+
+// list returns a copy of the transitions, from the oldest to the most
+// recent one
+func (l *transitionLog) list() []Transition {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	list := make([]Transition, 0, len(l.entries))
+	list = append(list, l.entries[l.next:]...)
+	return append(list, l.entries[:l.next]...)
 }
@@ -2,24 +2,36 @@ package breaker_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/cenk/backoff"
 	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
 	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/fbclock"
 	"github.com/stretchr/testify/assert"
 )
 
 func defaultBackOff(c breaker.Clock) backoff.BackOff {
 	bo := backoff.NewExponentialBackOff()
 	bo.InitialInterval = time.Millisecond
+	// Keep the intervals predictable, so that tests can advance
+	// the clock past the interval used by the breaker
+	bo.RandomizationFactor = 0
 	bo.Clock = c
 	bo.Reset()
 	return bo
 }
 
-func newBreaker(options ...breaker.Option) breaker.Breaker {
+func newBreaker(options ...breaker.BreakerOption) breaker.Breaker {
 	var c breaker.Clock
 	var bo backoff.BackOff
 	for _, option := range options {
@@ -69,6 +81,48 @@ func TestErrorRate(t *testing.T) {
 	}
 }
 
+func TestErrorRateSince(t *testing.T) {
+	c := clock.NewMock()
+	fast := breaker.WindowedRateTripper(0.5, 2, 5*time.Second)
+	cb := newBreaker(
+		breaker.WithClock(c),
+		breaker.WithWindowTime(time.Minute),
+		breaker.WithWindowBuckets(60),
+		breaker.WithTripper(breaker.NilTripper),
+	)
+
+	fail := breaker.CircuitFunc(func() error { return errors.New("error") })
+	succeed := breaker.CircuitFunc(func() error { return nil })
+	for i := 0; i < 6; i++ {
+		cb.Call(succeed)
+	}
+	c.Add(30 * time.Second)
+	cb.Call(fail)
+	cb.Call(fail)
+
+	if !assert.Equal(t, 0.25, cb.ErrorRate(), "error rate should cover the whole window") {
+		return
+	}
+	if !assert.Equal(t, 1.0, cb.ErrorRateSince(5*time.Second), "recent error rate should only cover recent calls") {
+		return
+	}
+	if !assert.True(t, fast.Trip(cb), "windowed tripper should use the recent error rate") {
+		return
+	}
+	if !assert.False(t, breaker.RateTripper(0.5, 2).Trip(cb), "rate tripper should use the whole window") {
+		return
+	}
+
+	c.Add(45 * time.Second)
+	f, s := cb.CountsSince(time.Hour)
+	if !assert.Equal(t, []int64{2, 0}, []int64{f, s}, "counts should not go beyond the window") {
+		return
+	}
+	if !assert.Equal(t, 0.0, cb.ErrorRateSince(5*time.Second), "expired calls should not be counted") {
+		return
+	}
+}
+
 func TestBreakerEvents(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -90,7 +144,7 @@ func TestBreakerEvents(t *testing.T) {
 		t.Fatalf("expected to receive a trip event, got %d", e)
 	}
 
-	c.Add(bo.NextBackOff() + time.Second)
+	c.Add(bo.NextBackOff() + 1)
 	cb.Ready()
 	if e := <-s.C; e != breaker.ReadyEvent {
 		t.Fatalf("expected to receive a breaker ready event, got %d", e)
@@ -101,3 +155,1882 @@ func TestBreakerEvents(t *testing.T) {
 		t.Fatalf("expected to receive a reset event, got %d", e)
 	}
 }
+
+func TestMap(t *testing.T) {
+	m := breaker.NewMap()
+
+	created := 0
+	factory := func() breaker.Breaker {
+		created++
+		return newBreaker()
+	}
+
+	cb := m.GetOrCreate("foo", factory)
+	if !assert.Equal(t, cb, m.GetOrCreate("foo", factory), "GetOrCreate should return the same breaker") {
+		return
+	}
+	if !assert.Equal(t, 1, created, "factory should be called once") {
+		return
+	}
+
+	m.Set("bar", newBreaker())
+	m.Set("baz", newBreaker())
+	if !assert.Equal(t, 3, m.Len(), "Len should be 3") {
+		return
+	}
+
+	names := map[string]struct{}{}
+	m.Range(func(name string, cb breaker.Breaker) bool {
+		names[name] = struct{}{}
+		m.Delete(name)
+		return true
+	})
+	if !assert.Len(t, names, 3, "Range should visit all breakers") {
+		return
+	}
+	if !assert.Equal(t, 0, m.Len(), "all breakers should be deleted") {
+		return
+	}
+
+	m.Set("foo", newBreaker())
+	m.Set("bar", newBreaker())
+	visited := 0
+	m.Range(func(string, breaker.Breaker) bool {
+		visited++
+		return false
+	})
+	if !assert.Equal(t, 1, visited, "Range should stop when false is returned") {
+		return
+	}
+}
+
+func TestOptionShims(t *testing.T) {
+	options := []breaker.Option{
+		breaker.WithClock(breaker.SystemClock),
+		breaker.WithContext(context.Background()),
+		breaker.WithTimeout(time.Second),
+	}
+
+	bopts := breaker.BreakerOptions(options...)
+	if !assert.Len(t, bopts, 2, "WithContext should be dropped") {
+		return
+	}
+	if !assert.Equal(t, "Clock", bopts[0].Name(), "first option should be Clock") {
+		return
+	}
+
+	copts := breaker.CallOptions(options...)
+	if !assert.Len(t, copts, 2, "WithClock should be dropped") {
+		return
+	}
+	if !assert.Equal(t, "Context", copts[0].Name(), "first option should be Context") {
+		return
+	}
+
+	cb := breaker.New(bopts...)
+	if !assert.NoError(t, cb.Call(breaker.CircuitFunc(func() error { return nil }), copts...), "Call should succeed") {
+		return
+	}
+}
+
+func TestBreakerEventData(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := breaker.NewEventEmitter(newBreaker(
+		breaker.WithClock(c),
+		breaker.WithName("backend"),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	))
+	defer cb.Close()
+
+	s := cb.Subscribe(ctx)
+	defer s.Stop()
+
+	circuitErr := errors.New("error")
+	go cb.Call(breaker.CircuitFunc(func() error {
+		return circuitErr
+	}))
+
+	data := <-s.Data
+	if !assert.Equal(t, breaker.FailEvent, data.Event, "expected to receive a fail event") {
+		return
+	}
+	if !assert.Equal(t, breaker.Closed, data.From, "breaker should have been closed") {
+		return
+	}
+	if !assert.Equal(t, circuitErr, data.Err, "event should carry the triggering error") {
+		return
+	}
+	if !assert.Equal(t, int64(1), data.Counts.Failures, "event should carry the failure count") {
+		return
+	}
+	if !assert.Equal(t, "backend", data.Name, "event should carry the name of the breaker") {
+		return
+	}
+	if !assert.Equal(t, "backend", cb.Name(), "emitter should report the name of the breaker") {
+		return
+	}
+	if !assert.True(t, c.Now().Equal(data.Time), "event should be stamped with the clock of the breaker") {
+		return
+	}
+	if !assert.True(t, cb.LastFailure().Equal(data.Time), "event time should match the last failure") {
+		return
+	}
+
+	// Events sent directly carry the time of the breaker clock too
+	c.Add(time.Minute)
+	cb.Events() <- breaker.ResetEvent
+	data = <-s.Data
+	if !assert.True(t, c.Now().Equal(data.Time), "event should be stamped with the clock of the breaker") {
+		return
+	}
+}
+
+func TestEmitterLifecycle(t *testing.T) {
+	cb := breaker.NewEventEmitter(newBreaker())
+
+	// Events that nobody is subscribed to are dropped
+	cb.Trip()
+	cb.Reset()
+
+	s := cb.Subscribe(context.Background())
+	cb.Trip()
+	if e := <-s.C; !assert.Equal(t, breaker.TrippedEvent, e, "subscription should receive events without calling Emit") {
+		return
+	}
+	s.Stop()
+	<-s.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s = cb.Subscribe(ctx)
+	cancel()
+	<-s.Done()
+
+	s = cb.Subscribe(context.Background())
+	if !assert.NoError(t, cb.Close(), "Close should succeed") {
+		return
+	}
+	<-s.Done()
+
+	s = cb.Subscribe(context.Background())
+	select {
+	case <-s.Done():
+	default:
+		t.Errorf("subscriptions to a closed emitter should be stopped")
+	}
+}
+
+func TestSubscribeFunc(t *testing.T) {
+	cb := breaker.NewEventEmitter(newBreaker())
+	defer cb.Close()
+
+	received := make(chan breaker.Event)
+	s := cb.SubscribeFunc(context.Background(), func(e breaker.Event) {
+		received <- e
+	}, breaker.WithLossless(true))
+	defer s.Stop()
+
+	cb.Trip()
+	cb.Reset()
+	for _, expected := range []breaker.Event{breaker.TrippedEvent, breaker.ResetEvent} {
+		if !assert.Equal(t, expected, <-received, "callback should receive the events in order") {
+			return
+		}
+	}
+}
+
+type wrappedBreaker struct {
+	breaker.Breaker
+}
+
+func TestMapEmitter(t *testing.T) {
+	m := breaker.NewMap()
+	m.Set("a", newBreaker())
+	me := breaker.NewMapEmitter(m)
+	defer me.Close()
+	me.Set("b", newBreaker())
+	me.Set("c", wrappedBreaker{newBreaker()})
+
+	s := me.Subscribe(context.Background(), breaker.WithBufferSize(4))
+	defer s.Stop()
+	next := func() breaker.EventData {
+		for {
+			switch data := <-s.Data; data.Event {
+			case breaker.StateChangeEvent, breaker.AddedEvent, breaker.RemovedEvent:
+			default:
+				return data
+			}
+		}
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		cb, _ := me.Get(name)
+		cb.Trip()
+		data := next()
+		if !assert.Equal(t, breaker.TrippedEvent, data.Event, "events of %s should be delivered", name) {
+			return
+		}
+		if !assert.Equal(t, name, data.Name, "events should be tagged with the name of the breaker") {
+			return
+		}
+	}
+
+	b, _ := me.Get("b")
+	me.Delete("b")
+	b.Reset()
+	a, _ := me.Get("a")
+	a.Reset()
+	if data := next(); !assert.Equal(t, "a", data.Name, "events of deleted breakers should not be delivered") {
+		return
+	}
+}
+
+func TestMapEmitterWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := breaker.NewMap()
+	m.Set("a", newBreaker())
+	me := breaker.NewMapEmitter(m)
+	defer me.Close()
+
+	added := make(chan string, 4)
+	removed := make(chan string, 4)
+	me.Watch(ctx, func(name string, cb breaker.Breaker) {
+		added <- name
+	}, func(name string) {
+		removed <- name
+	})
+	if !assert.Equal(t, "a", <-added, "existing breakers should be reported") {
+		return
+	}
+
+	me.Set("b", newBreaker())
+	if !assert.Equal(t, "b", <-added, "stored breakers should be reported") {
+		return
+	}
+	me.GetOrCreate("c", func() breaker.Breaker { return newBreaker() })
+	if !assert.Equal(t, "c", <-added, "created breakers should be reported") {
+		return
+	}
+	me.Get("c")
+	me.Delete("b")
+	if !assert.Equal(t, "b", <-removed, "deleted breakers should be reported") {
+		return
+	}
+	if !assert.Len(t, added, 0, "looking up breakers should not report them again") {
+		return
+	}
+}
+
+func TestSubscriptionOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cb := breaker.NewEventEmitter(newBreaker())
+	go cb.Emit(ctx)
+	<-cb.Emitting()
+
+	t.Run("Buffered", func(t *testing.T) {
+		s := cb.Subscribe(ctx, breaker.WithBufferSize(2))
+		defer s.Stop()
+
+		// Nobody is receiving yet, so both events must be buffered
+		cb.Trip()
+		for _, expected := range []breaker.Event{breaker.TrippedEvent, breaker.StateChangeEvent} {
+			if !assert.Equal(t, expected, (<-s.Data).Event, "expected buffered event") {
+				return
+			}
+		}
+		cb.Reset()
+		for _, expected := range []breaker.Event{breaker.ResetEvent, breaker.StateChangeEvent} {
+			if !assert.Equal(t, expected, (<-s.Data).Event, "expected buffered event") {
+				return
+			}
+		}
+	})
+	t.Run("Lossless", func(t *testing.T) {
+		s := cb.Subscribe(ctx, breaker.WithLossless(true))
+		defer s.Stop()
+
+		// Generate more events than the emitter can queue before
+		// the subscriber starts receiving
+		const rounds = 20
+		go func() {
+			for i := 0; i < rounds; i++ {
+				cb.Trip()
+				cb.Reset()
+			}
+		}()
+
+		time.Sleep(100 * time.Millisecond)
+		for i := 0; i < rounds; i++ {
+			for _, expected := range []breaker.Event{breaker.TrippedEvent, breaker.StateChangeEvent, breaker.ResetEvent, breaker.StateChangeEvent} {
+				if !assert.Equal(t, expected, (<-s.Data).Event, "expected event in round %d", i) {
+					return
+				}
+			}
+		}
+	})
+}
+
+func TestCallWithRetry(t *testing.T) {
+	policy := func() backoff.BackOff {
+		return backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Millisecond), 5)
+	}
+
+	t.Run("Success after retries", func(t *testing.T) {
+		cb := newBreaker(breaker.WithTripper(breaker.ConsecutiveTripper(10)))
+
+		var calls int
+		err := breaker.CallWithRetry(cb, breaker.CircuitFunc(func() error {
+			calls++
+			if calls < 3 {
+				return errors.New("error")
+			}
+			return nil
+		}), policy())
+		if !assert.NoError(t, err, "call should eventually succeed") {
+			return
+		}
+		if !assert.Equal(t, 3, calls, "circuit should be called until it succeeds") {
+			return
+		}
+	})
+	t.Run("Policy exhausted", func(t *testing.T) {
+		cb := newBreaker(breaker.WithTripper(breaker.ConsecutiveTripper(10)))
+
+		var calls int
+		err := breaker.CallWithRetry(cb, breaker.CircuitFunc(func() error {
+			calls++
+			return errors.New("error")
+		}), policy())
+		if !assert.Error(t, err, "call should fail") {
+			return
+		}
+		if !assert.Equal(t, 6, calls, "circuit should be called once, then retried 5 times") {
+			return
+		}
+	})
+	t.Run("Stop when the breaker opens", func(t *testing.T) {
+		cb := newBreaker(breaker.WithTripper(breaker.ConsecutiveTripper(2)))
+
+		var calls int
+		err := breaker.CallWithRetry(cb, breaker.CircuitFunc(func() error {
+			calls++
+			return errors.New("error")
+		}), policy())
+		if !assert.Error(t, err, "call should fail") {
+			return
+		}
+		if !assert.Equal(t, 2, calls, "retries should stop once the breaker trips") {
+			return
+		}
+	})
+	t.Run("Permanent error", func(t *testing.T) {
+		cb := newBreaker(breaker.WithTripper(breaker.ConsecutiveTripper(10)))
+
+		permanent := errors.New("permanent")
+		var calls int
+		err := breaker.CallWithRetry(cb, breaker.CircuitFunc(func() error {
+			calls++
+			return backoff.Permanent(permanent)
+		}), policy())
+		if !assert.Equal(t, permanent, err, "permanent error should be unwrapped") {
+			return
+		}
+		if !assert.Equal(t, 1, calls, "permanent errors should not be retried") {
+			return
+		}
+
+		calls = 0
+		err = breaker.CallWithRetry(cb, breaker.CircuitFunc(func() error {
+			calls++
+			return fmt.Errorf("context: %w", backoff.Permanent(permanent))
+		}), policy())
+		if !assert.Equal(t, permanent, err, "wrapped permanent error should be unwrapped") {
+			return
+		}
+		if !assert.Equal(t, 1, calls, "wrapped permanent errors should not be retried") {
+			return
+		}
+	})
+	t.Run("Clock of the breaker", func(t *testing.T) {
+		c := clock.NewMock()
+		cb := breaker.NewEventEmitter(newBreaker(
+			breaker.WithClock(fbclock.New(c)),
+			breaker.WithTripper(breaker.ConsecutiveTripper(10)),
+		))
+		defer cb.Close()
+
+		var calls int32
+		done := make(chan error)
+		go func() {
+			done <- breaker.CallWithRetry(cb, breaker.CircuitFunc(func() error {
+				if atomic.AddInt32(&calls, 1) < 2 {
+					return errors.New("error")
+				}
+				return nil
+			}), backoff.NewConstantBackOff(time.Hour))
+		}()
+
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case err := <-done:
+				if !assert.NoError(t, err, "call should succeed once the clock moves") {
+					return
+				}
+				if !assert.Equal(t, int32(2), atomic.LoadInt32(&calls), "circuit should be retried once") {
+					return
+				}
+				return
+			case <-timeout:
+				assert.Fail(t, "retry should be driven by the clock of the breaker")
+				return
+			default:
+				c.Add(time.Hour)
+			}
+		}
+	})
+}
+
+func TestAllow(t *testing.T) {
+	cb := newBreaker(breaker.WithTripper(breaker.ThresholdTripper(1)))
+
+	done, err := cb.Allow()
+	if !assert.NoError(t, err, "closed breaker should allow the operation") {
+		return
+	}
+	done(true)
+	done(false) // only the first report counts
+	if !assert.Equal(t, int64(1), cb.Successes(), "success should be recorded") {
+		return
+	}
+	if !assert.Equal(t, int64(0), cb.Failures(), "second report should be ignored") {
+		return
+	}
+
+	done, err = cb.Allow()
+	if !assert.NoError(t, err, "closed breaker should allow the operation") {
+		return
+	}
+	done(false)
+	if !assert.True(t, cb.Tripped(), "failure should trip the breaker") {
+		return
+	}
+
+	_, err = cb.Allow()
+	if !assert.True(t, breaker.IsOpen(err), "open breaker should reject the operation") {
+		return
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	c := clock.NewMock()
+	cb := newBreaker(
+		breaker.WithClock(c),
+		breaker.WithTripper(breaker.ThresholdTripper(5)),
+	)
+
+	fail := breaker.CircuitFunc(func() error { return errors.New("error") })
+	cb.Call(fail)
+	cb.Call(fail)
+
+	err := cb.Reconfigure(
+		breaker.WithTripper(breaker.ThresholdTripper(3)),
+		breaker.WithBackOff(backoff.NewConstantBackOff(time.Minute)),
+	)
+	if !assert.NoError(t, err, "Reconfigure should succeed") {
+		return
+	}
+	if !assert.Equal(t, int64(2), cb.Failures(), "counters should be kept") {
+		return
+	}
+
+	cb.Call(fail)
+	if !assert.True(t, cb.Tripped(), "breaker should trip at the new threshold") {
+		return
+	}
+	if !assert.Equal(t, c.Now().Add(time.Minute), cb.RetryAt(), "breaker should use the new backoff") {
+		return
+	}
+
+	err = cb.Reconfigure(
+		breaker.WithTimeout(time.Second),
+		breaker.WithWindowSize(10),
+	)
+	if !assert.Error(t, err, "options that can't be changed should be rejected") {
+		return
+	}
+
+	// The timeout is only observable with a real clock
+	cb = newBreaker()
+	if !assert.NoError(t, cb.Reconfigure(breaker.WithTimeout(10*time.Millisecond)), "Reconfigure should succeed") {
+		return
+	}
+	block := make(chan struct{})
+	defer close(block)
+	err = cb.Call(breaker.CircuitFunc(func() error {
+		<-block
+		return nil
+	}))
+	if !assert.True(t, breaker.IsTimeout(err), "call should use the new timeout") {
+		return
+	}
+}
+
+func TestTransitions(t *testing.T) {
+	if !assert.Nil(t, newBreaker().Transitions(), "transitions should not be kept by default") {
+		return
+	}
+
+	c := clock.NewMock()
+	cb := newBreaker(
+		breaker.WithClock(c),
+		breaker.WithEventHistory(2),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+
+	circuitErr := errors.New("error")
+	cb.Call(breaker.CircuitFunc(func() error { return circuitErr }))
+	c.Add(time.Second)
+	cb.Reset()
+	c.Add(time.Second)
+	cb.Trip()
+
+	list := cb.Transitions()
+	if !assert.Len(t, list, 2, "only the last transitions should be kept") {
+		return
+	}
+	if !assert.Equal(t, breaker.Transition{From: breaker.Open, To: breaker.Closed, Time: c.Now().Add(-time.Second)}, list[0], "oldest transition should be the reset") {
+		return
+	}
+	if !assert.Equal(t, breaker.Transition{From: breaker.Closed, To: breaker.Open, Time: c.Now()}, list[1], "most recent transition should be the trip") {
+		return
+	}
+
+	cb = newBreaker(
+		breaker.WithEventHistory(10),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+	cb.Call(breaker.CircuitFunc(func() error { return circuitErr }))
+	list = cb.Transitions()
+	if !assert.Len(t, list, 1, "trip should be recorded") {
+		return
+	}
+	if !assert.Equal(t, circuitErr, list[0].Err, "transition should carry the triggering error") {
+		return
+	}
+}
+
+type testLogger struct {
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestLogger(t *testing.T) {
+	c := clock.NewMock()
+	var l testLogger
+	cb := newBreaker(
+		breaker.WithBackOff(backoff.NewConstantBackOff(time.Second)),
+		breaker.WithClock(c),
+		breaker.WithLogger(&l),
+		breaker.WithName("backend"),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+
+	fail := breaker.CircuitFunc(func() error { return errors.New("boom") })
+	cb.Call(fail)
+	c.Add(2 * time.Second)
+	cb.Call(fail)
+	c.Add(2 * time.Second)
+	cb.Call(breaker.CircuitFunc(func() error { return nil }))
+
+	if !assert.Equal(t, []string{
+		`breaker "backend": breaker tripped: boom`,
+		`breaker "backend": letting half-open probes through`,
+		`breaker "backend": probe failed, breaker reopened: boom`,
+		`breaker "backend": letting half-open probes through`,
+		`breaker "backend": breaker reset`,
+	}, l.lines, "transitions should be logged") {
+		return
+	}
+}
+
+type recordHandler struct {
+	records []slog.Record
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	h.records = append(h.records, r)
+	return nil
+}
+
+func recordAttrs(r slog.Record) map[string]string {
+	attrs := make(map[string]string)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+	return attrs
+}
+
+func TestLoggingBreaker(t *testing.T) {
+	for name, wrap := range map[string]func(breaker.Breaker) breaker.Breaker{
+		"core":    func(cb breaker.Breaker) breaker.Breaker { return cb },
+		"wrapped": func(cb breaker.Breaker) breaker.Breaker { return breaker.NewEventEmitter(cb) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var h recordHandler
+			cb := breaker.NewLoggingBreaker(wrap(newBreaker(
+				breaker.WithClock(clock.NewMock()),
+				breaker.WithName("backend"),
+				breaker.WithTripper(breaker.ThresholdTripper(1)),
+			)), &h)
+
+			cb.Call(breaker.CircuitFunc(func() error { return errors.New("boom") }))
+			cb.Call(breaker.CircuitFunc(func() error { return nil }))
+			cb.Reset()
+
+			if !assert.Len(t, h.records, 3, "trip, rejection and reset should be logged") {
+				return
+			}
+
+			trip := h.records[0]
+			if !assert.Equal(t, slog.LevelWarn, trip.Level, "trip should be logged as a warning") {
+				return
+			}
+			attrs := recordAttrs(trip)
+			if !assert.Equal(t, "backend", attrs["breaker"], "record should carry the name") {
+				return
+			}
+			if !assert.Equal(t, "open", attrs["to"], "record should carry the new state") {
+				return
+			}
+			if !assert.Equal(t, "boom", attrs["error"], "record should carry the error") {
+				return
+			}
+			if !assert.Equal(t, "1", attrs["failures"], "record should carry the counts") {
+				return
+			}
+
+			rejected := h.records[1]
+			if !assert.Equal(t, "breaker rejected call", rejected.Message, "rejection should be logged") {
+				return
+			}
+			if !assert.Equal(t, slog.LevelDebug, rejected.Level, "rejection should be logged at the debug level") {
+				return
+			}
+
+			if !assert.Equal(t, "closed", recordAttrs(h.records[2])["to"], "reset should be logged") {
+				return
+			}
+		})
+	}
+}
+
+type ctxKey struct{}
+
+func TestCallContext(t *testing.T) {
+	// Ignore failures of calls that were marked as unimportant
+	important := breaker.TripContextFunc(func(ctx context.Context, cb breaker.Breaker) bool {
+		return ctx.Value(ctxKey{}) == nil
+	})
+	cb := newBreaker(breaker.WithTripper(breaker.AllTripper(important, breaker.ThresholdTripper(1))))
+
+	var received interface{}
+	circuit := breaker.CircuitContextFunc(func(ctx context.Context) error {
+		received = ctx.Value(ctxKey{})
+		return errors.New("error")
+	})
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "unimportant")
+	cb.Call(circuit, breaker.WithContext(ctx))
+	if !assert.Equal(t, "unimportant", received, "circuit should receive the context of the call") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "tripper should receive the context of the call") {
+		return
+	}
+
+	cb.Call(circuit)
+	if !assert.True(t, cb.Tripped(), "failures of other calls should trip the breaker") {
+		return
+	}
+}
+
+func TestDeadlineTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	circuit := breaker.CircuitFunc(func() error {
+		<-block
+		return nil
+	})
+
+	cb := newBreaker(breaker.WithTripper(breaker.ThresholdTripper(2)))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := cb.Call(circuit, breaker.WithContext(ctx), breaker.WithTimeout(time.Hour))
+	if !assert.True(t, breaker.IsDeadlineExceeded(err), "context deadline should be reached first") {
+		return
+	}
+	if !assert.False(t, breaker.IsTimeout(err), "breaker timeout should not be reported") {
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	err = cb.Call(circuit, breaker.WithContext(ctx), breaker.WithTimeout(10*time.Millisecond))
+	if !assert.True(t, breaker.IsTimeout(err), "breaker timeout should be reached first") {
+		return
+	}
+	if !assert.False(t, breaker.IsDeadlineExceeded(err), "context deadline should not be reported") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "both limits should be recorded as failures") {
+		return
+	}
+
+	// The time left until the deadline follows the clock of the breaker
+	c := clock.NewMock()
+	c.Add(time.Since(c.Now()) + 30*time.Minute)
+	cb = newBreaker(breaker.WithClock(fbclock.New(c)))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- cb.Call(circuit, breaker.WithContext(ctx), breaker.WithTimeout(2*time.Hour))
+	}()
+	for i := 0; i < 45; i++ {
+		select {
+		case err := <-errs:
+			if !assert.True(t, breaker.IsDeadlineExceeded(err), "context deadline should be reached on the clock of the breaker") {
+				return
+			}
+			return
+		case <-time.After(time.Millisecond):
+			c.Add(time.Minute)
+		}
+	}
+	t.Errorf("the deadline should be reached on the clock of the breaker")
+}
+
+func TestSentinelTripper(t *testing.T) {
+	var unhealthy int32
+	healthy := func() bool { return atomic.LoadInt32(&unhealthy) == 0 }
+	cb := newBreaker(breaker.WithTripper(breaker.SentinelTripper(healthy, breaker.ThresholdTripper(3))))
+
+	fail := breaker.CircuitFunc(func() error { return errors.New("error") })
+	cb.Call(fail)
+	if !assert.False(t, cb.Tripped(), "breaker should not trip while the backend is healthy") {
+		return
+	}
+	atomic.StoreInt32(&unhealthy, 1)
+	cb.Call(fail)
+	if !assert.True(t, cb.Tripped(), "breaker should trip once the backend is unhealthy") {
+		return
+	}
+
+	cb = newBreaker(breaker.WithTripper(breaker.SentinelTripper(healthy, nil)))
+	signals := make(chan bool)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		breaker.WatchHealth(context.Background(), cb, signals)
+	}()
+	signals <- true
+	signals <- false
+	close(signals)
+	<-done
+	if !assert.Equal(t, breaker.Open, cb.State(), "unhealthy signals should open the breaker") {
+		return
+	}
+
+	// Unhealthy signals push the next probe out, without going through
+	// the half-open state
+	c := clock.NewMock()
+	cb = newBreaker(breaker.WithClock(c), breaker.WithEventHistory(10))
+	cb.Trip()
+	c.Add(time.Second)
+	signals = make(chan bool)
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		breaker.WatchHealth(context.Background(), cb, signals)
+	}()
+	signals <- false
+	close(signals)
+	<-done
+	if !assert.Len(t, cb.Transitions(), 1, "only the trip should be recorded") {
+		return
+	}
+	if !assert.Equal(t, breaker.Open, cb.Snapshot().State, "the probe should wait for the next interval") {
+		return
+	}
+}
+
+func TestAdaptiveBreaker(t *testing.T) {
+	a := breaker.NewAdaptiveBreaker(newBreaker(),
+		breaker.WithInitialLimit(1),
+		breaker.WithLimitBounds(1, 4),
+		breaker.WithDecreaseFactor(0.5),
+	)
+
+	started := make(chan struct{})
+	block := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.Call(breaker.CircuitFunc(func() error {
+			close(started)
+			<-block
+			return nil
+		}))
+	}()
+	<-started
+	err := a.Call(breaker.CircuitFunc(func() error { return nil }))
+	if !assert.True(t, breaker.IsTooManyConcurrent(err), "calls over the limit should be rejected") {
+		return
+	}
+	close(block)
+	<-done
+	if !assert.Equal(t, 2, a.Limit(), "successes should raise the limit while it is in use") {
+		return
+	}
+
+	a.Call(breaker.CircuitFunc(func() error { return errors.New("error") }))
+	if !assert.Equal(t, 1, a.Limit(), "failures should decrease the limit") {
+		return
+	}
+
+	slow := breaker.NewAdaptiveBreaker(newBreaker(),
+		breaker.WithInitialLimit(4),
+		breaker.WithDecreaseFactor(0.5),
+		breaker.WithLatencyThreshold(time.Nanosecond),
+	)
+	slow.Call(breaker.CircuitFunc(func() error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}))
+	if !assert.Equal(t, 2, slow.Limit(), "slow calls should decrease the limit") {
+		return
+	}
+}
+
+func TestDisable(t *testing.T) {
+	cb := newBreaker(
+		breaker.WithDisabled(true),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+	if !assert.True(t, cb.Disabled(), "breaker should be created disabled") {
+		return
+	}
+
+	fail := breaker.CircuitFunc(func() error { return errors.New("error") })
+	cb.Call(fail)
+	if !assert.True(t, cb.Tripped(), "disabled breaker should still trip") {
+		return
+	}
+	err := cb.Call(fail)
+	if !assert.False(t, breaker.IsOpen(err), "disabled breaker should let calls through") {
+		return
+	}
+	if !assert.Equal(t, int64(2), cb.Failures(), "disabled breaker should count outcomes") {
+		return
+	}
+
+	cb.Enable()
+	err = cb.Call(fail)
+	if !assert.True(t, breaker.IsOpen(err), "enabled breaker should reject calls") {
+		return
+	}
+
+	cb.Disable()
+	if !assert.True(t, cb.Disabled(), "breaker should be disabled at runtime") {
+		return
+	}
+}
+
+func TestResetBackoff(t *testing.T) {
+	c := clock.NewMock()
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = 100 * time.Millisecond
+	bo.MaxElapsedTime = time.Second
+	bo.RandomizationFactor = 0
+	bo.Clock = c
+	bo.Reset()
+	cb := newBreaker(breaker.WithClock(c), breaker.WithBackOff(bo))
+	if !assert.Equal(t, backoff.BackOff(bo), cb.Backoff(), "Backoff should return the policy of the breaker") {
+		return
+	}
+
+	// Let the policy give up on the first failed probe
+	c.Add(2 * time.Second)
+	cb.Trip()
+	c.Add(time.Second)
+	cb.Ready()
+	cb.MarkFailure(errors.New("error"))
+	if !assert.True(t, cb.RetryAt().IsZero(), "breaker should not retry once the policy gave up") {
+		return
+	}
+
+	cb.ResetBackoff()
+	if !assert.Equal(t, c.Now().Add(100*time.Millisecond), cb.RetryAt(), "ResetBackoff should start the policy over") {
+		return
+	}
+
+	c.Add(2 * time.Second)
+	cb.Ready()
+	cb.MarkFailure(errors.New("error"))
+	if !assert.True(t, cb.RetryAt().IsZero(), "breaker should not retry once the policy gave up") {
+		return
+	}
+	cb.Reset()
+	cb.Trip()
+	if !assert.Equal(t, c.Now().Add(100*time.Millisecond), cb.RetryAt(), "Reset should start the policy over") {
+		return
+	}
+}
+
+func TestGroup(t *testing.T) {
+	parent := newBreaker()
+	g := breaker.NewGroup(parent, breaker.WithTripFraction(0.5))
+	factory := func() breaker.Breaker {
+		return newBreaker(breaker.WithTripper(breaker.ThresholdTripper(1)))
+	}
+	users := g.GetOrCreate("/users", factory)
+	orders := g.GetOrCreate("/orders", factory)
+
+	if !assert.NoError(t, orders.Call(breaker.CircuitFunc(func() error { return nil })), "call should succeed") {
+		return
+	}
+	users.Call(breaker.CircuitFunc(func() error { return errors.New("error") }))
+	if !assert.Equal(t, int64(1), parent.Failures(), "parent should record the failures of its children") {
+		return
+	}
+	if !assert.True(t, parent.Tripped(), "parent should trip once half of the children are open") {
+		return
+	}
+
+	err := orders.Call(breaker.CircuitFunc(func() error { return nil }))
+	if !assert.True(t, breaker.IsOpen(err), "children should reject calls while the parent is open") {
+		return
+	}
+	if !assert.True(t, orders.Tripped(), "children should look open while the parent is open") {
+		return
+	}
+
+	parent.Reset()
+	if !assert.NoError(t, orders.Call(breaker.CircuitFunc(func() error { return nil })), "children should work again once the parent is reset") {
+		return
+	}
+	if !assert.True(t, users.Tripped(), "open children should stay open") {
+		return
+	}
+
+	cb, ok := g.Get("/orders")
+	if !assert.True(t, ok, "children should be available from the group") {
+		return
+	}
+	parent.Trip()
+	if !assert.Equal(t, breaker.Open, cb.State(), "tripping the parent should open all children") {
+		return
+	}
+}
+
+func TestGroupProbe(t *testing.T) {
+	c := clock.NewMock()
+	g := breaker.NewGroup(newBreaker(breaker.WithClock(c)), breaker.WithTripFraction(1))
+	factory := func() breaker.Breaker {
+		return newBreaker(
+			breaker.WithClock(c),
+			breaker.WithTripper(breaker.ThresholdTripper(1)),
+		)
+	}
+	users := g.GetOrCreate("/users", factory)
+	g.GetOrCreate("/orders", factory)
+
+	users.Call(breaker.CircuitFunc(func() error { return errors.New("error") }))
+	if !assert.True(t, users.Tripped(), "child should trip") {
+		return
+	}
+
+	c.Add(time.Millisecond + 1)
+	if !assert.NoError(t, users.Call(breaker.CircuitFunc(func() error { return nil })), "child due a probe should let the call through") {
+		return
+	}
+	if !assert.False(t, users.Tripped(), "successful probe should close the child") {
+		return
+	}
+
+	users.Trip()
+	c.Add(time.Millisecond + 1)
+	done, err := users.Allow()
+	if !assert.NoError(t, err, "child due a probe should allow the operation") {
+		return
+	}
+	done(true)
+	if !assert.False(t, users.Tripped(), "successful probe should close the child") {
+		return
+	}
+
+	// A child that is not ready must leave the probe of the parent
+	g.Parent().Trip()
+	c.Add(time.Millisecond + 1)
+	users.Trip()
+	if ok, _ := users.Ready(); !assert.False(t, ok, "open child should not be ready") {
+		return
+	}
+	done, err = g.Parent().Allow()
+	if !assert.NoError(t, err, "parent should still be due a probe") {
+		return
+	}
+	done(true)
+}
+
+// plainClock hides the TimerClock methods of the clock it wraps
+type plainClock struct {
+	breaker.Clock
+}
+
+func TestTimerFallback(t *testing.T) {
+	c := plainClock{breaker.SystemClock}
+
+	timer := breaker.NewTimer(c, 10*time.Millisecond)
+	select {
+	case <-timer.C():
+	case <-time.After(time.Second):
+		assert.Fail(t, "timer should fire")
+		return
+	}
+
+	ticker := breaker.NewTicker(c, 10*time.Millisecond)
+	defer ticker.Stop()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ticker.C():
+		case <-time.After(time.Second):
+			assert.Fail(t, "ticker should tick")
+			return
+		}
+	}
+}
+
+func TestTimeoutGoroutines(t *testing.T) {
+	c := clock.NewMock()
+	cb := breaker.New(
+		breaker.WithClock(fbclock.New(c)),
+		breaker.WithTimeout(time.Second),
+	)
+
+	before := runtime.NumGoroutine()
+
+	const calls = 20
+	var canceled int64
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		go func() {
+			errs <- cb.Call(breaker.CircuitContextFunc(func(ctx context.Context) error {
+				<-ctx.Done()
+				atomic.AddInt64(&canceled, 1)
+				return ctx.Err()
+			}))
+		}()
+	}
+
+	// The timers are created concurrently, so keep advancing the
+	// clock until all calls have timed out
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < calls; {
+		select {
+		case err := <-errs:
+			if !assert.True(t, breaker.IsTimeout(err), "calls should time out") {
+				return
+			}
+			i++
+		case <-time.After(time.Millisecond):
+			c.Add(time.Second)
+		case <-timeout:
+			t.Errorf("timed out waiting for the calls to time out")
+			return
+		}
+	}
+
+	// The circuits should have been told to stop, so that no goroutine
+	// is left behind
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before || atomic.LoadInt64(&canceled) < calls {
+		if time.Now().After(deadline) {
+			t.Errorf("%d goroutines left behind, %d circuits canceled", runtime.NumGoroutine()-before, atomic.LoadInt64(&canceled))
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func BenchmarkCounters(b *testing.B) {
+	cb := breaker.New()
+	failure := breaker.CircuitFunc(func() error { return errors.New("error") })
+	for i := 0; i < 1000; i++ {
+		cb.Call(failure)
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cb.Failures()
+			cb.Successes()
+			cb.ErrorRate()
+		}
+	})
+}
+
+func BenchmarkCallWithRateTripper(b *testing.B) {
+	// The rate never reaches 1.1, so that every failure goes
+	// through the tripper without tripping the breaker
+	cb := breaker.New(breaker.WithTripper(breaker.RateTripper(1.1, 0)))
+	failure := breaker.CircuitFunc(func() error { return errors.New("error") })
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cb.Call(failure)
+		}
+	})
+}
+
+func BenchmarkCall(b *testing.B) {
+	cb := breaker.New()
+	success := breaker.CircuitFunc(func() error { return nil })
+
+	b.SetParallelism(128)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cb.Call(success)
+		}
+	})
+}
+
+func BenchmarkCallOpen(b *testing.B) {
+	cb := breaker.New(breaker.WithBackOff(&backoff.StopBackOff{}))
+	cb.Trip()
+	success := breaker.CircuitFunc(func() error { return nil })
+
+	b.SetParallelism(128)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			cb.Call(success)
+		}
+	})
+}
+
+func TestKeyed(t *testing.T) {
+	type request struct {
+		method string
+		fail   bool
+	}
+
+	called := 0
+	fn := func(_ context.Context, req request) (string, error) {
+		called++
+		if req.fail {
+			return "", errors.New("unavailable")
+		}
+		return "reply to " + req.method, nil
+	}
+
+	c := clock.NewMock()
+	breakers := breaker.NewMap()
+	k := breaker.NewKeyed(fn, func(req request) string { return req.method }, breakers, func() breaker.Breaker {
+		return breaker.New(
+			breaker.WithClock(c),
+			breaker.WithTripper(breaker.ConsecutiveTripper(2)),
+		)
+	})
+
+	ctx := context.Background()
+	resp, err := k.Do(ctx, request{method: "Get"})
+	if !assert.NoError(t, err, "Do should succeed") {
+		return
+	}
+	if !assert.Equal(t, "reply to Get", resp, "response should be returned") {
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		k.Do(ctx, request{method: "Put", fail: true})
+	}
+	if !assert.True(t, k.Breaker(request{method: "Put"}).Tripped(), "failures should trip the breaker for the key") {
+		return
+	}
+
+	called = 0
+	_, err = k.Do(ctx, request{method: "Put"})
+	if !assert.True(t, breaker.IsOpen(err), "requests should be rejected while the breaker is open") {
+		return
+	}
+	if !assert.Equal(t, 0, called, "function should not be called while the breaker is open") {
+		return
+	}
+
+	resp, err = k.Do(ctx, request{method: "Get"})
+	if !assert.NoError(t, err, "other keys should not be affected") {
+		return
+	}
+	if !assert.Equal(t, "reply to Get", resp, "response should be returned") {
+		return
+	}
+
+	// The function is canceled when the call times out
+	canceled := make(chan struct{})
+	slow := breaker.NewKeyed(func(ctx context.Context, req request) (string, error) {
+		<-ctx.Done()
+		close(canceled)
+		return "", ctx.Err()
+	}, func(req request) string { return req.method }, breaker.NewMap(), func() breaker.Breaker {
+		return breaker.New(breaker.WithTimeout(time.Millisecond))
+	})
+	if _, err := slow.Do(ctx, request{method: "Get"}); !assert.Error(t, err, "Do should time out") {
+		return
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Errorf("the context of the function should be canceled when the call times out")
+		return
+	}
+
+	unprotected := breaker.NewKeyed(fn, func(req request) string { return req.method }, breaker.NewMap(), nil)
+	if !assert.Nil(t, unprotected.Breaker(request{method: "Get"}), "unknown keys should not be protected without a factory") {
+		return
+	}
+}
+
+func TestExpiringMap(t *testing.T) {
+	t.Run("TTL", func(t *testing.T) {
+		c := clock.NewMock()
+		var evicted []string
+		m := breaker.NewExpiringMap(
+			breaker.WithClock(c),
+			breaker.WithTTL(time.Minute),
+			breaker.WithJanitorInterval(-1),
+			breaker.WithEvictionCallback(func(name string, _ breaker.Breaker) {
+				evicted = append(evicted, name)
+			}),
+		)
+		defer m.Close()
+
+		m.Set("idle", breaker.New())
+		m.Set("used", breaker.New())
+		tripped := breaker.New()
+		tripped.Trip()
+		m.Set("tripped", tripped)
+		m.SetWithTTL("pinned", breaker.New(), 0)
+
+		c.Add(30 * time.Second)
+		m.Get("used")
+		c.Add(45 * time.Second)
+		m.EvictExpired()
+
+		if !assert.Equal(t, []string{"idle"}, evicted, "only the idle breaker should be evicted") {
+			return
+		}
+		for _, name := range []string{"used", "tripped", "pinned"} {
+			_, ok := m.Get(name)
+			if !assert.True(t, ok, "%s should not be evicted", name) {
+				return
+			}
+		}
+
+		c.Add(2 * time.Minute)
+		_, ok := m.Get("used")
+		if !assert.False(t, ok, "expired breakers should not be returned") {
+			return
+		}
+		if !assert.Equal(t, []string{"idle", "used"}, evicted, "expired breakers should be evicted when looked up") {
+			return
+		}
+	})
+
+	t.Run("LRU", func(t *testing.T) {
+		var evicted []string
+		m := breaker.NewExpiringMap(
+			breaker.WithMaxEntries(2),
+			breaker.WithEvictionCallback(func(name string, _ breaker.Breaker) {
+				evicted = append(evicted, name)
+			}),
+		)
+		defer m.Close()
+
+		factory := func() breaker.Breaker { return breaker.New() }
+		m.GetOrCreate("a", factory)
+		m.GetOrCreate("b", factory)
+		m.GetOrCreate("a", factory)
+		m.GetOrCreate("c", factory)
+
+		if !assert.Equal(t, []string{"b"}, evicted, "the least recently used breaker should be evicted") {
+			return
+		}
+		if !assert.Equal(t, 2, m.Len(), "the map should not grow past its maximum size") {
+			return
+		}
+
+		var names []string
+		m.Range(func(name string, _ breaker.Breaker) bool {
+			names = append(names, name)
+			return true
+		})
+		if !assert.Equal(t, []string{"c", "a"}, names, "Range should go from the most to the least recently used breaker") {
+			return
+		}
+	})
+
+	t.Run("Janitor", func(t *testing.T) {
+		c := clock.NewMock()
+		evicted := make(chan string, 1)
+		m := breaker.NewExpiringMap(
+			breaker.WithClock(fbclock.New(c)),
+			breaker.WithTTL(time.Minute),
+			breaker.WithEvictionCallback(func(name string, _ breaker.Breaker) {
+				evicted <- name
+			}),
+		)
+		defer m.Close()
+		m.Set("idle", breaker.New())
+
+		timeout := time.After(5 * time.Second)
+		for {
+			c.Add(time.Minute)
+			select {
+			case name := <-evicted:
+				assert.Equal(t, "idle", name, "the janitor should evict the idle breaker")
+				return
+			case <-time.After(time.Millisecond):
+			case <-timeout:
+				t.Errorf("timed out waiting for the janitor")
+				return
+			}
+		}
+	})
+}
+
+func TestShardedMap(t *testing.T) {
+	m := breaker.NewShardedMap(3)
+	for i := 0; i < 100; i++ {
+		m.Set(fmt.Sprintf("host-%d", i), breaker.New())
+	}
+	if !assert.Equal(t, 100, m.Len(), "all breakers should be stored") {
+		return
+	}
+
+	_, ok := m.Get("host-42")
+	if !assert.True(t, ok, "stored breakers should be found") {
+		return
+	}
+	m.Delete("host-42")
+	_, ok = m.Get("host-42")
+	if !assert.False(t, ok, "deleted breakers should not be found") {
+		return
+	}
+
+	count := 0
+	m.Range(func(string, breaker.Breaker) bool {
+		count++
+		return count < 10
+	})
+	if !assert.Equal(t, 10, count, "Range should stop when the function returns false") {
+		return
+	}
+
+	var created int64
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.GetOrCreate("new", func() breaker.Breaker {
+				atomic.AddInt64(&created, 1)
+				return breaker.New()
+			})
+		}()
+	}
+	wg.Wait()
+	if !assert.Equal(t, int64(1), created, "factory should be called once per name") {
+		return
+	}
+}
+
+func benchmarkMap(b *testing.B, m breaker.Map) {
+	const hosts = 10000
+	names := make([]string, hosts)
+	for i := range names {
+		names[i] = fmt.Sprintf("host-%d.example.com", i)
+		m.Set(names[i], breaker.New())
+	}
+	factory := func() breaker.Breaker { return breaker.New() }
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := rand.Intn(hosts)
+		for pb.Next() {
+			i = (i + 1) % hosts
+			if i%100 == 0 {
+				// Breakers come and go, e.g. when idle ones are evicted
+				m.Delete(names[i])
+				m.GetOrCreate(names[i], factory)
+				continue
+			}
+			m.Get(names[i])
+		}
+	})
+}
+
+func BenchmarkMap(b *testing.B) {
+	b.Run("Simple", func(b *testing.B) {
+		benchmarkMap(b, breaker.NewMap())
+	})
+	b.Run("Sharded", func(b *testing.B) {
+		benchmarkMap(b, breaker.NewShardedMap(0))
+	})
+}
+
+func TestConfigAccessors(t *testing.T) {
+	var tripped int64
+	tripper := breaker.TripFunc(func(breaker.Breaker) bool {
+		atomic.AddInt64(&tripped, 1)
+		return false
+	})
+
+	cb := breaker.NewEventEmitter(breaker.New(
+		breaker.WithTimeout(time.Second),
+		breaker.WithTripper(tripper),
+	))
+	defer cb.Close()
+
+	if !assert.Equal(t, time.Second, cb.DefaultTimeout(), "DefaultTimeout should return the configured timeout") {
+		return
+	}
+	cb.Tripper().Trip(cb)
+	if !assert.Equal(t, int64(1), atomic.LoadInt64(&tripped), "Tripper should return the configured tripper") {
+		return
+	}
+
+	if !assert.NoError(t, cb.Reconfigure(breaker.WithTimeout(2*time.Second)), "Reconfigure should succeed") {
+		return
+	}
+	if !assert.Equal(t, 2*time.Second, cb.DefaultTimeout(), "DefaultTimeout should reflect Reconfigure") {
+		return
+	}
+
+	if !assert.Equal(t, time.Duration(0), breaker.New().DefaultTimeout(), "calls should not time out by default") {
+		return
+	}
+	if !assert.False(t, breaker.New().Tripper().Trip(cb), "the default tripper should never trip") {
+		return
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	cb := breaker.NewEventEmitter(breaker.New())
+	defer cb.Close()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cb.Call(breaker.CircuitFunc(func() error {
+			close(started)
+			<-release
+			return nil
+		}))
+	}()
+	<-started
+	if !assert.Equal(t, int64(1), cb.InFlight(), "running calls should be in flight") {
+		return
+	}
+
+	report, err := cb.Allow()
+	if !assert.NoError(t, err, "Allow should succeed") {
+		return
+	}
+	if !assert.Equal(t, int64(2), cb.InFlight(), "operations let through by Allow should be in flight") {
+		return
+	}
+
+	report(true)
+	report(true)
+	close(release)
+	<-done
+	if !assert.Equal(t, int64(0), cb.InFlight(), "completed calls should not be in flight") {
+		return
+	}
+
+	cb.Trip()
+	cb.Call(breaker.CircuitFunc(func() error { return nil }))
+	if !assert.Equal(t, int64(0), cb.InFlight(), "rejected calls should not be in flight") {
+		return
+	}
+}
+
+func TestStdlibErrors(t *testing.T) {
+	c := clock.NewMock()
+	cb := breaker.New(
+		breaker.WithClock(c),
+		breaker.WithBackOff(defaultBackOff(c)),
+	)
+	cb.Trip()
+
+	err := fmt.Errorf("request failed: %w", cb.Call(breaker.CircuitFunc(func() error { return nil })))
+	if !assert.True(t, errors.Is(err, breaker.ErrBreakerOpen), "errors.Is should see the open error through %%w") {
+		return
+	}
+	if !assert.True(t, breaker.IsOpen(err), "IsOpen should see the open error through %%w") {
+		return
+	}
+	if !assert.False(t, errors.Is(err, breaker.ErrBreakerTimeout), "open errors should not be timeout errors") {
+		return
+	}
+	var oerr *breaker.OpenError
+	if !assert.True(t, errors.As(err, &oerr), "errors.As should find the OpenError") {
+		return
+	}
+	if !assert.Equal(t, breaker.Open, oerr.State(), "OpenError should report the open state") {
+		return
+	}
+
+	err = fmt.Errorf("request failed: %w", breaker.ErrBreakerTimeout)
+	var nerr net.Error
+	if !assert.True(t, errors.As(err, &nerr), "timeout errors should satisfy net.Error") {
+		return
+	}
+	if !assert.True(t, nerr.Timeout(), "timeout errors should report a timeout") {
+		return
+	}
+	if !assert.True(t, breaker.IsTimeout(err), "IsTimeout should see the timeout error through %%w") {
+		return
+	}
+	if !assert.True(t, errors.Is(fmt.Errorf("%w", breaker.ErrTooManyConcurrent), breaker.ErrTooManyConcurrent), "errors.Is should match ErrTooManyConcurrent") {
+		return
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := breaker.New(
+		breaker.WithName("backend"),
+		breaker.WithClock(c),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+		breaker.WithBackOff(backoff.NewConstantBackOff(30*time.Second)),
+	)
+
+	failure := errors.New("connection refused")
+	cb.Call(breaker.CircuitFunc(func() error { return failure }))
+	if !assert.True(t, cb.Tripped(), "breaker should trip") {
+		return
+	}
+	since := c.Now()
+
+	c.Add(10 * time.Second)
+	err := cb.Call(breaker.CircuitFunc(func() error { return nil }))
+	var oerr *breaker.OpenError
+	if !assert.True(t, errors.As(err, &oerr), "rejections should return an OpenError") {
+		return
+	}
+	if !assert.Equal(t, "backend", oerr.Name, "Name should be the name of the breaker") {
+		return
+	}
+	if !assert.Equal(t, failure, oerr.LastError, "LastError should be the error of the last failure") {
+		return
+	}
+	if !assert.True(t, since.Equal(oerr.Since), "Since should be the time the breaker tripped") {
+		return
+	}
+	if !assert.Equal(t, 10*time.Second, oerr.OpenFor, "OpenFor should be the time since the breaker tripped") {
+		return
+	}
+	if !assert.True(t, since.Add(30*time.Second).Equal(oerr.RetryAt), "RetryAt should be when the breaker retries") {
+		return
+	}
+
+	cb.Reset()
+	cb.Trip()
+	err = cb.Call(breaker.CircuitFunc(func() error { return nil }))
+	if !assert.True(t, errors.As(err, &oerr), "rejections should return an OpenError") {
+		return
+	}
+	if !assert.NoError(t, oerr.LastError, "manually tripped breakers should not report a stale failure") {
+		return
+	}
+}
+
+func TestDefault(t *testing.T) {
+	const name = "TestDefault"
+	defer breaker.Default().Delete(name)
+
+	var called bool
+	err := breaker.Call(name, breaker.CircuitFunc(func() error {
+		called = true
+		return nil
+	}))
+	if !assert.NoError(t, err, "Call should succeed") || !assert.True(t, called, "circuit should be called") {
+		return
+	}
+
+	cb, ok := breaker.Default().Get(name)
+	if !assert.True(t, ok, "Call should register the breaker in the default map") {
+		return
+	}
+	if !assert.Equal(t, name, cb.Name(), "breaker should be named after the key") {
+		return
+	}
+
+	cb.Trip()
+	err = breaker.Call(name, breaker.CircuitFunc(func() error { return nil }))
+	if !assert.True(t, breaker.IsOpen(err), "Call should use the registered breaker") {
+		return
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) breaker.Decorator {
+		return breaker.Intercept(breaker.Interceptor{
+			Call: func(next breaker.Breaker, c breaker.Circuit, options ...breaker.CallOption) error {
+				order = append(order, name)
+				return next.Call(c, options...)
+			},
+			Trip: func(next breaker.Breaker) {
+				order = append(order, "trip "+name)
+				next.Trip()
+			},
+		})
+	}
+
+	var reset bool
+	cb := breaker.New()
+	decorated := breaker.Chain(
+		trace("outer"),
+		trace("inner"),
+		breaker.Intercept(breaker.Interceptor{
+			Reset: func(next breaker.Breaker) {
+				// Refuse to reset
+				reset = true
+			},
+		}),
+	)(cb)
+
+	err := decorated.Call(breaker.CircuitFunc(func() error {
+		order = append(order, "circuit")
+		return nil
+	}))
+	if !assert.NoError(t, err, "Call should succeed") {
+		return
+	}
+	if !assert.Equal(t, []string{"outer", "inner", "circuit"}, order, "decorators should be applied from the outermost") {
+		return
+	}
+	if !assert.Equal(t, int64(1), cb.Successes(), "call should reach the breaker") {
+		return
+	}
+
+	order = nil
+	decorated.Trip()
+	if !assert.Equal(t, []string{"trip outer", "trip inner"}, order, "Trip should be intercepted") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "Trip should reach the breaker") {
+		return
+	}
+
+	decorated.Reset()
+	if !assert.True(t, reset, "Reset should be intercepted") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "intercepted Reset should not reach the breaker") {
+		return
+	}
+	if !assert.True(t, decorated.Tripped(), "methods that are not intercepted should be delegated") {
+		return
+	}
+
+	if !assert.Equal(t, cb, breaker.Chain()(cb), "empty chain should return the breaker") {
+		return
+	}
+}
+
+type testObserver struct {
+	events []string
+}
+
+func (o *testObserver) OnFail(st breaker.State, err error) {
+	o.events = append(o.events, fmt.Sprintf("fail %s %v", st, err))
+}
+
+func (o *testObserver) OnSuccess(st breaker.State) {
+	o.events = append(o.events, fmt.Sprintf("success %s", st))
+}
+
+func (o *testObserver) OnStateChange(from, to breaker.State, err error) {
+	o.events = append(o.events, fmt.Sprintf("change %s %s %v", from, to, err))
+}
+
+func TestObserver(t *testing.T) {
+	var o testObserver
+	cb := newBreaker(
+		breaker.WithObserver(&o),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+
+	cb.Call(breaker.CircuitFunc(func() error { return nil }))
+	cb.Call(breaker.CircuitFunc(func() error { return errors.New("boom") }))
+	cb.Reset()
+
+	expected := []string{
+		"success closed",
+		"fail closed boom",
+		"change closed open boom",
+		"change open closed <nil>",
+	}
+	if !assert.Equal(t, expected, o.events, "observer should be notified by the breaker") {
+		return
+	}
+
+	// Observers added through wrappers reach the breaker underneath,
+	// and see the calls that the wrappers do not
+	var wrapped testObserver
+	decorated := breaker.Chain(breaker.LoggingDecorator(&recordHandler{}), breaker.Intercept(breaker.Interceptor{}))(cb)
+	if !assert.True(t, breaker.Observe(decorated, &wrapped), "Observe should find the breaker") {
+		return
+	}
+	cb.Call(breaker.CircuitFunc(func() error { return nil }))
+	if !assert.Equal(t, []string{"success closed"}, wrapped.events, "observer should be notified of calls made on the breaker") {
+		return
+	}
+	if !assert.Len(t, o.events, 5, "observers given to New should still be notified") {
+		return
+	}
+}
+
+func TestLabels(t *testing.T) {
+	if !assert.Nil(t, newBreaker().Labels(), "breakers should have no labels by default") {
+		return
+	}
+
+	labels := map[string]string{"service": "payments", "region": "eu"}
+	cb := newBreaker(
+		breaker.WithName("payments"),
+		breaker.WithLabels(labels),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+	labels["region"] = "us"
+	if !assert.Equal(t, map[string]string{"service": "payments", "region": "eu"}, cb.Labels(), "labels should be copied") {
+		return
+	}
+	cb.Labels()["tier"] = "1"
+	if !assert.Len(t, cb.Labels(), 2, "returned labels should be a copy") {
+		return
+	}
+
+	emitter := breaker.NewEventEmitter(cb)
+	defer emitter.Close()
+	if !assert.Equal(t, cb.Labels(), emitter.Labels(), "emitter should return the labels of the breaker") {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub := emitter.Subscribe(ctx)
+	defer sub.Stop()
+
+	emitter.Trip()
+	for {
+		select {
+		case data := <-sub.Data:
+			if data.Event != breaker.TrippedEvent {
+				continue
+			}
+			if !assert.Equal(t, cb.Labels(), data.Labels, "events should carry the labels") {
+				return
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Errorf("timed out waiting for the trip event")
+			return
+		}
+	}
+}
+
+// slowStorage blocks every save until release is closed
+type slowStorage struct {
+	release chan struct{}
+	saved   chan *breaker.StoredState
+}
+
+func (s *slowStorage) Load() (*breaker.StoredState, error) {
+	return nil, nil
+}
+
+func (s *slowStorage) Save(st *breaker.StoredState) error {
+	<-s.release
+	s.saved <- st
+	return nil
+}
+
+func TestSlowStorage(t *testing.T) {
+	s := &slowStorage{
+		release: make(chan struct{}),
+		saved:   make(chan *breaker.StoredState, 16),
+	}
+	cb := breaker.New(
+		breaker.WithStorage(s),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cb.Call(breaker.CircuitFunc(func() error {
+			return errors.New("boom")
+		}))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(s.release)
+		t.Errorf("Call should not wait for the storage")
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "breaker should be tripped") {
+		return
+	}
+
+	close(s.release)
+	for {
+		select {
+		case st := <-s.saved:
+			if !st.Tripped {
+				continue
+			}
+			return
+		case <-time.After(5 * time.Second):
+			t.Errorf("the tripped state should be saved in the background")
+			return
+		}
+	}
+}
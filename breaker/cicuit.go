@@ -1,6 +1,18 @@
 package breaker
 
+import "context"
+
 // Execute executes the given function
 func (c CircuitFunc) Execute() error {
 	return c()
 }
+
+// Execute executes the given function with context.Background()
+func (c CircuitContextFunc) Execute() error {
+	return c(context.Background())
+}
+
+// ExecuteContext executes the given function with ctx
+func (c CircuitContextFunc) ExecuteContext(ctx context.Context) error {
+	return c(ctx)
+}
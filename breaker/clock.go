@@ -9,3 +9,85 @@ func (c systemClock) After(d time.Duration) <-chan time.Time {
 func (c systemClock) Now() time.Time {
 	return time.Now()
 }
+
+func (c systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{timer: time.NewTimer(d)}
+}
+
+func (c systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{ticker: time.NewTicker(d)}
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t systemTimer) Stop() {
+	t.timer.Stop()
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t systemTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// NewTimer creates a Timer that fires after d according to the clock.
+// If the clock is not a TimerClock, the timer is built on top of
+// After(), and stopping it does not release its resources until it
+// would have fired.
+func NewTimer(c Clock, d time.Duration) Timer {
+	if tc, ok := c.(TimerClock); ok {
+		return tc.NewTimer(d)
+	}
+	return afterTimer{c: c.After(d)}
+}
+
+// NewTicker creates a Ticker that ticks every d according to the
+// clock. If the clock is not a TimerClock, the ticker is built on top
+// of After(), and runs a goroutine until it is stopped.
+func NewTicker(c Clock, d time.Duration) Ticker {
+	if tc, ok := c.(TimerClock); ok {
+		return tc.NewTicker(d)
+	}
+
+	t := &afterTicker{
+		c:    make(chan time.Time, 1),
+		done: make(chan struct{}),
+	}
+	go t.run(c, d)
+	return t
+}
+
+func (t afterTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t afterTimer) Stop() {}
+
+func (t *afterTicker) run(c Clock, d time.Duration) {
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-c.After(d):
+			// Drop ticks for slow receivers, like time.Ticker
+			select {
+			case t.c <- now:
+			default:
+			}
+		}
+	}
+}
+
+func (t *afterTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *afterTicker) Stop() {
+	t.once.Do(func() {
+		close(t.done)
+	})
+}
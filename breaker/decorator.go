@@ -0,0 +1,71 @@
+package breaker
+
+import "log/slog"
+
+// Chain composes the decorators into a single Decorator. The first
+// decorator is the outermost one, i.e. Chain(a, b)(cb) is a(b(cb)),
+// so that it sees calls first
+func Chain(decorators ...Decorator) Decorator {
+	return func(cb Breaker) Breaker {
+		for i := len(decorators) - 1; i >= 0; i-- {
+			cb = decorators[i](cb)
+		}
+		return cb
+	}
+}
+
+// Intercept creates a Decorator that routes Call, Trip and Reset
+// through the functions of the Interceptor, without having to
+// implement the whole Breaker interface
+func Intercept(i Interceptor) Decorator {
+	return func(cb Breaker) Breaker {
+		return &interceptedBreaker{
+			Breaker:     cb,
+			interceptor: i,
+		}
+	}
+}
+
+// LoggingDecorator creates a Decorator that wraps breakers with
+// NewLoggingBreaker
+func LoggingDecorator(h slog.Handler) Decorator {
+	return func(cb Breaker) Breaker {
+		return NewLoggingBreaker(cb, h)
+	}
+}
+
+// EmitterDecorator creates a Decorator that wraps breakers with
+// NewEventEmitter. The resulting breaker can be converted back to an
+// EventEmitter if it is the outermost one
+func EmitterDecorator() Decorator {
+	return func(cb Breaker) Breaker {
+		return NewEventEmitter(cb)
+	}
+}
+
+func (b *interceptedBreaker) unwrap() Breaker {
+	return b.Breaker
+}
+
+func (b *interceptedBreaker) Call(c Circuit, options ...CallOption) error {
+	if b.interceptor.Call == nil {
+		return b.Breaker.Call(c, options...)
+	}
+	return b.interceptor.Call(b.Breaker, c, options...)
+}
+
+func (b *interceptedBreaker) Trip() {
+	if b.interceptor.Trip == nil {
+		b.Breaker.Trip()
+		return
+	}
+	b.interceptor.Trip(b.Breaker)
+}
+
+func (b *interceptedBreaker) Reset() {
+	if b.interceptor.Reset == nil {
+		b.Breaker.Reset()
+		return
+	}
+	b.interceptor.Reset(b.Breaker)
+}
@@ -0,0 +1,22 @@
+package breaker
+
+// Default returns the process wide Map used by Call. Breakers may be
+// added to it with Set before they are first used, in order to
+// configure them, or looked up with Get, e.g. to check their state
+func Default() Map {
+	return defaultMap
+}
+
+// Call executes the circuit through the breaker registered under the
+// given name in the Map returned by Default. If there is no such
+// breaker, one is created with New and WithName, with default
+// settings otherwise.
+//
+// It is meant for small programs that don't want to pass breakers
+// around. Larger programs should create and hold their own breakers
+func Call(name string, circuit Circuit, options ...CallOption) error {
+	cb := defaultMap.GetOrCreate(name, func() Breaker {
+		return New(WithName(name))
+	})
+	return cb.Call(circuit, options...)
+}
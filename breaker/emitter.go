@@ -3,67 +3,370 @@ package breaker
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
+	"time"
 
+	"github.com/lestrrat/go-circuit-breaker/backoff"
 	pdebug "github.com/lestrrat/go-pdebug"
 )
 
 // NewEventEmitter wraps Breaker and creates an EventEmitter
 // (which also satisfies the Breaker interface) that can
 // generate events.
+//
+// If cb is a breaker created by New(), events are generated by the
+// breaker itself, which allows the emitter to report failures and
+// transitions that happen within Call(). Otherwise the emitter
+// guesses the transitions by inspecting the breaker.
 func NewEventEmitter(cb Breaker) EventEmitter {
-	return &eventEmitter{
-		breaker:     cb,
+	e := newFanOut()
+	e.watch(cb)
+	return e
+}
+
+// newFanOut creates an emitter that delivers the events sent to it to
+// its subscribers, without watching a breaker
+func newFanOut() *eventEmitter {
+	e := &eventEmitter{
+		clock:       SystemClock,
+		data:        make(chan EventData, eventQueueSize),
 		emitting:    make(chan struct{}),
 		events:      make(chan Event),
 		subscribers: make(map[string]*EventSubscription),
 	}
+	e.sink = e
+	return e
+}
+
+// watch makes the emitter generate the events of cb
+func (e *eventEmitter) watch(cb Breaker) {
+	e.breaker = cb
+	e.clock = clockOf(cb)
+	if l, ok := listenableOf(cb); ok {
+		l.addListener(e)
+		e.hooked = true
+	}
 }
 
 func (e *eventEmitter) Events() chan Event {
 	return e.events
 }
 
-func emitEvent(e EventEmitter, ev Event) {
+// newEventData creates an EventData, filling in the details that
+// can be obtained from the breaker
+func (e *eventEmitter) newEventData(ev Event, from, to State, err error) EventData {
+	name := e.name
+	if name == "" {
+		name = e.breaker.Name()
+	}
+	return EventData{
+		Event:  ev,
+		Name:   name,
+		Labels: e.breaker.Labels(),
+		From:   from,
+		To:     to,
+		Time:   e.clock.Now(),
+		Counts: Counts{
+			ConsecFailures: e.breaker.ConsecFailures(),
+			ErrorRate:      e.breaker.ErrorRate(),
+			Failures:       e.breaker.Failures(),
+			Successes:      e.breaker.Successes(),
+		},
+		Err: err,
+	}
+}
+
+// detach stops generating the events of the watched breaker, and
+// removes the emitter from its listeners
+func (e *eventEmitter) detach() {
+	atomic.StoreInt32(&e.detached, 1)
+	if !e.hooked {
+		return
+	}
+	if l, ok := listenableOf(e.breaker); ok {
+		l.removeListener(e)
+	}
+}
+
+func (e *eventEmitter) emit(ev Event, from, to State, err error) {
+	if atomic.LoadInt32(&e.detached) == 1 {
+		return
+	}
+	e.sink.send(e.newEventData(ev, from, to, err))
+}
+
+// dataOnly returns true for the events that are only sent over
+// EventSubscription.Data
+func (ev Event) dataOnly() bool {
+	switch ev {
+	case StateChangeEvent, AddedEvent, RemovedEvent:
+		return true
+	}
+	return false
+}
+
+// send queues the event for the fan-out
+func (e *eventEmitter) send(data EventData) {
+	// Only wait for the emitter to catch up if somebody asked for it
+	if atomic.LoadInt32(&e.lossless) > 0 {
+		if stopped := e.fanOut(); stopped != nil {
+			select {
+			case e.data <- data:
+			case <-stopped:
+			}
+			return
+		}
+	}
+
 	select {
-	case e.Events() <- ev:
+	case e.data <- data:
 	default:
 	}
 }
 
+// fanOut returns a channel that is closed when the running fan-out
+// stops, or nil if the fan-out is not running
+func (e *eventEmitter) fanOut() <-chan struct{} {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.stopped
+}
+
+// start starts the fan-out if it is not running. start assumes that
+// the caller has locked the mutex
+func (e *eventEmitter) start() {
+	if e.stopped != nil {
+		return
+	}
+
+	// Events that happened while nobody was subscribed are dropped
+	for drained := false; !drained; {
+		select {
+		case <-e.data:
+		default:
+			drained = true
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	e.cancel = cancel
+	e.stopped = stopped
+	go e.run(ctx, stopped)
+}
+
+func (e *eventEmitter) onFail(st State, err error) {
+	e.emit(FailEvent, st, st, err)
+}
+
+func (e *eventEmitter) onSuccess(State) {}
+
+func (e *eventEmitter) onStateChange(from, to State, err error) {
+	switch to {
+	case Open:
+		if from == Closed {
+			e.emit(TrippedEvent, from, to, err)
+		}
+	case Halfopen:
+		e.emit(HalfopenEvent, from, to, err)
+	case Closed:
+		e.emit(ResetEvent, from, to, err)
+	}
+	e.emit(StateChangeEvent, from, to, err)
+}
+
+// trippedState returns the state of the breaker without causing
+// side effects. The half-open state can not be determined this way,
+// so it is reported as Open
+func trippedState(cb Breaker) State {
+	if cb.Tripped() {
+		return Open
+	}
+	return Closed
+}
+
+func (e *eventEmitter) Allow() (func(bool), error) {
+	if e.hooked {
+		return e.breaker.Allow()
+	}
+	done, err := e.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	return func(success bool) {
+		wasTripped := e.breaker.Tripped()
+		done(success)
+		e.emitOutcome(wasTripped, nil)
+	}, nil
+}
+
+func (e *eventEmitter) Backoff() backoff.BackOff {
+	return e.breaker.Backoff()
+}
+
 func (e *eventEmitter) Break() {
+	if e.hooked {
+		e.breaker.Break()
+		return
+	}
+	from := trippedState(e.breaker)
 	e.breaker.Break()
+	e.emit(TrippedEvent, from, Open, nil)
+}
+
+func (e *eventEmitter) Call(c Circuit, options ...CallOption) error {
+	if e.hooked {
+		return e.breaker.Call(c, options...)
+	}
+	wasTripped := e.breaker.Tripped()
+	err := e.breaker.Call(c, options...)
+	e.emitOutcome(wasTripped, err)
+	return err
+}
+
+// emitOutcome emits the events caused by recording the outcome of a
+// call, for breakers that can't notify the emitter by themselves
+func (e *eventEmitter) emitOutcome(wasTripped bool, err error) {
+	isTripped := e.breaker.Tripped()
+
+	switch {
+	case !wasTripped && isTripped:
+		e.emit(TrippedEvent, Closed, Open, err)
+	case wasTripped && !isTripped:
+		e.emit(ResetEvent, Halfopen, Closed, nil)
+	}
+}
+
+func (e *eventEmitter) MarkFailure(err error) {
+	if e.hooked {
+		e.breaker.MarkFailure(err)
+		return
+	}
+	wasTripped := e.breaker.Tripped()
+	e.breaker.MarkFailure(err)
+	e.emitOutcome(wasTripped, err)
+}
+
+func (e *eventEmitter) MarkSuccess() {
+	if e.hooked {
+		e.breaker.MarkSuccess()
+		return
+	}
+	wasTripped := e.breaker.Tripped()
+	e.breaker.MarkSuccess()
+	e.emitOutcome(wasTripped, nil)
+}
+
+func (e *eventEmitter) unwrap() Breaker {
+	return e.breaker
+}
+
+func (e *eventEmitter) Name() string {
+	return e.breaker.Name()
 }
 
-func (e *eventEmitter) Call(c Circuit, options ...Option) error {
-	return e.breaker.Call(c, options...)
+func (e *eventEmitter) Reconfigure(options ...BreakerOption) error {
+	return e.breaker.Reconfigure(options...)
+}
+
+func (e *eventEmitter) AverageLatency() time.Duration {
+	return e.breaker.AverageLatency()
 }
 
 func (e *eventEmitter) ConsecFailures() int64 {
 	return e.breaker.ConsecFailures()
 }
 
+func (e *eventEmitter) CountsSince(d time.Duration) (int64, int64) {
+	return e.breaker.CountsSince(d)
+}
+
+func (e *eventEmitter) DefaultTimeout() time.Duration {
+	return e.breaker.DefaultTimeout()
+}
+
+func (e *eventEmitter) Disable() {
+	e.breaker.Disable()
+}
+
+func (e *eventEmitter) Disabled() bool {
+	return e.breaker.Disabled()
+}
+
+func (e *eventEmitter) Enable() {
+	e.breaker.Enable()
+}
+
 func (e *eventEmitter) ErrorRate() float64 {
 	return e.breaker.ErrorRate()
 }
 
+func (e *eventEmitter) ErrorRateSince(d time.Duration) float64 {
+	return e.breaker.ErrorRateSince(d)
+}
+
 func (e *eventEmitter) Failures() int64 {
 	return e.breaker.Failures()
 }
 
+func (e *eventEmitter) FailuresByClass() map[string]int64 {
+	return e.breaker.FailuresByClass()
+}
+
+func (e *eventEmitter) History() []Bucket {
+	return e.breaker.History()
+}
+
+func (e *eventEmitter) InFlight() int64 {
+	return e.breaker.InFlight()
+}
+
+func (e *eventEmitter) Labels() map[string]string {
+	return e.breaker.Labels()
+}
+
+func (e *eventEmitter) Transitions() []Transition {
+	return e.breaker.Transitions()
+}
+
+func (e *eventEmitter) LastFailure() time.Time {
+	return e.breaker.LastFailure()
+}
+
+func (e *eventEmitter) Percentile(p float64) time.Duration {
+	return e.breaker.Percentile(p)
+}
+
 func (e *eventEmitter) Ready() (bool, State) {
 	r, st := e.breaker.Ready()
+	if e.hooked {
+		return r, st
+	}
 	switch st {
 	case Halfopen:
-		defer emitEvent(e, ReadyEvent)
+		defer e.emit(ReadyEvent, Open, Halfopen, nil)
 	}
 	return r, st
 }
 
+func (e *eventEmitter) RetryAt() time.Time {
+	return e.breaker.RetryAt()
+}
+
 func (e *eventEmitter) Reset() {
-	defer emitEvent(e, ResetEvent)
+	if e.hooked {
+		e.breaker.Reset()
+		return
+	}
+	from := trippedState(e.breaker)
+	defer e.emit(ResetEvent, from, Closed, nil)
 	e.breaker.Reset()
 }
 
+func (e *eventEmitter) ResetBackoff() {
+	e.breaker.ResetBackoff()
+}
+
 func (e *eventEmitter) ResetCounters() {
 	e.breaker.ResetCounters()
 }
@@ -72,6 +375,10 @@ func (e *eventEmitter) State() State {
 	return e.breaker.State()
 }
 
+func (e *eventEmitter) Snapshot() Snapshot {
+	return e.breaker.Snapshot()
+}
+
 func (e *eventEmitter) Successes() int64 {
 	return e.breaker.Successes()
 }
@@ -81,66 +388,253 @@ func (e *eventEmitter) Trip() {
 		g := pdebug.Marker("EventEmitter.Trip")
 		defer g.End()
 	}
-	defer emitEvent(e, TrippedEvent)
+	if e.hooked {
+		e.breaker.Trip()
+		return
+	}
+	from := trippedState(e.breaker)
+	defer e.emit(TrippedEvent, from, Open, nil)
 	e.breaker.Trip()
 }
 
+func (e *eventEmitter) TripUntil(t time.Time) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("EventEmitter.TripUntil")
+		defer g.End()
+	}
+	if e.hooked {
+		e.breaker.TripUntil(t)
+		return
+	}
+	from := trippedState(e.breaker)
+	defer e.emit(TrippedEvent, from, Open, nil)
+	e.breaker.TripUntil(t)
+}
+
 func (e *eventEmitter) Tripped() bool {
 	return e.breaker.Tripped()
 }
 
+func (e *eventEmitter) Tripper() Tripper {
+	return e.breaker.Tripper()
+}
+
+func (e *eventEmitter) WouldTrip() bool {
+	return e.breaker.WouldTrip()
+}
+
+// Emitting returns a channel that is closed once the fan-out of events
+// has started.
+//
+// Deprecated: the fan-out is started by Subscribe.
 func (e *eventEmitter) Emitting() chan struct{} {
 	return e.emitting
 }
 
-// Emit does a fan-out of Breaker events
+// Emit does a fan-out of Breaker events until ctx is canceled.
+//
+// Deprecated: the fan-out is started by Subscribe, and stopped once
+// all subscriptions are stopped, or by Close.
 func (e *eventEmitter) Emit(ctx context.Context) {
-	close(e.emitting)
+	e.mutex.Lock()
+	if e.cancel != nil {
+		// Take over from the fan-out started by Subscribe
+		e.cancel()
+		e.cancel = nil
+	}
+	stopped := make(chan struct{})
+	e.manual = true
+	e.stopped = stopped
+	e.mutex.Unlock()
+
+	e.run(ctx, stopped)
+}
+
+// run delivers the events to the subscribers until ctx is canceled
+func (e *eventEmitter) run(ctx context.Context, stopped chan struct{}) {
+	defer func() {
+		e.mutex.Lock()
+		if e.stopped == stopped {
+			e.stopped = nil
+			e.manual = false
+		}
+		e.mutex.Unlock()
+		close(stopped)
+	}()
+	e.emittingOnce.Do(func() { close(e.emitting) })
 
 	for {
+		var data EventData
 		select {
 		case <-ctx.Done():
 			return
 		case ev, ok := <-e.events:
-			if pdebug.Enabled {
-				pdebug.Printf("Received event")
-			}
+			// Events sent directly to Events() carry no details
 			if !ok {
 				e.events = nil
+				continue
 			}
+			data = EventData{Event: ev, Time: e.clock.Now()}
+		case data = <-e.data:
+		}
 
-			e.mutex.RLock()
-			for _, l := range e.subscribers {
-				select {
-				case l.C <- ev:
-				default:
-				}
-			}
-			e.mutex.RUnlock()
+		if pdebug.Enabled {
+			pdebug.Printf("Received event")
+		}
+
+		// Take a snapshot so that subscribers can be stopped while
+		// a lossless subscriber is blocking the delivery
+		e.mutex.RLock()
+		subscribers := make([]*EventSubscription, 0, len(e.subscribers))
+		for _, l := range e.subscribers {
+			subscribers = append(subscribers, l)
+		}
+		e.mutex.RUnlock()
+
+		for _, l := range subscribers {
+			l.deliver(ctx, data)
+		}
+	}
+}
+
+// Close stops all subscriptions and the fan-out of events, and stops
+// listening to the breaker. Subscribe returns stopped subscriptions
+// once the emitter is closed.
+func (e *eventEmitter) Close() error {
+	e.detach()
+
+	e.mutex.Lock()
+	e.closed = true
+	subscribers := make([]*EventSubscription, 0, len(e.subscribers))
+	for _, s := range e.subscribers {
+		subscribers = append(subscribers, s)
+	}
+	e.mutex.Unlock()
+
+	for _, s := range subscribers {
+		s.Stop()
+	}
+	return nil
+}
+
+// deliver sends the event to the subscriber
+func (s *EventSubscription) deliver(ctx context.Context, data EventData) {
+	if !data.Event.dataOnly() {
+		select {
+		case s.C <- data.Event:
+		default:
+		}
+	}
+
+	if !s.lossless {
+		select {
+		case s.Data <- data:
+		default:
 		}
+		return
+	}
+
+	select {
+	case s.Data <- data:
+	case <-s.ctx.Done():
+	case <-s.stopped:
+	case <-ctx.Done():
 	}
 }
 
-// Subscribe starts a new subscription
-func (e *eventEmitter) Subscribe(ctx context.Context) *EventSubscription {
+// Subscribe starts a new subscription, and the fan-out of events if it
+// is not running yet. The subscription is stopped when ctx is canceled,
+// or when Stop is called.
+func (e *eventEmitter) Subscribe(ctx context.Context, options ...SubscribeOption) *EventSubscription {
 	s := EventSubscription{
-		C:       make(chan Event),
+		ctx:     ctx,
 		emitter: e,
+		stopped: make(chan struct{}),
+	}
+	for _, option := range options {
+		option.applySubscribe(&s)
 	}
+	s.C = make(chan Event, s.bufferSize)
+	s.Data = make(chan EventData, s.bufferSize)
+
 	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		s.stopOnce.Do(func() { close(s.stopped) })
+		return &s
+	}
+	if s.lossless {
+		atomic.AddInt32(&e.lossless, 1)
+	}
 	e.subscribers[fmt.Sprintf("%p", &s)] = &s
+	if !e.manual {
+		e.start()
+	}
 	e.mutex.Unlock()
+
+	if done := ctx.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				s.Stop()
+			case <-s.stopped:
+			}
+		}()
+	}
 	return &s
 }
 
+// SubscribeFunc starts a new subscription, and calls f with each event
+// from a goroutine managed by the emitter, until the subscription is
+// stopped. The same options as Subscribe are accepted; f is called
+// with the events that would have been sent to C.
+func (e *eventEmitter) SubscribeFunc(ctx context.Context, f func(Event), options ...SubscribeOption) *EventSubscription {
+	s := e.Subscribe(ctx, options...)
+	go func() {
+		for {
+			// Read the details, as lossless subscriptions only wait
+			// for Data to be received
+			select {
+			case data := <-s.Data:
+				if !data.Event.dataOnly() {
+					f(data.Event)
+				}
+			case <-s.stopped:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// remove removes the subscription, and stops the fan-out started by
+// Subscribe once there are no subscriptions left
 func (e *eventEmitter) remove(s *EventSubscription) {
 	e.mutex.Lock()
 	delete(e.subscribers, fmt.Sprintf("%p", s))
+	if len(e.subscribers) == 0 && e.cancel != nil {
+		e.cancel()
+		e.cancel = nil
+		e.stopped = nil
+	}
 	e.mutex.Unlock()
 }
 
+// Done returns a channel that is closed when the subscription is
+// stopped, either by Stop, by the cancellation of its context, or
+// by the emitter being closed
+func (s *EventSubscription) Done() <-chan struct{} {
+	return s.stopped
+}
+
 // Stop removes the subscription from the associated EventEmitter
 // and stops receiving events
 func (s *EventSubscription) Stop() {
-	s.emitter.remove(s)
+	s.stopOnce.Do(func() {
+		close(s.stopped)
+		s.emitter.remove(s)
+		if s.lossless {
+			atomic.AddInt32(&s.emitter.lossless, -1)
+		}
+	})
 }
@@ -1,29 +1,128 @@
 package breaker
 
-type breakerOpenErr struct {}
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
 
-func (e breakerOpenErr) Error() string {
+// OpenError is returned when a call is rejected because the breaker
+// is open. It describes why and for how long the breaker has been
+// open, and can be retrieved from the error returned by Call with
+// errors.As. errors.Is reports any OpenError as ErrBreakerOpen
+type OpenError struct {
+	// Name is the name of the breaker, as set by WithName
+	Name string
+	// LastError is the error of the last failure recorded before the
+	// call was rejected. It is nil if the breaker was tripped manually,
+	// or if the failure was reported without an error
+	LastError error
+	// Since is the time at which the breaker was tripped
+	Since time.Time
+	// OpenFor is how long the breaker had been open when the call
+	// was rejected
+	OpenFor time.Duration
+	// RetryAt is the time at which the breaker lets a call through
+	// again. It is zero if the breaker never retries
+	RetryAt time.Time
+}
+
+func (e *OpenError) Error() string {
 	return "breaker open"
 }
 
-func (e breakerOpenErr) State() State {
+func (e *OpenError) State() State {
 	return Open
 }
 
-type breakerTimeoutErr struct {}
+// Is returns true if target is an OpenError
+func (e *OpenError) Is(target error) bool {
+	_, ok := target.(*OpenError)
+	return ok
+}
 
-func (e breakerTimeoutErr) Error() string {
+// TimeoutError is returned when a call takes longer than the timeout
+// of the breaker. It satisfies net.Error, and errors.Is reports any
+// TimeoutError as ErrBreakerTimeout
+type TimeoutError struct{}
+
+func (e *TimeoutError) Error() string {
 	return "breaker timeout"
 }
 
-func (e breakerTimeoutErr) IsTimeout() bool {
+func (e *TimeoutError) IsTimeout() bool {
 	return true
 }
 
+// Timeout returns true, as required by net.Error
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary returns true, as required by net.Error
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// Is returns true if target is a TimeoutError
+func (e *TimeoutError) Is(target error) bool {
+	_, ok := target.(*TimeoutError)
+	return ok
+}
+
+// DeadlineExceededError is returned when the deadline of the context
+// given to Call is reached before the timeout of the breaker. errors.Is
+// reports any DeadlineExceededError as ErrDeadlineExceeded
+type DeadlineExceededError struct{}
+
+func (e *DeadlineExceededError) Error() string {
+	return "deadline exceeded"
+}
+
+func (e *DeadlineExceededError) IsDeadlineExceeded() bool {
+	return true
+}
+
+// Timeout returns true, like context.DeadlineExceeded does
+func (e *DeadlineExceededError) Timeout() bool {
+	return true
+}
+
+// Is returns true if target is a DeadlineExceededError
+func (e *DeadlineExceededError) Is(target error) bool {
+	_, ok := target.(*DeadlineExceededError)
+	return ok
+}
+
+// TooManyConcurrentError is returned when a call is rejected because
+// the limit set by WithMaxConcurrent is reached. errors.Is reports any
+// TooManyConcurrentError as ErrTooManyConcurrent
+type TooManyConcurrentError struct{}
+
+func (e *TooManyConcurrentError) Error() string {
+	return "too many concurrent calls"
+}
+
+func (e *TooManyConcurrentError) IsTooManyConcurrent() bool {
+	return true
+}
+
+// Is returns true if target is a TooManyConcurrentError
+func (e *TooManyConcurrentError) Is(target error) bool {
+	_, ok := target.(*TooManyConcurrentError)
+	return ok
+}
+
 type causer interface {
 	Cause() error
 }
 
+type unwrapper interface {
+	Unwrap() error
+}
+
 type stater interface {
 	State() State
 }
@@ -32,6 +131,18 @@ type isTimeouter interface {
 	IsTimeout() bool
 }
 
+type isDeadlineExceededer interface {
+	IsDeadlineExceeded() bool
+}
+
+type failureClasser interface {
+	FailureClass() string
+}
+
+type isTooManyConcurrenter interface {
+	IsTooManyConcurrent() bool
+}
+
 // IsOpen returns true if the error is caused by a "breaker open" error.
 func IsOpen(err error) bool {
 	for err != nil {
@@ -39,9 +150,7 @@ func IsOpen(err error) bool {
 			return bserr.State() == Open
 		}
 
-		if cerr, ok := err.(causer); ok {
-			err = cerr.Cause()
-		}
+		err = unwrap(err)
 	}
 	return false
 }
@@ -53,9 +162,69 @@ func IsTimeout(err error) bool {
 			return bterr.IsTimeout()
 		}
 
-		if cerr, ok := err.(causer); ok {
-			err = cerr.Cause()
+		err = unwrap(err)
+	}
+	return false
+}
+
+// IsDeadlineExceeded returns true if the error is caused by the
+// deadline of the context given to Call being reached before the
+// breaker timeout.
+func IsDeadlineExceeded(err error) bool {
+	for err != nil {
+		if derr, ok := err.(isDeadlineExceededer); ok {
+			return derr.IsDeadlineExceeded()
 		}
+
+		err = unwrap(err)
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// IsTooManyConcurrent returns true if the error is caused by a
+// "too many concurrent calls" error.
+func IsTooManyConcurrent(err error) bool {
+	for err != nil {
+		if cerr, ok := err.(isTooManyConcurrenter); ok {
+			return cerr.IsTooManyConcurrent()
+		}
+
+		err = unwrap(err)
+	}
+	return false
+}
+
+// failureClass returns the default class of a failure. The chain of
+// causes is walked until an error that can be classified is found
+func failureClass(err error) string {
+	for err != nil {
+		if cerr, ok := err.(failureClasser); ok {
+			return cerr.FailureClass()
+		}
+		if IsTimeout(err) || IsDeadlineExceeded(err) || err == context.DeadlineExceeded {
+			return FailureTimeout
+		}
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			return FailureTimeout
+		}
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return FailureConnectionRefused
+		}
+
+		err = unwrap(err)
+	}
+	return FailureOther
+}
+
+// unwrap returns the error that caused err, following both Cause()
+// as used by github.com/pkg/errors and Unwrap() as used by the standard
+// library, or nil if there is none
+func unwrap(err error) error {
+	switch err := err.(type) {
+	case causer:
+		return err.Cause()
+	case unwrapper:
+		return err.Unwrap()
+	}
+	return nil
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"time"
 
-	"github.com/cenk/backoff"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
 	"github.com/lestrrat/go-circuit-breaker/breaker"
 )
 
@@ -39,7 +39,9 @@ func ExampleEventEmitter() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Events are delivered as long as there are subscriptions
 	cb := breaker.NewEventEmitter(breaker.New())
+	defer cb.Close()
 	s := cb.Subscribe(ctx)
 
 	for {
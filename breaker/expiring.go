@@ -0,0 +1,233 @@
+package breaker
+
+import (
+	"container/list"
+	"time"
+)
+
+// NewExpiringMap creates an ExpiringMap. Unless a TTL or a maximum
+// number of entries is specified, it never evicts anything and behaves
+// like the map created by NewMap.
+//
+// Breakers that are tripped do not expire, as forgetting them would let
+// calls through to a service that is known to be failing. They are
+// still evicted when the map is full.
+//
+// If a TTL is specified, a janitor goroutine evicts expired breakers
+// in the background until Close is called.
+//
+// Possible optional parameters:
+// * WithClock: specify the clock used to determine expired breakers
+// * WithEvictionCallback: specify the function called for evicted breakers
+// * WithJanitorInterval: specify the interval at which the janitor runs
+// * WithMaxEntries: specify the maximum number of breakers
+// * WithTTL: specify how long breakers may go unused
+func NewExpiringMap(options ...MapOption) *ExpiringMap {
+	m := &ExpiringMap{
+		clock:   SystemClock,
+		done:    make(chan struct{}),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	for _, option := range options {
+		option.applyMap(m)
+	}
+
+	if m.janitorInterval == 0 {
+		m.janitorInterval = m.ttl
+	}
+	if m.ttl > 0 && m.janitorInterval > 0 {
+		go m.janitor()
+	}
+	return m
+}
+
+// Close stops the janitor. The map can still be used afterwards, but
+// expired breakers are only evicted when they are looked up.
+func (m *ExpiringMap) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+func (m *ExpiringMap) Delete(name string) {
+	m.mutex.Lock()
+	if e, ok := m.entries[name]; ok {
+		m.remove(e)
+	}
+	m.mutex.Unlock()
+}
+
+func (m *ExpiringMap) Get(name string) (Breaker, bool) {
+	m.mutex.Lock()
+	cb, ok, evicted := m.get(name, m.clock.Now())
+	m.mutex.Unlock()
+
+	m.evicted(evicted)
+	return cb, ok
+}
+
+func (m *ExpiringMap) GetOrCreate(name string, factory BreakerFactory) Breaker {
+	now := m.clock.Now()
+
+	m.mutex.Lock()
+	cb, ok, evicted := m.get(name, now)
+	if !ok {
+		cb = factory()
+		evicted = append(evicted, m.set(name, cb, m.ttl, now)...)
+	}
+	m.mutex.Unlock()
+
+	m.evicted(evicted)
+	return cb
+}
+
+func (m *ExpiringMap) Len() int {
+	m.mutex.Lock()
+	l := m.lru.Len()
+	m.mutex.Unlock()
+
+	return l
+}
+
+// Range calls f for each breaker in the map, from the most recently
+// used to the least recently used one. Ranging over the map does not
+// count as using the breakers.
+func (m *ExpiringMap) Range(f func(string, Breaker) bool) {
+	// Take a snapshot so that f may modify the map
+	m.mutex.Lock()
+	entries := make([]expiringEntry, 0, m.lru.Len())
+	for e := m.lru.Front(); e != nil; e = e.Next() {
+		entries = append(entries, *e.Value.(*expiringEntry))
+	}
+	m.mutex.Unlock()
+
+	for _, entry := range entries {
+		if !f(entry.name, entry.cb) {
+			return
+		}
+	}
+}
+
+func (m *ExpiringMap) Set(name string, cb Breaker) {
+	m.SetWithTTL(name, cb, m.ttl)
+}
+
+// SetWithTTL associates the breaker with the given name, like Set, but
+// with its own TTL instead of the one given to NewExpiringMap. The
+// breaker does not expire if the TTL is 0.
+func (m *ExpiringMap) SetWithTTL(name string, cb Breaker, ttl time.Duration) {
+	m.mutex.Lock()
+	evicted := m.set(name, cb, ttl, m.clock.Now())
+	m.mutex.Unlock()
+
+	m.evicted(evicted)
+}
+
+// EvictExpired evicts the breakers that have expired. It is called
+// periodically by the janitor, but may also be called directly, e.g.
+// when no janitor is running.
+func (m *ExpiringMap) EvictExpired() {
+	now := m.clock.Now()
+
+	var evicted []expiringEntry
+	m.mutex.Lock()
+	for e := m.lru.Back(); e != nil; {
+		prev := e.Prev()
+		if entry := e.Value.(*expiringEntry); m.expired(entry, now) {
+			evicted = append(evicted, *entry)
+			m.remove(e)
+		}
+		e = prev
+	}
+	m.mutex.Unlock()
+
+	m.evicted(evicted)
+}
+
+// get returns the breaker associated with the given name and marks
+// it as used, unless it has expired, in which case it is evicted and
+// returned in the list of evicted entries. The lock must be held
+func (m *ExpiringMap) get(name string, now time.Time) (Breaker, bool, []expiringEntry) {
+	e, ok := m.entries[name]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := e.Value.(*expiringEntry)
+	if m.expired(entry, now) {
+		m.remove(e)
+		return nil, false, []expiringEntry{*entry}
+	}
+
+	entry.lastUsed = now
+	m.lru.MoveToFront(e)
+	return entry.cb, true, nil
+}
+
+// set stores the breaker, and evicts the least recently used entries
+// if there are too many of them. The evicted entries are returned.
+// The lock must be held
+func (m *ExpiringMap) set(name string, cb Breaker, ttl time.Duration, now time.Time) []expiringEntry {
+	if e, ok := m.entries[name]; ok {
+		entry := e.Value.(*expiringEntry)
+		entry.cb = cb
+		entry.lastUsed = now
+		entry.ttl = ttl
+		m.lru.MoveToFront(e)
+		return nil
+	}
+
+	m.entries[name] = m.lru.PushFront(&expiringEntry{
+		name:     name,
+		cb:       cb,
+		lastUsed: now,
+		ttl:      ttl,
+	})
+
+	var evicted []expiringEntry
+	for m.maxEntries > 0 && m.lru.Len() > m.maxEntries {
+		e := m.lru.Back()
+		evicted = append(evicted, *e.Value.(*expiringEntry))
+		m.remove(e)
+	}
+	return evicted
+}
+
+func (m *ExpiringMap) expired(entry *expiringEntry, now time.Time) bool {
+	if entry.ttl <= 0 || now.Sub(entry.lastUsed) < entry.ttl {
+		return false
+	}
+	return !entry.cb.Tripped()
+}
+
+// remove removes the element from the map. The lock must be held
+func (m *ExpiringMap) remove(e *list.Element) {
+	delete(m.entries, e.Value.(*expiringEntry).name)
+	m.lru.Remove(e)
+}
+
+// evicted calls the eviction callback for the given entries. The lock
+// must not be held, so that the callback may use the map
+func (m *ExpiringMap) evicted(entries []expiringEntry) {
+	if m.onEvict == nil {
+		return
+	}
+	for _, entry := range entries {
+		m.onEvict(entry.name, entry.cb)
+	}
+}
+
+func (m *ExpiringMap) janitor() {
+	t := NewTicker(m.clock, m.janitorInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-t.C():
+			m.EvictExpired()
+		}
+	}
+}
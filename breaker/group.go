@@ -0,0 +1,198 @@
+package breaker
+
+import "time"
+
+// NewGroup creates a Group of child breakers under the given parent.
+// Breakers stored in the Group become its children, and the Group
+// returns them wrapped, so that they are controlled by the parent.
+// As the Group implements Map, it can be used wherever a Map of
+// breakers is expected.
+//
+// Possible optional parameters:
+// * WithTripFraction: specify the fraction of open children that trips the parent
+func NewGroup(parent Breaker, options ...GroupOption) *Group {
+	g := &Group{
+		children: NewMap(),
+		parent:   parent,
+	}
+	for _, option := range options {
+		option.applyGroup(g)
+	}
+	return g
+}
+
+// Parent returns the parent breaker of the group
+func (g *Group) Parent() Breaker {
+	return g.parent
+}
+
+func (g *Group) Delete(name string) {
+	g.children.Delete(name)
+}
+
+func (g *Group) Get(name string) (Breaker, bool) {
+	return g.children.Get(name)
+}
+
+func (g *Group) GetOrCreate(name string, factory BreakerFactory) Breaker {
+	return g.children.GetOrCreate(name, func() Breaker {
+		return g.wrap(factory())
+	})
+}
+
+func (g *Group) Len() int {
+	return g.children.Len()
+}
+
+func (g *Group) Range(f func(string, Breaker) bool) {
+	g.children.Range(f)
+}
+
+func (g *Group) Set(name string, cb Breaker) {
+	g.children.Set(name, g.wrap(cb))
+}
+
+func (g *Group) wrap(cb Breaker) Breaker {
+	if c, ok := cb.(*groupChild); ok && c.group == g {
+		return c
+	}
+	return &groupChild{Breaker: cb, group: g}
+}
+
+// childTripped trips the parent if enough children are open
+func (g *Group) childTripped() {
+	if g.fraction <= 0 || g.parent.Tripped() {
+		return
+	}
+
+	var open, total int
+	g.children.Range(func(_ string, cb Breaker) bool {
+		total++
+		if cb.(*groupChild).Breaker.Tripped() {
+			open++
+		}
+		return true
+	})
+	if total > 0 && float64(open)/float64(total) >= g.fraction {
+		g.parent.Trip()
+	}
+}
+
+// observe notifies the group if the child tripped during an operation
+func (c *groupChild) observe(wasTripped bool) {
+	if !wasTripped && c.Breaker.Tripped() {
+		c.group.childTripped()
+	}
+}
+
+// isGroupFailure returns true if the error returned by a child should
+// be recorded as a failure by the parent. Rejections by the child are
+// not, as they don't tell anything about the service as a whole
+func isGroupFailure(err error) bool {
+	return err != nil && !IsOpen(err) && !IsTooManyConcurrent(err)
+}
+
+func (c *groupChild) Allow() (func(bool), error) {
+	// Peek at the state of the child, so that its probe, if any, is
+	// only handed out by the Allow() below
+	if c.Breaker.Snapshot().State == Open {
+		return c.Breaker.Allow()
+	}
+
+	parentDone, err := c.group.parent.Allow()
+	if err != nil {
+		return nil, err
+	}
+	done, err := c.Breaker.Allow()
+	if err != nil {
+		// The child became open in the meantime. The parent must be
+		// told something, and the rejection says nothing about the
+		// service as a whole
+		parentDone(true)
+		return nil, err
+	}
+
+	return func(success bool) {
+		wasTripped := c.Breaker.Tripped()
+		done(success)
+		parentDone(success)
+		c.observe(wasTripped)
+	}, nil
+}
+
+func (c *groupChild) Break() {
+	wasTripped := c.Breaker.Tripped()
+	c.Breaker.Break()
+	c.observe(wasTripped)
+}
+
+// Call rejects the call if the parent is open, and otherwise calls
+// the circuit through the child, recording the outcome in both. Note
+// that the fallback of the child is not used when the parent rejects
+// the call
+func (c *groupChild) Call(circuit Circuit, options ...CallOption) error {
+	// Peek at the state of the child, so that its probe, if any, is
+	// only handed out by the Call() below
+	if c.Breaker.Snapshot().State == Open {
+		// Let the child reject the call, so that its fallback is used
+		wasTripped := c.Breaker.Tripped()
+		err := c.Breaker.Call(circuit, options...)
+		c.observe(wasTripped)
+		return err
+	}
+
+	done, err := c.group.parent.Allow()
+	if err != nil {
+		return err
+	}
+
+	wasTripped := c.Breaker.Tripped()
+	err = c.Breaker.Call(circuit, options...)
+	done(!isGroupFailure(err))
+	c.observe(wasTripped)
+	return err
+}
+
+func (c *groupChild) MarkFailure(err error) {
+	wasTripped := c.Breaker.Tripped()
+	c.Breaker.MarkFailure(err)
+	c.group.parent.MarkFailure(err)
+	c.observe(wasTripped)
+}
+
+func (c *groupChild) MarkSuccess() {
+	c.Breaker.MarkSuccess()
+	c.group.parent.MarkSuccess()
+}
+
+func (c *groupChild) Ready() (bool, State) {
+	// Peek at the state of the parent, so that its probe is not
+	// handed out when the child is not ready
+	if c.group.parent.Snapshot().State == Open {
+		return false, Open
+	}
+	return c.Breaker.Ready()
+}
+
+func (c *groupChild) State() State {
+	if c.group.parent.Snapshot().State == Open {
+		return Open
+	}
+	return c.Breaker.State()
+}
+
+func (c *groupChild) Trip() {
+	wasTripped := c.Breaker.Tripped()
+	c.Breaker.Trip()
+	c.observe(wasTripped)
+}
+
+func (c *groupChild) Tripped() bool {
+	return c.Breaker.Tripped() || c.group.parent.Tripped()
+}
+
+func (c *groupChild) TripUntil(t time.Time) {
+	wasTripped := c.Breaker.Tripped()
+	c.Breaker.TripUntil(t)
+	c.observe(wasTripped)
+}
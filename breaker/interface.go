@@ -1,12 +1,16 @@
 package breaker
 
 import (
+	"container/list"
 	"context"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/cenk/backoff"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
 	"github.com/lestrrat/go-circuit-breaker/breaker/internal/window"
+	"github.com/lestrrat/go-circuit-breaker/internal/option"
 )
 
 // Clock is an interface that defines a pluggable clock (as opposed to
@@ -18,8 +22,55 @@ type Clock interface {
 	Now() time.Time
 }
 
+// TimerClock is implemented by clocks that can create timers and
+// tickers. It is optional, so that any Clock can be used, but clocks
+// that implement it allow timeouts and periodic tasks to be stopped
+// early and controlled by mock clocks. SystemClock implements it, and
+// the fbclock package adapts clocks from github.com/facebookgo/clock.
+type TimerClock interface {
+	Clock
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a timer created by a TimerClock, or by NewTimer
+type Timer interface {
+	// C returns the channel on which the time is delivered
+	C() <-chan time.Time
+	// Stop prevents the timer from firing
+	Stop()
+}
+
+// Ticker is a ticker created by a TimerClock, or by NewTicker
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered
+	C() <-chan time.Time
+	// Stop turns off the ticker
+	Stop()
+}
+
 type systemClock struct{}
 
+type systemTimer struct {
+	timer *time.Timer
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+// afterTimer is a Timer for clocks that only implement After()
+type afterTimer struct {
+	c <-chan time.Time
+}
+
+// afterTicker is a Ticker for clocks that only implement After()
+type afterTicker struct {
+	c    chan time.Time
+	done chan struct{}
+	once sync.Once
+}
+
 // SystemClock is a simple clock using the time package
 var SystemClock = systemClock{}
 
@@ -29,6 +80,23 @@ const (
 
 	// DefaultWindowBuckets is the default number of buckets the window holds, 10.
 	DefaultWindowBuckets = 10
+
+	// DefaultStorageInterval is the default minimum interval between
+	// saves of the counters to the Storage, 10 seconds.
+	DefaultStorageInterval = 10 * time.Second
+
+	// DefaultInitialLimit is the default concurrency limit that an
+	// AdaptiveBreaker starts with, 20.
+	DefaultInitialLimit = 20
+
+	// DefaultMinLimit and DefaultMaxLimit are the default bounds of
+	// the concurrency limit of an AdaptiveBreaker, 1 and 1000.
+	DefaultMinLimit = 1
+	DefaultMaxLimit = 1000
+
+	// DefaultDecreaseFactor is the default factor by which an
+	// AdaptiveBreaker multiplies its limit on congestion, 0.9.
+	DefaultDecreaseFactor = 0.9
 )
 
 // Event indicates the type of event received over an event channel
@@ -41,13 +109,33 @@ const (
 	// ResetEvent is sent when a breaker resets
 	ResetEvent
 
-	// FailEvent is sent when Fail() is called
+	// FailEvent is sent when a failure is recorded
 	FailEvent
 
 	// ReadyEvent is sent when the breaker enters the half open state and is ready to retry
 	ReadyEvent
+
+	// StateChangeEvent is sent whenever the breaker moves from one
+	// state to another. It is only sent over EventSubscription.Data,
+	// as it carries no information without the From and To fields
+	StateChangeEvent
+
+	// AddedEvent is sent by a MapEmitter when a breaker is stored in
+	// it, including when it replaces another breaker. It is only sent
+	// over EventSubscription.Data, as it carries no information without
+	// the Name field
+	AddedEvent
+
+	// RemovedEvent is sent by a MapEmitter when a breaker is deleted
+	// from it. It is only sent over EventSubscription.Data, as it
+	// carries no information without the Name field
+	RemovedEvent
 )
 
+// HalfopenEvent is sent when the breaker enters the half open state.
+// It is the same event as ReadyEvent
+const HalfopenEvent = ReadyEvent
+
 // State describes the current state of the Breaker
 type State int
 
@@ -63,10 +151,20 @@ var (
 	defaultBackoffMaxElapsedTime  = 0 * time.Second
 )
 
-// Error codes returned by Call
+// defaultMap holds the breakers used by the package level Call
+var defaultMap = NewMap()
+
+// eventQueueSize is the number of events that may be queued for the
+// emitter, as a single call may generate several events in a row
+const eventQueueSize = 16
+
+// Error codes returned by Call. They are usually wrapped, so use
+// errors.Is, or the IsOpen family of functions, to check for them
 var (
-	ErrBreakerOpen    = breakerOpenErr{}
-	ErrBreakerTimeout = breakerTimeoutErr{}
+	ErrBreakerOpen       = &OpenError{}
+	ErrBreakerTimeout    = &TimeoutError{}
+	ErrDeadlineExceeded  = &DeadlineExceededError{}
+	ErrTooManyConcurrent = &TooManyConcurrentError{}
 )
 
 // Tripper is an interface called by a Breaker's Fail() method. It should
@@ -77,12 +175,82 @@ type Tripper interface {
 	Trip(Breaker) bool
 }
 
+// ContextTripper is implemented by Trippers that take the context of
+// the failed call into account, e.g. to ignore failures of requests
+// that had little time left before their deadline. The context is the
+// one given to Call() via WithContext. Failures that are not recorded
+// by Call(), such as those given to MarkFailure(), come with
+// context.Background().
+type ContextTripper interface {
+	Tripper
+	TripContext(context.Context, Breaker) bool
+}
+
+// TripContextFunc is a function that implements ContextTripper. When
+// it is called through Trip(), it receives context.Background()
+type TripContextFunc func(context.Context, Breaker) bool
+
 // TripFunc is a type of Tripper that is represented by a function with no state
 type TripFunc func(Breaker) bool
 
+// decayingTripper is a DecayingConsecutiveTripper
+type decayingTripper struct {
+	halfLife  time.Duration
+	mutex     sync.Mutex
+	others    map[Breaker]*decayingScore // breakers not created by New()
+	threshold int64
+}
+
+// decayingScore is the score of a breaker for a decayingTripper
+type decayingScore struct {
+	last  time.Time
+	mutex sync.Mutex
+	score float64
+}
+
+// budgetTripper is a BudgetTripper
+type budgetTripper struct {
+	budget float64
+	mutex  sync.Mutex
+	others map[Breaker]*budgetCounts // breakers not created by New()
+	period time.Duration
+}
+
+// budgetCounts are the counts of the current period of a breaker for
+// a budgetTripper. Once observing, they are updated as the breaker
+// records calls
+type budgetCounts struct {
+	clock     Clock
+	failures  int64
+	mutex     sync.Mutex
+	observing bool
+	period    time.Duration
+	resetting bool
+	start     time.Time
+	successes int64
+}
+
 // Breaker describes the interface of a circuit breaker. It maintains
 // failure and success counters and state information
 type Breaker interface {
+	// Allow is the two-phase alternative to Call(), for operations
+	// whose outcome is known long after they start. If the breaker
+	// lets the operation go ahead, the returned function must be
+	// called exactly once to report whether the operation succeeded.
+	// Otherwise an error is returned, as Call() would.
+	Allow() (done func(success bool), err error)
+
+	// AverageLatency returns the average time it took to execute the
+	// circuits recorded in the window. Calls that were rejected or
+	// canceled are not recorded.
+	AverageLatency() time.Duration
+
+	// Backoff returns the backoff policy that decides how long the
+	// breaker stays open, e.g. to inspect the elapsed time of an
+	// ExponentialBackOff. The policy must not be advanced by the
+	// caller: use ResetBackoff to start it over.
+	Backoff() backoff.BackOff
+
 	// Break trips the circuit breaker and prevents it from auto resetting.
 	// Use this when manual control over the circuit breaker state is needed.
 	Break()
@@ -93,20 +261,104 @@ type Breaker interface {
 	// `WithTimeout` may be specified in the options to override the default
 	// timeout associated with the breaker. If the called function takes longer
 	// than timeout to run, a failure will be recorded.
-	Call(Circuit, ...Option) error
+	//
+	// `WithContext` may be specified in the options to allow the call to
+	// be canceled. If the context has a deadline that comes before the
+	// timeout, the deadline is used instead, and ErrDeadlineExceeded is
+	// returned rather than ErrBreakerTimeout when it is reached. Both
+	// are recorded as failures.
+	Call(Circuit, ...CallOption) error
 
 	// ConsecFailures returns the number of consecutive failures that
 	// have occured.
 	ConsecFailures() int64
 
+	// CountsSince returns the number of failures and successes recorded
+	// over the last d. The result is rounded up to the buckets of the
+	// window, and is limited to the time covered by the window (see
+	// WithWindowTime). Count based windows return all their counts.
+	CountsSince(d time.Duration) (failures, successes int64)
+
+	// DefaultTimeout returns the timeout applied to calls that do not
+	// specify one with WithTimeout, or 0 if calls are not timed out
+	DefaultTimeout() time.Duration
+
+	// Disable makes the breaker let all calls through, whatever its
+	// state, as in shadow mode. Outcomes are still recorded, and the
+	// breaker still trips and resets, so that it enforces the right
+	// state as soon as it is enabled again. Limits on concurrent calls
+	// still apply.
+	Disable()
+
+	// Disabled returns true if the breaker was disabled via Disable()
+	// or WithDisabled.
+	Disabled() bool
+
+	// Enable undoes Disable().
+	Enable()
+
 	// ErrorRate returns the current error rate of the Breaker, expressed
 	// as a floating point number (e.g. 0.9 for 90%), since the last time
 	// the breaker was Reset.
 	ErrorRate() float64
 
+	// ErrorRateSince returns the error rate calculated over the last d,
+	// using the same counts as CountsSince. This allows trippers to
+	// evaluate rates over different periods of time.
+	ErrorRateSince(d time.Duration) float64
+
 	// Failures returns the number of failures for this circuit breaker.
 	Failures() int64
 
+	// FailuresByClass returns the number of failures recorded since the
+	// counters were last reset, keyed by class, such as FailureTimeout.
+	// See WithErrorCategorizer to define custom classes.
+	FailuresByClass() map[string]int64
+
+	// History returns the counts of each bucket of the window, from the
+	// oldest to the most recent one. For count based windows (see
+	// WithWindowSize) a single bucket holding the totals is returned.
+	History() []Bucket
+
+	// InFlight returns the number of calls that are running, including
+	// calls that timed out but whose circuit has not returned yet, and
+	// operations let through by Allow() whose outcome has not been
+	// reported yet.
+	InFlight() int64
+
+	// Labels returns the labels given to the breaker with WithLabels(),
+	// such as the service or the region that it protects. The returned
+	// map is a copy, and is nil if the breaker was given no labels.
+	Labels() map[string]string
+
+	// LastFailure returns the time of the last failure, or of the last
+	// time the breaker was tripped, whichever is the most recent. It
+	// returns the zero time if neither has happened yet.
+	LastFailure() time.Time
+
+	// MarkFailure records a failure, as if a call made through Call()
+	// had failed with the given error. It is meant for code that can't
+	// be wrapped in a Circuit, such as callbacks or asynchronous
+	// pipelines. The error is subject to the ErrorClassifier, if any,
+	// and may be nil. As the outcome does not come from a probe, it is
+	// recorded as if the breaker were open while it is tripped.
+	MarkFailure(error)
+
+	// MarkSuccess records a success, as if a call made through Call()
+	// had succeeded. As the outcome does not come from a probe, it
+	// never closes a tripped breaker: use Allow() for operations that
+	// should be able to do so.
+	MarkSuccess()
+
+	// Name returns the name given to the breaker with WithName(). It
+	// is empty if the breaker was not given a name.
+	Name() string
+
+	// Percentile returns the time below which p percent (0 to 100) of
+	// the circuits recorded in the window completed. For time based
+	// windows, the result is an approximation.
+	Percentile(p float64) time.Duration
+
 	// Ready will return true if the circuit breaker is ready to call the
 	// function. It will be ready if the breaker is in a reset state, or if
 	// it is time to retry the call for auto resetting.
@@ -116,10 +368,33 @@ type Breaker interface {
 	// you should use State()
 	Ready() (bool, State)
 
+	// Reconfigure changes the settings of the breaker without losing
+	// its state and counters. It accepts WithBackOff, WithErrorCategorizer,
+	// WithErrorClassifier, WithFallback, WithGradualRecovery,
+	// WithHalfOpenRequests, WithJitter, WithMinSamples, WithProbeWait,
+	// WithQueueing, WithShadowMode, WithSuccessThreshold, WithTimeout
+	// and WithTripper, and returns an error for other options, in which
+	// case nothing is changed. Calls that are already running keep the
+	// old settings.
+	Reconfigure(...BreakerOption) error
+
+	// RetryAt returns the time when the breaker will allow the next
+	// half-open probe. The returned time may be in the past if a probe
+	// is already allowed. It returns the zero time if the breaker is
+	// closed, or if it will not retry on its own (e.g. after Break())
+	RetryAt() time.Time
+
 	// Reset will reset the circuit breaker. After Reset() is called,
-	// Tripped() will return false.
+	// Tripped() will return false. The counters and the backoff policy
+	// are reset too.
 	Reset()
 
+	// ResetBackoff starts the backoff policy over, without changing the
+	// state of the breaker. This allows a policy that gave up (e.g. an
+	// ExponentialBackOff that reached its MaxElapsedTime) to be used
+	// again.
+	ResetBackoff()
+
 	// ResetCounters will reset only the failures, consecFailures,
 	// and success counters
 	ResetCounters()
@@ -130,55 +405,401 @@ type Breaker interface {
 	// Halfopen - the circuit is in a tripped state but the reset timeout has passed
 	State() State
 
+	// Snapshot returns the state and the counters of the Breaker, read
+	// at the same point in time. Unlike State(), it has no side effects.
+	Snapshot() Snapshot
+
 	// Successes returns the number of successes for this circuit breaker.
 	Successes() int64
 
+	// Transitions returns the most recent state transitions of the
+	// breaker, from the oldest to the most recent one. Transitions
+	// are only kept if the breaker was created with WithEventHistory,
+	// otherwise nil is returned.
+	Transitions() []Transition
+
 	// Trip will trip the circuit breaker. After Trip() is called, Tripped()
 	// willreturn true.
 	Trip()
 
+	// TripUntil trips the circuit breaker, and holds it open until at
+	// least the given time, even if the backoff would allow a half-open
+	// probe earlier. This is typically used to honor a server asking
+	// clients to back off, e.g. through a Retry-After header.
+	TripUntil(time.Time)
+
 	// Tripped returns true if the circuit breaker is tripped, false
 	// if it is reset.
 	Tripped() bool
+
+	// Tripper returns the tripper that decides when the breaker trips,
+	// which is NilTripper if none was specified
+	Tripper() Tripper
+
+	// WouldTrip returns true if the tripper would trip the breaker
+	// given the current counters, taking WithWarmup and WithMinSamples
+	// into account. Nothing is recorded, and the state of the breaker
+	// is not considered, so that dashboards and tests can tell how
+	// close a breaker is to tripping. See IsDryRun for trippers that
+	// keep their own state.
+	WouldTrip() bool
+}
+
+// Counts is a snapshot of the counters maintained by a Breaker
+type Counts struct {
+	ConsecFailures int64
+	ErrorRate      float64
+	Failures       int64
+	Successes      int64
+}
+
+// Bucket holds the number of failures and successes recorded during
+// a period of time, as returned by Breaker.History()
+type Bucket struct {
+	// Start is the time when the bucket started
+	Start time.Time
+	// Duration is the period of time covered by the bucket
+	Duration time.Duration
+	// Failures is the number of failures recorded in the bucket
+	Failures int64
+	// Successes is the number of successes recorded in the bucket
+	Successes int64
 }
 
-// EventSubscription describes a subscription to an EventEmitter
+// Transition describes a change of state of a Breaker, as returned
+// by Breaker.Transitions()
+type Transition struct {
+	// From is the state of the breaker before the transition
+	From State
+	// To is the state of the breaker after the transition
+	To State
+	// Time is the time when the transition occurred
+	Time time.Time
+	// Err is the error that triggered the transition, if any
+	Err error
+}
+
+// transitionLog is a ring buffer holding the most recent transitions
+type transitionLog struct {
+	entries []Transition
+	mutex   sync.Mutex
+	next    int
+	size    int
+}
+
+// Snapshot is a point in time view of a Breaker, as returned by
+// Breaker.Snapshot()
+type Snapshot struct {
+	// State is the state of the breaker. Halfopen is reported if a
+	// probe would be allowed, but no probe is consumed
+	State State
+	// Failures is the number of failures in the current window
+	Failures int64
+	// Successes is the number of successes in the current window
+	Successes int64
+	// ConsecFailures is the number of consecutive failures
+	ConsecFailures int64
+	// ErrorRate is the error rate in the current window
+	ErrorRate float64
+	// LastFailure is the time of the last failure or trip, if any
+	LastFailure time.Time
+	// NextRetry is the time when the breaker allows the next probe.
+	// It is the zero time if the breaker is closed, or if it will
+	// not retry (e.g. after Break())
+	NextRetry time.Time
+	// Trips is the number of times the breaker has tripped
+	Trips int64
+	// LastTrip is the time when the breaker last tripped, if ever
+	LastTrip time.Time
+	// OpenTime is the total time that the breaker has spent open,
+	// including the time since it last tripped if it is still open
+	OpenTime time.Duration
+	// LongestOpen is the longest time that the breaker has stayed
+	// open in a row, including the current streak if it is open
+	LongestOpen time.Duration
+}
+
+// EventData carries the details of an event generated by an EventEmitter
+type EventData struct {
+	// Event is the type of event
+	Event Event
+	// Name is the name of the breaker, if the breaker has one
+	Name string
+	// Labels are the labels of the breaker, if the breaker has any.
+	// The map must not be modified
+	Labels map[string]string
+	// From is the state of the breaker before the event
+	From State
+	// To is the state of the breaker after the event
+	To State
+	// Time is the time when the event occurred
+	Time time.Time
+	// Counts is a snapshot of the breaker's counters after the event
+	Counts Counts
+	// Err is the error that triggered the event, if any
+	Err error
+}
+
+// EventSubscription describes a subscription to an EventEmitter.
+// Events are sent to C, and the details of the same events are sent
+// to Data. Subscribers may receive from either channel.
 type EventSubscription struct {
-	C       chan Event
-	emitter *eventEmitter
+	C          chan Event
+	Data       chan EventData
+	bufferSize int
+	ctx        context.Context
+	emitter    *eventEmitter
+	lossless   bool
+	stopOnce   sync.Once
+	stopped    chan struct{}
 }
 
 // EventEmitter is used to wrap a Breaker object so that useful
 // notifications can be received from it.
+//
+// Events are delivered to subscribers as long as there is at least one
+// subscription. Call Close when the emitter is no longer needed.
 type EventEmitter interface {
 	Breaker
+	Close() error
 	Emitting() chan struct{}
 	Emit(context.Context)
 	Events() chan Event
-	Subscribe(context.Context) *EventSubscription
+	Subscribe(context.Context, ...SubscribeOption) *EventSubscription
+	SubscribeFunc(context.Context, func(Event), ...SubscribeOption) *EventSubscription
 }
 
 type eventEmitter struct {
-	breaker     Breaker
-	emitting    chan struct{}
-	events      chan Event
-	mutex       sync.RWMutex
-	subscribers map[string]*EventSubscription
+	breaker      Breaker
+	cancel       context.CancelFunc
+	clock        Clock
+	closed       bool
+	data         chan EventData
+	detached     int32
+	emitting     chan struct{}
+	emittingOnce sync.Once
+	events       chan Event
+	hooked       bool
+	lossless     int32
+	manual       bool
+	mutex        sync.RWMutex
+	name         string
+	sink         *eventEmitter // emitter delivering the events, itself unless part of a MapEmitter
+	stopped      chan struct{}
+	subscribers  map[string]*EventSubscription
+}
+
+// MapEmitter is a Map that delivers the events of all of its breakers
+// to the same subscriptions. The Name of each event is the name under
+// which the breaker is stored.
+type MapEmitter struct {
+	Map
+	emitter *eventEmitter
+	mutex   sync.Mutex
+	watched map[string]*eventEmitter
+}
+
+// StoredState is the state of a breaker that is saved to a Storage,
+// so that it can be restored when the breaker is created again
+type StoredState struct {
+	Broken         bool          `json:"broken"`
+	ConsecFailures int64         `json:"consecutive_failures"`
+	Failures       int64         `json:"failures"`
+	LastFailure    time.Time     `json:"last_failure"`
+	LastTrip       time.Time     `json:"last_trip"`
+	LongestOpen    time.Duration `json:"longest_open"`
+	OpenTime       time.Duration `json:"open_time"`
+	Successes      int64         `json:"successes"`
+	Tripped        bool          `json:"tripped"`
+	Trips          int64         `json:"trips"`
+}
+
+// Storage is used to persist the state of a breaker across restarts.
+// See the storage package for implementations.
+type Storage interface {
+	// Load returns the saved state. If no state has been saved,
+	// it should return nil and no error
+	Load() (*StoredState, error)
+
+	// Save saves the given state
+	Save(*StoredState) error
+}
+
+// Logger is the interface used by breakers to log state transitions,
+// as specified via WithLogger. *log.Logger satisfies this interface.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// logListener logs the transitions of a breaker to a Logger
+type logListener struct {
+	logger Logger
+	prefix string
+}
+
+// Decorator wraps a Breaker to add behavior to it, such as logging,
+// metrics or rate limiting. Decorators are composed with Chain
+type Decorator func(Breaker) Breaker
+
+// Interceptor holds the functions called by a breaker created by
+// Intercept in place of the methods of the wrapped breaker. Each
+// function receives the wrapped breaker, so that it can decide if and
+// how to delegate to it. Methods whose function is nil are delegated
+// to the wrapped breaker as is
+type Interceptor struct {
+	Call  func(next Breaker, c Circuit, options ...CallOption) error
+	Trip  func(next Breaker)
+	Reset func(next Breaker)
+}
+
+// interceptedBreaker is the breaker created by Intercept
+type interceptedBreaker struct {
+	Breaker
+	interceptor Interceptor
+}
+
+// loggingBreaker logs the state changes of a Breaker, and the calls
+// that it rejects, as structured records
+type loggingBreaker struct {
+	Breaker
+	hooked bool
+	logger *slog.Logger
+}
+
+// listener receives notifications from the core breaker about
+// things that can not be observed by wrapping it, such as failures
+// and state transitions that happen within Call()
+type listener interface {
+	onFail(State, error)
+	onSuccess(State)
+	onStateChange(from, to State, err error)
+}
+
+// listenable is implemented by breakers that can notify listeners
+type listenable interface {
+	addListener(listener)
+	removeListener(listener)
+}
+
+// wrapper is implemented by the breakers of this package that wrap
+// another breaker, so that listeners reach the breaker created by
+// New() underneath
+type wrapper interface {
+	unwrap() Breaker
+}
+
+// Observer receives notifications directly from a breaker created by
+// New(), about things that wrappers can not see, such as the outcome
+// of every call and the state transitions that happen within Call().
+// The methods are called synchronously, and must not block nor call
+// the breaker
+type Observer interface {
+	// OnFail is called when a failure is recorded, along with the
+	// state in which the call was made and its error, if any
+	OnFail(State, error)
+	// OnSuccess is called when a success is recorded, along with the
+	// state in which the call was made
+	OnSuccess(State)
+	// OnStateChange is called when the breaker changes state, along
+	// with the error that caused the change, if any
+	OnStateChange(from, to State, err error)
+}
+
+// observerListener adapts an Observer to the listener interface
+type observerListener struct {
+	observer Observer
 }
 
 type breaker struct {
-	backoff        backoff.BackOff
-	backoffLock    sync.Mutex
-	broken         int32
-	clock          Clock
-	consecFailures int64
-	counts         *window.Window
-	defaultTimeout time.Duration
-	halfOpens      int64
-	lastFailure    int64
-	nextBackOff    time.Duration
-	tripper        Tripper
-	tripped        int32
+	settings
+	backoff           backoff.BackOff
+	backoffAdvanced   int32
+	backoffLock       sync.Mutex
+	broken            int32
+	changed           chan struct{}
+	changeLock        sync.Mutex
+	clock             Clock
+	concurrent        int64
+	classes           map[string]int64
+	classesLock       sync.Mutex
+	consecFailures    int64
+	counts            window.Counter
+	disabled          int32
+	current           atomic.Value // *settings
+	halfOpens         int64
+	halfOpenSuccesses int64
+	historySize       int
+	inFlight          int64
+	lastError         atomic.Value // lastError
+	lastFailure       int64
+	lastSave          int64
+	lastTrip          int64
+	listeners         []listener
+	listenersLock     sync.RWMutex
+	logger            Logger
+	labels            map[string]string
+	longestOpen       int64
+	maxConcurrent     int64
+	name              string
+	nextBackOff       int64
+	openTime          int64
+	probe             chan struct{}
+	probeLock         sync.Mutex
+	probeStart        time.Time
+	queued            int64
+	recovering        int32
+	reconfigureLock   sync.Mutex
+	retryAfter        int64
+	saver             *stateSaver
+	storage           Storage
+	storageInterval   time.Duration
+	tripped           int32
+	tripperStates     sync.Map // state of stateful trippers, keyed by tripper
+	transitions       *transitionLog
+	trips             int64
+	warmUntil         time.Time
+	warmup            time.Duration
+	windowBuckets     int
+	windowSize        int
+	windowTime        time.Duration
+}
+
+// stateSaver saves the states of a breaker to its storage in the
+// background, so that calls never wait for the storage. States that
+// are queued while a save is in progress replace each other, so that
+// only the latest one is saved next
+type stateSaver struct {
+	mutex   sync.Mutex
+	pending *StoredState
+	running bool
+	storage Storage
+}
+
+// lastError holds the error of the last failure of a breaker, so that
+// it can be stored in an atomic.Value even when it is nil
+type lastError struct {
+	err error
+}
+
+// settings holds the parameters of a breaker that may be changed by
+// Reconfigure. Options write to the settings embedded in the breaker,
+// which are then published as a whole, so that calls always see a
+// consistent set of values
+type settings struct {
+	defaultTimeout   time.Duration
+	fallback         Circuit
+	halfOpenRequests int64
+	categorize       ErrorCategorizer
+	isFailure        ErrorClassifier
+	jitter           float64
+	minSamples       int64
+	probeWait        time.Duration
+	queueSize        int64
+	queueWait        time.Duration
+	recovery         time.Duration
+	shadow           bool
+	successThreshold int64
+	tripper          Tripper
 }
 
 // Circuit is the interface for things that can be Call'ed
@@ -187,22 +808,258 @@ type Circuit interface {
 	Execute() error
 }
 
+// ContextCircuit is implemented by circuits that accept the context of
+// the call. If the circuit given to Call() implements it, ExecuteContext
+// is called instead of Execute, with the context given via WithContext.
+// The context is canceled when the call times out, so that the circuit
+// can stop instead of running in the background after Call() returns.
+type ContextCircuit interface {
+	Circuit
+	ExecuteContext(context.Context) error
+}
+
+// CircuitContextFunc is a function that implements ContextCircuit.
+// When it is called through Execute(), it receives context.Background()
+type CircuitContextFunc func(context.Context) error
+
 // CircuitFunc is a Cuircuit represented as a standalone function
 type CircuitFunc func() error
 
-// Option is the interface used to provide optional arguments
+// ErrorClassifier is used to determine if an error returned from a
+// Circuit should be recorded as a failure. It should return true if
+// the error is to be counted as a failure.
+type ErrorClassifier func(error) bool
+
+// ErrorCategorizer is used to determine the class under which a failure
+// is counted by FailuresByClass. If it returns an empty string, the
+// default classes are used.
+type ErrorCategorizer func(error) string
+
+// The classes of failures that are recognized by default. Errors can
+// be given their own class by implementing FailureClass() string.
+const (
+	FailureTimeout           = "timeout"
+	FailureConnectionRefused = "connection_refused"
+	FailureOther             = "other"
+)
+
+// Option is the interface used to provide optional arguments.
+// All options of this package implement this interface, which is kept
+// for compatibility with code that collects them in a slice (see
+// BreakerOptions and CallOptions). Options are applied through their
+// typed interfaces, such as BreakerOption and CallOption, which specify
+// where an option may be used.
 type Option interface {
 	Name() string
 	Get() interface{}
 }
 
+// BreakerOption is an option that can be passed to `New`
+type BreakerOption interface {
+	Option
+	applyBreaker(*breaker)
+}
+
+// CallOption is an option that can be passed to `Breaker.Call`
+type CallOption interface {
+	Option
+	applyCall(*callConfig)
+}
+
+// SharedOption is an option that can be passed to both `New`
+// and `Breaker.Call`
+type SharedOption interface {
+	BreakerOption
+	CallOption
+}
+
+type breakerOption struct {
+	*option.Value
+	apply func(*breaker)
+}
+
+type callOption struct {
+	*option.Value
+	apply func(*callConfig)
+}
+
+type sharedOption struct {
+	*option.Value
+	breakerFn func(*breaker)
+	callFn    func(*callConfig)
+}
+
+// SubscribeOption is an option that can be passed to
+// `EventEmitter.Subscribe`
+type SubscribeOption interface {
+	Option
+	applySubscribe(*EventSubscription)
+}
+
+type subscribeOption struct {
+	*option.Value
+	apply func(*EventSubscription)
+}
+
+// GroupOption is an option that can be passed to `NewGroup`
+type GroupOption interface {
+	Option
+	applyGroup(*Group)
+}
+
+type groupOption struct {
+	*option.Value
+	apply func(*Group)
+}
+
+// Group is a Map of child breakers, e.g. one per endpoint, under a
+// parent breaker, e.g. for the whole service. The children returned
+// by the Group reject calls while the parent is open, and the parent
+// trips when enough of the children are open. Calls made through the
+// children are recorded by the parent too, so the parent can also
+// trip on its own.
+type Group struct {
+	children Map
+	fraction float64
+	parent   Breaker
+}
+
+// groupChild is a child breaker of a Group
+type groupChild struct {
+	Breaker
+	group *Group
+}
+
+// Keyed protects a request function, such as an RPC stub of a framework
+// without middleware support, with breakers selected by a key extracted
+// from each request
+type Keyed[Req, Resp any] struct {
+	breakers Map
+	factory  BreakerFactory
+	fn       func(context.Context, Req) (Resp, error)
+	key      func(Req) string
+}
+
+// AdaptiveOption is an option that can be passed to `NewAdaptiveBreaker`
+type AdaptiveOption interface {
+	Option
+	applyAdaptive(*AdaptiveBreaker)
+}
+
+type adaptiveOption struct {
+	*option.Value
+	apply func(*AdaptiveBreaker)
+}
+
+// AdaptiveBreaker limits the number of concurrent calls made through
+// a Breaker, and adapts the limit to the capacity of the service using
+// additive-increase/multiplicative-decrease: the limit grows slowly
+// while calls succeed, and shrinks quickly when they fail or become
+// slow, much like TCP congestion control.
+type AdaptiveBreaker struct {
+	Breaker
+	clock     Clock
+	decrease  float64
+	inFlight  int64
+	latency   time.Duration
+	limit     float64
+	limitLock sync.Mutex
+	maxLimit  float64
+	minLimit  float64
+}
+
+// callConfig holds the configuration for a single `Call`
+type callConfig struct {
+	ctx      context.Context
+	fallback Circuit
+	force    bool
+	timeout  time.Duration
+}
+
 // Map represents a map of breakers
 type Map interface {
+	// Delete removes the breaker associated with the given name
+	Delete(string)
+
+	// Get returns the breaker associated with the given name
 	Get(string) (Breaker, bool)
+
+	// GetOrCreate returns the breaker associated with the given name.
+	// If no breaker exists, the factory function is called to create
+	// a new breaker, which is stored in the map and returned. The factory
+	// is called at most once per name, even under concurrent access.
+	GetOrCreate(string, BreakerFactory) Breaker
+
+	// Len returns the number of breakers in the map
+	Len() int
+
+	// Range calls the given function for each breaker in the map.
+	// If the function returns false, the iteration is stopped.
+	// The function may safely modify the map.
+	Range(func(string, Breaker) bool)
+
+	// Set associates the breaker with the given name
 	Set(string, Breaker)
 }
 
+// BreakerFactory is used to create breakers on demand
+type BreakerFactory func() Breaker
+
 type simpleMap struct {
 	mutex    sync.RWMutex
 	breakers map[string]Breaker
 }
+
+// shardedMap spreads the breakers over several simpleMaps, so that
+// lookups of different names rarely contend for the same lock
+type shardedMap struct {
+	mask   uint32
+	shards []*simpleMap
+}
+
+// MapOption is an option that can be passed to `NewExpiringMap`
+type MapOption interface {
+	Option
+	applyMap(*ExpiringMap)
+}
+
+type mapOption struct {
+	*option.Value
+	apply func(*ExpiringMap)
+}
+
+// ClockOption is an option that can be passed to both `New` and
+// `NewExpiringMap`
+type ClockOption interface {
+	BreakerOption
+	MapOption
+}
+
+type clockOption struct {
+	*option.Value
+	v Clock
+}
+
+// ExpiringMap is a Map that evicts breakers that have not been used
+// for a while, and the least recently used breakers once it holds too
+// many of them, so that maps of per-customer or per-URL breakers do
+// not grow forever
+type ExpiringMap struct {
+	clock           Clock
+	closeOnce       sync.Once
+	done            chan struct{}
+	entries         map[string]*list.Element
+	janitorInterval time.Duration
+	lru             *list.List // most recently used first
+	maxEntries      int
+	mutex           sync.Mutex
+	onEvict         func(string, Breaker)
+	ttl             time.Duration
+}
+
+type expiringEntry struct {
+	name     string
+	cb       Breaker
+	lastUsed time.Time
+	ttl      time.Duration
+}
@@ -0,0 +1,161 @@
+package window
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// NewCount creates a new window holding the outcomes and the latencies
+// of the last size calls.
+func NewCount(size int) *CountWindow {
+	return &CountWindow{
+		latencies: make([]time.Duration, 0, size),
+		outcomes:  make([]bool, size),
+	}
+}
+
+// record adds an outcome, evicting the oldest one if the window is
+// full. record assumes that the caller has locked the lock
+func (w *CountWindow) record(failed bool) {
+	if w.filled == len(w.outcomes) {
+		if w.outcomes[w.next] {
+			atomic.AddInt64(&w.failures, -1)
+		} else {
+			atomic.AddInt64(&w.successes, -1)
+		}
+	} else {
+		w.filled++
+	}
+
+	w.outcomes[w.next] = failed
+	if failed {
+		atomic.AddInt64(&w.failures, 1)
+	} else {
+		atomic.AddInt64(&w.successes, 1)
+	}
+	w.next = (w.next + 1) % len(w.outcomes)
+}
+
+// Fail records a failure.
+func (w *CountWindow) Fail() {
+	w.lock.Lock()
+	w.record(true)
+	w.lock.Unlock()
+}
+
+// Success records a success.
+func (w *CountWindow) Success() {
+	w.lock.Lock()
+	w.record(false)
+	w.lock.Unlock()
+}
+
+// Observe records the latency of a call, evicting the oldest latency
+// if the window is full.
+func (w *CountWindow) Observe(d time.Duration) {
+	w.lock.Lock()
+	if len(w.latencies) < cap(w.latencies) {
+		w.latencies = append(w.latencies, d)
+	} else {
+		w.latencySum -= w.latencies[w.latencyNext]
+		w.latencies[w.latencyNext] = d
+		w.latencyNext = (w.latencyNext + 1) % len(w.latencies)
+	}
+	w.latencySum += d
+	w.lock.Unlock()
+}
+
+// AverageLatency returns the average latency of the calls in the window.
+func (w *CountWindow) AverageLatency() time.Duration {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if len(w.latencies) == 0 {
+		return 0
+	}
+	return w.latencySum / time.Duration(len(w.latencies))
+}
+
+// Percentile returns the latency below which p percent of the calls
+// in the window fall.
+func (w *CountWindow) Percentile(p float64) time.Duration {
+	w.lock.RLock()
+	list := make([]time.Duration, len(w.latencies))
+	copy(list, w.latencies)
+	w.lock.RUnlock()
+
+	if len(list) == 0 {
+		return 0
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+	return list[rank(int64(len(list)), p)-1]
+}
+
+// Buckets returns a single bucket holding the counts of the window.
+// As the time of each outcome is not recorded, the bucket covers no
+// particular period of time.
+func (w *CountWindow) Buckets() []BucketStats {
+	f, s := w.Counts()
+	return []BucketStats{{Failures: f, Successes: s}}
+}
+
+// Counts returns the number of failures and successes in the window.
+// The counts are read without locking, so an outcome that is being
+// recorded concurrently may be reflected in only one of them.
+func (w *CountWindow) Counts() (failures, successes int64) {
+	return atomic.LoadInt64(&w.failures), atomic.LoadInt64(&w.successes)
+}
+
+// CountsSince returns the number of failures and successes in the
+// window. As the time of each outcome is not recorded, d is ignored.
+func (w *CountWindow) CountsSince(d time.Duration) (failures, successes int64) {
+	return w.Counts()
+}
+
+// Failures returns the number of failures in the window.
+func (w *CountWindow) Failures() int64 {
+	f, _ := w.Counts()
+	return f
+}
+
+// Successes returns the number of successes in the window.
+func (w *CountWindow) Successes() int64 {
+	_, s := w.Counts()
+	return s
+}
+
+// ErrorRate returns the error rate calculated over the window,
+// expressed as a floating point number (e.g. 0.9 for 90%)
+func (w *CountWindow) ErrorRate() float64 {
+	f, s := w.Counts()
+	if f+s == 0 {
+		return 0.0
+	}
+	return float64(f) / float64(f+s)
+}
+
+// Reset removes all outcomes from the window.
+func (w *CountWindow) Reset() {
+	w.lock.Lock()
+	atomic.StoreInt64(&w.failures, 0)
+	w.filled = 0
+	w.latencies = w.latencies[:0]
+	w.latencyNext = 0
+	w.latencySum = 0
+	w.next = 0
+	atomic.StoreInt64(&w.successes, 0)
+	w.lock.Unlock()
+}
+
+// Restore adds previously recorded failures and successes. As the
+// order of the outcomes is unknown, failures are added first.
+func (w *CountWindow) Restore(failures, successes int64) {
+	w.lock.Lock()
+	for i := int64(0); i < failures; i++ {
+		w.record(true)
+	}
+	for i := int64(0); i < successes; i++ {
+		w.record(false)
+	}
+	w.lock.Unlock()
+}
@@ -10,20 +10,79 @@ type clock interface {
 	Now() time.Time
 }
 
-// Bucket holds counts of failures and successes
+// Counter records the outcome of calls and computes statistics
+// over the recorded outcomes
+type Counter interface {
+	AverageLatency() time.Duration
+	Buckets() []BucketStats
+	Counts() (failures, successes int64)
+	CountsSince(time.Duration) (failures, successes int64)
+	ErrorRate() float64
+	Fail()
+	Failures() int64
+	Observe(time.Duration)
+	Percentile(float64) time.Duration
+	Reset()
+	Restore(failures, successes int64)
+	Success()
+	Successes() int64
+}
+
+// latencyBins is the number of bins in a latency histogram. Bin i
+// holds latencies below 2^i microseconds, so the last bin covers
+// latencies of over half an hour.
+const latencyBins = 32
+
+// Bucket holds counts of failures and successes, and the latencies
+// of the calls
+//
+// The counts are updated atomically, so that outcomes can be recorded
+// while holding only a read lock on the window.
 type Bucket struct {
-	failure int64
-	success int64
+	failure   int64
+	histogram [latencyBins]int64
+	latencies int64
+	max       int64
+	min       int64
+	success   int64
+	sum       int64
+}
+
+// BucketStats holds the counts of a bucket, and the period of time
+// that it covers
+type BucketStats struct {
+	Duration  time.Duration
+	Failures  int64
+	Start     time.Time
+	Successes int64
 }
 
 // Window maintains a ring of buckets and increments the failure and success
 // counts of the current bucket. Once a specified time has elapsed, it will
 // advance to the next bucket, reseting its counts. This allows the keeping of
-// rolling statistics on the counts.
+// rolling statistics on the counts. Running totals of the counts are
+// maintained as buckets are updated, so that they can be read without
+// walking the buckets.
 type Window struct {
+	failures   int64
+	successes  int64
 	buckets    *ring.Ring
 	bucketTime time.Duration
 	bucketLock sync.RWMutex
 	lastAccess time.Time
 	clock      clock
 }
+
+// CountWindow keeps the outcomes of the last N calls, regardless of
+// when they happened.
+type CountWindow struct {
+	failures    int64
+	filled      int
+	latencies   []time.Duration
+	latencyNext int
+	latencySum  time.Duration
+	lock        sync.RWMutex
+	next        int
+	outcomes    []bool
+	successes   int64
+}
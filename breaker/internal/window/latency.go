@@ -0,0 +1,62 @@
+package window
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// latencyBin returns the index of the histogram bin for d
+func latencyBin(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	i := bits.Len64(uint64(d / time.Microsecond))
+	if i >= latencyBins {
+		i = latencyBins - 1
+	}
+	return i
+}
+
+// rank returns the 1-based rank of the p-th percentile of n values
+func rank(n int64, p float64) int64 {
+	r := int64(math.Ceil(p / 100 * float64(n)))
+	if r < 1 {
+		r = 1
+	}
+	if r > n {
+		r = n
+	}
+	return r
+}
+
+// histogramPercentile returns the upper bound of the bin holding the
+// p-th percentile of the n values in the histogram, clamped to the
+// smallest and largest values observed
+func histogramPercentile(histogram []int64, n int64, p float64, min, max time.Duration) time.Duration {
+	r := rank(n, p)
+	switch r {
+	case 1:
+		return min
+	case n:
+		return max
+	}
+
+	var seen int64
+	for i, v := range histogram {
+		seen += v
+		if seen < r {
+			continue
+		}
+
+		d := time.Duration(uint64(1)<<uint(i)) * time.Microsecond
+		if d < min {
+			d = min
+		}
+		if d > max {
+			d = max
+		}
+		return d
+	}
+	return max
+}
@@ -2,23 +2,54 @@ package window
 
 import (
 	"container/ring"
+	"math"
+	"sync/atomic"
 	"time"
 )
 
-// Reset resets the counts to 0
+// Reset resets the counts to 0. It must not be called concurrently
+// with other methods
 func (b *Bucket) Reset() {
-	b.failure = 0
-	b.success = 0
+	*b = Bucket{min: math.MaxInt64}
+}
+
+// Observe records the latency of a call
+func (b *Bucket) Observe(d time.Duration) {
+	for {
+		min := atomic.LoadInt64(&b.min)
+		if int64(d) >= min || atomic.CompareAndSwapInt64(&b.min, min, int64(d)) {
+			break
+		}
+	}
+	for {
+		max := atomic.LoadInt64(&b.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&b.max, max, int64(d)) {
+			break
+		}
+	}
+	atomic.AddInt64(&b.histogram[latencyBin(d)], 1)
+	atomic.AddInt64(&b.sum, int64(d))
+	atomic.AddInt64(&b.latencies, 1)
 }
 
 // Fail increments the failure count
 func (b *Bucket) Fail() {
-	b.failure++
+	atomic.AddInt64(&b.failure, 1)
 }
 
 // Success increments the success count
 func (b *Bucket) Success() {
-	b.success++
+	atomic.AddInt64(&b.success, 1)
+}
+
+// Failures returns the failure count
+func (b *Bucket) Failures() int64 {
+	return atomic.LoadInt64(&b.failure)
+}
+
+// Successes returns the success count
+func (b *Bucket) Successes() int64 {
+	return atomic.LoadInt64(&b.success)
 }
 
 // New creates a new window. windowTime is the time covering the entire
@@ -28,7 +59,9 @@ func (b *Bucket) Success() {
 func New(c clock, windowTime time.Duration, windowBuckets int) *Window {
 	buckets := ring.New(windowBuckets)
 	for i := 0; i < buckets.Len(); i++ {
-		buckets.Value = &Bucket{}
+		var b Bucket
+		b.Reset()
+		buckets.Value = &b
 		buckets = buckets.Next()
 	}
 
@@ -41,63 +74,191 @@ func New(c clock, windowTime time.Duration, windowBuckets int) *Window {
 	}
 }
 
-// Fail records a failure in the current bucket.
-func (w *Window) Fail() {
+// record calls f with the current bucket. Unless the current bucket
+// has expired, only a read lock is taken, which allows outcomes to be
+// recorded concurrently.
+func (w *Window) record(f func(*Bucket)) {
+	w.bucketLock.RLock()
+	if w.clock.Now().Sub(w.lastAccess) <= w.bucketTime {
+		f(w.buckets.Value.(*Bucket))
+		w.bucketLock.RUnlock()
+		return
+	}
+	w.bucketLock.RUnlock()
+
 	w.bucketLock.Lock()
-	b := w.getLatestBucket()
-	b.Fail()
+	f(w.getLatestBucket())
 	w.bucketLock.Unlock()
 }
 
+// Fail records a failure in the current bucket.
+func (w *Window) Fail() {
+	w.record(func(b *Bucket) {
+		b.Fail()
+		atomic.AddInt64(&w.failures, 1)
+	})
+}
+
 // Success records a success in the current bucket.
 func (w *Window) Success() {
-	w.bucketLock.Lock()
-	b := w.getLatestBucket()
-	b.Success()
-	w.bucketLock.Unlock()
+	w.record(func(b *Bucket) {
+		b.Success()
+		atomic.AddInt64(&w.successes, 1)
+	})
 }
 
-// Failures returns the total number of failures recorded in all buckets.
-func (w *Window) Failures() int64 {
-	w.bucketLock.RLock()
-
-	var failures int64
-	w.buckets.Do(func(x interface{}) {
-		b := x.(*Bucket)
-		failures += b.failure
+// Observe records the latency of a call in the current bucket.
+func (w *Window) Observe(d time.Duration) {
+	w.record(func(b *Bucket) {
+		b.Observe(d)
 	})
-
-	w.bucketLock.RUnlock()
-	return failures
 }
 
-// Successes returns the total number of successes recorded in all buckets.
-func (w *Window) Successes() int64 {
-	w.bucketLock.RLock()
+// AverageLatency returns the average latency of the calls recorded
+// in all buckets.
+func (w *Window) AverageLatency() time.Duration {
+	var n int64
+	var sum time.Duration
 
-	var successes int64
+	w.bucketLock.RLock()
 	w.buckets.Do(func(x interface{}) {
 		b := x.(*Bucket)
-		successes += b.success
+		n += atomic.LoadInt64(&b.latencies)
+		sum += time.Duration(atomic.LoadInt64(&b.sum))
 	})
 	w.bucketLock.RUnlock()
-	return successes
+
+	if n == 0 {
+		return 0
+	}
+	return sum / time.Duration(n)
 }
 
-// ErrorRate returns the error rate calculated over all buckets, expressed as
-// a floating point number (e.g. 0.9 for 90%)
-func (w *Window) ErrorRate() float64 {
-	var total int64
-	var failures int64
+// Percentile returns the latency below which p percent of the calls
+// recorded in all buckets fall. The result is approximated from a
+// histogram where each bin covers a power of two of microseconds.
+func (w *Window) Percentile(p float64) time.Duration {
+	var histogram [latencyBins]int64
+	var n int64
+	min := time.Duration(math.MaxInt64)
+	var max time.Duration
 
 	w.bucketLock.RLock()
 	w.buckets.Do(func(x interface{}) {
 		b := x.(*Bucket)
-		total += b.failure + b.success
-		failures += b.failure
+		if atomic.LoadInt64(&b.latencies) == 0 {
+			return
+		}
+		var count int64
+		for i := range b.histogram {
+			v := atomic.LoadInt64(&b.histogram[i])
+			histogram[i] += v
+			count += v
+		}
+		n += count
+		if v := time.Duration(atomic.LoadInt64(&b.min)); v < min {
+			min = v
+		}
+		if v := time.Duration(atomic.LoadInt64(&b.max)); v > max {
+			max = v
+		}
 	})
 	w.bucketLock.RUnlock()
 
+	if n == 0 {
+		return 0
+	}
+	return histogramPercentile(histogram[:], n, p, min, max)
+}
+
+// Buckets returns the counts of each bucket, from the oldest to the
+// most recent one. The start of each bucket is computed from the time
+// when the most recent bucket was started.
+func (w *Window) Buckets() []BucketStats {
+	w.bucketLock.RLock()
+	defer w.bucketLock.RUnlock()
+
+	n := w.buckets.Len()
+	list := make([]BucketStats, 0, n)
+	r := w.buckets.Next() // the oldest bucket
+	for i := 0; i < n; i++ {
+		b := r.Value.(*Bucket)
+		list = append(list, BucketStats{
+			Duration:  w.bucketTime,
+			Failures:  b.Failures(),
+			Start:     w.lastAccess.Add(-time.Duration(n-1-i) * w.bucketTime),
+			Successes: b.Successes(),
+		})
+		r = r.Next()
+	}
+	return list
+}
+
+// Failures returns the total number of failures recorded in all buckets.
+func (w *Window) Failures() int64 {
+	return atomic.LoadInt64(&w.failures)
+}
+
+// Successes returns the total number of successes recorded in all buckets.
+func (w *Window) Successes() int64 {
+	return atomic.LoadInt64(&w.successes)
+}
+
+// Restore adds previously recorded failures and successes to the
+// current bucket.
+func (w *Window) Restore(failures, successes int64) {
+	w.bucketLock.Lock()
+	b := w.getLatestBucket()
+	atomic.AddInt64(&b.failure, failures)
+	atomic.AddInt64(&b.success, successes)
+	atomic.AddInt64(&w.failures, failures)
+	atomic.AddInt64(&w.successes, successes)
+	w.bucketLock.Unlock()
+}
+
+// Counts returns the total number of failures and successes recorded
+// in all buckets. The totals are read without locking, so an outcome
+// that is being recorded concurrently may be reflected in only one
+// of them.
+func (w *Window) Counts() (failures, successes int64) {
+	return atomic.LoadInt64(&w.failures), atomic.LoadInt64(&w.successes)
+}
+
+// CountsSince returns the number of failures and successes recorded
+// in the buckets covering the last d. As outcomes are only tracked
+// per bucket, the counts cover up to one bucket more than d. If d is
+// longer than the window, the counts of all buckets are returned.
+func (w *Window) CountsSince(d time.Duration) (failures, successes int64) {
+	w.bucketLock.RLock()
+	defer w.bucketLock.RUnlock()
+
+	r := w.buckets
+	if max := time.Duration(r.Len()) * w.bucketTime; d > max {
+		d = max
+	}
+
+	// Buckets that are past their time may not have been reset yet,
+	// so the cutoff is computed from the current time
+	cutoff := w.clock.Now().Add(-d)
+	start := w.lastAccess
+	for i := 0; i < r.Len(); i++ {
+		if !start.Add(w.bucketTime).After(cutoff) {
+			break
+		}
+		b := r.Value.(*Bucket)
+		failures += b.Failures()
+		successes += b.Successes()
+		start = start.Add(-w.bucketTime)
+		r = r.Prev()
+	}
+	return failures, successes
+}
+
+// ErrorRate returns the error rate calculated over all buckets, expressed as
+// a floating point number (e.g. 0.9 for 90%)
+func (w *Window) ErrorRate() float64 {
+	failures, successes := w.Counts()
+	total := failures + successes
 	if total == 0 {
 		return 0.0
 	}
@@ -112,6 +273,8 @@ func (w *Window) Reset() {
 	w.buckets.Do(func(x interface{}) {
 		x.(*Bucket).Reset()
 	})
+	atomic.StoreInt64(&w.failures, 0)
+	atomic.StoreInt64(&w.successes, 0)
 	w.bucketLock.Unlock()
 }
 
@@ -130,6 +293,9 @@ func (w *Window) getLatestBucket() *Bucket {
 		for i := 0; i < w.buckets.Len(); i++ {
 			w.buckets = w.buckets.Next()
 			b = w.buckets.Value.(*Bucket)
+			// Remove the expired counts from the running totals
+			atomic.AddInt64(&w.failures, -b.failure)
+			atomic.AddInt64(&w.successes, -b.success)
 			b.Reset()
 			elapsed = time.Duration(int64(elapsed) - int64(w.bucketTime))
 			if elapsed < w.bucketTime {
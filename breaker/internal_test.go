@@ -1,19 +1,28 @@
 package breaker
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"net"
+	"os"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
-	"github.com/cenk/backoff"
 	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	pkgerrors "github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
 func defaultBackOff(c Clock) backoff.BackOff {
 	bo := backoff.NewExponentialBackOff()
 	bo.InitialInterval = time.Millisecond
+	// Keep the intervals predictable, so that tests can advance
+	// the clock past the interval used by the breaker
+	bo.RandomizationFactor = 0
 	bo.Clock = c
 	bo.Reset()
 	return bo
@@ -23,9 +32,14 @@ func TestSanity(t *testing.T) {
 	var b Breaker
 	b = &breaker{}
 	_ = b
+
+	var m Map
+	m = &Group{}
+	m = &MapEmitter{}
+	_ = m
 }
 
-func newBreaker(options ...Option) Breaker {
+func newBreaker(options ...BreakerOption) Breaker {
 	var c Clock
 	var bo backoff.BackOff
 	for _, option := range options {
@@ -197,7 +211,7 @@ func TestThresholdBreakerResets(t *testing.T) {
 		return
 	}
 
-	c.Add(bo.NextBackOff() + time.Second)
+	c.Add(bo.NextBackOff() + 1)
 	for i := 0; i < 4; i++ {
 		t.Logf("Attempting subsequent call %d, should succeed", i)
 		if !assert.NoError(t, cb.Call(circuit), "Expected cb to be successful (#%d)", i) {
@@ -275,6 +289,92 @@ func TestRateBreakerSampleSize(t *testing.T) {
 	}
 }
 
+func TestMinSamples(t *testing.T) {
+	cb := newBreaker(
+		WithTripper(ConsecutiveTripper(1)),
+		WithMinSamples(3),
+	)
+	cb.(*breaker).fail()
+	cb.(*breaker).fail()
+	if !assert.False(t, cb.Tripped(), "breaker should not trip below the minimum number of samples") {
+		return
+	}
+	cb.(*breaker).fail()
+	if !assert.True(t, cb.Tripped(), "breaker should trip once there are enough samples") {
+		return
+	}
+}
+
+func TestBudgetTripper(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(24*time.Hour - 10*time.Minute)
+	cb := newBreaker(
+		WithClock(c),
+		WithTripper(BudgetTripper(0.1, 24*time.Hour)),
+	)
+
+	for i := 0; i < 9; i++ {
+		cb.(*breaker).success(Closed)
+	}
+	cb.(*breaker).fail()
+	if !assert.False(t, cb.Tripped(), "breaker should not trip within the budget") {
+		return
+	}
+
+	// Calls are counted for the whole period, not only over the
+	// window of the breaker
+	c.Add(time.Minute)
+	for i := 0; i < 90; i++ {
+		cb.(*breaker).success(Closed)
+	}
+	c.Add(time.Minute)
+	cb.(*breaker).fail()
+	if !assert.False(t, cb.Tripped(), "calls older than the window should be counted") {
+		return
+	}
+	for i := 0; i < 10; i++ {
+		cb.(*breaker).fail()
+	}
+	if !assert.True(t, cb.Tripped(), "breaker should trip once the budget of the period is exhausted") {
+		return
+	}
+
+	// The breaker is reset when the next period starts
+	c.Add(8 * time.Minute)
+	for i := 0; i < 1000 && cb.Tripped(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if !assert.False(t, cb.Tripped(), "breaker should be reset at the start of the period") {
+		return
+	}
+
+	// The calls of the previous period don't count anymore
+	cb.(*breaker).success(Closed)
+	cb.(*breaker).fail()
+	if !assert.True(t, cb.Tripped(), "breaker should trip once the budget of the new period is exhausted") {
+		return
+	}
+}
+
+func TestWarmup(t *testing.T) {
+	c := clock.NewMock()
+	cb := newBreaker(
+		WithClock(c),
+		WithTripper(ThresholdTripper(1)),
+		WithWarmup(time.Minute),
+	)
+	cb.(*breaker).fail()
+	if !assert.False(t, cb.Tripped(), "breaker should not trip while warming up") {
+		return
+	}
+
+	c.Add(time.Minute)
+	cb.(*breaker).fail()
+	if !assert.True(t, cb.Tripped(), "breaker should trip once warmed up") {
+		return
+	}
+}
+
 func TestRateBreakerResets(t *testing.T) {
 	serviceError := errors.New("service error")
 
@@ -310,7 +410,7 @@ func TestRateBreakerResets(t *testing.T) {
 		return
 	}
 
-	c.Add(bo.NextBackOff() + time.Second)
+	c.Add(bo.NextBackOff() + 1)
 	err = cb.Call(circuit)
 	if err != nil {
 		t.Fatal("Expected cb to be successful")
@@ -370,3 +470,1200 @@ func TestBreakerCounts(t *testing.T) {
 		t.Fatalf("expected 0 consecutive failures, got %d", consecFailures)
 	}
 }
+
+func TestFallback(t *testing.T) {
+	fallbackCalled := 0
+	fallback := CircuitFunc(func() error {
+		fallbackCalled++
+		return nil
+	})
+	failing := CircuitFunc(func() error {
+		return errors.New("error")
+	})
+
+	cb := newBreaker(
+		WithTripper(ThresholdTripper(1)),
+		WithFallback(fallback),
+	)
+
+	if !assert.NoError(t, cb.Call(failing), "failed circuit should be replaced by fallback") {
+		return
+	}
+	if !assert.Equal(t, 1, fallbackCalled, "fallback should be called once") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "failure should still be recorded") {
+		return
+	}
+
+	if !assert.NoError(t, cb.Call(failing), "open breaker should execute fallback") {
+		return
+	}
+	if !assert.Equal(t, 2, fallbackCalled, "fallback should be called twice") {
+		return
+	}
+
+	fallbackErr := errors.New("fallback error")
+	err := cb.Call(failing, WithFallback(CircuitFunc(func() error {
+		return fallbackErr
+	})))
+	if !assert.Equal(t, fallbackErr, err, "per-call fallback should override default") {
+		return
+	}
+}
+
+func TestErrorClassifier(t *testing.T) {
+	ignorable := errors.New("ignorable")
+	cb := newBreaker(
+		WithTripper(ConsecutiveTripper(1)),
+		WithErrorClassifier(func(err error) bool {
+			return err != ignorable
+		}),
+	)
+
+	err := cb.Call(CircuitFunc(func() error { return ignorable }))
+	if !assert.Equal(t, ignorable, err, "error should be returned to the caller") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "ignorable error should not trip the breaker") {
+		return
+	}
+	if !assert.Equal(t, int64(0), cb.Failures(), "ignorable error should not be counted") {
+		return
+	}
+	if !assert.Equal(t, int64(0), cb.Successes(), "ignorable error should not be counted as a success") {
+		return
+	}
+
+	cb.Call(CircuitFunc(func() error { return errors.New("error") }))
+	if !assert.True(t, cb.Tripped(), "other errors should trip the breaker") {
+		return
+	}
+
+	// Ignored errors neither break a streak of failures, nor close a
+	// half-open breaker
+	c := clock.NewMock()
+	cb = newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(time.Second)),
+		WithClock(c),
+		WithTripper(ConsecutiveTripper(3)),
+		WithErrorClassifier(func(err error) bool {
+			return err != ignorable
+		}),
+	)
+	failing := CircuitFunc(func() error { return errors.New("error") })
+	cb.Call(failing)
+	cb.Call(failing)
+	cb.Call(CircuitFunc(func() error { return ignorable }))
+	if !assert.Equal(t, int64(2), cb.ConsecFailures(), "ignorable error should not reset consecutive failures") {
+		return
+	}
+	if !assert.Equal(t, int64(0), cb.Successes(), "ignorable error should not be counted as a success") {
+		return
+	}
+	cb.Call(failing)
+	if !assert.True(t, cb.Tripped(), "consecutive failures should trip the breaker") {
+		return
+	}
+
+	c.Add(time.Second + 1)
+	cb.Call(CircuitFunc(func() error { return ignorable }))
+	if !assert.True(t, cb.Tripped(), "ignorable error on a probe should not close the breaker") {
+		return
+	}
+}
+
+func TestHalfOpenRequests(t *testing.T) {
+	c := clock.NewMock()
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = time.Second
+	bo.Multiplier = 2
+	bo.RandomizationFactor = 0
+	bo.Clock = c
+	bo.Reset()
+
+	cb := newBreaker(
+		WithBackOff(bo),
+		WithClock(c),
+		WithHalfOpenRequests(3),
+	)
+
+	cb.Trip()
+	c.Add(time.Second + 1)
+	for i := 0; i < 3; i++ {
+		if r, st := cb.Ready(); !assert.True(t, r, "probe #%d should be allowed", i) || !assert.Equal(t, Halfopen, st, "state should be halfopen") {
+			return
+		}
+	}
+	if r, _ := cb.Ready(); !assert.False(t, r, "only 3 probes should be allowed") {
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		cb.(*breaker).success(Halfopen)
+		if !assert.True(t, cb.Tripped(), "breaker should wait for all probes") {
+			return
+		}
+	}
+	cb.(*breaker).success(Halfopen)
+	if !assert.False(t, cb.Tripped(), "breaker should be reset after all probes succeed") {
+		return
+	}
+}
+
+func TestSuccessThreshold(t *testing.T) {
+	c := clock.NewMock()
+	bo := defaultBackOff(c)
+	cb := newBreaker(
+		WithBackOff(bo),
+		WithClock(c),
+		WithSuccessThreshold(3),
+	)
+
+	cb.Trip()
+	cb.(*breaker).success(Halfopen)
+	cb.(*breaker).success(Halfopen)
+	if !assert.True(t, cb.Tripped(), "breaker should not be reset before reaching the threshold") {
+		return
+	}
+
+	cb.(*breaker).fail()
+	cb.(*breaker).success(Halfopen)
+	cb.(*breaker).success(Halfopen)
+	if !assert.True(t, cb.Tripped(), "failure should restart the count") {
+		return
+	}
+
+	cb.(*breaker).success(Halfopen)
+	if !assert.False(t, cb.Tripped(), "breaker should be reset after 3 consecutive successes") {
+		return
+	}
+}
+
+func TestCompositeTrippers(t *testing.T) {
+	var called []string
+	tripper := func(name string, v bool) Tripper {
+		return TripFunc(func(cb Breaker) bool {
+			called = append(called, name)
+			return v
+		})
+	}
+	cb := newBreaker()
+
+	t.Run("AnyTripper", func(t *testing.T) {
+		called = nil
+		if !assert.True(t, AnyTripper(tripper("a", false), tripper("b", true), tripper("c", true)).Trip(cb), "should trip") {
+			return
+		}
+		if !assert.Equal(t, []string{"a", "b"}, called, "should short-circuit") {
+			return
+		}
+		if !assert.False(t, AnyTripper().Trip(cb), "empty AnyTripper should not trip") {
+			return
+		}
+	})
+	t.Run("AllTripper", func(t *testing.T) {
+		called = nil
+		if !assert.False(t, AllTripper(tripper("a", true), tripper("b", false), tripper("c", true)).Trip(cb), "should not trip") {
+			return
+		}
+		if !assert.Equal(t, []string{"a", "b"}, called, "should short-circuit") {
+			return
+		}
+		if !assert.False(t, AllTripper().Trip(cb), "empty AllTripper should not trip") {
+			return
+		}
+	})
+	t.Run("Nested", func(t *testing.T) {
+		nested := AnyTripper(
+			AllTripper(tripper("a", true), tripper("b", false)),
+			AllTripper(tripper("c", true), tripper("d", true)),
+		)
+		called = nil
+		if !assert.True(t, nested.Trip(cb), "should trip") {
+			return
+		}
+		if !assert.Equal(t, []string{"a", "b", "c", "d"}, called, "should evaluate in order") {
+			return
+		}
+	})
+	t.Run("WithCounters", func(t *testing.T) {
+		cb := newBreaker(WithTripper(AllTripper(
+			ConsecutiveTripper(2),
+			RateTripper(0.5, 2),
+		)))
+		cb.(*breaker).fail()
+		if !assert.False(t, cb.Tripped(), "should not trip on first failure") {
+			return
+		}
+		cb.(*breaker).fail()
+		if !assert.True(t, cb.Tripped(), "should trip when both trippers agree") {
+			return
+		}
+	})
+}
+
+func TestErrorPredicates(t *testing.T) {
+	err := errors.New("error")
+	if !assert.False(t, IsOpen(err), "plain errors should not be open errors") {
+		return
+	}
+	if !assert.False(t, IsTimeout(err), "plain errors should not be timeout errors") {
+		return
+	}
+	if !assert.True(t, IsOpen(ErrBreakerOpen), "ErrBreakerOpen should be an open error") {
+		return
+	}
+	if !assert.True(t, IsTimeout(ErrBreakerTimeout), "ErrBreakerTimeout should be a timeout error") {
+		return
+	}
+	if !assert.True(t, IsDeadlineExceeded(ErrDeadlineExceeded), "ErrDeadlineExceeded should be a deadline error") {
+		return
+	}
+}
+
+type classErr struct{}
+
+func (e classErr) Error() string        { return "class error" }
+func (e classErr) FailureClass() string { return "custom" }
+
+func TestFailuresByClass(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	classes := map[error]string{
+		errors.New("error"):                            FailureOther,
+		pkgerrors.Wrap(ErrBreakerTimeout, "wrapped"):   FailureTimeout,
+		pkgerrors.Wrap(ErrDeadlineExceeded, "wrapped"): FailureTimeout,
+		refused:                               FailureConnectionRefused,
+		pkgerrors.Wrap(classErr{}, "wrapped"): "custom",
+	}
+	for err, class := range classes {
+		if !assert.Equal(t, class, failureClass(err), "%s should be classified as %s", err, class) {
+			return
+		}
+	}
+
+	cb := newBreaker(WithErrorCategorizer(func(err error) string {
+		if err.Error() == "validation" {
+			return "validation"
+		}
+		return ""
+	}))
+	cb.MarkFailure(errors.New("validation"))
+	cb.MarkFailure(errors.New("validation"))
+	cb.MarkFailure(ErrBreakerTimeout)
+	cb.MarkFailure(nil)
+	expected := map[string]int64{"validation": 2, FailureTimeout: 1, FailureOther: 1}
+	if !assert.Equal(t, expected, cb.FailuresByClass(), "failures should be counted by class") {
+		return
+	}
+
+	cb.ResetCounters()
+	if !assert.Empty(t, cb.FailuresByClass(), "ResetCounters should clear the classes") {
+		return
+	}
+}
+
+func TestCallContext(t *testing.T) {
+	cb := newBreaker(WithTripper(ThresholdTripper(1)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	called := false
+	err := cb.Call(CircuitFunc(func() error {
+		called = true
+		return nil
+	}), WithContext(ctx))
+	if !assert.Equal(t, context.Canceled, err, "Call should return context.Canceled") {
+		return
+	}
+	if !assert.False(t, called, "circuit should not be executed") {
+		return
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	wait := make(chan struct{})
+	errc := make(chan error)
+	go func() {
+		errc <- cb.Call(CircuitFunc(func() error {
+			close(wait)
+			<-ctx.Done()
+			return ctx.Err()
+		}), WithContext(ctx))
+	}()
+	<-wait
+	cancel()
+
+	if !assert.Equal(t, context.Canceled, <-errc, "Call should return context.Canceled") {
+		return
+	}
+	if !assert.Equal(t, int64(0), cb.Failures(), "cancellation should not be recorded as a failure") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "cancellation should not trip the breaker") {
+		return
+	}
+}
+
+func TestEmitterFanOut(t *testing.T) {
+	e := NewEventEmitter(newBreaker()).(*eventEmitter)
+	if !assert.Nil(t, e.fanOut(), "fan-out should not run without subscriptions") {
+		return
+	}
+
+	s1 := e.Subscribe(context.Background())
+	s2 := e.Subscribe(context.Background())
+	stopped := e.fanOut()
+	if !assert.NotNil(t, stopped, "fan-out should run once subscribed") {
+		return
+	}
+
+	s1.Stop()
+	if !assert.NotNil(t, e.fanOut(), "fan-out should run while subscriptions remain") {
+		return
+	}
+	s2.Stop()
+	<-stopped
+	if !assert.Nil(t, e.fanOut(), "fan-out should stop with the last subscription") {
+		return
+	}
+}
+
+func TestEmitterCloseDetaches(t *testing.T) {
+	cb := newBreaker(WithTripper(ThresholdTripper(1))).(*breaker)
+	listeners := func() int {
+		cb.listenersLock.RLock()
+		defer cb.listenersLock.RUnlock()
+		return len(cb.listeners)
+	}
+	before := listeners()
+
+	e := NewEventEmitter(cb)
+	s := e.Subscribe(context.Background(), WithLossless(true))
+	defer s.Stop()
+	if !assert.Equal(t, before+1, listeners(), "emitter should listen to the breaker") {
+		return
+	}
+
+	if !assert.NoError(t, e.Close(), "Close should succeed") {
+		return
+	}
+	if !assert.Equal(t, before, listeners(), "Close should stop listening to the breaker") {
+		return
+	}
+
+	// Nobody drains the lossless subscription, which must not block
+	// the breaker once the emitter is closed
+	for i := 0; i < eventQueueSize+1; i++ {
+		cb.fail()
+		cb.Reset()
+	}
+}
+
+func TestEmitterStateChanges(t *testing.T) {
+	c := clock.NewMock()
+	e := NewEventEmitter(newBreaker(
+		WithBackOff(defaultBackOff(c)),
+		WithClock(c),
+		WithTripper(ThresholdTripper(1)),
+	)).(*eventEmitter)
+	if !assert.True(t, e.hooked, "emitter should be hooked into the breaker") {
+		return
+	}
+
+	type transition struct {
+		Event Event
+		From  State
+		To    State
+	}
+	drain := func() []transition {
+		var list []transition
+		for {
+			select {
+			case data := <-e.data:
+				list = append(list, transition{data.Event, data.From, data.To})
+			default:
+				return list
+			}
+		}
+	}
+
+	circuitErr := errors.New("error")
+	failure := CircuitFunc(func() error { return circuitErr })
+	success := CircuitFunc(func() error { return nil })
+
+	e.Call(failure)
+	if !assert.Equal(t, []transition{
+		{FailEvent, Closed, Closed},
+		{TrippedEvent, Closed, Open},
+		{StateChangeEvent, Closed, Open},
+	}, drain(), "a failure should trip the breaker") {
+		return
+	}
+
+	c.Add(2 * time.Second)
+	e.Call(failure)
+	if !assert.Equal(t, []transition{
+		{HalfopenEvent, Open, Halfopen},
+		{StateChangeEvent, Open, Halfopen},
+		{FailEvent, Halfopen, Halfopen},
+		{StateChangeEvent, Halfopen, Open},
+	}, drain(), "a failed probe should open the breaker again") {
+		return
+	}
+
+	c.Add(2 * time.Second)
+	e.Call(success)
+	if !assert.Equal(t, []transition{
+		{HalfopenEvent, Open, Halfopen},
+		{StateChangeEvent, Open, Halfopen},
+		{ResetEvent, Halfopen, Closed},
+		{StateChangeEvent, Halfopen, Closed},
+	}, drain(), "a successful probe should close the breaker") {
+		return
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(10*time.Second)),
+		WithClock(c),
+		WithTripper(ThresholdTripper(2)),
+	)
+
+	s := cb.Snapshot()
+	if !assert.Equal(t, Closed, s.State, "breaker should be closed") {
+		return
+	}
+	if !assert.True(t, s.NextRetry.IsZero(), "closed breaker has no retry time") {
+		return
+	}
+
+	cb.Call(CircuitFunc(func() error { return nil }))
+	cb.(*breaker).fail()
+	cb.(*breaker).fail()
+
+	s = cb.Snapshot()
+	if !assert.Equal(t, Snapshot{
+		State:          Open,
+		Failures:       2,
+		Successes:      1,
+		ConsecFailures: 2,
+		ErrorRate:      2.0 / 3.0,
+		LastFailure:    c.Now(),
+		NextRetry:      c.Now().Add(10 * time.Second),
+		Trips:          1,
+		LastTrip:       c.Now(),
+	}, s, "snapshot should match") {
+		return
+	}
+
+	if !assert.Equal(t, s.NextRetry, cb.RetryAt(), "RetryAt should match the snapshot") {
+		return
+	}
+
+	c.Add(11 * time.Second)
+	if !assert.Equal(t, Halfopen, cb.Snapshot().State, "breaker should be ready to retry") {
+		return
+	}
+	// Taking a snapshot must not consume the half-open probe
+	if r, _ := cb.Ready(); !assert.True(t, r, "breaker should still allow a probe") {
+		return
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(10*time.Second)),
+		WithClock(c),
+		WithTripper(ThresholdTripper(1)),
+	)
+
+	closed, err := json.Marshal(cb.Snapshot())
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+	if !assert.Contains(t, string(closed), `"state":"closed"`, "state should be written as a string") {
+		return
+	}
+	if !assert.NotContains(t, string(closed), "last_trip", "unset times should be omitted") {
+		return
+	}
+
+	cb.(*breaker).fail()
+	c.Add(1500 * time.Millisecond)
+	data, err := json.Marshal(cb.Snapshot())
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+	if !assert.Contains(t, string(data), `"open_time":"1.5s"`, "durations should be written as strings") {
+		return
+	}
+
+	var s Snapshot
+	if !assert.NoError(t, json.Unmarshal(data, &s), "Unmarshal should succeed") {
+		return
+	}
+	if !assert.Equal(t, Open, s.State, "state should be read back") {
+		return
+	}
+	if !assert.Equal(t, 1500*time.Millisecond, s.OpenTime, "durations should be read back") {
+		return
+	}
+	if !assert.True(t, s.LastTrip.Equal(c.Now().Add(-1500*time.Millisecond)), "times should be read back") {
+		return
+	}
+	if !assert.Error(t, json.Unmarshal([]byte(`{"state":"broken"}`), &s), "unknown states should be rejected") {
+		return
+	}
+}
+
+func TestOpenStats(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(WithClock(c))
+
+	s := cb.Snapshot()
+	if !assert.True(t, s.LastTrip.IsZero(), "breaker should not have tripped yet") {
+		return
+	}
+
+	tripped := c.Now()
+	cb.Trip()
+	c.Add(3 * time.Second)
+	s = cb.Snapshot()
+	if !assert.Equal(t, tripped, s.LastTrip, "last trip should be recorded") {
+		return
+	}
+	if !assert.Equal(t, 3*time.Second, s.OpenTime, "current streak should count towards the open time") {
+		return
+	}
+	if !assert.Equal(t, 3*time.Second, s.LongestOpen, "current streak should be the longest") {
+		return
+	}
+
+	cb.Reset()
+	c.Add(time.Minute)
+	cb.Trip()
+	c.Add(time.Second)
+	cb.Reset()
+
+	s = cb.Snapshot()
+	if !assert.Equal(t, int64(2), s.Trips, "both trips should be counted") {
+		return
+	}
+	if !assert.Equal(t, 4*time.Second, s.OpenTime, "closed periods should not count towards the open time") {
+		return
+	}
+	if !assert.Equal(t, 3*time.Second, s.LongestOpen, "longest streak should be kept") {
+		return
+	}
+}
+
+func TestJitter(t *testing.T) {
+	c := clock.NewMock()
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 50; i++ {
+		cb := newBreaker(
+			WithClock(c),
+			WithBackOff(backoff.NewConstantBackOff(10*time.Second)),
+			WithJitter(0.2),
+		)
+		cb.Trip()
+		d := cb.RetryAt().Sub(c.Now())
+		if !assert.True(t, d >= 8*time.Second && d <= 12*time.Second, "retry time should be within the jitter, got %s", d) {
+			return
+		}
+		seen[d] = struct{}{}
+	}
+	if !assert.True(t, len(seen) > 1, "retry times should be randomized") {
+		return
+	}
+}
+
+func TestRetryAt(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(5*time.Second)),
+		WithClock(c),
+	)
+
+	if !assert.True(t, cb.RetryAt().IsZero(), "closed breaker should not have a retry time") {
+		return
+	}
+
+	cb.Trip()
+	if !assert.Equal(t, c.Now().Add(5*time.Second), cb.RetryAt(), "retry time should be computed from the backoff") {
+		return
+	}
+
+	cb.Break()
+	if !assert.True(t, cb.RetryAt().IsZero(), "broken breaker should not have a retry time") {
+		return
+	}
+}
+
+func TestSubSecondBackOff(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(100*time.Millisecond)),
+		WithClock(c),
+	)
+
+	c.Add(250 * time.Millisecond)
+	cb.Trip()
+	if !assert.Equal(t, c.Now(), cb.LastFailure(), "last failure should keep sub-second precision") {
+		return
+	}
+
+	c.Add(50 * time.Millisecond)
+	if !assert.Equal(t, Open, cb.State(), "breaker should stay open before the backoff elapses") {
+		return
+	}
+
+	c.Add(51 * time.Millisecond)
+	if !assert.Equal(t, Halfopen, cb.State(), "breaker should be half-open once the backoff elapses") {
+		return
+	}
+}
+
+func TestForce(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(time.Minute)),
+		WithClock(c),
+	)
+
+	var called int
+	circuit := CircuitFunc(func() error {
+		called++
+		return nil
+	})
+
+	cb.Break()
+	if !assert.NoError(t, cb.Call(circuit, WithForce(true)), "forced call should not be rejected") {
+		return
+	}
+	if !assert.Equal(t, 1, called, "circuit should be executed") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "forced call should not close a broken breaker") {
+		return
+	}
+
+	cb.Reset()
+	cb.Trip()
+	if !assert.True(t, IsOpen(cb.Call(circuit)), "unforced call should be rejected") {
+		return
+	}
+	if !assert.NoError(t, cb.Call(circuit, WithForce(true)), "forced call should not be rejected") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "successful forced call should close the breaker") {
+		return
+	}
+	if !assert.Equal(t, 2, called, "circuit should only be executed by forced calls") {
+		return
+	}
+}
+
+func TestProbeWait(t *testing.T) {
+	for _, probeErr := range []error{nil, errors.New("still down")} {
+		c := clock.NewMock()
+		c.Add(time.Hour)
+		cb := newBreaker(
+			WithBackOff(backoff.NewConstantBackOff(time.Second)),
+			WithClock(c),
+			WithProbeWait(time.Minute),
+		)
+		cb.Trip()
+		c.Add(2 * time.Second)
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		go cb.Call(CircuitFunc(func() error {
+			close(started)
+			<-release
+			return probeErr
+		}))
+		<-started
+
+		result := make(chan error)
+		go func() {
+			result <- cb.Call(CircuitFunc(func() error { return nil }))
+		}()
+
+		select {
+		case err := <-result:
+			t.Errorf("call should wait for the probe, got %v", err)
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		err := <-result
+		if probeErr == nil {
+			if !assert.NoError(t, err, "call should go ahead once the probe closes the breaker") {
+				return
+			}
+		} else {
+			if !assert.True(t, IsOpen(err), "call should be rejected once the probe fails") {
+				return
+			}
+		}
+	}
+}
+
+func TestQueueing(t *testing.T) {
+	var c *clock.Mock
+	var cb Breaker
+	setup := func() {
+		// The timers of the mock clock can't be stopped, so start
+		// over with a new clock rather than leave timers behind
+		c = clock.NewMock()
+		c.Add(time.Hour)
+		cb = newBreaker(
+			WithBackOff(backoff.NewConstantBackOff(time.Minute)),
+			WithClock(c),
+			WithQueueing(1, time.Hour),
+		)
+		cb.Trip()
+	}
+	setup()
+
+	call := func() <-chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- cb.Call(CircuitFunc(func() error { return nil }))
+		}()
+		return result
+	}
+
+	queued := call()
+	select {
+	case err := <-queued:
+		t.Errorf("call should be queued, got %v", err)
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !assert.True(t, IsOpen(<-call()), "calls beyond the queue size should be rejected") {
+		return
+	}
+
+	cb.Reset()
+	if !assert.NoError(t, <-queued, "queued call should go ahead once the breaker is reset") {
+		return
+	}
+
+	setup()
+	queued = call()
+	c.Add(2 * time.Minute)
+	if !assert.NoError(t, <-queued, "queued call should be let through as a half-open probe") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "successful probe should close the breaker") {
+		return
+	}
+}
+
+func TestTripUntil(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(time.Second)),
+		WithClock(c),
+	)
+
+	until := c.Now().Add(10 * time.Second)
+	cb.TripUntil(until)
+	if !assert.Equal(t, until, cb.RetryAt(), "retry time should be pushed out") {
+		return
+	}
+
+	c.Add(2 * time.Second)
+	if !assert.Equal(t, Open, cb.State(), "breaker should be held open past the backoff") {
+		return
+	}
+
+	c.Add(8*time.Second + 1)
+	if !assert.Equal(t, Halfopen, cb.State(), "breaker should be half-open after the given time") {
+		return
+	}
+
+	cb.Reset()
+	cb.TripUntil(c.Now())
+	if !assert.Equal(t, c.Now().Add(time.Second), cb.RetryAt(), "backoff should win over an earlier time") {
+		return
+	}
+}
+
+func TestMarkOutcome(t *testing.T) {
+	ignored := errors.New("ignored")
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(time.Second)),
+		WithClock(c),
+		WithErrorClassifier(func(err error) bool { return err != ignored }),
+		WithTripper(ConsecutiveTripper(2)),
+	)
+
+	cb.MarkFailure(errors.New("error"))
+	cb.MarkFailure(ignored)
+	if !assert.Equal(t, int64(0), cb.Successes(), "ignored errors should not be recorded as successes") {
+		return
+	}
+	if !assert.Equal(t, int64(1), cb.ConsecFailures(), "ignored errors should not reset consecutive failures") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "breaker should not trip yet") {
+		return
+	}
+
+	cb.MarkFailure(nil)
+	cb.MarkFailure(nil)
+	if !assert.True(t, cb.Tripped(), "breaker should trip after consecutive failures") {
+		return
+	}
+
+	cb.MarkSuccess()
+	if !assert.True(t, cb.Tripped(), "success while open should not reset the breaker") {
+		return
+	}
+
+	c.Add(time.Second + 1)
+	cb.MarkSuccess()
+	if !assert.True(t, cb.Tripped(), "success that is not a probe should not reset the breaker") {
+		return
+	}
+	if !assert.Equal(t, Halfopen, cb.Snapshot().State, "probe should still be available") {
+		return
+	}
+
+	done, err := cb.Allow()
+	if !assert.NoError(t, err, "probe should be allowed") {
+		return
+	}
+	done(true)
+	if !assert.False(t, cb.Tripped(), "successful probe should reset the breaker") {
+		return
+	}
+}
+
+func TestShadowMode(t *testing.T) {
+	c := clock.NewMock()
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(10*time.Second)),
+		WithClock(c),
+		WithShadowMode(true),
+		WithTripper(ThresholdTripper(1)),
+	)
+
+	circuitErr := errors.New("error")
+	err := cb.Call(CircuitFunc(func() error { return circuitErr }))
+	if !assert.Equal(t, circuitErr, err, "Call should return the circuit error") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "breaker should be tripped") {
+		return
+	}
+
+	called := false
+	err = cb.Call(CircuitFunc(func() error {
+		called = true
+		return nil
+	}))
+	if !assert.NoError(t, err, "Call should not be rejected in shadow mode") {
+		return
+	}
+	if !assert.True(t, called, "circuit should be executed in shadow mode") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "breaker should still be tripped") {
+		return
+	}
+	if !assert.Equal(t, int64(1), cb.Successes(), "success should be recorded") {
+		return
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	cb := newBreaker(
+		WithMaxConcurrent(1),
+		WithTripper(ThresholdTripper(1)),
+	)
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	errc := make(chan error)
+	go func() {
+		errc <- cb.Call(CircuitFunc(func() error {
+			close(started)
+			<-finish
+			return nil
+		}))
+	}()
+	<-started
+
+	err := cb.Call(CircuitFunc(func() error { return nil }))
+	if !assert.True(t, IsTooManyConcurrent(err), "Call should be rejected, got %v", err) {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "rejections should not trip the breaker") {
+		return
+	}
+
+	close(finish)
+	if !assert.NoError(t, <-errc, "first call should succeed") {
+		return
+	}
+	if !assert.NoError(t, cb.Call(CircuitFunc(func() error { return nil })), "Call should succeed once the slot is released") {
+		return
+	}
+}
+
+func TestGradualRecovery(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(
+		WithBackOff(backoff.NewConstantBackOff(10*time.Second)),
+		WithClock(c),
+		WithGradualRecovery(10*time.Second),
+	)
+	cb.Trip()
+
+	ratio := func() float64 {
+		var ready int
+		for i := 0; i < 1000; i++ {
+			if r, _ := cb.Ready(); r {
+				ready++
+			}
+		}
+		return float64(ready) / 1000
+	}
+
+	if !assert.Equal(t, 0.0, ratio(), "all calls should be shed before the backoff elapses") {
+		return
+	}
+
+	c.Add(15 * time.Second)
+	if r := ratio(); !assert.True(t, r > 0.4 && r < 0.6, "about half of the calls should be let through, got %f", r) {
+		return
+	}
+
+	// Successes while recovering should not close the breaker
+	cb.Call(CircuitFunc(func() error { return nil }))
+	if !assert.True(t, cb.Tripped(), "breaker should still be tripped") {
+		return
+	}
+
+	c.Add(6 * time.Second)
+	if !assert.Equal(t, 1.0, ratio(), "all calls should be let through once recovered") {
+		return
+	}
+	cb.Call(CircuitFunc(func() error { return nil }))
+	if !assert.False(t, cb.Tripped(), "breaker should be reset") {
+		return
+	}
+}
+
+func TestDecayingConsecutiveTripper(t *testing.T) {
+	c := clock.NewMock()
+	cb := newBreaker(
+		WithClock(c),
+		WithTripper(DecayingConsecutiveTripper(3, time.Minute)),
+	)
+
+	// Failures spread over a long time should not trip the breaker
+	for i := 0; i < 10; i++ {
+		cb.(*breaker).fail()
+		c.Add(time.Hour)
+	}
+	if !assert.False(t, cb.Tripped(), "rare failures should not trip the breaker") {
+		return
+	}
+	if !assert.Equal(t, int64(10), cb.ConsecFailures(), "failures should still be consecutive") {
+		return
+	}
+
+	// A burst of failures should
+	for i := 0; i < 4; i++ {
+		cb.(*breaker).fail()
+		c.Add(time.Second)
+	}
+	if !assert.True(t, cb.Tripped(), "burst of failures should trip the breaker") {
+		return
+	}
+}
+
+func TestDecayingConsecutiveTripperShared(t *testing.T) {
+	c := clock.NewMock()
+	tripper := DecayingConsecutiveTripper(3, time.Minute)
+	a := newBreaker(WithClock(c), WithTripper(tripper))
+	b := newBreaker(WithClock(c), WithTripper(tripper))
+
+	// Each breaker has a score of its own
+	for i := 0; i < 2; i++ {
+		a.(*breaker).fail()
+		b.(*breaker).fail()
+	}
+	if !assert.False(t, a.Tripped() || b.Tripped(), "failures of other breakers should not count") {
+		return
+	}
+	a.(*breaker).fail()
+	if !assert.True(t, a.Tripped(), "third failure should trip the breaker") {
+		return
+	}
+	if !assert.False(t, b.Tripped(), "other breaker should not trip") {
+		return
+	}
+}
+
+func TestWouldTrip(t *testing.T) {
+	c := clock.NewMock()
+	cb := newBreaker(
+		WithClock(c),
+		WithTripper(ThresholdTripper(2)),
+	)
+
+	cb.(*breaker).fail()
+	if !assert.False(t, cb.WouldTrip(), "one failure should not be enough") {
+		return
+	}
+	cb.Trip()
+	cb.Reset()
+	cb.(*breaker).fail()
+	cb.(*breaker).fail()
+	if !assert.True(t, cb.Tripped(), "breaker should trip") {
+		return
+	}
+	if !assert.True(t, cb.WouldTrip(), "tripper should be evaluated regardless of the state") {
+		return
+	}
+	if !assert.Equal(t, int64(2), cb.Failures(), "nothing should be recorded") {
+		return
+	}
+
+	// Dry runs must not feed stateful trippers
+	cb = newBreaker(
+		WithClock(c),
+		WithTripper(DecayingConsecutiveTripper(3, time.Minute)),
+	)
+	cb.(*breaker).fail()
+	cb.(*breaker).fail()
+	for i := 0; i < 5; i++ {
+		if !assert.False(t, cb.WouldTrip(), "dry runs should not count as failures") {
+			return
+		}
+	}
+	cb.(*breaker).fail()
+	if !assert.True(t, cb.Tripped(), "third failure should trip the breaker") {
+		return
+	}
+	if !assert.True(t, cb.WouldTrip(), "score should be evaluated right after the failure") {
+		return
+	}
+	c.Add(time.Minute)
+	if !assert.False(t, cb.WouldTrip(), "score should decay") {
+		return
+	}
+}
+
+func TestWindowSize(t *testing.T) {
+	c := clock.NewMock()
+	cb := newBreaker(
+		WithClock(c),
+		WithWindowSize(4),
+	)
+
+	for i := 0; i < 4; i++ {
+		cb.(*breaker).fail()
+	}
+	cb.(*breaker).success(Closed)
+	cb.(*breaker).success(Closed)
+
+	// The two oldest failures have been pushed out of the window
+	if !assert.Equal(t, int64(2), cb.Failures(), "expected 2 failures") {
+		return
+	}
+	if !assert.Equal(t, int64(2), cb.Successes(), "expected 2 successes") {
+		return
+	}
+
+	// Outcomes do not expire with time
+	c.Add(time.Hour)
+	if !assert.Equal(t, 0.5, cb.ErrorRate(), "expected error rate to be 0.5") {
+		return
+	}
+}
+
+func TestLatency(t *testing.T) {
+	for _, size := range []int{0, 100} {
+		c := clock.NewMock()
+		cb := newBreaker(
+			WithClock(c),
+			WithWindowSize(size),
+		)
+
+		if !assert.Equal(t, time.Duration(0), cb.AverageLatency(), "no latency should be recorded yet") {
+			return
+		}
+
+		for i := 1; i <= 100; i++ {
+			d := time.Duration(i) * time.Millisecond
+			cb.Call(CircuitFunc(func() error {
+				c.Add(d)
+				if d > 90*time.Millisecond {
+					return errors.New("slow")
+				}
+				return nil
+			}))
+		}
+
+		if !assert.Equal(t, 50500*time.Microsecond, cb.AverageLatency(), "average latency should include failures (window size %d)", size) {
+			return
+		}
+		if !assert.Equal(t, 100*time.Millisecond, cb.Percentile(100), "100th percentile should be the maximum (window size %d)", size) {
+			return
+		}
+		if !assert.Equal(t, time.Millisecond, cb.Percentile(0), "0th percentile should be the minimum (window size %d)", size) {
+			return
+		}
+		p50 := cb.Percentile(50)
+		if !assert.True(t, p50 >= 50*time.Millisecond && p50 <= 100*time.Millisecond, "50th percentile should be close to the median (window size %d), got %s", size, p50) {
+			return
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	cb := newBreaker(WithClock(c))
+
+	cb.(*breaker).fail()
+	c.Add(1500 * time.Millisecond)
+	cb.(*breaker).success(Closed)
+	cb.(*breaker).success(Closed)
+
+	history := cb.History()
+	if !assert.Len(t, history, DefaultWindowBuckets, "there should be one entry per bucket") {
+		return
+	}
+
+	latest := history[len(history)-1]
+	if !assert.Equal(t, Bucket{Start: c.Now(), Duration: time.Second, Successes: 2}, latest, "latest bucket should hold the successes") {
+		return
+	}
+	previous := history[len(history)-2]
+	if !assert.Equal(t, Bucket{Start: c.Now().Add(-time.Second), Duration: time.Second, Failures: 1}, previous, "previous bucket should hold the failure") {
+		return
+	}
+}
@@ -0,0 +1,128 @@
+package breaker
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ParseState returns the State whose String() is s
+func ParseState(s string) (State, error) {
+	for _, st := range []State{Open, Halfopen, Closed} {
+		if st.String() == s {
+			return st, nil
+		}
+	}
+	return 0, errors.Errorf("unknown breaker state %q", s)
+}
+
+// MarshalJSON writes the state as a string, such as "open"
+func (s State) MarshalJSON() ([]byte, error) {
+	switch s {
+	case Open, Halfopen, Closed:
+		return json.Marshal(s.String())
+	}
+	return nil, errors.Errorf("unknown breaker state %d", int(s))
+}
+
+// UnmarshalJSON reads a state written by MarshalJSON
+func (s *State) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return errors.Wrap(err, "breaker state must be a string")
+	}
+
+	st, err := ParseState(str)
+	if err != nil {
+		return err
+	}
+	*s = st
+	return nil
+}
+
+// snapshotJSON is the JSON representation of a Snapshot. Times that
+// are not set are omitted, and durations are written as strings
+type snapshotJSON struct {
+	State          State      `json:"state"`
+	Failures       int64      `json:"failures"`
+	Successes      int64      `json:"successes"`
+	ConsecFailures int64      `json:"consecutive_failures"`
+	ErrorRate      float64    `json:"error_rate"`
+	LastFailure    *time.Time `json:"last_failure,omitempty"`
+	NextRetry      *time.Time `json:"next_retry,omitempty"`
+	Trips          int64      `json:"trips"`
+	LastTrip       *time.Time `json:"last_trip,omitempty"`
+	OpenTime       string     `json:"open_time"`
+	LongestOpen    string     `json:"longest_open"`
+}
+
+// MarshalJSON writes the snapshot as a JSON object, with the state
+// as a string and the durations as strings such as "1.5s"
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(snapshotJSON{
+		State:          s.State,
+		Failures:       s.Failures,
+		Successes:      s.Successes,
+		ConsecFailures: s.ConsecFailures,
+		ErrorRate:      s.ErrorRate,
+		LastFailure:    optionalTime(s.LastFailure),
+		NextRetry:      optionalTime(s.NextRetry),
+		Trips:          s.Trips,
+		LastTrip:       optionalTime(s.LastTrip),
+		OpenTime:       s.OpenTime.String(),
+		LongestOpen:    s.LongestOpen.String(),
+	})
+}
+
+// UnmarshalJSON reads a snapshot written by MarshalJSON
+func (s *Snapshot) UnmarshalJSON(data []byte) error {
+	var v snapshotJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return errors.Wrap(err, "failed to decode snapshot")
+	}
+
+	openTime, err := parseOptionalDuration(v.OpenTime)
+	if err != nil {
+		return errors.Wrap(err, "invalid open_time")
+	}
+	longestOpen, err := parseOptionalDuration(v.LongestOpen)
+	if err != nil {
+		return errors.Wrap(err, "invalid longest_open")
+	}
+
+	*s = Snapshot{
+		State:          v.State,
+		Failures:       v.Failures,
+		Successes:      v.Successes,
+		ConsecFailures: v.ConsecFailures,
+		ErrorRate:      v.ErrorRate,
+		Trips:          v.Trips,
+		OpenTime:       openTime,
+		LongestOpen:    longestOpen,
+	}
+	if v.LastFailure != nil {
+		s.LastFailure = *v.LastFailure
+	}
+	if v.NextRetry != nil {
+		s.NextRetry = *v.NextRetry
+	}
+	if v.LastTrip != nil {
+		s.LastTrip = *v.LastTrip
+	}
+	return nil
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func parseOptionalDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
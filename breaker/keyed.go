@@ -0,0 +1,61 @@
+package breaker
+
+import "context"
+
+// NewKeyed creates a Keyed that sends requests through fn, using the
+// breaker stored in breakers under the key returned by key for each
+// request, e.g. the service and method names. If factory is not nil,
+// it is used to create breakers for unknown keys. Otherwise requests
+// with unknown keys are not protected.
+func NewKeyed[Req, Resp any](fn func(context.Context, Req) (Resp, error), key func(Req) string, breakers Map, factory BreakerFactory) *Keyed[Req, Resp] {
+	return &Keyed[Req, Resp]{
+		breakers: breakers,
+		factory:  factory,
+		fn:       fn,
+		key:      key,
+	}
+}
+
+// Breaker returns the breaker used for the request, or nil if the
+// request is not protected
+func (k *Keyed[Req, Resp]) Breaker(req Req) Breaker {
+	name := k.key(req)
+	if k.factory != nil {
+		return k.breakers.GetOrCreate(name, k.factory)
+	}
+	cb, ok := k.breakers.Get(name)
+	if !ok {
+		return nil
+	}
+	return cb
+}
+
+// Do sends the request through the breaker for its key. The context
+// is passed to the breaker via WithContext, and the options are passed
+// to Call. The function receives the context of the call, which is
+// canceled when the call times out. If the request was not sent, e.g.
+// because the breaker is open, or if the call timed out, the zero
+// response is returned along with the error from the breaker.
+func (k *Keyed[Req, Resp]) Do(ctx context.Context, req Req, options ...CallOption) (Resp, error) {
+	cb := k.Breaker(req)
+	if cb == nil {
+		return k.fn(ctx, req)
+	}
+
+	// The circuit may still be running when Call returns if the call
+	// timed out, so the response is handed over through a channel
+	responses := make(chan Resp, 1)
+	err := cb.Call(CircuitContextFunc(func(ctx context.Context) error {
+		resp, err := k.fn(ctx, req)
+		responses <- resp
+		return err
+	}), append([]CallOption{WithContext(ctx)}, options...)...)
+
+	select {
+	case resp := <-responses:
+		return resp, err
+	default:
+		var zero Resp
+		return zero, err
+	}
+}
@@ -0,0 +1,198 @@
+package breaker
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newLogListener(l Logger, name string) *logListener {
+	prefix := "breaker: "
+	if name != "" {
+		prefix = "breaker " + strconv.Quote(name) + ": "
+	}
+	return &logListener{
+		logger: l,
+		prefix: prefix,
+	}
+}
+
+// Failures are not logged, as there may be lots of them. The
+// transitions that they cause are
+func (l *logListener) onFail(State, error) {}
+
+func (l *logListener) onSuccess(State) {}
+
+func (l *logListener) onStateChange(from, to State, err error) {
+	var msg string
+	switch {
+	case to == Open && from == Halfopen:
+		msg = "probe failed, breaker reopened"
+	case to == Open:
+		msg = "breaker tripped"
+	case to == Halfopen:
+		msg = "letting half-open probes through"
+	case to == Closed:
+		msg = "breaker reset"
+	default:
+		msg = "state changed from " + from.String() + " to " + to.String()
+	}
+
+	if err != nil {
+		l.logger.Printf("%s%s: %s", l.prefix, msg, err)
+		return
+	}
+	l.logger.Printf("%s%s", l.prefix, msg)
+}
+
+// NewLoggingBreaker wraps cb so that every state change, and every
+// call that is rejected, is logged as a structured record to h. The
+// records carry the name of the breaker, its counts, and the error
+// that caused the change, if any.
+//
+// Trips are logged at the warning level, other state changes at the
+// info level, and rejected calls at the debug level, since there may
+// be many of them while the breaker is open.
+//
+// Like NewEventEmitter, if cb is a breaker created by New() the
+// changes are reported by the breaker itself. Otherwise they are
+// guessed by inspecting the breaker.
+func NewLoggingBreaker(cb Breaker, h slog.Handler) Breaker {
+	lb := &loggingBreaker{
+		Breaker: cb,
+		logger:  slog.New(h),
+	}
+	if l, ok := listenableOf(cb); ok {
+		l.addListener(lb)
+		lb.hooked = true
+	}
+	return lb
+}
+
+func (b *loggingBreaker) onFail(State, error) {}
+
+func (b *loggingBreaker) onSuccess(State) {}
+
+func (b *loggingBreaker) unwrap() Breaker {
+	return b.Breaker
+}
+
+func (b *loggingBreaker) onStateChange(from, to State, err error) {
+	b.logStateChange(from, to, err)
+}
+
+func (b *loggingBreaker) logStateChange(from, to State, err error) {
+	level := slog.LevelInfo
+	if to == Open {
+		level = slog.LevelWarn
+	}
+
+	attrs := b.attributes(err)
+	attrs = append(attrs, slog.String("from", from.String()), slog.String("to", to.String()))
+	b.logger.LogAttrs(context.Background(), level, "breaker state changed", attrs...)
+}
+
+func (b *loggingBreaker) logRejected(ctx context.Context, err error) {
+	if !IsOpen(err) && !IsTooManyConcurrent(err) {
+		return
+	}
+	b.logger.LogAttrs(ctx, slog.LevelDebug, "breaker rejected call", b.attributes(err)...)
+}
+
+func (b *loggingBreaker) attributes(err error) []slog.Attr {
+	attrs := make([]slog.Attr, 0, 8)
+	if name := b.Breaker.Name(); name != "" {
+		attrs = append(attrs, slog.String("breaker", name))
+	}
+	attrs = append(attrs,
+		slog.Int64("failures", b.Breaker.Failures()),
+		slog.Int64("successes", b.Breaker.Successes()),
+		slog.Int64("consecutive_failures", b.Breaker.ConsecFailures()),
+		slog.Float64("error_rate", b.Breaker.ErrorRate()),
+	)
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+		if cause := errors.Cause(err); cause != err {
+			attrs = append(attrs, slog.String("cause", cause.Error()))
+		}
+	}
+	return attrs
+}
+
+// observe logs the change of state guessed from the tripped state of
+// the breaker before and after an operation
+func (b *loggingBreaker) observe(wasTripped bool, err error) {
+	if b.hooked {
+		return
+	}
+	isTripped := b.Breaker.Tripped()
+	switch {
+	case !wasTripped && isTripped:
+		b.logStateChange(Closed, Open, err)
+	case wasTripped && !isTripped:
+		b.logStateChange(Open, Closed, err)
+	}
+}
+
+func (b *loggingBreaker) Allow() (func(bool), error) {
+	wasTripped := b.Breaker.Tripped()
+	done, err := b.Breaker.Allow()
+	if err != nil {
+		b.logRejected(context.Background(), err)
+		return nil, err
+	}
+	if b.hooked {
+		return done, nil
+	}
+	return func(success bool) {
+		done(success)
+		b.observe(wasTripped, nil)
+	}, nil
+}
+
+func (b *loggingBreaker) Break() {
+	wasTripped := b.Breaker.Tripped()
+	b.Breaker.Break()
+	b.observe(wasTripped, nil)
+}
+
+func (b *loggingBreaker) Call(c Circuit, options ...CallOption) error {
+	wasTripped := b.Breaker.Tripped()
+	err := b.Breaker.Call(c, options...)
+	b.logRejected(CallContext(options...), err)
+	b.observe(wasTripped, err)
+	return err
+}
+
+func (b *loggingBreaker) MarkFailure(err error) {
+	wasTripped := b.Breaker.Tripped()
+	b.Breaker.MarkFailure(err)
+	b.observe(wasTripped, err)
+}
+
+func (b *loggingBreaker) MarkSuccess() {
+	wasTripped := b.Breaker.Tripped()
+	b.Breaker.MarkSuccess()
+	b.observe(wasTripped, nil)
+}
+
+func (b *loggingBreaker) Reset() {
+	wasTripped := b.Breaker.Tripped()
+	b.Breaker.Reset()
+	b.observe(wasTripped, nil)
+}
+
+func (b *loggingBreaker) Trip() {
+	wasTripped := b.Breaker.Tripped()
+	b.Breaker.Trip()
+	b.observe(wasTripped, nil)
+}
+
+func (b *loggingBreaker) TripUntil(t time.Time) {
+	wasTripped := b.Breaker.Tripped()
+	b.Breaker.TripUntil(t)
+	b.observe(wasTripped, nil)
+}
@@ -7,6 +7,42 @@ func NewMap() Map {
 	}
 }
 
+// DefaultShards is the number of shards used by NewShardedMap when
+// the given number is not positive
+const DefaultShards = 32
+
+// NewShardedMap creates a Map that spreads the breakers over several
+// shards, each with its own lock. It is meant for maps that hold many
+// breakers, e.g. one per host, and are looked up concurrently, where
+// the single lock of the map created by NewMap becomes contended.
+// The number of shards is rounded up to a power of two.
+func NewShardedMap(shards int) Map {
+	if shards <= 0 {
+		shards = DefaultShards
+	}
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	m := &shardedMap{
+		mask:   uint32(n - 1),
+		shards: make([]*simpleMap, n),
+	}
+	for i := range m.shards {
+		m.shards[i] = &simpleMap{
+			breakers: make(map[string]Breaker),
+		}
+	}
+	return m
+}
+
+func (m *simpleMap) Delete(name string) {
+	m.mutex.Lock()
+	delete(m.breakers, name)
+	m.mutex.Unlock()
+}
+
 func (m *simpleMap) Set(name string, cb Breaker) {
 	m.mutex.Lock()
 	m.breakers[name] = cb
@@ -20,3 +56,98 @@ func (m *simpleMap) Get(name string) (Breaker, bool) {
 
 	return cb, ok
 }
+
+func (m *simpleMap) GetOrCreate(name string, factory BreakerFactory) Breaker {
+	if cb, ok := m.Get(name); ok {
+		return cb
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Check again, as somebody may have created it while
+	// we were waiting for the lock
+	if cb, ok := m.breakers[name]; ok {
+		return cb
+	}
+
+	cb := factory()
+	m.breakers[name] = cb
+	return cb
+}
+
+func (m *simpleMap) Len() int {
+	m.mutex.RLock()
+	l := len(m.breakers)
+	m.mutex.RUnlock()
+
+	return l
+}
+
+func (m *simpleMap) Range(f func(string, Breaker) bool) {
+	// Take a snapshot so that f may modify the map
+	m.mutex.RLock()
+	names := make([]string, 0, len(m.breakers))
+	breakers := make([]Breaker, 0, len(m.breakers))
+	for name, cb := range m.breakers {
+		names = append(names, name)
+		breakers = append(breakers, cb)
+	}
+	m.mutex.RUnlock()
+
+	for i, name := range names {
+		if !f(name, breakers[i]) {
+			return
+		}
+	}
+}
+
+// shard returns the shard holding the given name, which is found by
+// hashing the name with FNV-1a
+func (m *shardedMap) shard(name string) *simpleMap {
+	h := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		h ^= uint32(name[i])
+		h *= 16777619
+	}
+	return m.shards[h&m.mask]
+}
+
+func (m *shardedMap) Delete(name string) {
+	m.shard(name).Delete(name)
+}
+
+func (m *shardedMap) Set(name string, cb Breaker) {
+	m.shard(name).Set(name, cb)
+}
+
+func (m *shardedMap) Get(name string) (Breaker, bool) {
+	return m.shard(name).Get(name)
+}
+
+func (m *shardedMap) GetOrCreate(name string, factory BreakerFactory) Breaker {
+	return m.shard(name).GetOrCreate(name, factory)
+}
+
+func (m *shardedMap) Len() int {
+	var l int
+	for _, s := range m.shards {
+		l += s.Len()
+	}
+	return l
+}
+
+func (m *shardedMap) Range(f func(string, Breaker) bool) {
+	done := false
+	for _, s := range m.shards {
+		s.Range(func(name string, cb Breaker) bool {
+			if !f(name, cb) {
+				done = true
+			}
+			return !done
+		})
+		if done {
+			return
+		}
+	}
+}
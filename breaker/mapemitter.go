@@ -0,0 +1,174 @@
+package breaker
+
+import (
+	"context"
+)
+
+// NewMapEmitter creates a MapEmitter for the breakers in m. Breakers
+// must be stored through the MapEmitter for their events to be
+// delivered. Breakers that were not created by New() can only report
+// events for the operations made through the MapEmitter, so they are
+// stored wrapped, including those that are already in m.
+func NewMapEmitter(m Map) *MapEmitter {
+	me := &MapEmitter{
+		Map:     m,
+		emitter: newFanOut(),
+		watched: make(map[string]*eventEmitter),
+	}
+	m.Range(func(name string, cb Breaker) bool {
+		if stored := me.watch(name, cb); stored != cb {
+			m.Set(name, stored)
+		}
+		return true
+	})
+	return me
+}
+
+// watch starts generating the events of cb under the given name, and
+// stops generating those of the breaker previously stored under it.
+// The breaker to store in the map is returned
+func (me *MapEmitter) watch(name string, cb Breaker) Breaker {
+	var added *eventEmitter
+	defer func() {
+		// Send the event without holding the lock, as lossless
+		// subscribers may want to use the map
+		if added != nil {
+			added.emitMembership(AddedEvent)
+		}
+	}()
+
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	e, ok := me.watched[name]
+	if !ok || (e != cb && e.breaker != cb) {
+		me.unwatch(name)
+		e = newFanOut()
+		e.name = name
+		e.sink = me.emitter
+		e.watch(cb)
+		me.watched[name] = e
+		added = e
+	}
+
+	if e.hooked {
+		return e.breaker
+	}
+	return e
+}
+
+// unwatch stops generating the events of the breaker stored under the
+// given name. unwatch assumes that the caller has locked the mutex
+func (me *MapEmitter) unwatch(name string) {
+	if e, ok := me.watched[name]; ok {
+		e.detach()
+		delete(me.watched, name)
+	}
+}
+
+// emitMembership sends an AddedEvent or a RemovedEvent for the breaker,
+// which stays in its current state. The event is sent even if the
+// breaker is no longer watched
+func (e *eventEmitter) emitMembership(ev Event) {
+	st := e.breaker.Snapshot().State
+	e.sink.send(e.newEventData(ev, st, st, nil))
+}
+
+// Close stops all subscriptions and the fan-out of events, and stops
+// listening to the breakers of the map
+func (me *MapEmitter) Close() error {
+	me.mutex.Lock()
+	for _, e := range me.watched {
+		e.detach()
+	}
+	me.mutex.Unlock()
+	return me.emitter.Close()
+}
+
+func (me *MapEmitter) Delete(name string) {
+	me.mutex.Lock()
+	e, ok := me.watched[name]
+	me.unwatch(name)
+	me.mutex.Unlock()
+	me.Map.Delete(name)
+
+	if ok {
+		e.emitMembership(RemovedEvent)
+	}
+}
+
+func (me *MapEmitter) GetOrCreate(name string, factory BreakerFactory) Breaker {
+	return me.Map.GetOrCreate(name, func() Breaker {
+		return me.watch(name, factory())
+	})
+}
+
+func (me *MapEmitter) Set(name string, cb Breaker) {
+	me.Map.Set(name, me.watch(name, cb))
+}
+
+// Subscribe starts a new subscription to the events of all breakers
+func (me *MapEmitter) Subscribe(ctx context.Context, options ...SubscribeOption) *EventSubscription {
+	return me.emitter.Subscribe(ctx, options...)
+}
+
+// SubscribeFunc starts a new subscription to the events of all
+// breakers, calling f with each of them
+func (me *MapEmitter) SubscribeFunc(ctx context.Context, f func(Event), options ...SubscribeOption) *EventSubscription {
+	return me.emitter.SubscribeFunc(ctx, f, options...)
+}
+
+// Watch calls added with the name and the breaker of each breaker in
+// the map, and then of each breaker stored in it, and removed with the
+// name of each breaker deleted from it, until ctx is done or the
+// returned subscription is stopped. Either function may be nil. This
+// allows components such as metrics exporters to pick up new breakers
+// without polling the map.
+//
+// The functions are called one at a time from a separate goroutine.
+// added may be called twice for breakers stored while Watch is
+// starting. Events are never dropped for the subscription, so the
+// functions should return quickly.
+func (me *MapEmitter) Watch(ctx context.Context, added func(string, Breaker), removed func(string)) *EventSubscription {
+	// Subscribe before listing the breakers, so that none is missed
+	s := me.Subscribe(ctx, WithLossless(true))
+
+	var existing []string
+	me.mutex.Lock()
+	for name := range me.watched {
+		existing = append(existing, name)
+	}
+	me.mutex.Unlock()
+
+	go func() {
+		notifyAdded := func(name string) {
+			if added == nil {
+				return
+			}
+			if cb, ok := me.Map.Get(name); ok {
+				added(name, cb)
+			}
+		}
+
+		for _, name := range existing {
+			notifyAdded(name)
+		}
+
+		for {
+			select {
+			case data := <-s.Data:
+				switch data.Event {
+				case AddedEvent:
+					notifyAdded(data.Name)
+				case RemovedEvent:
+					if removed != nil {
+						removed(data.Name)
+					}
+				}
+			case <-s.stopped:
+				return
+			}
+		}
+	}()
+	return s
+}
@@ -0,0 +1,43 @@
+package breaker
+
+// Observe adds an Observer to the breaker created by New() that cb
+// is, or that cb wraps if it was created by one of the wrappers of
+// this package, such as NewEventEmitter or Intercept. It returns false
+// if no such breaker could be found, in which case the observer is
+// never notified
+func Observe(cb Breaker, o Observer) bool {
+	l, ok := listenableOf(cb)
+	if !ok {
+		return false
+	}
+	l.addListener(observerListener{observer: o})
+	return true
+}
+
+// listenableOf finds the breaker that notifies listeners, going
+// through the wrappers of this package
+func listenableOf(cb Breaker) (listenable, bool) {
+	for cb != nil {
+		if l, ok := cb.(listenable); ok {
+			return l, true
+		}
+		w, ok := cb.(wrapper)
+		if !ok {
+			break
+		}
+		cb = w.unwrap()
+	}
+	return nil, false
+}
+
+func (l observerListener) onFail(st State, err error) {
+	l.observer.OnFail(st, err)
+}
+
+func (l observerListener) onSuccess(st State) {
+	l.observer.OnSuccess(st)
+}
+
+func (l observerListener) onStateChange(from, to State, err error) {
+	l.observer.OnStateChange(from, to, err)
+}
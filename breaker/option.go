@@ -1,33 +1,567 @@
 package breaker
 
 import (
+	"context"
+	"math"
 	"time"
 
-	"github.com/cenk/backoff"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
 	"github.com/lestrrat/go-circuit-breaker/internal/option"
 )
 
-// WithClock is used specify the clock used by the circuir breaker.
-// Normally, this is only used for testing
-func WithClock(v Clock) Option {
-	return option.NewValue("Clock", v)
+func newBreakerOption(name string, v interface{}, apply func(*breaker)) BreakerOption {
+	return &breakerOption{
+		Value: option.NewValue(name, v),
+		apply: apply,
+	}
+}
+
+func (o *breakerOption) applyBreaker(b *breaker) {
+	o.apply(b)
+}
+
+func newCallOption(name string, v interface{}, apply func(*callConfig)) CallOption {
+	return &callOption{
+		Value: option.NewValue(name, v),
+		apply: apply,
+	}
+}
+
+func (o *callOption) applyCall(c *callConfig) {
+	o.apply(c)
+}
+
+func newSubscribeOption(name string, v interface{}, apply func(*EventSubscription)) SubscribeOption {
+	return &subscribeOption{
+		Value: option.NewValue(name, v),
+		apply: apply,
+	}
+}
+
+func (o *subscribeOption) applySubscribe(s *EventSubscription) {
+	o.apply(s)
+}
+
+func newSharedOption(name string, v interface{}, breakerFn func(*breaker), callFn func(*callConfig)) SharedOption {
+	return &sharedOption{
+		Value:     option.NewValue(name, v),
+		breakerFn: breakerFn,
+		callFn:    callFn,
+	}
+}
+
+func (o *sharedOption) applyBreaker(b *breaker) {
+	o.breakerFn(b)
+}
+
+func (o *sharedOption) applyCall(c *callConfig) {
+	o.callFn(c)
+}
+
+func newGroupOption(name string, v interface{}, apply func(*Group)) GroupOption {
+	return &groupOption{
+		Value: option.NewValue(name, v),
+		apply: apply,
+	}
+}
+
+func (o *groupOption) applyGroup(g *Group) {
+	o.apply(g)
+}
+
+func newMapOption(name string, v interface{}, apply func(*ExpiringMap)) MapOption {
+	return &mapOption{
+		Value: option.NewValue(name, v),
+		apply: apply,
+	}
+}
+
+func (o *mapOption) applyMap(m *ExpiringMap) {
+	o.apply(m)
+}
+
+func (o *clockOption) applyBreaker(b *breaker) {
+	b.clock = o.v
+}
+
+func (o *clockOption) applyMap(m *ExpiringMap) {
+	m.clock = o.v
+}
+
+func newAdaptiveOption(name string, v interface{}, apply func(*AdaptiveBreaker)) AdaptiveOption {
+	return &adaptiveOption{
+		Value: option.NewValue(name, v),
+		apply: apply,
+	}
+}
+
+func (o *adaptiveOption) applyAdaptive(a *AdaptiveBreaker) {
+	o.apply(a)
+}
+
+// BreakerOptions is a compatibility shim for code that collects options
+// in a slice of `Option`. It returns the options that can be passed to
+// `New`. Options that can not be passed to `New` are dropped, as they
+// would have been silently ignored by `New` in previous versions.
+func BreakerOptions(options ...Option) []BreakerOption {
+	list := make([]BreakerOption, 0, len(options))
+	for _, option := range options {
+		if o, ok := option.(BreakerOption); ok {
+			list = append(list, o)
+		}
+	}
+	return list
+}
+
+// CallOptions is a compatibility shim for code that collects options
+// in a slice of `Option`. It returns the options that can be passed to
+// `Call`. Options that can not be passed to `Call` are dropped, as they
+// would have been silently ignored by `Call` in previous versions.
+func CallOptions(options ...Option) []CallOption {
+	list := make([]CallOption, 0, len(options))
+	for _, option := range options {
+		if o, ok := option.(CallOption); ok {
+			list = append(list, o)
+		}
+	}
+	return list
+}
+
+// CallContext returns the context given to WithContext among options,
+// or context.Background() if there is none, so that decorators can
+// use the context of the calls made through them
+func CallContext(options ...CallOption) context.Context {
+	config := callConfig{ctx: context.Background()}
+	for _, option := range options {
+		option.applyCall(&config)
+	}
+	return config.ctx
+}
+
+// WithClock is used specify the clock used by the circuir breaker,
+// or by the janitor of an ExpiringMap. Normally, this is only used for
+// testing
+func WithClock(v Clock) ClockOption {
+	return &clockOption{
+		Value: option.NewValue("Clock", v),
+		v:     v,
+	}
+}
+
+// WithName is used to specify the name of the breaker, which is
+// reported in events so that breakers can be told apart.
+func WithName(v string) BreakerOption {
+	return newBreakerOption("Name", v, func(b *breaker) {
+		b.name = v
+	})
+}
+
+// WithLabels is used to specify labels attached to the breaker, such as
+// the service, region or tier that it protects. They are reported in
+// events and by the packages that export metrics, so that breakers can
+// be grouped by more than their name. The map is copied.
+func WithLabels(v map[string]string) BreakerOption {
+	return newBreakerOption("Labels", v, func(b *breaker) {
+		b.labels = copyLabels(v)
+	})
 }
 
 // WithBackOff is used to specify the backoff policy that is used when
 // determining if the breaker should attempt to retry. `Breaker` objects
 // will use an exponential backoff policy by default.
-func WithBackOff(v backoff.BackOff) Option {
-	return option.NewValue("Backoff", v)
+func WithBackOff(v backoff.BackOff) BreakerOption {
+	return newBreakerOption("Backoff", v, func(b *breaker) {
+		b.backoff = v
+	})
 }
 
 // WithTripper is used to specify the tripper that is used when
 // determining when the breaker should trip.
-func WithTripper(v Tripper) Option {
-	return option.NewValue("Tripper", v)
+func WithTripper(v Tripper) BreakerOption {
+	return newBreakerOption("Tripper", v, func(b *breaker) {
+		b.tripper = v
+	})
 }
 
 // WithTimeout is used to specify the timeout used when `Call` is
-// executed.
-func WithTimeout(v time.Duration) Option {
-	return option.NewValue("Timeout", v)
+// executed. This may be passed to either `New` or `Call`.
+func WithTimeout(v time.Duration) SharedOption {
+	return newSharedOption("Timeout", v, func(b *breaker) {
+		b.defaultTimeout = v
+	}, func(c *callConfig) {
+		c.timeout = v
+	})
+}
+
+// WithFallback is used to specify a Circuit that is executed in place
+// of the protected circuit when the breaker is open, or when the protected
+// circuit fails. The result of the fallback is returned from `Call`.
+// This may be passed to either `New` or `Call`.
+func WithFallback(v Circuit) SharedOption {
+	return newSharedOption("Fallback", v, func(b *breaker) {
+		b.fallback = v
+	}, func(c *callConfig) {
+		c.fallback = v
+	})
+}
+
+// WithErrorClassifier is used to specify a function that determines
+// which errors returned from a Circuit count as failures. Errors for which
+// the classifier returns false are still returned from `Call`, but are
+// recorded neither as failures nor as successes, so that errors such as
+// validation errors or context cancellations do not affect the breaker
+// state.
+func WithErrorClassifier(v ErrorClassifier) BreakerOption {
+	return newBreakerOption("ErrorClassifier", v, func(b *breaker) {
+		b.isFailure = v
+	})
+}
+
+// WithErrorCategorizer is used to specify a function that determines
+// the class under which each failure is counted by FailuresByClass,
+// e.g. to tell validation errors from internal errors. Errors for which
+// the categorizer returns an empty string are classified by default as
+// FailureTimeout, FailureConnectionRefused or FailureOther.
+func WithErrorCategorizer(v ErrorCategorizer) BreakerOption {
+	return newBreakerOption("ErrorCategorizer", v, func(b *breaker) {
+		b.categorize = v
+	})
+}
+
+// WithHalfOpenRequests is used to specify the number of probes that
+// are allowed through while the breaker is in the half-open state.
+// Unless specified otherwise via `WithSuccessThreshold`, the breaker is
+// only reset once all of the probes have succeeded, and a single failure
+// will keep the breaker tripped. The default is 1.
+func WithHalfOpenRequests(v int) BreakerOption {
+	return newBreakerOption("HalfOpenRequests", v, func(b *breaker) {
+		b.halfOpenRequests = int64(v)
+	})
+}
+
+// WithMinSamples is used to specify the number of calls that must be
+// counted in the window before the tripper is consulted. This keeps
+// breakers from flapping on a handful of failures during periods of
+// low traffic, whatever the Tripper in use. The default is 0, which
+// lets the tripper decide from the first failure.
+func WithMinSamples(v int64) BreakerOption {
+	return newBreakerOption("MinSamples", v, func(b *breaker) {
+		b.minSamples = v
+	})
+}
+
+// WithProbeWait is used to make calls that are rejected while a
+// half-open probe is running wait up to the given duration for the
+// outcome of the probe, instead of failing right away. If the probe
+// closes the breaker, the waiting calls go ahead, and otherwise they
+// are rejected. No other probe is started while one is running, for
+// up to the given duration. The default is 0, which does not wait.
+func WithProbeWait(v time.Duration) BreakerOption {
+	return newBreakerOption("ProbeWait", v, func(b *breaker) {
+		b.probeWait = v
+	})
+}
+
+// WithQueueing is used to make up to maxQueue calls wait, instead of
+// being rejected right away, while the breaker is open. The calls go
+// ahead as soon as the breaker lets calls through again, either as
+// half-open probes or because it was reset, and are rejected if that
+// does not happen within maxWait. Further calls are rejected right
+// away. This suits batch workers, for which a rejection means that
+// the work is lost. By default, calls are not queued.
+func WithQueueing(maxQueue int, maxWait time.Duration) BreakerOption {
+	return newBreakerOption("Queueing", [2]interface{}{maxQueue, maxWait}, func(b *breaker) {
+		b.queueSize = int64(maxQueue)
+		b.queueWait = maxWait
+	})
+}
+
+// WithSuccessThreshold is used to specify the number of consecutive
+// successful half-open probes required before the breaker is reset.
+// Any failure in the half-open state starts the count over.
+// The default is the number of half-open requests (see `WithHalfOpenRequests`)
+func WithSuccessThreshold(v int) BreakerOption {
+	return newBreakerOption("SuccessThreshold", v, func(b *breaker) {
+		b.successThreshold = int64(v)
+	})
+}
+
+// WithGradualRecovery is used to specify that the breaker should
+// recover gradually instead of letting a fixed number of probes through
+// once the backoff has elapsed. Over the given duration, the fraction of
+// calls that are let through grows from 0% to 100%. Any failure during
+// this period opens the breaker again, and the breaker is only reset by
+// a success once all calls are let through. `WithHalfOpenRequests` and
+// `WithSuccessThreshold` are ignored when this option is specified.
+func WithGradualRecovery(v time.Duration) BreakerOption {
+	return newBreakerOption("GradualRecovery", v, func(b *breaker) {
+		b.recovery = v
+	})
+}
+
+// WithJitter is used to specify that the time before the breaker lets
+// probes through should be moved randomly by up to the given fraction
+// of the backoff interval, in either direction. With a fraction of 0.2,
+// a 10 second interval becomes anything between 8 and 12 seconds. This
+// keeps a fleet of instances from probing a recovering service all at
+// once. The fraction is capped to 1. The default is 0, for no jitter.
+func WithJitter(v float64) BreakerOption {
+	return newBreakerOption("Jitter", v, func(b *breaker) {
+		b.jitter = math.Max(0, math.Min(1, v))
+	})
+}
+
+// WithMaxConcurrent is used to specify the maximum number of circuit
+// executions that may be in flight at the same time. Calls beyond this
+// limit are rejected with ErrTooManyConcurrent (or the fallback, if
+// specified), and are not recorded as failures. The default is 0,
+// which means there is no limit.
+func WithMaxConcurrent(v int) BreakerOption {
+	return newBreakerOption("MaxConcurrent", v, func(b *breaker) {
+		b.maxConcurrent = int64(v)
+	})
+}
+
+// WithShadowMode is used to specify that the breaker should never
+// prevent a circuit from being executed. The breaker still records
+// failures, trips, resets and emits events as usual, which allows
+// tripper settings to be evaluated in production before enforcing them.
+func WithShadowMode(v bool) BreakerOption {
+	return newBreakerOption("ShadowMode", v, func(b *breaker) {
+		b.shadow = v
+	})
+}
+
+// WithDisabled is used to specify that the breaker should be created
+// disabled, as if Disable() had been called. This allows breakers to
+// be put in place behind a feature flag.
+func WithDisabled(v bool) BreakerOption {
+	return newBreakerOption("Disabled", v, func(b *breaker) {
+		if v {
+			b.disabled = 1
+		} else {
+			b.disabled = 0
+		}
+	})
+}
+
+// WithStorage is used to specify the Storage where the state of the
+// breaker is persisted. The state is restored from the storage when
+// the breaker is created, and saved whenever the breaker trips or
+// resets. Counters are saved at most once per storage interval
+// (see `WithStorageInterval`) as calls are recorded. States are saved
+// in the background, so that calls do not wait for the storage, and
+// only the latest state is saved when the storage falls behind.
+func WithStorage(v Storage) BreakerOption {
+	return newBreakerOption("Storage", v, func(b *breaker) {
+		b.storage = v
+	})
+}
+
+// WithStorageInterval is used to specify the minimum interval between
+// saves of the counters to the storage specified by `WithStorage`.
+// The default is DefaultStorageInterval
+func WithStorageInterval(v time.Duration) BreakerOption {
+	return newBreakerOption("StorageInterval", v, func(b *breaker) {
+		b.storageInterval = v
+	})
+}
+
+// WithWindowSize is used to specify that the failures and successes
+// should be counted over the last n calls, instead of over a period
+// of time. This gives more stable error rates for breakers that
+// see little traffic.
+func WithWindowSize(v int) BreakerOption {
+	return newBreakerOption("WindowSize", v, func(b *breaker) {
+		b.windowSize = v
+	})
+}
+
+// WithWarmup is used to specify a period of time after the creation
+// of the breaker during which the tripper is not consulted. Failures
+// are still recorded, but cold caches and connection pools that are
+// being established at startup can't trip the breaker.
+func WithWarmup(v time.Duration) BreakerOption {
+	return newBreakerOption("Warmup", v, func(b *breaker) {
+		b.warmup = v
+	})
+}
+
+// WithWindowTime is used to specify the period of time over which
+// failures and successes are counted. The default is DefaultWindowTime.
+// The window must cover the longest period given to ErrorRateSince.
+func WithWindowTime(v time.Duration) BreakerOption {
+	return newBreakerOption("WindowTime", v, func(b *breaker) {
+		b.windowTime = v
+	})
+}
+
+// WithWindowBuckets is used to specify the number of buckets the window
+// is divided into. More buckets give finer grained results to
+// CountsSince and ErrorRateSince. The default is DefaultWindowBuckets.
+func WithWindowBuckets(v int) BreakerOption {
+	return newBreakerOption("WindowBuckets", v, func(b *breaker) {
+		b.windowBuckets = v
+	})
+}
+
+// WithEventHistory is used to specify that the breaker should keep
+// its last n state transitions, which can then be queried via
+// Transitions(). This allows inspecting what happened to a breaker
+// without having subscribed to its events beforehand.
+func WithEventHistory(v int) BreakerOption {
+	return newBreakerOption("EventHistory", v, func(b *breaker) {
+		b.historySize = v
+	})
+}
+
+// WithLogger is used to specify a Logger, to which the breaker logs
+// when it trips, when it lets half-open probes through, and when it
+// resets. Unlike the debug output, which is enabled at build time,
+// this can be enabled for individual breakers.
+func WithLogger(v Logger) BreakerOption {
+	return newBreakerOption("Logger", v, func(b *breaker) {
+		b.logger = v
+	})
+}
+
+// WithObserver is used to specify an Observer that is notified of the
+// failures, successes and state changes of the breaker. It may be
+// given several times to add several observers. Observers can also be
+// added to existing breakers with Observe
+func WithObserver(v Observer) BreakerOption {
+	return newBreakerOption("Observer", v, func(b *breaker) {
+		b.addListener(observerListener{observer: v})
+	})
+}
+
+// WithContext is used to specify the context used when `Call` is
+// executed. If the context is done before the circuit completes,
+// `Call` returns the context's error. Calls that are canceled via the
+// context are not recorded as either a success or a failure, whereas
+// calls that exceed the context deadline are recorded as failures.
+func WithContext(v context.Context) CallOption {
+	return newCallOption("Context", v, func(c *callConfig) {
+		c.ctx = v
+	})
+}
+
+// WithForce is used to execute the circuit even if the breaker is
+// open, such as for manually triggered health probes. If the breaker
+// is open, the call counts as a half-open probe, so that a success may
+// close the breaker. Breakers held open by `Break` stay open.
+func WithForce(v bool) CallOption {
+	return newCallOption("Force", v, func(c *callConfig) {
+		c.force = v
+	})
+}
+
+// WithBufferSize is used to specify the capacity of the channels of
+// an `EventSubscription`. Events are dropped when the subscriber falls
+// behind by more than this many events, unless `WithLossless` is
+// specified. The default is 0 (unbuffered), which means that events are
+// only delivered if the subscriber is waiting to receive them.
+func WithBufferSize(v int) SubscribeOption {
+	return newSubscribeOption("BufferSize", v, func(s *EventSubscription) {
+		s.bufferSize = v
+	})
+}
+
+// WithLossless is used to specify that events should never be dropped
+// for the subscription. The emitter blocks until each event has been
+// received from `EventSubscription.Data`, until the context passed to
+// `Subscribe` is done, or until the subscription is stopped. Events are
+// still sent to `EventSubscription.C` on a best-effort basis.
+//
+// A slow lossless subscriber delays the delivery of events to all other
+// subscribers of the same emitter.
+func WithLossless(v bool) SubscribeOption {
+	return newSubscribeOption("Lossless", v, func(s *EventSubscription) {
+		s.lossless = v
+	})
+}
+
+// WithTripFraction is used to specify the fraction (0 to 1) of the
+// children of a Group that must be open for the parent to trip. The
+// parent does not trip because of its children if this is not given.
+func WithTripFraction(v float64) GroupOption {
+	return newGroupOption("TripFraction", v, func(g *Group) {
+		g.fraction = v
+	})
+}
+
+// WithInitialLimit is used to specify the concurrency limit that an
+// AdaptiveBreaker starts with. The default is DefaultInitialLimit.
+func WithInitialLimit(v int) AdaptiveOption {
+	return newAdaptiveOption("InitialLimit", v, func(a *AdaptiveBreaker) {
+		a.limit = float64(v)
+	})
+}
+
+// WithLimitBounds is used to specify the range within which an
+// AdaptiveBreaker adapts its concurrency limit. The defaults are
+// DefaultMinLimit and DefaultMaxLimit.
+func WithLimitBounds(min, max int) AdaptiveOption {
+	return newAdaptiveOption("LimitBounds", [2]int{min, max}, func(a *AdaptiveBreaker) {
+		a.minLimit = float64(min)
+		a.maxLimit = float64(max)
+	})
+}
+
+// WithDecreaseFactor is used to specify the factor by which an
+// AdaptiveBreaker multiplies its concurrency limit when a call fails
+// or is too slow. The default is DefaultDecreaseFactor.
+func WithDecreaseFactor(v float64) AdaptiveOption {
+	return newAdaptiveOption("DecreaseFactor", v, func(a *AdaptiveBreaker) {
+		a.decrease = v
+	})
+}
+
+// WithLatencyThreshold is used to specify the latency above which a
+// successful call made through an AdaptiveBreaker is considered a
+// sign of congestion, and decreases the limit as a failure would.
+// By default, only failures decrease the limit.
+func WithLatencyThreshold(v time.Duration) AdaptiveOption {
+	return newAdaptiveOption("LatencyThreshold", v, func(a *AdaptiveBreaker) {
+		a.latency = v
+	})
+}
+
+// WithTTL is used to specify how long a breaker may go unused before
+// it is evicted from an ExpiringMap. Breakers are not evicted for being
+// unused if the TTL is 0, which is the default.
+func WithTTL(v time.Duration) MapOption {
+	return newMapOption("TTL", v, func(m *ExpiringMap) {
+		m.ttl = v
+	})
+}
+
+// WithMaxEntries is used to specify the maximum number of breakers an
+// ExpiringMap holds. Once it is reached, the least recently used
+// breaker is evicted to make room for a new one. The number of
+// breakers is not limited if the value is 0, which is the default.
+func WithMaxEntries(v int) MapOption {
+	return newMapOption("MaxEntries", v, func(m *ExpiringMap) {
+		m.maxEntries = v
+	})
+}
+
+// WithEvictionCallback is used to specify a function that is called
+// with the name and the breaker of each entry evicted from an
+// ExpiringMap because it expired or because the map was full. It is
+// not called for entries removed with Delete or replaced with Set.
+func WithEvictionCallback(v func(string, Breaker)) MapOption {
+	return newMapOption("EvictionCallback", v, func(m *ExpiringMap) {
+		m.onEvict = v
+	})
+}
+
+// WithJanitorInterval is used to specify the interval at which an
+// ExpiringMap evicts expired breakers in the background. Without a
+// janitor, expired breakers are only evicted when they are looked up.
+// The default is the TTL, and a negative interval disables the janitor.
+func WithJanitorInterval(v time.Duration) MapOption {
+	return newMapOption("JanitorInterval", v, func(m *ExpiringMap) {
+		m.janitorInterval = v
+	})
 }
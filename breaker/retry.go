@@ -0,0 +1,102 @@
+package breaker
+
+import (
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	pdebug "github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// CallWithRetry calls the circuit through the breaker, retrying failed
+// calls according to the given backoff policy. The breaker is consulted
+// before every attempt, and retrying stops immediately once the breaker
+// is open, so that retries never pile up on a service that the breaker
+// has already given up on.
+//
+// Retrying also stops when the policy returns backoff.Stop, when the
+// circuit returns a *backoff.PermanentError, possibly wrapped, or when
+// the context given through WithContext is done. In all cases the
+// error from the last attempt is returned.
+//
+// The delays between attempts are measured with the clock of the
+// breaker (see WithClock), if cb is or wraps a breaker created by New.
+//
+// If a fallback is configured, a failed attempt returns the result of
+// the fallback, so the call is retried only if the fallback fails too.
+func CallWithRetry(cb Breaker, circuit Circuit, policy backoff.BackOff, options ...CallOption) (err error) {
+	if pdebug.Enabled {
+		g := pdebug.Marker("CallWithRetry").BindError(&err)
+		defer g.End()
+	}
+
+	config := callConfig{}
+	for _, option := range options {
+		option.applyCall(&config)
+	}
+	var done <-chan struct{}
+	if config.ctx != nil {
+		done = config.ctx.Done()
+	}
+
+	clock := clockOf(cb)
+	policy.Reset()
+	for {
+		err = cb.Call(circuit, options...)
+		if err == nil {
+			return nil
+		}
+
+		var permanent *backoff.PermanentError
+		if errors.As(err, &permanent) {
+			return permanent.Err
+		}
+
+		if IsOpen(err) || cb.Tripped() {
+			if pdebug.Enabled {
+				pdebug.Printf("Breaker is open, giving up")
+			}
+			return err
+		}
+
+		next := policy.NextBackOff()
+		if next == backoff.Stop {
+			return err
+		}
+
+		if pdebug.Enabled {
+			pdebug.Printf("Retrying in %s: %s", next, err)
+		}
+
+		t := NewTimer(clock, next)
+		select {
+		case <-done:
+			t.Stop()
+			return err
+		case <-t.C():
+		}
+	}
+}
+
+// clockOf returns the clock of the breaker created by New() that cb is,
+// or that cb wraps, or SystemClock if there is none
+func clockOf(cb Breaker) Clock {
+	if b, ok := coreOf(cb); ok {
+		return b.clock
+	}
+	return SystemClock
+}
+
+// coreOf returns the breaker created by New() that cb is, or that cb
+// wraps, going through the wrappers of this package
+func coreOf(cb Breaker) (*breaker, bool) {
+	for cb != nil {
+		if b, ok := cb.(*breaker); ok {
+			return b, true
+		}
+		w, ok := cb.(wrapper)
+		if !ok {
+			break
+		}
+		cb = w.unwrap()
+	}
+	return nil, false
+}
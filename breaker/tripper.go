@@ -1,5 +1,11 @@
 package breaker
 
+import (
+	"context"
+	"math"
+	"time"
+)
+
 // Trip return true if the TripFunc thinks the failure
 // state has reached the point where the circuit
 // breaker should be tripped
@@ -7,6 +13,40 @@ func (f TripFunc) Trip(cb Breaker) bool {
 	return f(cb)
 }
 
+// Trip calls the function with context.Background()
+func (f TripContextFunc) Trip(cb Breaker) bool {
+	return f(context.Background(), cb)
+}
+
+// TripContext calls the function with ctx
+func (f TripContextFunc) TripContext(ctx context.Context, cb Breaker) bool {
+	return f(ctx, cb)
+}
+
+// tripContext asks t whether the breaker should trip, passing ctx
+// along if t accepts it
+func tripContext(ctx context.Context, t Tripper, cb Breaker) bool {
+	if ct, ok := t.(ContextTripper); ok {
+		return ct.TripContext(ctx, cb)
+	}
+	return t.Trip(cb)
+}
+
+// dryRunKey is the context key that marks the evaluations made by
+// WouldTrip
+type dryRunKey struct{}
+
+// dryRunContext is the context passed to trippers by WouldTrip
+var dryRunContext = context.WithValue(context.Background(), dryRunKey{}, true)
+
+// IsDryRun returns true if ctx is the context given to a ContextTripper
+// by Breaker.WouldTrip. Trippers that keep state between calls should
+// leave it untouched during dry runs.
+func IsDryRun(ctx context.Context) bool {
+	v, _ := ctx.Value(dryRunKey{}).(bool)
+	return v
+}
+
 // NilTripper is a Tripper that always returns false
 var NilTripper = TripFunc(func(cb Breaker) bool {
 	return false
@@ -28,6 +68,72 @@ func ConsecutiveTripper(threshold int64) Tripper {
 	})
 }
 
+// DecayingConsecutiveTripper returns a Tripper that trips whenever the
+// *consecutive* failure count meets the given threshold, where each
+// failure counts less as time goes by. The weight of a failure halves
+// every halfLife, so that rare failures spread over a long period of
+// time do not trip the breaker, while a burst of failures does. Time
+// is measured with the clock of the breaker (see WithClock). The score
+// is kept per breaker, so that the Tripper may be shared by breakers.
+func DecayingConsecutiveTripper(threshold int64, halfLife time.Duration) Tripper {
+	t := &decayingTripper{
+		halfLife:  halfLife,
+		threshold: threshold,
+	}
+	return TripContextFunc(t.trip)
+}
+
+func (t *decayingTripper) trip(ctx context.Context, cb Breaker) bool {
+	s := t.scoreOf(cb)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := clockOf(cb).Now()
+	if IsDryRun(ctx) {
+		// Decay the score of the last failure, without counting
+		// a new one
+		if cb.ConsecFailures() == 0 {
+			return false
+		}
+		return s.score*math.Pow(0.5, float64(now.Sub(s.last))/float64(t.halfLife)) >= float64(t.threshold)
+	}
+
+	if cb.ConsecFailures() <= 1 {
+		// A success happened since the last failure, start over
+		s.score = 0
+	} else {
+		s.score *= math.Pow(0.5, float64(now.Sub(s.last))/float64(t.halfLife))
+	}
+	s.score++
+	s.last = now
+
+	return s.score >= float64(t.threshold)
+}
+
+// scoreOf returns the score of cb. The scores of the breakers created
+// by New() are kept in the breakers, so that they go away with them
+func (t *decayingTripper) scoreOf(cb Breaker) *decayingScore {
+	if b, ok := coreOf(cb); ok {
+		if v, ok := b.tripperStates.Load(t); ok {
+			return v.(*decayingScore)
+		}
+		v, _ := b.tripperStates.LoadOrStore(t, &decayingScore{})
+		return v.(*decayingScore)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.others == nil {
+		t.others = make(map[Breaker]*decayingScore)
+	}
+	s, ok := t.others[cb]
+	if !ok {
+		s = &decayingScore{}
+		t.others[cb] = s
+	}
+	return s
+}
+
 // RateTripper returns a Tripper that trips whenever the
 // error rate hits the given threshold.
 //
@@ -44,3 +150,213 @@ func RateTripper(rate float64, minSamples int64) Tripper {
 		return samples >= minSamples && cb.ErrorRate() >= rate
 	})
 }
+
+// WindowedRateTripper returns a Tripper that trips whenever the error
+// rate over the last d hits the given threshold, regardless of the time
+// covered by the window of the breaker. This allows a single breaker
+// to react both to short bursts of errors and to slow degradations:
+//
+//	breaker.WithWindowTime(5 * time.Minute),
+//	breaker.WithWindowBuckets(60),
+//	breaker.WithTripper(breaker.AnyTripper(
+//		breaker.WindowedRateTripper(0.9, 10, 5*time.Second),
+//		breaker.WindowedRateTripper(0.2, 100, 5*time.Minute),
+//	)),
+//
+// This Tripper will not trip until there has been at least minSamples
+// events over the last d.
+func WindowedRateTripper(rate float64, minSamples int64, d time.Duration) Tripper {
+	return TripFunc(func(cb Breaker) bool {
+		failures, successes := cb.CountsSince(d)
+		samples := failures + successes
+		return samples >= minSamples && samples > 0 && float64(failures)/float64(samples) >= rate
+	})
+}
+
+// BudgetTripper returns a Tripper that trips once the fraction of
+// failed calls since the start of the current period exceeds budget,
+// the error budget of an SLO (e.g. 0.001 for 99.9% availability).
+// Periods start at multiples of period since the zero time, and the
+// budget is renewed at the start of each of them. A breaker tripped by
+// the Tripper is reset when the next period starts.
+//
+// The Tripper keeps its own counts per breaker, so that they do not
+// depend on the window of the breaker. It starts counting the calls of
+// a breaker the first time it is consulted, i.e. on its first failure,
+// and the calls made before that are taken from the window of the
+// breaker. Breakers that were not created by New() are always counted
+// from their window. Use WithMinSamples to avoid tripping on the first
+// calls of a period. Periods follow the clock of the breaker (see
+// WithClock).
+func BudgetTripper(budget float64, period time.Duration) Tripper {
+	t := &budgetTripper{
+		budget: budget,
+		period: period,
+	}
+	return TripContextFunc(t.trip)
+}
+
+func (t *budgetTripper) trip(ctx context.Context, cb Breaker) bool {
+	c := t.countsOf(cb)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.clock.Now()
+	c.roll(now)
+	if !c.observing {
+		c.failures, c.successes = cb.CountsSince(now.Sub(c.start))
+		if _, ok := coreOf(cb); ok && !IsDryRun(ctx) {
+			c.observing = Observe(cb, c)
+		}
+	}
+
+	total := c.failures + c.successes
+	if total == 0 || float64(c.failures)/float64(total) <= t.budget {
+		return false
+	}
+	if !IsDryRun(ctx) && !c.resetting {
+		c.resetting = true
+		go c.resetAfter(cb, c.clock.After(c.start.Add(t.period).Sub(now)))
+	}
+	return true
+}
+
+// countsOf returns the counts of cb. The counts of the breakers created
+// by New() are kept in the breakers, so that they go away with them
+func (t *budgetTripper) countsOf(cb Breaker) *budgetCounts {
+	if b, ok := coreOf(cb); ok {
+		if v, ok := b.tripperStates.Load(t); ok {
+			return v.(*budgetCounts)
+		}
+		v, _ := b.tripperStates.LoadOrStore(t, &budgetCounts{clock: b.clock, period: t.period})
+		return v.(*budgetCounts)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.others == nil {
+		t.others = make(map[Breaker]*budgetCounts)
+	}
+	c, ok := t.others[cb]
+	if !ok {
+		c = &budgetCounts{clock: SystemClock, period: t.period}
+		t.others[cb] = c
+	}
+	return c
+}
+
+// roll starts a new period if now is past the current one. roll
+// assumes that the caller has locked the mutex
+func (c *budgetCounts) roll(now time.Time) {
+	if start := now.Truncate(c.period); start.After(c.start) {
+		c.start = start
+		c.failures = 0
+		c.successes = 0
+	}
+}
+
+// resetAfter resets cb when after fires, at the start of the next
+// period, so that calls are let through again once the budget is renewed
+func (c *budgetCounts) resetAfter(cb Breaker, after <-chan time.Time) {
+	<-after
+	c.mutex.Lock()
+	c.resetting = false
+	c.mutex.Unlock()
+	if cb.Tripped() {
+		cb.Reset()
+	}
+}
+
+func (c *budgetCounts) OnFail(State, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.roll(c.clock.Now())
+	c.failures++
+}
+
+func (c *budgetCounts) OnSuccess(State) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.roll(c.clock.Now())
+	c.successes++
+}
+
+func (c *budgetCounts) OnStateChange(State, State, error) {}
+
+// AnyTripper returns a Tripper that trips whenever any of the
+// given Trippers trip. The Trippers are evaluated in order, and
+// evaluation stops at the first Tripper that trips. The context of
+// the call is passed along to ContextTrippers.
+func AnyTripper(trippers ...Tripper) Tripper {
+	return TripContextFunc(func(ctx context.Context, cb Breaker) bool {
+		for _, t := range trippers {
+			if tripContext(ctx, t, cb) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// AllTripper returns a Tripper that trips only when all of the
+// given Trippers trip. The Trippers are evaluated in order, and
+// evaluation stops at the first Tripper that does not trip.
+// An AllTripper with no Trippers never trips. The context of the
+// call is passed along to ContextTrippers.
+func AllTripper(trippers ...Tripper) Tripper {
+	return TripContextFunc(func(ctx context.Context, cb Breaker) bool {
+		if len(trippers) == 0 {
+			return false
+		}
+		for _, t := range trippers {
+			if !tripContext(ctx, t, cb) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// SentinelTripper returns a Tripper that trips whenever healthy reports
+// that the backend is unhealthy, e.g. according to service discovery
+// or to the health checks of a load balancer, or whenever t trips.
+// t decides from the local counts, and may be nil to rely on the
+// signal alone. The context of the call is passed along to t if it
+// is a ContextTripper.
+//
+// As trippers are only consulted when a failure is recorded, use
+// WatchHealth to open the breaker as soon as the backend is marked
+// unhealthy.
+func SentinelTripper(healthy func() bool, t Tripper) Tripper {
+	return TripContextFunc(func(ctx context.Context, cb Breaker) bool {
+		if !healthy() {
+			return true
+		}
+		return t != nil && tripContext(ctx, t, cb)
+	})
+}
+
+// WatchHealth trips cb whenever false is received from signals, so
+// that the breaker opens preemptively when the backend is marked
+// unhealthy, without waiting for calls to fail. Receiving true does
+// not close the breaker: it recovers through half-open probes as
+// usual. WatchHealth blocks until ctx is canceled or signals is
+// closed.
+func WatchHealth(ctx context.Context, cb Breaker, signals <-chan bool) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case healthy, ok := <-signals:
+			if !ok {
+				return
+			}
+			// Peek at the state, so that no probe is handed out.
+			// A breaker that would let a probe through is tripped
+			// again, so that the probe waits for the next interval
+			if !healthy && cb.Snapshot().State != Open {
+				cb.Trip()
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package breakertest
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// AssertState checks that cb is in the given state, without side
+// effects. Like the functions of testify's assert package, it reports
+// a failure through t and returns false if the check failed.
+func AssertState(t TB, cb breaker.Breaker, st breaker.State) bool {
+	t.Helper()
+	if got := cb.Snapshot().State; got != st {
+		t.Errorf("breaker %q should be %s, but is %s", cb.Name(), st, got)
+		return false
+	}
+	return true
+}
+
+// AssertTripped checks that cb is tripped, i.e. open or half-open
+func AssertTripped(t TB, cb breaker.Breaker) bool {
+	t.Helper()
+	if !cb.Tripped() {
+		t.Errorf("breaker %q should be tripped", cb.Name())
+		return false
+	}
+	return true
+}
+
+// AssertNotTripped checks that cb is closed
+func AssertNotTripped(t TB, cb breaker.Breaker) bool {
+	t.Helper()
+	if cb.Tripped() {
+		t.Errorf("breaker %q should not be tripped", cb.Name())
+		return false
+	}
+	return true
+}
+
+// AssertTrippedWithin calls c through cb up to n times, and checks
+// that cb trips by then. Calls stop as soon as cb is tripped.
+func AssertTrippedWithin(t TB, cb breaker.Breaker, n int, c breaker.Circuit) bool {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		cb.Call(c)
+		if cb.Tripped() {
+			return true
+		}
+	}
+	t.Errorf("breaker %q should trip within %d calls", cb.Name(), n)
+	return false
+}
+
+// AssertHalfopensWithin moves clk forward by step until cb would let
+// a probe through, and checks that it does so within d. Only the
+// snapshot of cb is used, so no probe is consumed.
+func AssertHalfopensWithin(t TB, cb breaker.Breaker, clk *Clock, d, step time.Duration) bool {
+	t.Helper()
+	if step <= 0 {
+		step = time.Millisecond
+	}
+	for elapsed := time.Duration(0); ; elapsed += step {
+		if cb.Snapshot().State == breaker.Halfopen {
+			return true
+		}
+		if elapsed >= d {
+			break
+		}
+		clk.Add(step)
+	}
+	t.Errorf("breaker %q should half-open within %s", cb.Name(), d)
+	return false
+}
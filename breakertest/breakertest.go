@@ -0,0 +1,15 @@
+// Package breakertest provides utilities to test code that depends on
+// breakers, such as a deterministic clock, a fake breaker whose state
+// is controlled by the test, and assertions on the state of breakers.
+//
+//	c := breakertest.NewClock()
+//	cb := breakertest.NewBreaker(c, breaker.WithTripper(breaker.ThresholdTripper(1)))
+//	breakertest.AssertTrippedWithin(t, cb, 1, failing)
+//	breakertest.AssertHalfopensWithin(t, cb, c, time.Second, time.Millisecond)
+//
+// A Fake can be scripted to go through states, for example to check
+// how a client behaves when the breaker opens and closes again:
+//
+//	f := breakertest.NewFake()
+//	f.Script(breaker.Closed, breaker.Open, breaker.Closed)
+package breakertest
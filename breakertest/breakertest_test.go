@@ -0,0 +1,136 @@
+package breakertest_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/breakertest"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingT) Helper() {}
+
+var failing = breaker.CircuitFunc(func() error { return errors.New("error") })
+
+func TestBreaker(t *testing.T) {
+	c := breakertest.NewClock()
+	cb := breakertest.NewBreaker(c,
+		breaker.WithName("test"),
+		breaker.WithTripper(breaker.ThresholdTripper(3)),
+	)
+
+	if !breakertest.AssertNotTripped(t, cb) {
+		return
+	}
+	if !breakertest.AssertTrippedWithin(t, cb, 3, failing) {
+		return
+	}
+	if !assert.Equal(t, int64(3), cb.Failures(), "calls should stop once the breaker trips") {
+		return
+	}
+	if !breakertest.AssertState(t, cb, breaker.Open) {
+		return
+	}
+	if !breakertest.AssertHalfopensWithin(t, cb, c, time.Second, time.Millisecond) {
+		return
+	}
+	if !assert.True(t, c.Now().After(time.Unix(0, 0)), "the clock should have been moved") {
+		return
+	}
+
+	var rt recordingT
+	cb.Reset()
+	breakertest.AssertTrippedWithin(&rt, breakertest.NewBreaker(c), 5, failing)
+	breakertest.AssertTripped(&rt, cb)
+	if !assert.Len(t, rt.errors, 2, "failed assertions should be reported") {
+		return
+	}
+	if !assert.Equal(t, `breaker "" should trip within 5 calls`, rt.errors[0], "message should describe the failure") {
+		return
+	}
+
+	cb.Break()
+	rt.errors = nil
+	breakertest.AssertHalfopensWithin(&rt, cb, c, time.Minute, time.Second)
+	if !assert.Len(t, rt.errors, 1, "broken breaker should not half-open") {
+		return
+	}
+}
+
+func TestFake(t *testing.T) {
+	c := breakertest.NewClock()
+	f := breakertest.NewFake(breakertest.WithName("fake"), breakertest.WithClock(c))
+	var cb breaker.Breaker = f
+
+	f.Script(breaker.Closed, breaker.Open, breaker.Halfopen, breaker.Closed)
+
+	if !assert.NoError(t, cb.Call(breaker.CircuitFunc(func() error { return nil })), "first call should be let through") {
+		return
+	}
+	err := cb.Call(failing)
+	if !assert.True(t, breaker.IsOpen(err), "second call should be rejected") {
+		return
+	}
+	if !assert.Error(t, cb.Call(failing), "third call should be let through in half-open") {
+		return
+	}
+	if !breakertest.AssertState(t, cb, breaker.Halfopen) {
+		return
+	}
+	if ok, st := cb.Ready(); !assert.True(t, ok, "fake should be ready") || !assert.Equal(t, breaker.Closed, st, "script should end closed") {
+		return
+	}
+	if ok, _ := cb.Ready(); !assert.True(t, ok, "fake should stay in the last state") {
+		return
+	}
+
+	if !assert.Equal(t, int64(2), f.Calls(), "calls should be counted") {
+		return
+	}
+	if !assert.Equal(t, int64(1), f.Rejected(), "rejections should be counted") {
+		return
+	}
+	if !assert.Equal(t, int64(1), cb.Failures(), "failures should be recorded") {
+		return
+	}
+	if !assert.Equal(t, int64(1), cb.Successes(), "successes should be recorded") {
+		return
+	}
+	if !assert.Len(t, cb.Transitions(), 3, "transitions should be recorded") {
+		return
+	}
+
+	f.SetState(breaker.Open)
+	if !breakertest.AssertTripped(t, cb) {
+		return
+	}
+	cb.Disable()
+	if !assert.NoError(t, cb.Call(breaker.CircuitFunc(func() error { return nil })), "disabled fake should let calls through") {
+		return
+	}
+	cb.Enable()
+
+	done, err := cb.Allow()
+	if !assert.True(t, breaker.IsOpen(err), "Allow should be rejected") || !assert.Nil(t, done, "no function should be returned") {
+		return
+	}
+
+	cb.Reset()
+	if !breakertest.AssertNotTripped(t, cb) {
+		return
+	}
+	if !assert.Equal(t, breaker.Snapshot{State: breaker.Closed, Trips: 2, LastFailure: c.Now()}, cb.Snapshot(), "counters should be reset") {
+		return
+	}
+}
@@ -0,0 +1,48 @@
+package breakertest
+
+import (
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/fbclock"
+)
+
+// NewClock creates a Clock set to the Unix epoch
+func NewClock() *Clock {
+	m := clock.NewMock()
+	return &Clock{
+		Clock: fbclock.New(m),
+		mock:  m,
+	}
+}
+
+// Add moves the clock forward by d, firing the timers and tickers
+// that expire in the meantime
+func (c *Clock) Add(d time.Duration) {
+	c.mock.Add(d)
+}
+
+// NewBackOff creates an exponential backoff policy driven by c, that
+// starts at one millisecond and is not randomized, so that tests can
+// tell how far the clock must be moved for a breaker to half-open
+func NewBackOff(c *Clock) backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = time.Millisecond
+	bo.RandomizationFactor = 0
+	bo.Clock = c
+	bo.Reset()
+	return bo
+}
+
+// NewBreaker creates a breaker with New() that uses c for both the
+// breaker and its backoff policy (see NewBackOff). The options are
+// applied afterwards, so that they can override either.
+func NewBreaker(c *Clock, options ...breaker.BreakerOption) breaker.Breaker {
+	defaults := []breaker.BreakerOption{
+		breaker.WithClock(c),
+		breaker.WithBackOff(NewBackOff(c)),
+	}
+	return breaker.New(append(defaults, options...)...)
+}
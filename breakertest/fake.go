@@ -0,0 +1,389 @@
+package breakertest
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// NewFake creates a Fake, which is closed unless WithState is given
+//
+// Possible optional parameters:
+// * WithName: specify the name of the fake
+// * WithLabels: specify the labels of the fake
+// * WithClock: specify the clock used to timestamp failures and transitions
+// * WithState: specify the initial state
+func NewFake(options ...Option) *Fake {
+	f := &Fake{
+		backoff: &backoff.ZeroBackOff{},
+		clock:   breaker.SystemClock,
+		state:   breaker.Closed,
+	}
+	for _, option := range options {
+		option.apply(f)
+	}
+	return f
+}
+
+// SetState changes the state of the fake, and discards the states
+// given to Script that were not used yet
+func (f *Fake) SetState(st breaker.State) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = nil
+	f.setState(st, nil)
+}
+
+// Script queues states that the fake takes one after another, each
+// time a call is made through Call, Allow or Ready. Once the script
+// is exhausted, the fake stays in the last state.
+func (f *Fake) Script(states ...breaker.State) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = append(f.script, states...)
+}
+
+// Calls returns the number of calls that were let through by Call and
+// Allow
+func (f *Fake) Calls() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// Rejected returns the number of calls that were rejected by Call and
+// Allow because the fake was open
+func (f *Fake) Rejected() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rejected
+}
+
+// next moves to the next scripted state, if any. It must be called
+// with the lock held
+func (f *Fake) next() breaker.State {
+	if len(f.script) > 0 {
+		st := f.script[0]
+		f.script = f.script[1:]
+		f.setState(st, nil)
+	}
+	return f.state
+}
+
+// setState must be called with the lock held
+func (f *Fake) setState(st breaker.State, err error) {
+	if st == f.state {
+		return
+	}
+	now := f.clock.Now()
+	if st == breaker.Open && f.state == breaker.Closed {
+		f.trips++
+		f.lastFailure = now
+	}
+	if st == breaker.Closed {
+		f.broken = false
+		f.retryAt = time.Time{}
+	}
+	f.transitions = append(f.transitions, breaker.Transition{
+		From: f.state,
+		To:   st,
+		Time: now,
+		Err:  err,
+	})
+	f.state = st
+}
+
+// admit decides whether a call is let through. It must be called with
+// the lock held
+func (f *Fake) admit() error {
+	if f.next() == breaker.Open && !f.disabled {
+		f.rejected++
+		return &breaker.OpenError{
+			Name:    f.name,
+			RetryAt: f.retryAt,
+		}
+	}
+	f.calls++
+	return nil
+}
+
+// record must be called with the lock held
+func (f *Fake) record(failed bool) {
+	if !failed {
+		f.successes++
+		f.consec = 0
+		return
+	}
+	f.failures++
+	f.consec++
+	f.lastFailure = f.clock.Now()
+}
+
+func (f *Fake) Allow() (func(bool), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.admit(); err != nil {
+		return nil, err
+	}
+	return func(success bool) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.record(!success)
+	}, nil
+}
+
+func (f *Fake) AverageLatency() time.Duration {
+	return 0
+}
+
+func (f *Fake) Backoff() backoff.BackOff {
+	return f.backoff
+}
+
+func (f *Fake) Break() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = nil
+	f.setState(breaker.Open, nil)
+	f.broken = true
+	f.retryAt = time.Time{}
+}
+
+// Call executes the circuit unless the fake is open, and records its
+// outcome. The options are ignored.
+func (f *Fake) Call(c breaker.Circuit, _ ...breaker.CallOption) error {
+	f.mu.Lock()
+	if err := f.admit(); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	f.mu.Unlock()
+
+	err := c.Execute()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(err != nil)
+	return err
+}
+
+func (f *Fake) ConsecFailures() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.consec
+}
+
+func (f *Fake) CountsSince(time.Duration) (int64, int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failures, f.successes
+}
+
+func (f *Fake) DefaultTimeout() time.Duration {
+	return 0
+}
+
+func (f *Fake) Disable() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disabled = true
+}
+
+func (f *Fake) Disabled() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.disabled
+}
+
+func (f *Fake) Enable() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.disabled = false
+}
+
+func (f *Fake) ErrorRate() float64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.errorRate()
+}
+
+// errorRate must be called with the lock held
+func (f *Fake) errorRate() float64 {
+	total := f.failures + f.successes
+	if total == 0 {
+		return 0
+	}
+	return float64(f.failures) / float64(total)
+}
+
+func (f *Fake) ErrorRateSince(time.Duration) float64 {
+	return f.ErrorRate()
+}
+
+func (f *Fake) Failures() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failures
+}
+
+func (f *Fake) FailuresByClass() map[string]int64 {
+	return map[string]int64{}
+}
+
+func (f *Fake) History() []breaker.Bucket {
+	return nil
+}
+
+func (f *Fake) InFlight() int64 {
+	return 0
+}
+
+func (f *Fake) Labels() map[string]string {
+	return copyLabels(f.labels)
+}
+
+// copyLabels returns a copy of labels, or nil if there are none
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
+
+func (f *Fake) LastFailure() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lastFailure
+}
+
+func (f *Fake) MarkFailure(error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(true)
+}
+
+func (f *Fake) MarkSuccess() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record(false)
+}
+
+func (f *Fake) Name() string {
+	return f.name
+}
+
+func (f *Fake) Percentile(float64) time.Duration {
+	return 0
+}
+
+func (f *Fake) Ready() (bool, breaker.State) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	st := f.next()
+	return st != breaker.Open || f.disabled, st
+}
+
+// Reconfigure accepts any option, and ignores them
+func (f *Fake) Reconfigure(...breaker.BreakerOption) error {
+	return nil
+}
+
+func (f *Fake) RetryAt() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.retryAt
+}
+
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = nil
+	f.setState(breaker.Closed, nil)
+	f.resetCounters()
+}
+
+func (f *Fake) ResetBackoff() {}
+
+func (f *Fake) ResetCounters() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.resetCounters()
+}
+
+// resetCounters must be called with the lock held
+func (f *Fake) resetCounters() {
+	f.failures = 0
+	f.successes = 0
+	f.consec = 0
+}
+
+func (f *Fake) State() breaker.State {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state
+}
+
+func (f *Fake) Snapshot() breaker.Snapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return breaker.Snapshot{
+		State:          f.state,
+		Failures:       f.failures,
+		Successes:      f.successes,
+		ConsecFailures: f.consec,
+		ErrorRate:      f.errorRate(),
+		LastFailure:    f.lastFailure,
+		NextRetry:      f.retryAt,
+		Trips:          f.trips,
+	}
+}
+
+func (f *Fake) Successes() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.successes
+}
+
+func (f *Fake) Transitions() []breaker.Transition {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]breaker.Transition(nil), f.transitions...)
+}
+
+func (f *Fake) Trip() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = nil
+	f.setState(breaker.Open, nil)
+}
+
+// TripUntil trips the fake, and reports t from RetryAt. The fake does
+// not half-open on its own: use SetState or Script to do so.
+func (f *Fake) TripUntil(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.script = nil
+	f.setState(breaker.Open, nil)
+	if !f.broken {
+		f.retryAt = t
+	}
+}
+
+func (f *Fake) Tripped() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.state != breaker.Closed
+}
+
+func (f *Fake) Tripper() breaker.Tripper {
+	return breaker.NilTripper
+}
+
+func (f *Fake) WouldTrip() bool {
+	return false
+}
@@ -0,0 +1,58 @@
+package breakertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/fbclock"
+)
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*Fake)
+}
+
+type optionFunc func(*Fake)
+
+// TB is the subset of testing.TB used by the assertion helpers
+type TB interface {
+	Errorf(format string, args ...interface{})
+	Helper()
+}
+
+// Clock is a deterministic breaker.TimerClock, whose time only moves
+// when Add is called. Timers and tickers created by the
+// breaker fire when the clock is moved past them.
+type Clock struct {
+	*fbclock.Clock
+	mock *clock.Mock
+}
+
+// Fake is a breaker.Breaker whose state is controlled by the test,
+// instead of being decided by failures and trippers. Calls are let
+// through unless the fake is open, and their outcomes are counted.
+// Trip, Break and Reset change the state as they would on a real
+// breaker.
+type Fake struct {
+	backoff     backoff.BackOff
+	broken      bool
+	calls       int64
+	consec      int64
+	disabled    bool
+	failures    int64
+	labels      map[string]string
+	lastFailure time.Time
+	mu          sync.Mutex
+	name        string
+	clock       breaker.Clock
+	rejected    int64
+	retryAt     time.Time
+	script      []breaker.State
+	state       breaker.State
+	successes   int64
+	transitions []breaker.Transition
+	trips       int64
+}
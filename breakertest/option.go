@@ -0,0 +1,39 @@
+package breakertest
+
+import (
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (o optionFunc) apply(f *Fake) {
+	o(f)
+}
+
+// WithName is used to specify the name returned by Fake.Name
+func WithName(v string) Option {
+	return optionFunc(func(f *Fake) {
+		f.name = v
+	})
+}
+
+// WithLabels is used to specify the labels returned by Fake.Labels
+func WithLabels(v map[string]string) Option {
+	return optionFunc(func(f *Fake) {
+		f.labels = copyLabels(v)
+	})
+}
+
+// WithClock is used to specify the clock used by a Fake to timestamp
+// failures and transitions. By default, breaker.SystemClock is used.
+func WithClock(v breaker.Clock) Option {
+	return optionFunc(func(f *Fake) {
+		f.clock = v
+	})
+}
+
+// WithState is used to specify the initial state of a Fake, which is
+// Closed by default
+func WithState(v breaker.State) Option {
+	return optionFunc(func(f *Fake) {
+		f.state = v
+	})
+}
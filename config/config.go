@@ -0,0 +1,353 @@
+// Package config builds breakers from a declarative configuration,
+// written in JSON or YAML.
+//
+//	breakers:
+//	  payments:
+//	    tripper:
+//	      type: rate
+//	      rate: 0.5
+//	      min_samples: 20
+//	    timeout: 2s
+//	    backoff:
+//	      initial_interval: 500ms
+//	      max_interval: 30s
+//
+// Use Load to read a file, and Build to create the breakers:
+//
+//	cfg, err := config.Load("breakers.yaml")
+//	if err != nil {
+//		...
+//	}
+//	breakers, err := cfg.Build()
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Load reads the configuration from the file at path. Files ending
+// in ".json" are parsed as JSON, and all others as YAML
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read configuration")
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ParseJSON(data)
+	}
+	return ParseYAML(data)
+}
+
+// ParseJSON parses a JSON configuration
+func ParseJSON(data []byte) (*Config, error) {
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.Wrap(err, "failed to parse JSON configuration")
+	}
+	return &c, nil
+}
+
+// ParseYAML parses a YAML configuration
+func ParseYAML(data []byte) (*Config, error) {
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, errors.Wrap(err, "failed to parse YAML configuration")
+	}
+	return &c, nil
+}
+
+// Build creates a breaker for each entry of the configuration, and
+// returns them in a Map keyed by name. Each breaker is also given
+// its name via breaker.WithName. No breaker is created unless the
+// whole configuration is valid.
+//
+// Possible optional parameters:
+// * WithClock: specify the clock used by the breakers
+// * WithBreakerOptions: specify options passed to every breaker
+func (c *Config) Build(options ...Option) (breaker.Map, error) {
+	cfg := newBuildConfig(options)
+	clock, common := cfg.clock, cfg.common
+
+	// Validate everything before creating any breaker, since
+	// breakers may restore their state from storages on creation
+	list := make(map[string][]breaker.BreakerOption, len(c.Breakers))
+	for name, bc := range c.Breakers {
+		bopts, err := bc.options(clock)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid configuration for breaker %q", name)
+		}
+		list[name] = bopts
+	}
+
+	m := breaker.NewMap()
+	for name, bopts := range list {
+		all := make([]breaker.BreakerOption, 0, len(common)+len(bopts)+2)
+		all = append(all, breaker.WithClock(clock))
+		all = append(all, common...)
+		all = append(all, breaker.WithName(name))
+		all = append(all, bopts...)
+		m.Set(name, breaker.New(all...))
+	}
+	return m, nil
+}
+
+// Options converts the configuration into options that can be given
+// to breaker.New, or to Reconfigure to change an existing breaker.
+// Note that Reconfigure rejects some options, such as the one created
+// for WindowSize.
+//
+// Possible optional parameters:
+// * WithClock: specify the clock used by the backoff policy
+func (bc *BreakerConfig) Options(options ...Option) ([]breaker.BreakerOption, error) {
+	return bc.options(newBuildConfig(options).clock)
+}
+
+func (bc *BreakerConfig) options(c breaker.Clock) ([]breaker.BreakerOption, error) {
+	var options []breaker.BreakerOption
+
+	if bc.Tripper != nil {
+		t, err := bc.Tripper.tripper()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, breaker.WithTripper(t))
+	}
+
+	if bc.BackOff != nil {
+		bo, err := bc.BackOff.backoff(c)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, breaker.WithBackOff(bo))
+	}
+
+	switch {
+	case bc.Timeout < 0:
+		return nil, errors.New("timeout must not be negative")
+	case bc.Jitter < 0 || bc.Jitter > 1:
+		return nil, errors.New("jitter must be between 0 and 1")
+	case bc.WindowSize < 0:
+		return nil, errors.New("window_size must not be negative")
+	case bc.WindowTime < 0:
+		return nil, errors.New("window_time must not be negative")
+	case bc.WindowBuckets < 0:
+		return nil, errors.New("window_buckets must not be negative")
+	case bc.Warmup < 0:
+		return nil, errors.New("warmup must not be negative")
+	case bc.MinSamples < 0:
+		return nil, errors.New("min_samples must not be negative")
+	case bc.HalfOpenRequests < 0:
+		return nil, errors.New("half_open_requests must not be negative")
+	case bc.ProbeWait < 0:
+		return nil, errors.New("probe_wait must not be negative")
+	case bc.QueueSize < 0:
+		return nil, errors.New("queue_size must not be negative")
+	case bc.QueueWait < 0:
+		return nil, errors.New("queue_wait must not be negative")
+	case bc.SuccessThreshold < 0:
+		return nil, errors.New("success_threshold must not be negative")
+	case bc.MaxConcurrent < 0:
+		return nil, errors.New("max_concurrent must not be negative")
+	case bc.GradualRecovery < 0:
+		return nil, errors.New("gradual_recovery must not be negative")
+	}
+
+	if bc.Timeout > 0 {
+		options = append(options, breaker.WithTimeout(time.Duration(bc.Timeout)))
+	}
+	if bc.Jitter > 0 {
+		options = append(options, breaker.WithJitter(bc.Jitter))
+	}
+	if bc.WindowSize > 0 {
+		options = append(options, breaker.WithWindowSize(bc.WindowSize))
+	}
+	if bc.WindowTime > 0 {
+		options = append(options, breaker.WithWindowTime(time.Duration(bc.WindowTime)))
+	}
+	if bc.WindowBuckets > 0 {
+		options = append(options, breaker.WithWindowBuckets(bc.WindowBuckets))
+	}
+	if bc.Warmup > 0 {
+		options = append(options, breaker.WithWarmup(time.Duration(bc.Warmup)))
+	}
+	if bc.MinSamples > 0 {
+		options = append(options, breaker.WithMinSamples(bc.MinSamples))
+	}
+	if bc.HalfOpenRequests > 0 {
+		options = append(options, breaker.WithHalfOpenRequests(bc.HalfOpenRequests))
+	}
+	if bc.ProbeWait > 0 {
+		options = append(options, breaker.WithProbeWait(time.Duration(bc.ProbeWait)))
+	}
+	if bc.QueueSize > 0 && bc.QueueWait > 0 {
+		options = append(options, breaker.WithQueueing(bc.QueueSize, time.Duration(bc.QueueWait)))
+	}
+	if bc.SuccessThreshold > 0 {
+		options = append(options, breaker.WithSuccessThreshold(bc.SuccessThreshold))
+	}
+	if bc.MaxConcurrent > 0 {
+		options = append(options, breaker.WithMaxConcurrent(bc.MaxConcurrent))
+	}
+	if bc.GradualRecovery > 0 {
+		options = append(options, breaker.WithGradualRecovery(time.Duration(bc.GradualRecovery)))
+	}
+	return options, nil
+}
+
+func (tc *TripperConfig) tripper() (breaker.Tripper, error) {
+	switch tc.Type {
+	case ThresholdTripper, ConsecutiveTripper, DecayingTripper:
+		if tc.Threshold <= 0 {
+			return nil, errors.Errorf("%s tripper requires a positive threshold", tc.Type)
+		}
+	}
+
+	switch tc.Type {
+	case ThresholdTripper:
+		return breaker.ThresholdTripper(tc.Threshold), nil
+	case ConsecutiveTripper:
+		return breaker.ConsecutiveTripper(tc.Threshold), nil
+	case DecayingTripper:
+		if tc.HalfLife <= 0 {
+			return nil, errors.New("decaying tripper requires a positive half_life")
+		}
+		return breaker.DecayingConsecutiveTripper(tc.Threshold, time.Duration(tc.HalfLife)), nil
+	case RateTripper:
+		if tc.Rate <= 0 || tc.Rate > 1 {
+			return nil, errors.New("rate tripper requires a rate between 0 and 1")
+		}
+		if tc.Window < 0 {
+			return nil, errors.New("rate tripper requires a positive window")
+		}
+		if tc.Window > 0 {
+			return breaker.WindowedRateTripper(tc.Rate, tc.MinSamples, time.Duration(tc.Window)), nil
+		}
+		return breaker.RateTripper(tc.Rate, tc.MinSamples), nil
+	case AnyTripper, AllTripper:
+		if len(tc.Trippers) == 0 {
+			return nil, errors.Errorf("%s tripper requires at least one tripper", tc.Type)
+		}
+		list := make([]breaker.Tripper, len(tc.Trippers))
+		for i := range tc.Trippers {
+			t, err := tc.Trippers[i].tripper()
+			if err != nil {
+				return nil, err
+			}
+			list[i] = t
+		}
+		if tc.Type == AnyTripper {
+			return breaker.AnyTripper(list...), nil
+		}
+		return breaker.AllTripper(list...), nil
+	default:
+		return nil, errors.Errorf("unknown tripper type %q", tc.Type)
+	}
+}
+
+func (bc *BackOffConfig) backoff(c breaker.Clock) (backoff.BackOff, error) {
+	switch bc.Type {
+	case "", ExponentialBackOff:
+		bo := backoff.NewExponentialBackOff()
+		if bc.InitialInterval > 0 {
+			bo.InitialInterval = time.Duration(bc.InitialInterval)
+		}
+		if bc.MaxInterval > 0 {
+			bo.MaxInterval = time.Duration(bc.MaxInterval)
+		}
+		if bc.MaxElapsedTime > 0 {
+			bo.MaxElapsedTime = time.Duration(bc.MaxElapsedTime)
+		}
+		if bc.Multiplier != 0 {
+			if bc.Multiplier < 1 {
+				return nil, errors.New("backoff multiplier must be at least 1")
+			}
+			bo.Multiplier = bc.Multiplier
+		}
+		if bc.RandomizationFactor != nil {
+			if *bc.RandomizationFactor < 0 || *bc.RandomizationFactor > 1 {
+				return nil, errors.New("backoff randomization_factor must be between 0 and 1")
+			}
+			bo.RandomizationFactor = *bc.RandomizationFactor
+		}
+		bo.Clock = c
+		bo.Reset()
+		return bo, nil
+	case ConstantBackOff:
+		if bc.Interval <= 0 {
+			return nil, errors.New("constant backoff requires a positive interval")
+		}
+		return backoff.NewConstantBackOff(time.Duration(bc.Interval)), nil
+	case FibonacciBackOff:
+		if bc.InitialInterval <= 0 {
+			return nil, errors.New("fibonacci backoff requires a positive initial_interval")
+		}
+		return backoff.NewFibonacciBackOff(time.Duration(bc.InitialInterval), time.Duration(bc.MaxInterval)), nil
+	default:
+		return nil, errors.Errorf("unknown backoff type %q", bc.Type)
+	}
+}
+
+// UnmarshalJSON accepts either a duration string or a number of
+// nanoseconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return d.parse(s)
+	}
+
+	var n int64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return errors.Errorf("invalid duration %s", data)
+	}
+	*d = Duration(n)
+	return nil
+}
+
+// UnmarshalYAML accepts either a duration string or a number of
+// nanoseconds
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var n int64
+	if err := node.Decode(&n); err == nil {
+		*d = Duration(n)
+		return nil
+	}
+
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return errors.Errorf("invalid duration %q", node.Value)
+	}
+	return d.parse(s)
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// MarshalYAML writes the duration as a string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return errors.Wrapf(err, "invalid duration %q", s)
+	}
+	*d = Duration(v)
+	return nil
+}
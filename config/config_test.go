@@ -0,0 +1,189 @@
+package config_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const yamlConfig = `
+breakers:
+  payments:
+    tripper:
+      type: threshold
+      threshold: 2
+    backoff:
+      type: constant
+      interval: 10s
+    timeout: 1s
+  search:
+    tripper:
+      type: any
+      trippers:
+        - type: consecutive
+          threshold: 5
+        - type: rate
+          rate: 0.5
+          min_samples: 10
+    window_size: 100
+`
+
+const jsonConfig = `{
+  "breakers": {
+    "payments": {
+      "tripper": {"type": "threshold", "threshold": 2},
+      "backoff": {"type": "constant", "interval": "10s"},
+      "timeout": "1s"
+    },
+    "search": {
+      "tripper": {
+        "type": "any",
+        "trippers": [
+          {"type": "consecutive", "threshold": 5},
+          {"type": "rate", "rate": 0.5, "min_samples": 10}
+        ]
+      },
+      "window_size": 100
+    }
+  }
+}`
+
+func TestParse(t *testing.T) {
+	fromYAML, err := config.ParseYAML([]byte(yamlConfig))
+	if !assert.NoError(t, err, "ParseYAML should succeed") {
+		return
+	}
+	fromJSON, err := config.ParseJSON([]byte(jsonConfig))
+	if !assert.NoError(t, err, "ParseJSON should succeed") {
+		return
+	}
+	if !assert.Equal(t, fromYAML, fromJSON, "both formats should give the same configuration") {
+		return
+	}
+	if !assert.Equal(t, config.Duration(10*time.Second), fromYAML.Breakers["payments"].BackOff.Interval, "durations should be parsed") {
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "config")
+	if !assert.NoError(t, err, "TempDir should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	for name, data := range map[string]string{"breakers.yaml": yamlConfig, "breakers.json": jsonConfig} {
+		path := filepath.Join(dir, name)
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0644), "WriteFile should succeed") {
+			return
+		}
+		loaded, err := config.Load(path)
+		if !assert.NoError(t, err, "Load should succeed for %s", name) {
+			return
+		}
+		if !assert.Equal(t, fromYAML, loaded, "Load should parse %s according to its extension", name) {
+			return
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	c, err := config.ParseJSON([]byte(jsonConfig))
+	if !assert.NoError(t, err, "ParseJSON should succeed") {
+		return
+	}
+
+	data, err := json.Marshal(c)
+	if !assert.NoError(t, err, "Marshal should succeed") {
+		return
+	}
+	roundTrip, err := config.ParseJSON(data)
+	if !assert.NoError(t, err, "ParseJSON should accept its own output") {
+		return
+	}
+	if !assert.Equal(t, c, roundTrip, "configuration should survive a round trip") {
+		return
+	}
+	if !assert.Contains(t, string(data), `"interval":"10s"`, "durations should be written as strings") {
+		return
+	}
+}
+
+func TestBuild(t *testing.T) {
+	cfg, err := config.ParseYAML([]byte(yamlConfig))
+	if !assert.NoError(t, err, "ParseYAML should succeed") {
+		return
+	}
+
+	c := clock.NewMock()
+	breakers, err := cfg.Build(config.WithClock(c))
+	if !assert.NoError(t, err, "Build should succeed") {
+		return
+	}
+	if !assert.Equal(t, 2, breakers.Len(), "a breaker should be created for each entry") {
+		return
+	}
+
+	cb, ok := breakers.Get("payments")
+	if !assert.True(t, ok, "payments breaker should exist") {
+		return
+	}
+	if !assert.Equal(t, "payments", cb.Name(), "breaker should be named after its entry") {
+		return
+	}
+
+	fail := breaker.CircuitFunc(func() error { return errors.New("error") })
+	cb.Call(fail)
+	if !assert.False(t, cb.Tripped(), "breaker should not trip below the threshold") {
+		return
+	}
+	cb.Call(fail)
+	if !assert.True(t, cb.Tripped(), "breaker should trip at the threshold") {
+		return
+	}
+	if !assert.Equal(t, c.Now().Add(10*time.Second), cb.RetryAt(), "breaker should use the configured backoff") {
+		return
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	configs := map[string]string{
+		"unknown tripper":    "breakers: {a: {tripper: {type: magic}}}",
+		"missing threshold":  "breakers: {a: {tripper: {type: threshold}}}",
+		"invalid rate":       "breakers: {a: {tripper: {type: rate, rate: 2}}}",
+		"empty any":          "breakers: {a: {tripper: {type: any}}}",
+		"invalid nested":     "breakers: {a: {tripper: {type: all, trippers: [{type: magic}]}}}",
+		"unknown backoff":    "breakers: {a: {backoff: {type: magic}}}",
+		"constant interval":  "breakers: {a: {backoff: {type: constant}}}",
+		"fibonacci interval": "breakers: {a: {backoff: {type: fibonacci}}}",
+		"negative timeout":   "breakers: {a: {timeout: -1s}}",
+		"invalid multiplier": "breakers: {a: {backoff: {multiplier: 0.5}}}",
+		"negative window":    "breakers: {a: {tripper: {type: rate, rate: 0.5, window: -1s}}}",
+	}
+
+	for name, data := range configs {
+		cfg, err := config.ParseYAML([]byte(data))
+		if !assert.NoError(t, err, "ParseYAML should succeed for %s", name) {
+			return
+		}
+		breakers, err := cfg.Build()
+		if !assert.Error(t, err, "Build should fail for %s", name) {
+			return
+		}
+		if !assert.Nil(t, breakers, "no breakers should be returned for %s", name) {
+			return
+		}
+	}
+
+	_, err := config.ParseYAML([]byte("breakers: {a: {timeout: soon}}"))
+	if !assert.Error(t, err, "invalid durations should be rejected") {
+		return
+	}
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// Tripper types understood by TripperConfig
+const (
+	ThresholdTripper   = "threshold"
+	ConsecutiveTripper = "consecutive"
+	DecayingTripper    = "decaying"
+	RateTripper        = "rate"
+	AnyTripper         = "any"
+	AllTripper         = "all"
+)
+
+// BackOff types understood by BackOffConfig
+const (
+	ExponentialBackOff = "exponential"
+	ConstantBackOff    = "constant"
+	FibonacciBackOff   = "fibonacci"
+)
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*buildConfig)
+}
+
+type optionFunc func(*buildConfig)
+
+// buildConfig holds the values given by the options
+type buildConfig struct {
+	clock  breaker.Clock
+	common []breaker.BreakerOption
+}
+
+// Duration is a time.Duration that is written as a string such as
+// "1.5s" or "300ms" in configuration files. Plain numbers are
+// interpreted as nanoseconds, like time.Duration.
+type Duration time.Duration
+
+// Config describes a set of breakers, keyed by name
+type Config struct {
+	Breakers map[string]BreakerConfig `json:"breakers" yaml:"breakers"`
+}
+
+// BreakerConfig describes a single breaker. Zero values mean that the
+// default of the breaker package is used.
+type BreakerConfig struct {
+	// Tripper specifies when the breaker trips. Breakers without a
+	// tripper can only be tripped manually.
+	Tripper *TripperConfig `json:"tripper,omitempty" yaml:"tripper,omitempty"`
+
+	// BackOff specifies how long the breaker stays open before
+	// retrying
+	BackOff *BackOffConfig `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+
+	// Jitter is the fraction of the backoff interval by which the
+	// time of the next probe is moved randomly
+	Jitter float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+
+	// Timeout is the default timeout of calls made through the breaker
+	Timeout Duration `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+
+	// WindowSize makes the breaker count the last WindowSize calls
+	// instead of the calls made within a time window
+	WindowSize int `json:"window_size,omitempty" yaml:"window_size,omitempty"`
+
+	// WindowTime and WindowBuckets specify the time window over which
+	// calls are counted, and the number of buckets it is divided into
+	WindowTime    Duration `json:"window_time,omitempty" yaml:"window_time,omitempty"`
+	WindowBuckets int      `json:"window_buckets,omitempty" yaml:"window_buckets,omitempty"`
+
+	// Warmup is the period of time after the creation of the breaker
+	// during which it does not trip
+	Warmup Duration `json:"warmup,omitempty" yaml:"warmup,omitempty"`
+
+	// MinSamples is the number of calls that must be counted before
+	// the tripper is consulted
+	MinSamples int64 `json:"min_samples,omitempty" yaml:"min_samples,omitempty"`
+
+	// HalfOpenRequests is the number of probes allowed while the
+	// breaker is half-open
+	HalfOpenRequests int `json:"half_open_requests,omitempty" yaml:"half_open_requests,omitempty"`
+
+	// ProbeWait is how long calls rejected while a half-open probe is
+	// running wait for its outcome
+	ProbeWait Duration `json:"probe_wait,omitempty" yaml:"probe_wait,omitempty"`
+
+	// QueueSize and QueueWait make up to QueueSize calls wait up to
+	// QueueWait for the breaker to let calls through, instead of being
+	// rejected while it is open
+	QueueSize int      `json:"queue_size,omitempty" yaml:"queue_size,omitempty"`
+	QueueWait Duration `json:"queue_wait,omitempty" yaml:"queue_wait,omitempty"`
+
+	// SuccessThreshold is the number of successful probes needed to
+	// close the breaker
+	SuccessThreshold int `json:"success_threshold,omitempty" yaml:"success_threshold,omitempty"`
+
+	// MaxConcurrent limits the number of calls running at once
+	MaxConcurrent int `json:"max_concurrent,omitempty" yaml:"max_concurrent,omitempty"`
+
+	// GradualRecovery is the duration over which traffic is ramped
+	// up after the breaker closes
+	GradualRecovery Duration `json:"gradual_recovery,omitempty" yaml:"gradual_recovery,omitempty"`
+}
+
+// TripperConfig describes a Tripper. Type is one of "threshold",
+// "consecutive", "decaying", "rate", "any" or "all", and determines
+// which of the other fields are used.
+type TripperConfig struct {
+	Type string `json:"type" yaml:"type"`
+
+	// Threshold is used by "threshold", "consecutive" and "decaying"
+	Threshold int64 `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+
+	// HalfLife is used by "decaying"
+	HalfLife Duration `json:"half_life,omitempty" yaml:"half_life,omitempty"`
+
+	// Rate, MinSamples and Window are used by "rate". If Window is
+	// set, the rate is calculated over that period of time instead
+	// of the whole window of the breaker
+	Rate       float64  `json:"rate,omitempty" yaml:"rate,omitempty"`
+	MinSamples int64    `json:"min_samples,omitempty" yaml:"min_samples,omitempty"`
+	Window     Duration `json:"window,omitempty" yaml:"window,omitempty"`
+
+	// Trippers are combined by "any" and "all"
+	Trippers []TripperConfig `json:"trippers,omitempty" yaml:"trippers,omitempty"`
+}
+
+// BackOffConfig describes a backoff policy. Type is one of
+// "exponential" (the default), "constant" or "fibonacci".
+type BackOffConfig struct {
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+
+	// Interval is used by "constant"
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+
+	// InitialInterval and MaxInterval are used by "exponential" and
+	// "fibonacci", the remaining fields by "exponential" only
+	InitialInterval     Duration `json:"initial_interval,omitempty" yaml:"initial_interval,omitempty"`
+	MaxInterval         Duration `json:"max_interval,omitempty" yaml:"max_interval,omitempty"`
+	MaxElapsedTime      Duration `json:"max_elapsed_time,omitempty" yaml:"max_elapsed_time,omitempty"`
+	Multiplier          float64  `json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	RandomizationFactor *float64 `json:"randomization_factor,omitempty" yaml:"randomization_factor,omitempty"`
+}
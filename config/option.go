@@ -0,0 +1,39 @@
+package config
+
+import (
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(c *buildConfig) {
+	f(c)
+}
+
+// newBuildConfig applies the options over the defaults
+func newBuildConfig(options []Option) *buildConfig {
+	c := &buildConfig{}
+	for _, option := range options {
+		option.apply(c)
+	}
+	if c.clock == nil {
+		c.clock = breaker.SystemClock
+	}
+	return c
+}
+
+// WithClock is used to specify the clock used by the breakers and
+// their backoff policies. Normally, this is only used for testing
+func WithClock(v breaker.Clock) Option {
+	return optionFunc(func(c *buildConfig) {
+		c.clock = v
+	})
+}
+
+// WithBreakerOptions is used to specify options that are passed to
+// every breaker, before those derived from the configuration. This
+// is how things that can't be written in a file, such as storages
+// or error classifiers, are given to the breakers.
+func WithBreakerOptions(v ...breaker.BreakerOption) Option {
+	return optionFunc(func(c *buildConfig) {
+		c.common = append(c.common, v...)
+	})
+}
@@ -0,0 +1,89 @@
+package distributed
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// NewLocalBackend creates a Backend that exchanges messages within
+// the same process. It is mostly useful for testing
+func NewLocalBackend() Backend {
+	return &localBackend{
+		subscribers: make(map[chan *Message]struct{}),
+	}
+}
+
+func (b *localBackend) Publish(_ context.Context, msg *Message) error {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+func (b *localBackend) Subscribe(ctx context.Context) (<-chan *Message, error) {
+	ch := make(chan *Message, 64)
+	b.mutex.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mutex.Lock()
+		delete(b.subscribers, ch)
+		b.mutex.Unlock()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// NewRedisBackend creates a Backend that exchanges messages using
+// Redis pub/sub on the given channel
+func NewRedisBackend(client RedisPubSub, channel string) Backend {
+	return &redisBackend{
+		channel: channel,
+		client:  client,
+	}
+}
+
+func (b *redisBackend) Publish(ctx context.Context, msg *Message) error {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode message")
+	}
+	if err := b.client.Publish(ctx, b.channel, string(buf)); err != nil {
+		return errors.Wrap(err, "failed to publish message")
+	}
+	return nil
+}
+
+func (b *redisBackend) Subscribe(ctx context.Context) (<-chan *Message, error) {
+	in, err := b.client.Subscribe(ctx, b.channel)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to subscribe")
+	}
+
+	out := make(chan *Message, 64)
+	go func() {
+		defer close(out)
+		for v := range in {
+			var msg Message
+			if err := json.Unmarshal([]byte(v), &msg); err != nil {
+				// Not one of ours
+				continue
+			}
+			select {
+			case out <- &msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,238 @@
+// Package distributed shares the trip and reset decisions of breakers
+// between multiple instances of a service. When a breaker trips on one
+// instance, the breakers of the same name on the other instances are
+// tripped as well, so that every instance stops calling the failing
+// backend.
+//
+//	c := distributed.New(distributed.NewRedisBackend(client, "breakers"))
+//	go c.Run(ctx)
+//
+//	cb := c.Wrap("example.com", breaker.New(...))
+//
+// Messages are exchanged via a Backend. Conflicting changes are resolved
+// by keeping the most recent one, so the clocks of the instances should
+// be reasonably in sync.
+package distributed
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	pdebug "github.com/lestrrat/go-pdebug"
+	"github.com/pkg/errors"
+)
+
+// New creates a new Coordinator that exchanges messages via the
+// given backend. Run() must be called for messages to be exchanged
+func New(backend Backend, options ...Option) *Coordinator {
+	c := &Coordinator{
+		backend:  backend,
+		breakers: make(map[string]*sharedBreaker),
+		clock:    breaker.SystemClock,
+		delay:    DefaultPropagationDelay,
+		outgoing: make(chan *Message, 64),
+	}
+	for _, option := range options {
+		option.apply(c)
+	}
+
+	if c.id == "" {
+		c.id = randomID()
+	}
+	return c
+}
+
+func randomID() string {
+	var buf [8]byte
+	rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
+
+// ID returns the ID of this instance
+func (c *Coordinator) ID() string {
+	return c.id
+}
+
+// Wrap registers the breaker under the given name, and returns a
+// breaker whose trips and resets are shared with the peers. Breakers
+// on different instances are matched by name
+func (c *Coordinator) Wrap(name string, cb breaker.Breaker) breaker.Breaker {
+	b := &sharedBreaker{
+		Breaker:     cb,
+		coordinator: c,
+		name:        name,
+	}
+
+	c.mutex.Lock()
+	c.breakers[name] = b
+	c.mutex.Unlock()
+	return b
+}
+
+// Run exchanges messages with the peers until the context is done
+func (c *Coordinator) Run(ctx context.Context) error {
+	incoming, err := c.backend.Subscribe(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to subscribe")
+	}
+
+	republish := breaker.NewTicker(c.clock, c.delay)
+	defer republish.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-incoming:
+			if !ok {
+				return errors.New("subscription closed")
+			}
+			c.apply(msg)
+		case msg := <-c.outgoing:
+			c.publish(ctx, msg)
+		case <-republish.C():
+			c.republish(ctx)
+		}
+	}
+}
+
+func (c *Coordinator) publish(ctx context.Context, msg *Message) {
+	if err := c.backend.Publish(ctx, msg); err != nil {
+		if pdebug.Enabled {
+			pdebug.Printf("failed to publish message: %s", err)
+		}
+	}
+}
+
+// republish publishes the last change of each breaker that was made
+// by this instance, for the benefit of peers that missed it
+func (c *Coordinator) republish(ctx context.Context) {
+	var list []Message
+	c.mutex.RLock()
+	for _, b := range c.breakers {
+		b.mutex.Lock()
+		if b.last.Origin == c.id {
+			list = append(list, b.last)
+		}
+		b.mutex.Unlock()
+	}
+	c.mutex.RUnlock()
+
+	for i := range list {
+		c.publish(ctx, &list[i])
+	}
+}
+
+// apply applies a message received from a peer to the local breaker
+func (c *Coordinator) apply(msg *Message) {
+	if msg.Origin == c.id {
+		return
+	}
+
+	c.mutex.RLock()
+	b, ok := c.breakers[msg.Name]
+	c.mutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	b.mutex.Lock()
+	if !msg.Time.After(b.last.Time) {
+		b.mutex.Unlock()
+		return
+	}
+	b.last = *msg
+	b.mutex.Unlock()
+
+	// Use the wrapped breaker directly, so that the change is
+	// not published again
+	switch tripped := b.Breaker.Tripped(); {
+	case msg.Tripped && !tripped:
+		b.Breaker.Trip()
+	case !msg.Tripped && tripped:
+		b.Breaker.Reset()
+	}
+}
+
+func (b *sharedBreaker) Allow() (func(bool), error) {
+	done, err := b.Breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	return func(success bool) {
+		before := b.Breaker.Tripped()
+		done(success)
+		b.transition(before, b.Breaker.Tripped())
+	}, nil
+}
+
+func (b *sharedBreaker) Break() {
+	before := b.Breaker.Tripped()
+	b.Breaker.Break()
+	b.transition(before, b.Breaker.Tripped())
+}
+
+func (b *sharedBreaker) Call(c breaker.Circuit, options ...breaker.CallOption) error {
+	before := b.Breaker.Tripped()
+	err := b.Breaker.Call(c, options...)
+	b.transition(before, b.Breaker.Tripped())
+	return err
+}
+
+func (b *sharedBreaker) MarkFailure(err error) {
+	before := b.Breaker.Tripped()
+	b.Breaker.MarkFailure(err)
+	b.transition(before, b.Breaker.Tripped())
+}
+
+func (b *sharedBreaker) MarkSuccess() {
+	before := b.Breaker.Tripped()
+	b.Breaker.MarkSuccess()
+	b.transition(before, b.Breaker.Tripped())
+}
+
+func (b *sharedBreaker) Reset() {
+	before := b.Breaker.Tripped()
+	b.Breaker.Reset()
+	b.transition(before, b.Breaker.Tripped())
+}
+
+func (b *sharedBreaker) Trip() {
+	before := b.Breaker.Tripped()
+	b.Breaker.Trip()
+	b.transition(before, b.Breaker.Tripped())
+}
+
+func (b *sharedBreaker) TripUntil(t time.Time) {
+	before := b.Breaker.Tripped()
+	b.Breaker.TripUntil(t)
+	b.transition(before, b.Breaker.Tripped())
+}
+
+// transition queues a message for the peers if the breaker
+// tripped or reset
+func (b *sharedBreaker) transition(before, after bool) {
+	if before == after {
+		return
+	}
+
+	c := b.coordinator
+	msg := Message{
+		Origin:  c.id,
+		Name:    b.name,
+		Tripped: after,
+		Time:    c.clock.Now(),
+	}
+	b.mutex.Lock()
+	b.last = msg
+	b.mutex.Unlock()
+
+	// If the queue is full, the change is published on the
+	// next round of re-publishing
+	select {
+	case c.outgoing <- &msg:
+	default:
+	}
+}
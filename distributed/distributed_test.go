@@ -0,0 +1,98 @@
+package distributed_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/distributed"
+	"github.com/stretchr/testify/assert"
+)
+
+func eventually(cond func() bool) bool {
+	timeout := time.After(5 * time.Second)
+	for !cond() {
+		select {
+		case <-timeout:
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+func TestCoordinator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := distributed.NewLocalBackend()
+	c1 := distributed.New(backend, distributed.WithInstanceID("one"))
+	c2 := distributed.New(backend, distributed.WithInstanceID("two"))
+	go c1.Run(ctx)
+	go c2.Run(ctx)
+
+	cb1 := c1.Wrap("example.com", breaker.New())
+	cb2 := c2.Wrap("example.com", breaker.New())
+	other := c2.Wrap("example.org", breaker.New())
+
+	// Wait for both coordinators to subscribe
+	time.Sleep(100 * time.Millisecond)
+
+	cb1.Trip()
+	if !assert.True(t, eventually(cb2.Tripped), "peer breaker should be tripped") {
+		return
+	}
+	if !assert.False(t, other.Tripped(), "breakers with other names should not be affected") {
+		return
+	}
+
+	cb2.Reset()
+	if !assert.True(t, eventually(func() bool { return !cb1.Tripped() }), "peer breaker should be reset") {
+		return
+	}
+
+	// Trips caused by reported outcomes are published too
+	cb3 := c1.Wrap("example.net", breaker.New(breaker.WithTripper(breaker.ConsecutiveTripper(2))))
+	cb4 := c2.Wrap("example.net", breaker.New())
+	cb3.MarkFailure(nil)
+	cb3.MarkFailure(nil)
+	if !assert.True(t, cb3.Tripped(), "reported failures should trip the breaker") {
+		return
+	}
+	if !assert.True(t, eventually(cb4.Tripped), "peer breaker should be tripped by reported failures") {
+		return
+	}
+
+	cb3.TripUntil(time.Now().Add(time.Hour))
+	cb4.Reset()
+	if !assert.True(t, eventually(func() bool { return !cb3.Tripped() }), "peer breaker should be reset") {
+		return
+	}
+	cb3.TripUntil(time.Now().Add(time.Hour))
+	if !assert.True(t, eventually(cb4.Tripped), "peer breaker should be tripped by TripUntil") {
+		return
+	}
+}
+
+func TestCoordinatorRepublish(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	backend := distributed.NewLocalBackend()
+	c1 := distributed.New(backend, distributed.WithPropagationDelay(50*time.Millisecond))
+	c2 := distributed.New(backend, distributed.WithPropagationDelay(50*time.Millisecond))
+	cb1 := c1.Wrap("example.com", breaker.New())
+	cb2 := c2.Wrap("example.com", breaker.New())
+
+	go c1.Run(ctx)
+	time.Sleep(50 * time.Millisecond)
+	cb1.Trip()
+
+	// The second instance starts after the trip was published, and
+	// learns about it when the state is published again
+	go c2.Run(ctx)
+	if !assert.True(t, eventually(cb2.Tripped), "late peer should learn about the trip") {
+		return
+	}
+}
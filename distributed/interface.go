@@ -0,0 +1,85 @@
+package distributed
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// DefaultPropagationDelay is the default interval at which each
+// instance re-publishes the state of its breakers
+const DefaultPropagationDelay = 5 * time.Second
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*Coordinator)
+}
+
+type optionFunc func(*Coordinator)
+
+// Message is published to the peers whenever a breaker trips or resets
+type Message struct {
+	// Origin is the ID of the instance where the change happened
+	Origin string `json:"origin"`
+	// Name is the name of the breaker
+	Name string `json:"name"`
+	// Tripped is true if the breaker tripped, false if it reset
+	Tripped bool `json:"tripped"`
+	// Time is the time when the change happened
+	Time time.Time `json:"time"`
+}
+
+// Backend is used to exchange messages between the instances
+type Backend interface {
+	// Publish sends the message to all subscribers, including
+	// the sender itself
+	Publish(context.Context, *Message) error
+
+	// Subscribe starts receiving messages. The channel should be
+	// closed once the context is done
+	Subscribe(context.Context) (<-chan *Message, error)
+}
+
+// RedisPubSub is the subset of a Redis client used by the Redis
+// backend. It is kept small so that any client library can be adapted
+// with a few lines of code.
+type RedisPubSub interface {
+	// Publish publishes the message on the channel
+	Publish(ctx context.Context, channel string, message string) error
+
+	// Subscribe starts receiving messages published on the channel.
+	// The returned channel should be closed once the context is done
+	Subscribe(ctx context.Context, channel string) (<-chan string, error)
+}
+
+// Coordinator shares the trip and reset decisions of its breakers
+// with the breakers of the same names on other instances
+type Coordinator struct {
+	backend  Backend
+	breakers map[string]*sharedBreaker
+	clock    breaker.Clock
+	delay    time.Duration
+	id       string
+	mutex    sync.RWMutex
+	outgoing chan *Message
+}
+
+type sharedBreaker struct {
+	breaker.Breaker
+	coordinator *Coordinator
+	last        Message
+	mutex       sync.Mutex
+	name        string
+}
+
+type localBackend struct {
+	mutex       sync.RWMutex
+	subscribers map[chan *Message]struct{}
+}
+
+type redisBackend struct {
+	channel string
+	client  RedisPubSub
+}
@@ -0,0 +1,37 @@
+package distributed
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(c *Coordinator) {
+	f(c)
+}
+
+// WithClock is used to specify the clock used to timestamp messages
+// and to schedule re-publishing. Normally, this is only used for testing
+func WithClock(v breaker.Clock) Option {
+	return optionFunc(func(c *Coordinator) {
+		c.clock = v
+	})
+}
+
+// WithInstanceID is used to specify the ID of this instance, which is
+// used to ignore messages sent by itself. By default a random ID is used.
+func WithInstanceID(v string) Option {
+	return optionFunc(func(c *Coordinator) {
+		c.id = v
+	})
+}
+
+// WithPropagationDelay is used to specify the interval at which the
+// state of the breakers is re-published. Peers that missed a message
+// learn about the change within this delay.
+// The default is DefaultPropagationDelay
+func WithPropagationDelay(v time.Duration) Option {
+	return optionFunc(func(c *Coordinator) {
+		c.delay = v
+	})
+}
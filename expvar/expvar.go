@@ -0,0 +1,61 @@
+// Package expvar publishes circuit breaker states and counters
+// using the standard expvar package.
+package expvar
+
+import (
+	"expvar"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// NewPublisher creates a new Publisher, where breakers are published
+// in a map under the given prefix. If prefix is empty, DefaultPrefix
+// is used. Creating multiple Publishers with the same prefix is allowed,
+// in which case they share the same underlying map.
+func NewPublisher(prefix string) *Publisher {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	var vars *expvar.Map
+	if v, ok := expvar.Get(prefix).(*expvar.Map); ok {
+		vars = v
+	} else {
+		vars = expvar.NewMap(prefix)
+	}
+
+	return &Publisher{
+		vars: vars,
+	}
+}
+
+// Publish publishes the given breaker under the given name. The values
+// are computed every time the variable is read.
+func (p *Publisher) Publish(name string, cb breaker.Breaker) {
+	p.vars.Set(name, expvar.Func(func() interface{} {
+		return NewStats(cb)
+	}))
+}
+
+// Unpublish removes the breaker published under the given name
+func (p *Publisher) Unpublish(name string) {
+	p.vars.Delete(name)
+}
+
+// NewStats creates a Stats object from the current values in
+// the given breaker
+func NewStats(cb breaker.Breaker) Stats {
+	s := cb.Snapshot()
+	return Stats{
+		State:          s.State.String(),
+		Labels:         cb.Labels(),
+		Tripped:        s.State != breaker.Closed,
+		Failures:       s.Failures,
+		Successes:      s.Successes,
+		ConsecFailures: s.ConsecFailures,
+		ErrorRate:      s.ErrorRate,
+		Trips:          s.Trips,
+		OpenTime:       s.OpenTime.Seconds(),
+		LongestOpen:    s.LongestOpen.Seconds(),
+	}
+}
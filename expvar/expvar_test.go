@@ -0,0 +1,47 @@
+package expvar_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	bexpvar "github.com/lestrrat/go-circuit-breaker/expvar"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublish(t *testing.T) {
+	cb := breaker.New(breaker.WithLabels(map[string]string{"region": "eu"}))
+	p := bexpvar.NewPublisher("test_breakers")
+	p.Publish("example.com", cb)
+
+	cb.Trip()
+
+	v := expvar.Get("test_breakers")
+	if !assert.NotNil(t, v, "map should be published") {
+		return
+	}
+
+	var data map[string]bexpvar.Stats
+	if !assert.NoError(t, json.Unmarshal([]byte(v.String()), &data), "json.Unmarshal should succeed") {
+		return
+	}
+
+	stats, ok := data["example.com"]
+	if !assert.True(t, ok, "breaker should be published") {
+		return
+	}
+	if !assert.True(t, stats.Tripped, "published breaker should be tripped") {
+		return
+	}
+	if !assert.Equal(t, map[string]string{"region": "eu"}, stats.Labels, "labels should be published") {
+		return
+	}
+
+	// Same prefix should re-use the existing map
+	p2 := bexpvar.NewPublisher("test_breakers")
+	p2.Unpublish("example.com")
+	if !assert.Equal(t, "{}", v.String(), "breaker should be unpublished") {
+		return
+	}
+}
@@ -0,0 +1,29 @@
+package expvar
+
+import (
+	"expvar"
+)
+
+// DefaultPrefix is the name used to publish breakers when no prefix
+// is specified
+const DefaultPrefix = "breakers"
+
+// Publisher publishes breaker states and counters via the expvar
+// package, so that they can be inspected from /debug/vars
+type Publisher struct {
+	vars *expvar.Map
+}
+
+// Stats is the set of values published for each breaker
+type Stats struct {
+	State          string            `json:"state"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Tripped        bool              `json:"tripped"`
+	Failures       int64             `json:"failures"`
+	Successes      int64             `json:"successes"`
+	ConsecFailures int64             `json:"consecutive_failures"`
+	ErrorRate      float64           `json:"error_rate"`
+	Trips          int64             `json:"trips"`
+	OpenTime       float64           `json:"open_time_seconds"`
+	LongestOpen    float64           `json:"longest_open_seconds"`
+}
@@ -0,0 +1,58 @@
+// Package fbclock adapts clocks from github.com/facebookgo/clock, such
+// as clock.Mock, to breaker.TimerClock, so that timers and tickers
+// used by breakers are controlled by the clock.
+//
+//	c := clock.NewMock()
+//	cb := breaker.New(breaker.WithClock(fbclock.New(c)))
+package fbclock
+
+import (
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// Clock is a breaker.TimerClock that wraps a facebookgo clock
+type Clock struct {
+	clock.Clock
+}
+
+type timer struct {
+	timer *clock.Timer
+}
+
+type ticker struct {
+	ticker *clock.Ticker
+}
+
+// New wraps c in a breaker.TimerClock
+func New(c clock.Clock) *Clock {
+	return &Clock{Clock: c}
+}
+
+// NewTimer creates a timer using the Timer method of the clock
+func (c *Clock) NewTimer(d time.Duration) breaker.Timer {
+	return &timer{timer: c.Clock.Timer(d)}
+}
+
+// NewTicker creates a ticker using the Ticker method of the clock
+func (c *Clock) NewTicker(d time.Duration) breaker.Ticker {
+	return &ticker{ticker: c.Clock.Ticker(d)}
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t *timer) Stop() {
+	t.timer.Stop()
+}
+
+func (t *ticker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t *ticker) Stop() {
+	t.ticker.Stop()
+}
@@ -0,0 +1,65 @@
+package fbclock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/fbclock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	c := clock.NewMock()
+	var tc breaker.TimerClock = fbclock.New(c)
+
+	// The mock clock blocks until timers are received, so receive
+	// in the background
+	timer := breaker.NewTimer(tc, time.Second)
+	fired := make(chan struct{})
+	go func() {
+		<-timer.C()
+		close(fired)
+	}()
+
+	c.Add(500 * time.Millisecond)
+	select {
+	case <-fired:
+		assert.Fail(t, "timer should not fire early")
+		return
+	default:
+	}
+
+	c.Add(500 * time.Millisecond)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		assert.Fail(t, "timer should fire once the clock advances")
+		return
+	}
+
+	ticker := breaker.NewTicker(tc, time.Second)
+	ticks := make(chan time.Time, 10)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C():
+				ticks <- now
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for len(ticks) < 2 && time.Now().Before(deadline) {
+		c.Add(time.Second)
+	}
+	ticker.Stop()
+	if !assert.True(t, len(ticks) >= 2, "ticker should tick as the clock advances") {
+		return
+	}
+}
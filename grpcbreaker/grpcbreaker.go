@@ -0,0 +1,162 @@
+// Package grpcbreaker provides gRPC client interceptors that protect
+// RPCs using circuit breakers, and server interceptors that protect
+// servers from overload.
+package grpcbreaker
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// DefaultFailureCodes is the list of gRPC status codes that are
+// recorded as failures by default. These codes indicate that the
+// server (or the network) is in trouble, as opposed to codes such
+// as NotFound or InvalidArgument that are part of the normal
+// application logic.
+var DefaultFailureCodes = []codes.Code{
+	codes.DataLoss,
+	codes.DeadlineExceeded,
+	codes.Internal,
+	codes.ResourceExhausted,
+	codes.Unavailable,
+	codes.Unknown,
+}
+
+func newInterceptor(l BreakerLookupper, options ...Option) *interceptor {
+	cfg := config{failureCodes: DefaultFailureCodes}
+	for _, option := range options {
+		option.apply(&cfg)
+	}
+
+	i := &interceptor{
+		failureCodes:     make(map[codes.Code]struct{}),
+		latencyThreshold: cfg.latencyThreshold,
+		lookup:           l,
+	}
+	for _, code := range cfg.failureCodes {
+		i.failureCodes[code] = struct{}{}
+	}
+	return i
+}
+
+// UnaryClientInterceptor creates a grpc.UnaryClientInterceptor where
+// RPCs are controlled via the provided circuit breaker(s). The mandatory
+// argument `l` is an object that provides the breaker to be used for
+// the given RPC. It receives a *CallInfo as its argument.
+//
+// When the breaker is open, the RPC is not invoked and an error with
+// the codes.Unavailable status code is returned. breaker.IsOpen can
+// be used to distinguish this error from errors returned by the server.
+// The RPC is invoked with the context of the call, so that it is
+// canceled when the call times out.
+//
+// Possible optional parameters:
+// * WithFailureCodes: specify the status codes that are recorded as failures
+func UnaryClientInterceptor(l BreakerLookupper, options ...Option) grpc.UnaryClientInterceptor {
+	i := newInterceptor(l, options...)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		cb := i.breakerLookup(method, cc)
+		if cb == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		var invokeErr error
+		err := cb.Call(breaker.CircuitContextFunc(func(ctx context.Context) error {
+			invokeErr = invoker(ctx, method, req, reply, cc, opts...)
+			return i.failure(invokeErr)
+		}), breaker.WithContext(ctx))
+		if err != nil {
+			return i.callError(err)
+		}
+		return invokeErr
+	}
+}
+
+// StreamClientInterceptor creates a grpc.StreamClientInterceptor where
+// streams are controlled via the provided circuit breaker(s). Only the
+// establishment of the stream is protected by the breaker: errors that
+// occur while sending or receiving messages are not recorded. The
+// timeout of the breaker does not apply, as the stream outlives the
+// call, so that the establishment of the stream is only limited by the
+// deadline of the context.
+//
+// See UnaryClientInterceptor for details on the arguments
+func StreamClientInterceptor(l BreakerLookupper, options ...Option) grpc.StreamClientInterceptor {
+	i := newInterceptor(l, options...)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cb := i.breakerLookup(method, cc)
+		if cb == nil {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		var stream grpc.ClientStream
+		var streamErr error
+		err := cb.Call(breaker.CircuitFunc(func() error {
+			stream, streamErr = streamer(ctx, desc, cc, method, opts...)
+			return i.failure(streamErr)
+		}), breaker.WithContext(ctx), breaker.WithTimeout(0))
+		if err != nil {
+			return nil, i.callError(err)
+		}
+		return stream, streamErr
+	}
+}
+
+func (i *interceptor) breakerLookup(method string, cc *grpc.ClientConn) breaker.Breaker {
+	info := CallInfo{Method: method}
+	if cc != nil {
+		info.Target = cc.Target()
+	}
+	return i.lookup.BreakerLookup(&info)
+}
+
+// failure returns the error if it should be recorded as a failure,
+// or nil otherwise
+func (i *interceptor) failure(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := i.failureCodes[status.Code(err)]; !ok {
+		return nil
+	}
+	return err
+}
+
+// callError converts errors from the breaker into gRPC errors
+func (i *interceptor) callError(err error) error {
+	if breaker.IsOpen(err) {
+		return &openError{code: codes.Unavailable, err: err}
+	}
+	if breaker.IsTimeout(err) {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return err
+}
+
+func (e *openError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the underlying breaker error, so that breaker.IsOpen
+// can be used with this error
+func (e *openError) Cause() error {
+	return e.err
+}
+
+// Unwrap returns the underlying breaker error, so that errors.Is can
+// be used with this error
+func (e *openError) Unwrap() error {
+	return e.err
+}
+
+// GRPCStatus returns the gRPC status for this error, so that
+// status.Code returns codes.Unavailable (or codes.ResourceExhausted
+// for server interceptors) for this error
+func (e *openError) GRPCStatus() *status.Status {
+	return status.New(e.code, e.err.Error())
+}
@@ -0,0 +1,269 @@
+package grpcbreaker_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/grpcbreaker"
+)
+
+const testMethod = "/test.Service/Method"
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	cb := breaker.New(
+		breaker.WithClock(clock.NewMock()),
+		breaker.WithTripper(breaker.ConsecutiveTripper(2)),
+	)
+	m := breaker.NewMap()
+	m.Set(testMethod, cb)
+
+	interceptor := grpcbreaker.UnaryClientInterceptor(grpcbreaker.NewPerMethodLookup(m))
+
+	var code codes.Code
+	invoked := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked++
+		return status.Error(code, "error")
+	}
+	invoke := func() error {
+		return interceptor(context.Background(), testMethod, nil, nil, nil, invoker)
+	}
+
+	code = codes.NotFound
+	for i := 0; i < 3; i++ {
+		err := invoke()
+		if !assert.Equal(t, codes.NotFound, status.Code(err), "server error should be returned") {
+			return
+		}
+	}
+	if !assert.False(t, cb.Tripped(), "NotFound should not trip the breaker") {
+		return
+	}
+
+	code = codes.Unavailable
+	for i := 0; i < 2; i++ {
+		invoke()
+	}
+	if !assert.True(t, cb.Tripped(), "Unavailable should trip the breaker") {
+		return
+	}
+
+	invoked = 0
+	err := invoke()
+	if !assert.Equal(t, 0, invoked, "invoker should not be called when breaker is open") {
+		return
+	}
+	if !assert.True(t, breaker.IsOpen(err), "error should be an open error") {
+		return
+	}
+	if !assert.Equal(t, codes.Unavailable, status.Code(err), "error should be a gRPC error") {
+		return
+	}
+
+	// The RPC is canceled when the call times out
+	slow := breaker.New(breaker.WithTimeout(time.Millisecond))
+	m.Set(testMethod, slow)
+	canceled := make(chan struct{})
+	err = interceptor(context.Background(), testMethod, nil, nil, nil, func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	})
+	if !assert.Equal(t, codes.DeadlineExceeded, status.Code(err), "timeouts should be returned as gRPC errors") {
+		return
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Errorf("the RPC should be canceled when the call times out")
+		return
+	}
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	cb := breaker.New(
+		breaker.WithClock(clock.NewMock()),
+		breaker.WithTripper(breaker.ConsecutiveTripper(1)),
+	)
+	m := breaker.NewMap()
+	m.Set(testMethod, cb)
+
+	interceptor := grpcbreaker.StreamClientInterceptor(
+		grpcbreaker.NewPerMethodLookup(m),
+		grpcbreaker.WithFailureCodes(codes.Internal),
+	)
+
+	var code codes.Code
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, status.Error(code, "error")
+	}
+	stream := func() error {
+		_, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, testMethod, streamer)
+		return err
+	}
+
+	code = codes.Unavailable
+	stream()
+	if !assert.False(t, cb.Tripped(), "Unavailable should not trip the breaker") {
+		return
+	}
+
+	code = codes.Internal
+	stream()
+	if !assert.True(t, cb.Tripped(), "Internal should trip the breaker") {
+		return
+	}
+	if !assert.True(t, breaker.IsOpen(stream()), "error should be an open error") {
+		return
+	}
+
+	// The stream is not canceled by the timeout of the breaker once
+	// the call returns
+	m.Set(testMethod, breaker.New(breaker.WithTimeout(time.Millisecond)))
+	var streamCtx context.Context
+	_, err := interceptor(context.Background(), &grpc.StreamDesc{}, nil, testMethod, func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		time.Sleep(10 * time.Millisecond)
+		streamCtx = ctx
+		return nil, nil
+	})
+	if !assert.NoError(t, err, "the breaker timeout should not apply to streams") {
+		return
+	}
+	if !assert.NoError(t, streamCtx.Err(), "the stream should not be canceled") {
+		return
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	m := breaker.NewMap()
+	lookup := grpcbreaker.NewPerMethodLookup(m, grpcbreaker.WithBreakerFactory(func() breaker.Breaker {
+		return breaker.New(
+			breaker.WithClock(clock.NewMock()),
+			breaker.WithTripper(breaker.ConsecutiveTripper(2)),
+		)
+	}))
+	interceptor := grpcbreaker.UnaryServerInterceptor(lookup, grpcbreaker.WithLatencyThreshold(5*time.Millisecond))
+
+	var handlerErr error
+	var delay time.Duration
+	handled := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handled++
+		time.Sleep(delay)
+		return "reply", handlerErr
+	}
+	handle := func(method string) (interface{}, error) {
+		return interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	}
+
+	handlerErr = status.Error(codes.Internal, "dependency failed")
+	for i := 0; i < 2; i++ {
+		_, err := handle(testMethod)
+		if !assert.Equal(t, codes.Internal, status.Code(err), "handler error should be returned") {
+			return
+		}
+	}
+	cb, ok := m.Get(testMethod)
+	if !assert.True(t, ok, "breaker should be created for the method") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "handler errors should trip the breaker") {
+		return
+	}
+
+	handled = 0
+	_, err := handle(testMethod)
+	if !assert.Equal(t, 0, handled, "handler should not be called when breaker is open") {
+		return
+	}
+	if !assert.Equal(t, codes.ResourceExhausted, status.Code(err), "error should be RESOURCE_EXHAUSTED") {
+		return
+	}
+	if !assert.True(t, breaker.IsOpen(err), "error should be an open error") {
+		return
+	}
+
+	const slowMethod = "/test.Service/Slow"
+	handlerErr = nil
+	delay = 10 * time.Millisecond
+	for i := 0; i < 2; i++ {
+		res, err := handle(slowMethod)
+		if !assert.NoError(t, err, "slow handlers should succeed") || !assert.Equal(t, "reply", res, "reply should be returned") {
+			return
+		}
+	}
+	cb, _ = m.Get(slowMethod)
+	if !assert.True(t, cb.Tripped(), "slow handlers should trip the breaker") {
+		return
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	cb := breaker.New(
+		breaker.WithClock(clock.NewMock()),
+		breaker.WithTripper(breaker.ConsecutiveTripper(1)),
+	)
+	m := breaker.NewMap()
+	m.Set(testMethod, cb)
+	interceptor := grpcbreaker.StreamServerInterceptor(grpcbreaker.NewPerMethodLookup(m))
+
+	var code codes.Code
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		return status.Error(code, "error")
+	}
+	stream := func() error {
+		return interceptor(nil, nil, &grpc.StreamServerInfo{FullMethod: testMethod}, handler)
+	}
+
+	code = codes.NotFound
+	if !assert.Equal(t, codes.NotFound, status.Code(stream()), "handler error should be returned") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "NotFound should not trip the breaker") {
+		return
+	}
+
+	code = codes.Unavailable
+	stream()
+	if !assert.True(t, cb.Tripped(), "Unavailable should trip the breaker") {
+		return
+	}
+	if !assert.Equal(t, codes.ResourceExhausted, status.Code(stream()), "error should be RESOURCE_EXHAUSTED") {
+		return
+	}
+}
+
+func TestServerInterceptorFallback(t *testing.T) {
+	cb := breaker.New(breaker.WithFallback(breaker.CircuitFunc(func() error { return nil })))
+	cb.Trip()
+	m := breaker.NewMap()
+	m.Set(testMethod, cb)
+	lookup := grpcbreaker.NewPerMethodLookup(m)
+
+	unary := grpcbreaker.UnaryServerInterceptor(lookup)
+	res, err := unary(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: testMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "reply", nil
+	})
+	if !assert.Nil(t, res, "no reply should be returned") {
+		return
+	}
+	if !assert.Equal(t, codes.ResourceExhausted, status.Code(err), "rejection handled by a fallback should be RESOURCE_EXHAUSTED") {
+		return
+	}
+
+	stream := grpcbreaker.StreamServerInterceptor(lookup)
+	err = stream(nil, nil, &grpc.StreamServerInfo{FullMethod: testMethod}, func(srv interface{}, ss grpc.ServerStream) error {
+		return nil
+	})
+	if !assert.Equal(t, codes.ResourceExhausted, status.Code(err), "rejection handled by a fallback should be RESOURCE_EXHAUSTED") {
+		return
+	}
+}
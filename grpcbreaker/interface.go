@@ -0,0 +1,60 @@
+package grpcbreaker
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*config)
+}
+
+type optionFunc func(*config)
+
+// config holds the values given by the options
+type config struct {
+	factory          breaker.BreakerFactory
+	failureCodes     []codes.Code
+	latencyThreshold time.Duration
+}
+
+// CallInfo describes the RPC that is about to be invoked. It is the
+// value passed to BreakerLookupper.BreakerLookup
+type CallInfo struct {
+	// Method is the full name of the RPC method, e.g. "/pkg.Service/Method"
+	Method string
+	// Target is the target of the client connection
+	Target string
+}
+
+// BreakerLookupper is used to find the breaker for a given RPC
+type BreakerLookupper interface {
+	BreakerLookup(*CallInfo) breaker.Breaker
+}
+
+// PerMethodLookup looks up breakers using the full RPC method name
+type PerMethodLookup struct {
+	factory breaker.BreakerFactory
+	methods breaker.Map
+}
+
+// PerTargetLookup looks up breakers using the target of the
+// client connection
+type PerTargetLookup struct {
+	targets breaker.Map
+}
+
+type interceptor struct {
+	failureCodes     map[codes.Code]struct{}
+	latencyThreshold time.Duration
+	lookup           BreakerLookupper
+}
+
+type openError struct {
+	code codes.Code
+	err  error
+}
@@ -0,0 +1,52 @@
+package grpcbreaker
+
+import "github.com/lestrrat/go-circuit-breaker/breaker"
+
+// NewPerMethodLookup creates a BreakerLookupper that looks up breakers
+// in the given map using the full RPC method name as the key.
+//
+// By default, nil is returned for methods that do not have a breaker
+// associated with them, which means those RPCs are not protected. If
+// a factory is specified via `WithBreakerFactory`, breakers are created
+// on demand for such methods.
+//
+// Possible optional parameters:
+// * WithBreakerFactory: specify the function used to create breakers for unknown methods
+func NewPerMethodLookup(methods breaker.Map, options ...Option) *PerMethodLookup {
+	var cfg config
+	for _, option := range options {
+		option.apply(&cfg)
+	}
+
+	return &PerMethodLookup{
+		factory: cfg.factory,
+		methods: methods,
+	}
+}
+
+func (l *PerMethodLookup) BreakerLookup(info *CallInfo) breaker.Breaker {
+	if l.factory != nil {
+		return l.methods.GetOrCreate(info.Method, l.factory)
+	}
+	cb, ok := l.methods.Get(info.Method)
+	if !ok {
+		return nil
+	}
+	return cb
+}
+
+// NewPerTargetLookup creates a BreakerLookupper that looks up breakers
+// in the given map using the target of the client connection as the key
+func NewPerTargetLookup(targets breaker.Map) *PerTargetLookup {
+	return &PerTargetLookup{
+		targets: targets,
+	}
+}
+
+func (l *PerTargetLookup) BreakerLookup(info *CallInfo) breaker.Breaker {
+	cb, ok := l.targets.Get(info.Target)
+	if !ok {
+		return nil
+	}
+	return cb
+}
@@ -0,0 +1,41 @@
+package grpcbreaker
+
+import (
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// WithFailureCodes is used to specify the gRPC status codes that are
+// recorded as failures. Errors with other status codes are returned to
+// the caller, but are recorded as successes.
+func WithFailureCodes(v ...codes.Code) Option {
+	return optionFunc(func(c *config) {
+		c.failureCodes = v
+	})
+}
+
+// WithLatencyThreshold is used to specify the duration after which
+// an RPC handled by a server interceptor is recorded as a failure,
+// even if it succeeded, so that the breaker opens when the server is
+// too slow. The response of the handler is still returned.
+func WithLatencyThreshold(v time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.latencyThreshold = v
+	})
+}
+
+// WithBreakerFactory is used to specify the function that creates
+// the breakers of the methods that have no breaker in the map given
+// to NewPerMethodLookup
+func WithBreakerFactory(v breaker.BreakerFactory) Option {
+	return optionFunc(func(c *config) {
+		c.factory = v
+	})
+}
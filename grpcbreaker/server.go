@@ -0,0 +1,107 @@
+package grpcbreaker
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// errTooSlow is recorded as the failure of RPCs that took longer
+// than the latency threshold
+var errTooSlow = errors.New("handler exceeded the latency threshold")
+
+// UnaryServerInterceptor creates a grpc.UnaryServerInterceptor that
+// protects the server from overload. The mandatory argument `l` is an
+// object that provides the breaker to be used for the given RPC, e.g.
+// one created by NewPerMethodLookup with WithBreakerFactory, so that
+// each method has its own breaker. The Target of the *CallInfo it
+// receives is empty.
+//
+// Handler errors with one of the failure codes, as well as handlers
+// that take longer than the latency threshold, are recorded as
+// failures. When the breaker is open, or has too many RPCs running,
+// the handler is not called and an error with the
+// codes.ResourceExhausted status code is returned, so that clients
+// back off instead of piling up requests on a server that can't
+// serve them.
+//
+// Handlers are never abandoned: the timeout of the breaker is ignored,
+// and slow handlers are detected with WithLatencyThreshold instead.
+//
+// Possible optional parameters:
+// * WithFailureCodes: specify the status codes that are recorded as failures
+// * WithLatencyThreshold: specify the duration after which RPCs are recorded as failures
+func UnaryServerInterceptor(l BreakerLookupper, options ...Option) grpc.UnaryServerInterceptor {
+	i := newInterceptor(l, options...)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		cb := i.lookup.BreakerLookup(&CallInfo{Method: info.FullMethod})
+		if cb == nil {
+			return handler(ctx, req)
+		}
+
+		var res interface{}
+		var handlerErr error
+		var handled bool
+		err := cb.Call(breaker.CircuitFunc(func() error {
+			handled = true
+			start := time.Now()
+			res, handlerErr = handler(ctx, req)
+			if err := i.failure(handlerErr); err != nil {
+				return err
+			}
+			if i.latencyThreshold > 0 && time.Since(start) > i.latencyThreshold {
+				return errTooSlow
+			}
+			return nil
+		}), breaker.WithTimeout(0))
+		if !handled {
+			return nil, rejectedError(err)
+		}
+		return res, handlerErr
+	}
+}
+
+// StreamServerInterceptor creates a grpc.StreamServerInterceptor that
+// protects the server from overload. The whole stream is run through
+// the breaker, and recorded as a failure if the handler returns an
+// error with one of the failure codes. The latency threshold does not
+// apply to streams, as they may last for any amount of time.
+//
+// See UnaryServerInterceptor for details on the arguments
+func StreamServerInterceptor(l BreakerLookupper, options ...Option) grpc.StreamServerInterceptor {
+	i := newInterceptor(l, options...)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		cb := i.lookup.BreakerLookup(&CallInfo{Method: info.FullMethod})
+		if cb == nil {
+			return handler(srv, ss)
+		}
+
+		var handlerErr error
+		var handled bool
+		err := cb.Call(breaker.CircuitFunc(func() error {
+			handled = true
+			handlerErr = handler(srv, ss)
+			return i.failure(handlerErr)
+		}), breaker.WithTimeout(0))
+		if !handled {
+			return rejectedError(err)
+		}
+		return handlerErr
+	}
+}
+
+// rejectedError converts the error returned by the breaker when the
+// handler was not called into a gRPC error for server interceptors.
+// err may be nil if the fallback of the breaker handled the rejection,
+// but the RPC must fail all the same
+func rejectedError(err error) error {
+	if err == nil {
+		err = errors.Wrap(breaker.ErrBreakerOpen, "handler was not called")
+	}
+	return &openError{code: codes.ResourceExhausted, err: err}
+}
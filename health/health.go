@@ -0,0 +1,121 @@
+// Package health reports the health of an instance based on the state
+// of its breakers, so that orchestrators such as Kubernetes can stop
+// routing traffic to instances whose dependencies are down.
+//
+//	checker := health.NewChecker(m, health.WithCritical("database"))
+//	mux.Handle("/readyz", checker)
+//
+// Checker.Check can also be used as a probe function by health check
+// libraries that expect a func() error.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// Status values reported by Checker.ServeHTTP
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// NewChecker creates a Checker for the breakers in m.
+//
+// By default, the instance is unhealthy when all of the breakers in m
+// are open, i.e. when none of its dependencies can be reached. An
+// empty map is considered healthy.
+//
+// Possible optional parameters:
+// * WithCritical: specify breakers that make the instance unhealthy as soon as they are open
+func NewChecker(m breaker.Map, options ...Option) *Checker {
+	var cfg config
+	for _, option := range options {
+		option.apply(&cfg)
+	}
+
+	return &Checker{
+		breakers: m,
+		critical: cfg.critical,
+	}
+}
+
+// Check returns nil if the instance is healthy, or an *UnhealthyError
+// listing the open breakers otherwise
+func (c *Checker) Check() error {
+	_, err := c.check()
+	return err
+}
+
+// check returns the states of all breakers, along with the result
+// of the check
+func (c *Checker) check() (map[string]breaker.State, error) {
+	states := make(map[string]breaker.State, c.breakers.Len())
+	c.breakers.Range(func(name string, cb breaker.Breaker) bool {
+		states[name] = cb.Snapshot().State
+		return true
+	})
+
+	var open []string
+	if len(c.critical) > 0 {
+		for _, name := range c.critical {
+			// Breakers that don't exist yet have not seen any failure
+			if st, ok := states[name]; ok && st == breaker.Open {
+				open = append(open, name)
+			}
+		}
+	} else {
+		for name, st := range states {
+			if st != breaker.Open {
+				return states, nil
+			}
+			open = append(open, name)
+		}
+	}
+
+	if len(open) == 0 {
+		return states, nil
+	}
+	sort.Strings(open)
+	return states, &UnhealthyError{Open: open}
+}
+
+func (e *UnhealthyError) Error() string {
+	return "breakers open: " + strings.Join(e.Open, ", ")
+}
+
+// ServeHTTP reports the result of the check in JSON, with a status
+// code of 200 if the instance is healthy, and 503 otherwise
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	states, err := c.check()
+	report := Report{
+		Status:   StatusOK,
+		Breakers: make(map[string]string, len(states)),
+	}
+	for name, st := range states {
+		report.Breakers[name] = st.String()
+	}
+
+	code := http.StatusOK
+	if uerr, ok := err.(*UnhealthyError); ok {
+		report.Status = StatusUnavailable
+		report.Open = uerr.Open
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
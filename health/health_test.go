@@ -0,0 +1,123 @@
+package health_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/health"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker(t *testing.T) {
+	m := breaker.NewMap()
+	m.Set("cache", breaker.New())
+	m.Set("database", breaker.New())
+
+	checker := health.NewChecker(m)
+	if !assert.NoError(t, checker.Check(), "closed breakers should be healthy") {
+		return
+	}
+
+	cache, _ := m.Get("cache")
+	cache.Break()
+	if !assert.NoError(t, checker.Check(), "some dependencies are still available") {
+		return
+	}
+
+	database, _ := m.Get("database")
+	database.Break()
+	err := checker.Check()
+	if !assert.IsType(t, &health.UnhealthyError{}, err, "all dependencies are down") {
+		return
+	}
+	if !assert.Equal(t, []string{"cache", "database"}, err.(*health.UnhealthyError).Open, "open breakers should be listed") {
+		return
+	}
+
+	database.Reset()
+	critical := health.NewChecker(m, health.WithCritical("database", "search"))
+	if !assert.NoError(t, critical.Check(), "non-critical breakers should be ignored") {
+		return
+	}
+	database.Break()
+	if !assert.Error(t, critical.Check(), "open critical breakers should be unhealthy") {
+		return
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := breaker.NewMap()
+	m.Set("database", breaker.New())
+
+	srv := httptest.NewServer(health.NewChecker(m))
+	defer srv.Close()
+
+	get := func() (int, health.Report) {
+		res, err := http.Get(srv.URL)
+		if !assert.NoError(t, err, "GET should succeed") {
+			return 0, health.Report{}
+		}
+		defer res.Body.Close()
+
+		var report health.Report
+		assert.NoError(t, json.NewDecoder(res.Body).Decode(&report), "decoding the report should succeed")
+		return res.StatusCode, report
+	}
+
+	code, report := get()
+	if !assert.Equal(t, http.StatusOK, code, "healthy instance should respond with 200") {
+		return
+	}
+	if !assert.Equal(t, health.StatusOK, report.Status, "status should be ok") {
+		return
+	}
+
+	database, _ := m.Get("database")
+	database.Break()
+	code, report = get()
+	if !assert.Equal(t, http.StatusServiceUnavailable, code, "unhealthy instance should respond with 503") {
+		return
+	}
+	if !assert.Equal(t, []string{"database"}, report.Open, "open breakers should be listed") {
+		return
+	}
+	if !assert.Equal(t, "open", report.Breakers["database"], "states should be reported") {
+		return
+	}
+}
+
+func TestCheckHasNoSideEffects(t *testing.T) {
+	c := clock.NewMock()
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = time.Second
+	bo.RandomizationFactor = 0
+	bo.Clock = c
+	bo.Reset()
+	cb := breaker.New(
+		breaker.WithClock(c),
+		breaker.WithBackOff(bo),
+	)
+	m := breaker.NewMap()
+	m.Set("database", cb)
+	checker := health.NewChecker(m)
+
+	cb.Trip()
+	c.Add(time.Second + 1)
+	if !assert.NoError(t, checker.Check(), "breaker due a probe should be healthy") {
+		return
+	}
+
+	err := cb.Call(breaker.CircuitFunc(func() error { return nil }))
+	if !assert.NoError(t, err, "the check should not take the probe") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "the probe should close the breaker") {
+		return
+	}
+}
@@ -0,0 +1,36 @@
+package health
+
+import "github.com/lestrrat/go-circuit-breaker/breaker"
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*config)
+}
+
+type optionFunc func(*config)
+
+// config holds the values given by the options
+type config struct {
+	critical []string
+}
+
+// Checker reports the health of an instance based on the state of
+// the breakers guarding its dependencies
+type Checker struct {
+	breakers breaker.Map
+	critical []string
+}
+
+// UnhealthyError is returned by Checker.Check when the instance is
+// considered unhealthy. Open lists the names of the open breakers
+// that caused it.
+type UnhealthyError struct {
+	Open []string
+}
+
+// Report is the body of the responses sent by Checker.ServeHTTP
+type Report struct {
+	Status   string            `json:"status"`
+	Breakers map[string]string `json:"breakers"`
+	Open     []string          `json:"open,omitempty"`
+}
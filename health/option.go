@@ -0,0 +1,14 @@
+package health
+
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// WithCritical is used to specify the names of the breakers that the
+// instance can not work without. If any of them is open, the instance
+// is reported as unhealthy.
+func WithCritical(v ...string) Option {
+	return optionFunc(func(c *config) {
+		c.critical = append(c.critical, v...)
+	})
+}
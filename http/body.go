@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	switch err {
+	case nil:
+	case io.EOF:
+		b.finish(nil)
+	default:
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *watchedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+func (b *watchedBody) finish(err error) {
+	b.once.Do(func() {
+		b.done <- err
+	})
+}
+
+// streamingDo sends the request through the breaker, and returns the
+// response as soon as the headers are received. The call only completes
+// once the body has been read or closed, so that the time spent reading
+// the body counts towards the timeout, and errors while reading it are
+// recorded as failures. The status code of the response is returned
+// even if the response is not.
+func (c *Client) streamingDo(b breaker.Breaker, req *http.Request) (*http.Response, int, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	req = req.WithContext(ctx)
+
+	var mutex sync.Mutex
+	var abandoned bool
+	var failed, res *http.Response
+	var status int
+	ready := make(chan struct{})
+	done := make(chan error, 1)
+
+	circuit := breaker.CircuitFunc(func() error {
+		r, err := c.client.Do(req)
+		holdOff(c.holdOffBreaker(b), c.clock, r, err)
+		if err = validate(r, err, c.validator); err != nil {
+			mutex.Lock()
+			if !abandoned {
+				status = statusCode(r)
+				if r != nil && !c.drainOnFailure {
+					// The caller wants to read the response
+					failed, r = r, nil
+				}
+			}
+			mutex.Unlock()
+			drainResponse(r)
+			return err
+		}
+
+		body := &watchedBody{ReadCloser: r.Body, done: make(chan error, 1)}
+		r.Body = body
+
+		mutex.Lock()
+		if abandoned {
+			mutex.Unlock()
+			r.Body.Close()
+			return context.Canceled
+		}
+		res = r
+		close(ready)
+		mutex.Unlock()
+
+		select {
+		case err := <-body.done:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	go func() {
+		err := b.Call(circuit, callOptions(ctx, c.timeout)...)
+		mutex.Lock()
+		abandoned = true
+		kept := failed != nil
+		mutex.Unlock()
+		// Abort reading the body if the call timed out, and release
+		// the context in any case, unless the response rejected by
+		// the validator is returned, in which case closing it does
+		if !kept {
+			cancel()
+		}
+		done <- err
+	}()
+
+	select {
+	case <-ready:
+		return res, res.StatusCode, nil
+	case err := <-done:
+		select {
+		case <-ready:
+			// The headers arrived just as the call failed, but the
+			// body can't be read anymore
+			res.Body.Close()
+		default:
+		}
+		if failed != nil {
+			// The caller wants to read the response that was
+			// rejected by the validator
+			failed.Body = &cancelOnClose{ReadCloser: failed.Body, cancel: cancel}
+			return failed, status, openError(c.lookup, b, req, err)
+		}
+		return nil, status, openError(c.lookup, b, req, err)
+	}
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// openError converts errors caused by an open breaker to a
+// BreakerOpenError. Other errors are returned unchanged
+func openError(l BreakerLookupper, b breaker.Breaker, req *http.Request, err error) error {
+	if !breaker.IsOpen(err) {
+		return err
+	}
+
+	e := &BreakerOpenError{
+		Host:    req.URL.Host,
+		RetryAt: b.RetryAt(),
+		err:     err,
+	}
+	e.Name = lookupBreakerName(l, req)
+	return e
+}
+
+func (e *BreakerOpenError) Error() string {
+	if e.RetryAt.IsZero() {
+		return fmt.Sprintf("breaker open for %s: %s", e.Host, e.err)
+	}
+	return fmt.Sprintf("breaker open for %s until %s: %s", e.Host, e.RetryAt.Format(http.TimeFormat), e.err)
+}
+
+// Cause returns the underlying breaker error, so that breaker.IsOpen
+// can be used with this error
+func (e *BreakerOpenError) Cause() error {
+	return e.err
+}
+
+// Unwrap returns the underlying breaker error, so that errors.Is can
+// be used with this error
+func (e *BreakerOpenError) Unwrap() error {
+	return e.err
+}
+
+func (e badStatusErr) Error() string {
+	return "bad HTTP status"
+}
+
+func (e badStatusErr) FailureClass() string {
+	return FailureBadStatus
+}
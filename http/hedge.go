@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+type hedgeResult struct {
+	attempt  int
+	err      error
+	response *http.Response
+	status   int
+}
+
+// cancelOnClose releases the context of the winning attempt once the
+// caller is done with the response body
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// isReplayable returns true if the request can be sent more than once
+func isReplayable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// hedgedDo sends the request through the breaker, and sends it once
+// more if no response arrived within the hedge delay. The first
+// successful response wins, and the other attempt is canceled. The
+// status code of the response is returned even if the response is not.
+func (c *Client) hedgedDo(b breaker.Breaker, req *http.Request) (*http.Response, int, error) {
+	var cancels [2]context.CancelFunc
+	results := make(chan hedgeResult, len(cancels))
+	attempt := func(i int, r *http.Request) {
+		actx, cancel := context.WithCancel(req.Context())
+		cancels[i] = cancel
+		r = r.WithContext(actx)
+
+		go func() {
+			ctx := getDoCtx()
+			c.setup(&ctx.ctxCommon, b)
+			ctx.Client = c.client
+			ctx.Request = r
+			res, status, err := callPooled(b, ctx, actx, c.timeout)
+			results <- hedgeResult{attempt: i, err: err, response: res, status: status}
+		}()
+	}
+
+	attempt(0, req)
+	started, pending := 1, 1
+
+	t := breaker.NewTimer(c.clock, c.hedgeDelay)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C():
+			hedge, err := cloneRequest(req)
+			if err != nil {
+				// Can't hedge, keep waiting for the first attempt
+				continue
+			}
+			attempt(started, hedge)
+			started++
+			pending++
+		case res := <-results:
+			pending--
+			if res.err != nil {
+				if res.response != nil && pending == 0 {
+					// The response was rejected by the validator, and
+					// the caller wants to read it
+					res.response.Body = &cancelOnClose{ReadCloser: res.response.Body, cancel: cancels[res.attempt]}
+					return res.response, res.status, openError(c.lookup, b, req, res.err)
+				}
+				drainResponse(res.response)
+				cancels[res.attempt]()
+				if pending > 0 {
+					// The other attempt may still succeed
+					continue
+				}
+				return nil, res.status, openError(c.lookup, b, req, res.err)
+			}
+
+			for i := 0; i < started; i++ {
+				if i != res.attempt {
+					cancels[i]()
+				}
+			}
+			go discardHedgeResults(results, pending)
+			if res.response == nil {
+				// A fallback handled the call
+				cancels[res.attempt]()
+				return nil, 0, nil
+			}
+			res.response.Body = &cancelOnClose{ReadCloser: res.response.Body, cancel: cancels[res.attempt]}
+			return res.response, res.status, nil
+		}
+	}
+}
+
+// discardHedgeResults releases the responses of the attempts that
+// lost the race once they complete
+func discardHedgeResults(results chan hedgeResult, pending int) {
+	for ; pending > 0; pending-- {
+		res := <-results
+		if res.response != nil {
+			res.response.Body.Close()
+		}
+	}
+}
+
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+	return r, nil
+}
@@ -1,9 +1,12 @@
 package http
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/lestrrat/go-circuit-breaker/breaker"
 )
@@ -14,129 +17,319 @@ import (
 //
 // Possible optional parameters:
 // * WithClient: specify the HTTP Client instance
+// * WithCheckRedirect: specify the redirect policy of the HTTP Client
+// * WithJar: specify the cookie jar of the HTTP Client
 // * WithErrorOnBadStatus: specify if you want the breaker to consider 5XX status codes as errors
+// * WithDrainOnFailure: specify if responses recorded as failures should be drained, or returned with the error
+// * WithStatusValidator: specify how responses are mapped to failures
+// * WithHedgeDelay: specify the delay after which slow requests are hedged
+// * WithRetryAfter: specify if you want the breaker to honor Retry-After headers
+// * WithClock: specify the clock used to compute the time given by Retry-After headers, and to time hedge requests
+// * WithTimeout: specify the timeout of requests, after which they are recorded as failures
+// * WithTimeoutIncludesBody: specify if the timeout should cover reading the response body
+// * WithRecorder: specify the recorder notified of the requests sent through breakers
 func NewClient(l BreakerLookupper, options ...Option) *Client {
-	var cl HTTPClient
-	errOnBadStatus := true
-	for _, option := range options {
-		switch option.Name() {
-		case "Client":
-			cl = option.Get().(HTTPClient)
-		case "ErrorOnBadStatus":
-			errOnBadStatus = option.Get().(bool)
-		}
-	}
+	cfg := newConfig(options)
+	cl := cfg.client
 	if cl == nil {
 		cl = &http.Client{}
 	}
+	if cfg.checkRedirect != nil || cfg.jar != nil {
+		if hc, ok := cl.(*http.Client); ok {
+			copied := *hc
+			if cfg.checkRedirect != nil {
+				copied.CheckRedirect = cfg.checkRedirect
+			}
+			if cfg.jar != nil {
+				copied.Jar = cfg.jar
+			}
+			cl = &copied
+		}
+	}
 
 	return &Client{
-		client:         cl,
-		errOnBadStatus: errOnBadStatus,
-		lookup:         l,
+		client:              cl,
+		clock:               cfg.clock,
+		drainOnFailure:      cfg.drainOnFailure,
+		hedgeDelay:          cfg.hedgeDelay,
+		lookup:              l,
+		recorder:            cfg.recorder,
+		retryAfter:          cfg.retryAfter,
+		timeout:             cfg.timeout,
+		timeoutIncludesBody: cfg.timeoutIncludesBody,
+		validator:           cfg.validator,
 	}
 }
 
+// NewClientWithBreaker creates a new HTTP Client where all requests are
+// controlled by the given breaker. It is meant for clients that only
+// talk to a single service, for which a Map and a BreakerLookupper are
+// superfluous. The options are the same as for NewClient
+func NewClientWithBreaker(b breaker.Breaker, options ...Option) *Client {
+	return NewClient(singleLookup{breaker: b}, options...)
+}
+
+// CloseIdleConnections wraps http.Client CloseIdleConnections()
+func (c *Client) CloseIdleConnections() {
+	c.client.CloseIdleConnections()
+}
+
 // Do wraps http.Client Do()
 func (c *Client) Do(req *http.Request) (*http.Response, error) {
-	b := c.breakerLookup(req.URL.String())
+	b := lookupBreaker(c.lookup, req)
 	if b == nil {
 		return c.client.Do(req)
 	}
-
-	ctx := getDoCtx()
-	defer releaseDoCtx(ctx)
-
-	ctx.Client = c.client
-	ctx.ErrorOnBadStatus = c.errOnBadStatus
-	ctx.Request = req
-	if err := b.Call(ctx, breaker.WithTimeout(c.timeout)); err != nil {
-		return nil, err
-	}
-	return ctx.Response, ctx.Error
+	return c.do(b, req, true)
 }
 
 // Get wraps http.Client Get()
 func (c *Client) Get(url string) (*http.Response, error) {
-	b := c.breakerLookup(url)
+	b, req := c.breakerLookup(http.MethodGet, url)
 	if b == nil {
 		return c.client.Get(url)
 	}
 
-	ctx := getGetCtx()
-	defer releaseGetCtx(ctx)
+	if c.hedgeDelay > 0 || c.timeoutIncludesBody {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		return c.do(b, req, true)
+	}
 
+	ctx := getGetCtx()
+	c.setup(&ctx.ctxCommon, b)
 	ctx.Client = c.client
-	ctx.ErrorOnBadStatus = c.errOnBadStatus
 	ctx.URL = url
-	if err := b.Call(ctx, breaker.WithTimeout(c.timeout)); err != nil {
-		return nil, err
-	}
-	return ctx.Response, ctx.Error
+	return c.call(b, req, ctx, context.Background())
 }
 
 // Head wraps http.Client Head()
 func (c *Client) Head(url string) (*http.Response, error) {
-	b := c.breakerLookup(url)
+	b, req := c.breakerLookup(http.MethodHead, url)
 	if b == nil {
 		return c.client.Head(url)
 	}
 
-	ctx := getHeadCtx()
-	defer releaseHeadCtx(ctx)
+	if c.hedgeDelay > 0 || c.timeoutIncludesBody {
+		req, err := http.NewRequest(http.MethodHead, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		return c.do(b, req, true)
+	}
 
+	ctx := getHeadCtx()
+	c.setup(&ctx.ctxCommon, b)
 	ctx.Client = c.client
-	ctx.ErrorOnBadStatus = c.errOnBadStatus
 	ctx.URL = url
-	if err := b.Call(ctx, breaker.WithTimeout(c.timeout)); err != nil {
-		return nil, err
-	}
-	return ctx.Response, ctx.Error
+	return c.call(b, req, ctx, context.Background())
 }
 
 // Post wraps http.Client Post()
 func (c *Client) Post(url string, bodyType string, body io.Reader) (*http.Response, error) {
-	b := c.breakerLookup(url)
+	b, req := c.breakerLookup(http.MethodPost, url)
 	if b == nil {
-		return c.client.Head(url)
+		return c.client.Post(url, bodyType, body)
 	}
 
-	ctx := getPostCtx()
-	defer releasePostCtx(ctx)
+	if c.timeoutIncludesBody {
+		req, err := http.NewRequest(http.MethodPost, url, body)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", bodyType)
+		return c.do(b, req, false)
+	}
 
+	ctx := getPostCtx()
+	c.setup(&ctx.ctxCommon, b)
 	ctx.Client = c.client
-	ctx.ErrorOnBadStatus = c.errOnBadStatus
 	ctx.URL = url
 	ctx.Body = body
 	ctx.BodyType = bodyType
-	if err := b.Call(ctx, breaker.WithTimeout(c.timeout)); err != nil {
-		return nil, err
-	}
-	return ctx.Response, ctx.Error
+	return c.call(b, req, ctx, context.Background())
 }
 
 // PostForm wraps http.Client PostForm()
 func (c *Client) PostForm(url string, data url.Values) (*http.Response, error) {
-	b := c.breakerLookup(url)
+	b, req := c.breakerLookup(http.MethodPost, url)
 	if b == nil {
 		return c.client.PostForm(url, data)
 	}
 
-	ctx := getPostFormCtx()
-	defer releasePostFormCtx(ctx)
+	if c.timeoutIncludesBody {
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(data.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return c.do(b, req, false)
+	}
 
+	ctx := getPostFormCtx()
+	c.setup(&ctx.ctxCommon, b)
 	ctx.Client = c.client
-	ctx.ErrorOnBadStatus = c.errOnBadStatus
 	ctx.URL = url
 	ctx.Data = data
-	if err := b.Call(ctx, breaker.WithTimeout(c.timeout)); err != nil {
+	return c.call(b, req, ctx, context.Background())
+}
+
+// Put issues a PUT to the specified URL, with the given body and
+// content type. The request is made with ctx, so that it can be
+// canceled, and so that ForceContext and TimeoutContext can be used
+func (c *Client) Put(ctx context.Context, url string, bodyType string, body io.Reader) (*http.Response, error) {
+	// PUT is idempotent, so the request may be hedged
+	return c.send(ctx, http.MethodPut, url, bodyType, body, true)
+}
+
+// Patch issues a PATCH to the specified URL, with the given body and
+// content type. The request is made with ctx, as in Put
+func (c *Client) Patch(ctx context.Context, url string, bodyType string, body io.Reader) (*http.Response, error) {
+	return c.send(ctx, http.MethodPatch, url, bodyType, body, false)
+}
+
+// Delete issues a DELETE to the specified URL. The request is made
+// with ctx, as in Put
+func (c *Client) Delete(ctx context.Context, url string) (*http.Response, error) {
+	return c.send(ctx, http.MethodDelete, url, "", nil, true)
+}
+
+// send creates a request and sends it through its breaker, if any.
+// hedge tells if the request may be hedged
+func (c *Client) send(ctx context.Context, method, url, bodyType string, body io.Reader, hedge bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
 		return nil, err
 	}
-	return ctx.Response, ctx.Error
+	if bodyType != "" {
+		req.Header.Set("Content-Type", bodyType)
+	}
+
+	b := lookupBreaker(c.lookup, req)
+	if b == nil {
+		return c.client.Do(req)
+	}
+	return c.do(b, req, hedge)
+}
+
+// do sends the request through the breaker. hedge tells if the
+// request may be hedged
+func (c *Client) do(b breaker.Breaker, req *http.Request, hedge bool) (*http.Response, error) {
+	var run func(breaker.Breaker, *http.Request) (*http.Response, int, error)
+	switch {
+	case hedge && c.hedgeDelay > 0 && isReplayable(req):
+		run = c.hedgedDo
+	case c.timeoutIncludesBody:
+		run = c.streamingDo
+	}
+	if run != nil {
+		start := c.clock.Now()
+		res, status, err := run(b, req)
+		recordRequest(c.recorder, c.lookup, c.clock, b, req, start, status, err)
+		return res, err
+	}
+
+	ctx := getDoCtx()
+	c.setup(&ctx.ctxCommon, b)
+	ctx.Client = c.client
+	ctx.Request = req
+	return c.call(b, req, ctx, req.Context())
+}
+
+// call calls the pooled circuit through the breaker
+func (c *Client) call(b breaker.Breaker, req *http.Request, pc pooledCtx, ctx context.Context) (*http.Response, error) {
+	start := c.clock.Now()
+	res, status, err := callPooled(b, pc, ctx, c.timeout)
+	err = openError(c.lookup, b, req, err)
+	recordRequest(c.recorder, c.lookup, c.clock, b, req, start, status, err)
+	return res, err
+}
+
+// ForceContext returns a copy of ctx that makes the Client and the
+// Transport send requests made with it even if their breaker is open,
+// as breaker.WithForce does. This is meant for health probes, and
+// other requests that are triggered manually.
+func ForceContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceKey{}, true)
+}
+
+// WithBreakerName returns a copy of ctx that makes the Client and the
+// Transport use the breaker with the given name for requests made with
+// it, instead of the one found by their BreakerLookupper. This is for
+// callers that know which dependency they are calling, e.g. when many
+// services share a host. The name is only honored by lookups that
+// implement NamedBreakerLookupper; other lookups may read it with
+// BreakerNameFromContext
+func WithBreakerName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, breakerNameKey{}, name)
+}
+
+// BreakerNameFromContext returns the name given to WithBreakerName,
+// if any
+func BreakerNameFromContext(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(breakerNameKey{}).(string)
+	return name, ok
+}
+
+// TimeoutContext returns a copy of ctx that makes the Client and the
+// Transport give up on requests made with it after d, and record them
+// as failures, instead of using the timeout given to WithTimeout. A
+// zero duration disables the timeout for the request. The deadline of
+// ctx, if any, is still honored.
+func TimeoutContext(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey{}, d)
+}
+
+// callOptions returns the options used to call the circuit of a
+// request made with ctx
+func callOptions(ctx context.Context, timeout time.Duration) []breaker.CallOption {
+	if d, ok := ctx.Value(timeoutKey{}).(time.Duration); ok {
+		timeout = d
+	}
+	options := []breaker.CallOption{breaker.WithContext(ctx), breaker.WithTimeout(timeout)}
+	if isForced(ctx) {
+		options = append(options, breaker.WithForce(true))
+	}
+	return options
+}
+
+// isForced returns true if ctx was returned by ForceContext
+func isForced(ctx context.Context) bool {
+	forced, _ := ctx.Value(forceKey{}).(bool)
+	return forced
+}
+
+// setup fills the fields shared by the pooled circuits
+func (c *Client) setup(cc *ctxCommon, b breaker.Breaker) {
+	cc.Breaker = c.holdOffBreaker(b)
+	cc.Clock = c.clock
+	cc.DrainOnFailure = c.drainOnFailure
+	cc.Validator = c.validator
+}
+
+// holdOffBreaker returns the breaker to be held open by Retry-After
+// headers, or nil if the headers should be ignored
+func (c *Client) holdOffBreaker(b breaker.Breaker) breaker.Breaker {
+	if !c.retryAfter {
+		return nil
+	}
+	return b
 }
 
-func (c *Client) breakerLookup(val interface{}) breaker.Breaker {
-	return c.lookup.BreakerLookup(val)
+// breakerLookup looks up the breaker for a request that is described
+// by its method and URL, for the methods that don't take a request.
+// The request that was used for the lookup is returned along with
+// the breaker
+func (c *Client) breakerLookup(method, rawURL string) (breaker.Breaker, *http.Request) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// The request will fail anyway, so don't bother protecting it
+		return nil, nil
+	}
+	req := &http.Request{Method: method, URL: u, Host: u.Host}
+	return c.lookup.BreakerLookup(req), req
 }
 
 /*
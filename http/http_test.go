@@ -1,20 +1,27 @@
 package http_test
 
 import (
+	"context"
+	"io"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
-	"github.com/cenk/backoff"
 	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/backoff"
 	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/fbclock"
 	httpb "github.com/lestrrat/go-circuit-breaker/http"
 	"github.com/stretchr/testify/assert"
 )
 
-func ExampleHTTPWithBreaker() {
+func ExampleNewClient() {
 	m := breaker.NewMap()
 	m.Set("_default", breaker.New())
 	m.Set("example.com", breaker.New())
@@ -25,6 +32,17 @@ func ExampleHTTPWithBreaker() {
 	cl.Get("http://example.com")
 }
 
+func ExampleNewTransport() {
+	m := breaker.NewMap()
+	m.Set("example.com", breaker.New())
+
+	cl := &http.Client{
+		Transport: httpb.NewTransport(httpb.NewPerHostLookup(m)),
+	}
+
+	cl.Get("http://example.com")
+}
+
 func TestTreshold(t *testing.T) {
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.FormValue("fail") == "" {
@@ -67,3 +85,977 @@ func TestTreshold(t *testing.T) {
 		}
 	}
 }
+
+func TestTransport(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("fail") == "" {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("overloaded"))
+		}
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+
+	c := clock.NewMock()
+	cb := breaker.New(
+		breaker.WithClock(c),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+	m := breaker.NewMap()
+	m.Set(u.Host, cb)
+	cl := &http.Client{
+		Transport: httpb.NewTransport(httpb.NewPerHostLookup(m)),
+	}
+
+	res, err := cl.Get(s.URL)
+	if !assert.NoError(t, err, "Get should succeed") {
+		return
+	}
+	res.Body.Close()
+
+	// Bad responses are recorded as failures, but returned as is
+	res, err = cl.Get(s.URL + "?fail=true")
+	if !assert.NoError(t, err, "Get should return the bad response") {
+		return
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !assert.NoError(t, err, "body should be readable") {
+		return
+	}
+	if !assert.Equal(t, http.StatusInternalServerError, res.StatusCode, "status code should be returned") {
+		return
+	}
+	if !assert.Equal(t, "overloaded", string(body), "body should be returned") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "bad response should trip the breaker") {
+		return
+	}
+
+	_, err = cl.Get(s.URL)
+	if !assert.IsType(t, &url.Error{}, err, "http.Client should return *url.Error") {
+		return
+	}
+	if !assert.True(t, breaker.IsOpen(err.(*url.Error).Err), "Get should fail with open breaker") {
+		return
+	}
+}
+
+func TestTransportFallback(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+
+	fallbacks := 0
+	m := breaker.NewMap()
+	m.Set(u.Host, breaker.New(
+		breaker.WithFallback(breaker.CircuitFunc(func() error {
+			fallbacks++
+			return nil
+		})),
+	))
+	cb, _ := m.Get(u.Host)
+	cb.Trip()
+
+	cl := &http.Client{
+		Transport: httpb.NewTransport(httpb.NewPerHostLookup(m)),
+	}
+
+	res, err := cl.Get(s.URL)
+	if res != nil {
+		res.Body.Close()
+	}
+	if !assert.IsType(t, &url.Error{}, err, "http.Client should return *url.Error") {
+		return
+	}
+	if !assert.IsType(t, &httpb.BreakerOpenError{}, err.(*url.Error).Err, "Get should fail with BreakerOpenError") {
+		return
+	}
+	if !assert.Equal(t, 1, fallbacks, "fallback should be called") {
+		return
+	}
+}
+
+func TestCanceledRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+
+	cb := breaker.New(breaker.WithTripper(breaker.ThresholdTripper(1)))
+	m := breaker.NewMap()
+	m.Set(u.Host, cb)
+	cl := httpb.NewClient(httpb.NewPerHostLookup(m))
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+	_, err := cl.Do(req.WithContext(ctx))
+	if !assert.Error(t, err, "Do should fail") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "canceled request should not trip the breaker") {
+		return
+	}
+}
+
+func newRequest(method, u string) *http.Request {
+	req, _ := http.NewRequest(method, u, nil)
+	return req
+}
+
+func TestPerHostLookupFactory(t *testing.T) {
+	c := clock.NewMock()
+	m := breaker.NewMap()
+	m.Set("static.example.com", breaker.New())
+
+	l := httpb.NewPerHostLookup(m,
+		httpb.WithBreakerFactory(func() breaker.Breaker {
+			return breaker.New(breaker.WithClock(c))
+		}),
+		httpb.WithIdleTimeout(time.Minute),
+		httpb.WithClock(c),
+	)
+
+	cb := l.BreakerLookup(newRequest(http.MethodGet, "http://a.example.com/foo"))
+	if !assert.NotNil(t, cb, "breaker should be created") {
+		return
+	}
+	if !assert.Equal(t, cb, l.BreakerLookup(newRequest(http.MethodGet, "http://a.example.com/bar")), "same breaker should be returned for the same host") {
+		return
+	}
+	l.BreakerLookup(newRequest(http.MethodGet, "http://b.example.com"))
+	l.BreakerLookup(newRequest(http.MethodGet, "http://static.example.com"))
+	if !assert.Equal(t, 3, m.Len(), "map should contain 3 breakers") {
+		return
+	}
+
+	cbB, _ := m.Get("b.example.com")
+	cbB.Trip()
+
+	c.Add(2 * time.Minute)
+	l.BreakerLookup(newRequest(http.MethodGet, "http://c.example.com"))
+
+	if _, ok := m.Get("a.example.com"); !assert.False(t, ok, "idle breaker should be evicted") {
+		return
+	}
+	if _, ok := m.Get("b.example.com"); !assert.True(t, ok, "tripped breaker should not be evicted") {
+		return
+	}
+	if _, ok := m.Get("static.example.com"); !assert.True(t, ok, "breakers not created by the factory should not be evicted") {
+		return
+	}
+	if _, ok := m.Get("c.example.com"); !assert.True(t, ok, "new breaker should be created") {
+		return
+	}
+}
+
+func TestHedgedRequest(t *testing.T) {
+	var requests int32
+	canceled := make(chan struct{})
+	arrived := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			close(arrived)
+			// The first request is slow, and should be canceled
+			// once the hedge request succeeds
+			<-r.Context().Done()
+			close(canceled)
+			return
+		}
+		io.WriteString(w, "hedged")
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+
+	cb := breaker.New(breaker.WithTripper(breaker.ThresholdTripper(1)))
+	m := breaker.NewMap()
+	m.Set(u.Host, cb)
+	c := clock.NewMock()
+	cl := httpb.NewClient(httpb.NewPerHostLookup(m),
+		httpb.WithClock(fbclock.New(c)),
+		httpb.WithHedgeDelay(time.Minute),
+	)
+
+	type result struct {
+		res *http.Response
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		res, err := cl.Get(s.URL)
+		done <- result{res: res, err: err}
+	}()
+
+	// The hedge request is only sent once the clock reaches the delay
+	<-arrived
+	var r result
+	timeout := time.After(5 * time.Second)
+	for r.res == nil && r.err == nil {
+		select {
+		case r = <-done:
+		case <-timeout:
+			t.Fatal("hedge request should be sent once the delay has passed")
+		default:
+			c.Add(time.Minute)
+		}
+	}
+	res, err := r.res, r.err
+	if !assert.NoError(t, err, "Get should succeed") {
+		return
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !assert.NoError(t, err, "reading the body should succeed") {
+		return
+	}
+	if !assert.Equal(t, "hedged", string(body), "response should come from the hedge request") {
+		return
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("slow request should be canceled")
+	}
+	if !assert.Equal(t, int64(1), cb.Successes(), "hedge request should be recorded") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "canceled request should not trip the breaker") {
+		return
+	}
+}
+
+func TestStatusValidator(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("status") {
+		case "429":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "501":
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+
+	cb := breaker.New(breaker.WithTripper(breaker.ThresholdTripper(1)))
+	m := breaker.NewMap()
+	m.Set(u.Host, cb)
+	m.Set("127.0.0.1:1", cb)
+	cl := httpb.NewClient(httpb.NewPerHostLookup(m), httpb.WithStatusValidator(func(res *http.Response) error {
+		if res.StatusCode == http.StatusTooManyRequests {
+			return httpb.ErrBadStatus
+		}
+		return nil
+	}))
+
+	res, err := cl.Get(s.URL + "?status=501")
+	if !assert.NoError(t, err, "501 should not be a failure") {
+		return
+	}
+	res.Body.Close()
+
+	if _, err := cl.Get("http://127.0.0.1:1"); !assert.Error(t, err, "transport error should be returned") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "transport error should trip the breaker") {
+		return
+	}
+
+	cb.Reset()
+	if _, err := cl.Get(s.URL + "?status=429"); !assert.Error(t, err, "429 should be a failure") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "429 should trip the breaker") {
+		return
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	u, _ := url.Parse(s.URL)
+
+	c := clock.NewMock()
+	cb := breaker.New(
+		breaker.WithBackOff(backoff.NewConstantBackOff(time.Second)),
+		breaker.WithClock(c),
+	)
+	m := breaker.NewMap()
+	m.Set(u.Host, cb)
+	cl := httpb.NewClient(httpb.NewPerHostLookup(m), httpb.WithRetryAfter(true), httpb.WithClock(c))
+
+	res, err := cl.Get(s.URL)
+	if !assert.NoError(t, err, "429 should not be a failure by default") {
+		return
+	}
+	res.Body.Close()
+
+	if !assert.True(t, cb.Tripped(), "Retry-After should trip the breaker") {
+		return
+	}
+	if !assert.Equal(t, c.Now().Add(2*time.Minute), cb.RetryAt(), "breaker should retry once the server is ready") {
+		return
+	}
+}
+
+func TestLookups(t *testing.T) {
+	t.Run("PerPath", func(t *testing.T) {
+		m := breaker.NewMap()
+		users := breaker.New()
+		m.Set("example.com/users", users)
+		l := httpb.NewPerPathLookup(m)
+
+		if !assert.Equal(t, users, l.BreakerLookup(newRequest(http.MethodGet, "http://example.com/users?id=1")), "breaker for the path should be returned") {
+			return
+		}
+		if !assert.Nil(t, l.BreakerLookup(newRequest(http.MethodGet, "http://example.com/posts")), "unknown path should not be protected") {
+			return
+		}
+	})
+	t.Run("PerHostAndMethod", func(t *testing.T) {
+		m := breaker.NewMap()
+		l := httpb.NewPerHostAndMethodLookup(m, httpb.WithBreakerFactory(func() breaker.Breaker {
+			return breaker.New()
+		}))
+
+		get := l.BreakerLookup(newRequest(http.MethodGet, "http://example.com/foo"))
+		post := l.BreakerLookup(newRequest(http.MethodPost, "http://example.com/foo"))
+		if !assert.NotEqual(t, get, post, "methods should have separate breakers") {
+			return
+		}
+		if !assert.Equal(t, get, l.BreakerLookup(newRequest(http.MethodGet, "http://example.com/bar")), "requests with the same method and host should share a breaker") {
+			return
+		}
+		if _, ok := m.Get("POST example.com"); !assert.True(t, ok, "breaker should be stored using the method and the host") {
+			return
+		}
+	})
+	t.Run("Pattern", func(t *testing.T) {
+		m := breaker.NewMap()
+		api := breaker.New()
+		m.Set("api", api)
+		l := httpb.NewPatternLookup(m)
+		if !assert.NoError(t, l.Handle(`^GET example\.com/api/`, "api"), "Handle should succeed") {
+			return
+		}
+		if !assert.Error(t, l.Handle(`(`, "broken"), "invalid patterns should be rejected") {
+			return
+		}
+
+		if !assert.Equal(t, api, l.BreakerLookup(newRequest(http.MethodGet, "http://example.com/api/users")), "matching request should use the breaker") {
+			return
+		}
+		if !assert.Nil(t, l.BreakerLookup(newRequest(http.MethodPost, "http://example.com/api/users")), "request that does not match should not be protected") {
+			return
+		}
+	})
+}
+
+func TestBreakerLookupFunc(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	cb := breaker.New()
+	var method string
+	cl := httpb.NewClient(httpb.BreakerLookupFunc(func(req *http.Request) breaker.Breaker {
+		method = req.Method
+		return cb
+	}))
+
+	res, err := cl.Head(s.URL)
+	if !assert.NoError(t, err, "Head should succeed") {
+		return
+	}
+	res.Body.Close()
+	if !assert.Equal(t, http.MethodHead, method, "lookup should receive the method of the request") {
+		return
+	}
+	if !assert.Equal(t, int64(1), cb.Successes(), "request should be recorded by the breaker") {
+		return
+	}
+}
+
+func TestNewClientWithBreaker(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	cb := breaker.New(breaker.WithName("backend"))
+	cl := httpb.NewClientWithBreaker(cb)
+
+	res, err := cl.Get(s.URL)
+	if !assert.NoError(t, err, "Get should succeed") {
+		return
+	}
+	res.Body.Close()
+	if !assert.Equal(t, int64(1), cb.Successes(), "request should be recorded by the breaker") {
+		return
+	}
+
+	cb.Trip()
+	_, err = cl.Get(s.URL + "/foo")
+	openErr, ok := err.(*httpb.BreakerOpenError)
+	if !assert.True(t, ok, "error should be a *BreakerOpenError") {
+		return
+	}
+	if !assert.Equal(t, "backend", openErr.Name, "breaker name should be reported") {
+		return
+	}
+}
+
+func TestBreakerOpenError(t *testing.T) {
+	c := clock.NewMock()
+	cb := breaker.New(
+		breaker.WithBackOff(backoff.NewConstantBackOff(time.Minute)),
+		breaker.WithClock(c),
+	)
+	m := breaker.NewMap()
+	m.Set("example.com", cb)
+	cl := httpb.NewClient(httpb.NewPerHostLookup(m))
+
+	cb.Trip()
+	_, err := cl.Get("http://example.com/foo")
+	if !assert.True(t, breaker.IsOpen(err), "error should be an open breaker error") {
+		return
+	}
+
+	openErr, ok := err.(*httpb.BreakerOpenError)
+	if !assert.True(t, ok, "error should be a *BreakerOpenError") {
+		return
+	}
+	if !assert.Equal(t, "example.com", openErr.Host, "host should be reported") {
+		return
+	}
+	if !assert.Equal(t, "example.com", openErr.Name, "breaker name should be reported") {
+		return
+	}
+	if !assert.Equal(t, c.Now().Add(time.Minute), openErr.RetryAt, "retry time should be reported") {
+		return
+	}
+}
+
+func TestForceContext(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+
+	cb := breaker.New()
+	cl := httpb.NewClient(httpb.BreakerLookupFunc(func(*http.Request) breaker.Breaker {
+		return cb
+	}))
+
+	cb.Trip()
+	if _, err := cl.Get(s.URL); !assert.True(t, breaker.IsOpen(err), "request should be rejected") {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if !assert.NoError(t, err, "NewRequest should succeed") {
+		return
+	}
+	res, err := cl.Do(req.WithContext(httpb.ForceContext(context.Background())))
+	if !assert.NoError(t, err, "forced request should be sent") {
+		return
+	}
+	res.Body.Close()
+	if !assert.False(t, cb.Tripped(), "successful forced request should close the breaker") {
+		return
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (r *closeRecorder) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func TestTimeoutDiscardsLateResponse(t *testing.T) {
+	body := &closeRecorder{Reader: strings.NewReader("late"), closed: make(chan struct{})}
+	cl := httpb.NewClient(
+		httpb.BreakerLookupFunc(func(*http.Request) breaker.Breaker { return breaker.New() }),
+		httpb.WithClient(&http.Client{
+			Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				time.Sleep(50 * time.Millisecond)
+				return &http.Response{StatusCode: http.StatusOK, Body: body, Request: req}, nil
+			}),
+		}),
+		httpb.WithTimeout(10*time.Millisecond),
+	)
+
+	_, err := cl.Get("http://example.com")
+	if !assert.True(t, breaker.IsTimeout(err), "Get should time out") {
+		return
+	}
+
+	select {
+	case <-body.closed:
+	case <-time.After(time.Second):
+		t.Fatal("late response should be closed")
+	}
+}
+
+func TestTimeoutIncludesBody(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "head")
+		w.(http.Flusher).Flush()
+		if r.FormValue("slow") != "" {
+			<-r.Context().Done()
+			return
+		}
+		io.WriteString(w, "tail")
+	}))
+	defer s.Close()
+
+	cb := breaker.New()
+	cl := httpb.NewClient(
+		httpb.BreakerLookupFunc(func(*http.Request) breaker.Breaker { return cb }),
+		httpb.WithTimeout(100*time.Millisecond),
+		httpb.WithTimeoutIncludesBody(true),
+	)
+
+	res, err := cl.Get(s.URL)
+	if !assert.NoError(t, err, "Get should succeed") {
+		return
+	}
+	if !assert.Equal(t, int64(0), cb.Successes(), "outcome should not be recorded before the body is read") {
+		return
+	}
+	buf, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !assert.NoError(t, err, "reading the body should succeed") {
+		return
+	}
+	if !assert.Equal(t, "headtail", string(buf), "body should be streamed") {
+		return
+	}
+	// The outcome is recorded asynchronously
+	time.Sleep(50 * time.Millisecond)
+	if !assert.Equal(t, int64(1), cb.Successes(), "success should be recorded once the body is read") {
+		return
+	}
+
+	res, err = cl.Get(s.URL + "?slow=true")
+	if !assert.NoError(t, err, "Get should return once the headers are received") {
+		return
+	}
+	_, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	if !assert.Error(t, err, "reading a slow body should be aborted by the timeout") {
+		return
+	}
+	// The outcome is recorded asynchronously
+	time.Sleep(50 * time.Millisecond)
+	if !assert.Equal(t, int64(1), cb.Failures(), "slow body should be recorded as a failure") {
+		return
+	}
+}
+
+func TestClientSurface(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		case "/redirect":
+			http.Redirect(w, r, "/login", http.StatusFound)
+		case "/whoami":
+			c, err := r.Cookie("session")
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			io.WriteString(w, c.Value)
+		}
+	}))
+	defer s.Close()
+
+	jar, err := cookiejar.New(nil)
+	if !assert.NoError(t, err, "cookiejar.New should succeed") {
+		return
+	}
+	base := &http.Client{}
+	cl := httpb.NewClientWithBreaker(breaker.New(),
+		httpb.WithClient(base),
+		httpb.WithJar(jar),
+		httpb.WithCheckRedirect(func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}),
+	)
+	defer cl.CloseIdleConnections()
+	if !assert.Nil(t, base.Jar, "the given http.Client should not be modified") {
+		return
+	}
+
+	res, err := cl.Get(s.URL + "/redirect")
+	if !assert.NoError(t, err, "Get should succeed") {
+		return
+	}
+	res.Body.Close()
+	if !assert.Equal(t, http.StatusFound, res.StatusCode, "redirect policy should be honored") {
+		return
+	}
+
+	res, err = cl.Get(s.URL + "/login")
+	if !assert.NoError(t, err, "Get should succeed") {
+		return
+	}
+	res.Body.Close()
+
+	res, err = cl.Get(s.URL + "/whoami")
+	if !assert.NoError(t, err, "Get should succeed") {
+		return
+	}
+	defer res.Body.Close()
+	buf, _ := io.ReadAll(res.Body)
+	if !assert.Equal(t, "secret", string(buf), "cookies should be kept in the jar") {
+		return
+	}
+}
+
+func TestTimeoutContext(t *testing.T) {
+	cb := breaker.New()
+	cl := httpb.NewClientWithBreaker(cb,
+		httpb.WithClient(&http.Client{
+			Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				select {
+				case <-time.After(50 * time.Millisecond):
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+			}),
+		}),
+		httpb.WithTimeout(10*time.Millisecond),
+	)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	if !assert.NoError(t, err, "http.NewRequest should succeed") {
+		return
+	}
+	req = req.WithContext(httpb.TimeoutContext(req.Context(), time.Second))
+	res, err := cl.Do(req)
+	if !assert.NoError(t, err, "request should not time out with a longer timeout") {
+		return
+	}
+	res.Body.Close()
+
+	req = req.WithContext(httpb.TimeoutContext(context.Background(), time.Millisecond))
+	_, err = cl.Do(req)
+	if !assert.True(t, breaker.IsTimeout(err), "request should time out with a shorter timeout") {
+		return
+	}
+	if !assert.Equal(t, int64(1), cb.Failures(), "timed out request should be recorded as a failure") {
+		return
+	}
+}
+
+func TestMethods(t *testing.T) {
+	type received struct {
+		method      string
+		contentType string
+		body        string
+	}
+	requests := make(chan received, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, _ := io.ReadAll(r.Body)
+		requests <- received{method: r.Method, contentType: r.Header.Get("Content-Type"), body: string(buf)}
+	}))
+	defer s.Close()
+
+	cb := breaker.New()
+	var lookup breaker.Breaker
+	cl := httpb.NewClient(httpb.BreakerLookupFunc(func(*http.Request) breaker.Breaker {
+		return lookup
+	}))
+
+	calls := []struct {
+		name string
+		call func() (*http.Response, error)
+		want received
+	}{
+		{
+			name: "Post",
+			call: func() (*http.Response, error) { return cl.Post(s.URL, "text/plain", strings.NewReader("post")) },
+			want: received{method: http.MethodPost, contentType: "text/plain", body: "post"},
+		},
+		{
+			name: "Put",
+			call: func() (*http.Response, error) {
+				return cl.Put(context.Background(), s.URL, "text/plain", strings.NewReader("put"))
+			},
+			want: received{method: http.MethodPut, contentType: "text/plain", body: "put"},
+		},
+		{
+			name: "Patch",
+			call: func() (*http.Response, error) {
+				return cl.Patch(context.Background(), s.URL, "application/json", strings.NewReader("{}"))
+			},
+			want: received{method: http.MethodPatch, contentType: "application/json", body: "{}"},
+		},
+		{
+			name: "Delete",
+			call: func() (*http.Response, error) { return cl.Delete(context.Background(), s.URL) },
+			want: received{method: http.MethodDelete},
+		},
+	}
+	for _, protected := range []bool{false, true} {
+		lookup = nil
+		if protected {
+			lookup = cb
+		}
+		for _, c := range calls {
+			res, err := c.call()
+			if !assert.NoError(t, err, "%s should succeed (protected: %t)", c.name, protected) {
+				return
+			}
+			res.Body.Close()
+			if !assert.Equal(t, c.want, <-requests, "%s should send the request (protected: %t)", c.name, protected) {
+				return
+			}
+		}
+	}
+	if !assert.Equal(t, int64(len(calls)), cb.Successes(), "protected requests should be recorded by the breaker") {
+		return
+	}
+
+	cb.Trip()
+	_, err := cl.Delete(context.Background(), s.URL)
+	if !assert.True(t, breaker.IsOpen(err), "requests should be rejected while the breaker is open") {
+		return
+	}
+	res, err := cl.Delete(httpb.ForceContext(context.Background()), s.URL)
+	if !assert.NoError(t, err, "forced requests should be sent while the breaker is open") {
+		return
+	}
+	res.Body.Close()
+	<-requests
+}
+
+func TestWithBreakerName(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+
+	m := breaker.NewMap()
+	host := breaker.New()
+	payments := breaker.New()
+	m.Set(u.Host, host)
+	m.Set("payments", payments)
+	cl := httpb.NewClient(httpb.NewPerHostLookup(m))
+
+	req, err := http.NewRequestWithContext(httpb.WithBreakerName(context.Background(), "payments"), http.MethodGet, s.URL, nil)
+	if !assert.NoError(t, err, "http.NewRequest should succeed") {
+		return
+	}
+	res, err := cl.Do(req)
+	if !assert.NoError(t, err, "Do should succeed") {
+		return
+	}
+	res.Body.Close()
+	if !assert.Equal(t, int64(1), payments.Successes(), "named breaker should record the request") {
+		return
+	}
+	if !assert.Equal(t, int64(0), host.Successes(), "breaker of the host should be bypassed") {
+		return
+	}
+
+	payments.Trip()
+	_, err = cl.Do(req)
+	openErr, ok := err.(*httpb.BreakerOpenError)
+	if !assert.True(t, ok, "error should be a *BreakerOpenError") {
+		return
+	}
+	if !assert.Equal(t, "payments", openErr.Name, "name of the named breaker should be reported") {
+		return
+	}
+
+	rt := httpb.NewTransport(httpb.NewPerHostLookup(m))
+	_, err = rt.RoundTrip(req)
+	if !assert.True(t, breaker.IsOpen(err), "Transport should use the named breaker") {
+		return
+	}
+
+	var name string
+	cl = httpb.NewClient(httpb.BreakerLookupFunc(func(req *http.Request) breaker.Breaker {
+		name, _ = httpb.BreakerNameFromContext(req.Context())
+		return nil
+	}))
+	res, err = cl.Do(req)
+	if !assert.NoError(t, err, "Do should succeed") {
+		return
+	}
+	res.Body.Close()
+	if !assert.Equal(t, "payments", name, "other lookups should be able to read the name") {
+		return
+	}
+}
+
+func TestDrainOnFailure(t *testing.T) {
+	// Too large to be drained by net/http when the body is closed
+	body := strings.Repeat("boom", 128<<10)
+	var conns int64
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, body)
+	}))
+	s.Config.ConnState = func(_ net.Conn, st http.ConnState) {
+		if st == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	s.Start()
+	defer s.Close()
+
+	t.Run("Drain", func(t *testing.T) {
+		cl := httpb.NewClientWithBreaker(breaker.New(), httpb.WithClient(&http.Client{Transport: &http.Transport{}}))
+		defer cl.CloseIdleConnections()
+
+		atomic.StoreInt64(&conns, 0)
+		for i := 0; i < 3; i++ {
+			res, err := cl.Get(s.URL)
+			if !assert.Error(t, err, "5XX responses should be failures") {
+				return
+			}
+			if !assert.Nil(t, res, "response should not be returned") {
+				return
+			}
+		}
+		if !assert.Equal(t, int64(1), atomic.LoadInt64(&conns), "drained connections should be reused") {
+			return
+		}
+	})
+
+	for _, includesBody := range []bool{false, true} {
+		cb := breaker.New()
+		cl := httpb.NewClientWithBreaker(cb,
+			httpb.WithDrainOnFailure(false),
+			httpb.WithTimeoutIncludesBody(includesBody),
+		)
+		res, err := cl.Get(s.URL)
+		if !assert.Error(t, err, "5XX responses should be failures (includes body: %t)", includesBody) {
+			return
+		}
+		if !assert.NotNil(t, res, "response should be returned with the error (includes body: %t)", includesBody) {
+			return
+		}
+		buf, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if !assert.NoError(t, err, "body should be readable (includes body: %t)", includesBody) {
+			return
+		}
+		if !assert.Equal(t, body, string(buf), "error body should be returned (includes body: %t)", includesBody) {
+			return
+		}
+		if !assert.Equal(t, int64(1), cb.Failures(), "response should be recorded as a failure (includes body: %t)", includesBody) {
+			return
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+
+	m := breaker.NewMap()
+	cb := breaker.New()
+	m.Set(u.Host, cb)
+	rec := httpb.NewRecorder(2)
+	cl := httpb.NewClient(httpb.NewPerHostLookup(m), httpb.WithRecorder(rec))
+
+	for _, path := range []string{"/ok", "/fail"} {
+		res, err := cl.Get(s.URL + path)
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+	cb.Trip()
+	cl.Get(s.URL + "/rejected")
+	res, err := cl.Do(mustRequest(t, httpb.ForceContext(context.Background()), s.URL+"/forced"))
+	if !assert.NoError(t, err, "forced request should succeed") {
+		return
+	}
+	res.Body.Close()
+
+	list := rec.Requests(u.Host)
+	if !assert.Len(t, list, 2, "only the last requests should be kept") {
+		return
+	}
+	if !assert.Equal(t, s.URL+"/rejected", list[0].URL, "requests should be listed from the oldest") {
+		return
+	}
+	if !assert.Equal(t, httpb.Rejected, list[0].Decision, "rejected request should be recorded as such") {
+		return
+	}
+	if !assert.True(t, breaker.IsOpen(list[0].Err), "error of the rejected request should be recorded") {
+		return
+	}
+	if !assert.Equal(t, httpb.Forced, list[1].Decision, "forced request should be recorded as such") {
+		return
+	}
+	if !assert.Equal(t, http.MethodGet, list[1].Method, "method should be recorded") {
+		return
+	}
+	if !assert.Equal(t, http.StatusOK, list[1].StatusCode, "status should be recorded") {
+		return
+	}
+
+	rec = httpb.NewRecorder(0)
+	rt := httpb.NewTransport(httpb.NewPerHostLookup(m), httpb.WithRecorder(rec))
+	cb.Reset()
+	res, err = rt.RoundTrip(mustRequest(t, context.Background(), s.URL+"/fail"))
+	if !assert.NoError(t, err, "5XX responses should be returned") {
+		return
+	}
+	res.Body.Close()
+	list = rec.Requests(u.Host)
+	if !assert.Len(t, list, 1, "Transport should record requests") {
+		return
+	}
+	if !assert.Equal(t, httpb.Allowed, list[0].Decision, "failed request should be allowed") {
+		return
+	}
+	if !assert.Equal(t, http.StatusInternalServerError, list[0].StatusCode, "status of the failed request should be recorded") {
+		return
+	}
+	if !assert.Error(t, list[0].Err, "5XX responses should be recorded as failures") {
+		return
+	}
+}
+
+func mustRequest(t *testing.T, ctx context.Context, u string) *http.Request {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest failed: %s", err)
+	}
+	return req
+}
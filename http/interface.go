@@ -1,23 +1,72 @@
 package http
 
 import (
-	"errors"
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sync"
 	"time"
 
 	"github.com/lestrrat/go-circuit-breaker/breaker"
 )
 
-var ErrBadStatus = errors.New("bad HTTP status")
+// ErrBadStatus is returned when a response is rejected by the
+// StatusValidator. Breakers count it under the FailureBadStatus class
+// in FailuresByClass.
+var ErrBadStatus error = badStatusErr{}
 
+// FailureBadStatus is the class of failures caused by responses that
+// are rejected by the StatusValidator, such as 5XX responses
+const FailureBadStatus = "bad_status"
+
+type badStatusErr struct{}
+
+// forceKey is the context key that marks requests sent through open
+// breakers
+type forceKey struct{}
+
+// breakerNameKey is the context key that holds the name of the breaker
+// to be used for a request, overriding the lookup
+type breakerNameKey struct{}
+
+// timeoutKey is the context key that holds the timeout of a request,
+// overriding the one given to WithTimeout
+type timeoutKey struct{}
+
+// StatusValidator inspects a response, and returns an error if the
+// response should be recorded as a failure by the breaker
+type StatusValidator func(*http.Response) error
+
+// Option is an option that can be passed to NewClient, NewTransport
+// and the constructors of the lookups
 type Option interface {
-	Name() string
-	Get() interface{}
+	apply(*config)
 }
 
+type optionFunc func(*config)
+
+// config holds the values given by the options
+type config struct {
+	breakerFactory      breaker.BreakerFactory
+	checkRedirect       func(*http.Request, []*http.Request) error
+	client              HTTPClient
+	clock               breaker.Clock
+	drainOnFailure      bool
+	hedgeDelay          time.Duration
+	idleTimeout         time.Duration
+	jar                 http.CookieJar
+	recorder            RequestRecorder
+	retryAfter          bool
+	timeout             time.Duration
+	timeoutIncludesBody bool
+	transport           http.RoundTripper
+	validator           StatusValidator
+}
+
+// HTTPClient is the part of *http.Client used by Client
 type HTTPClient interface {
+	CloseIdleConnections()
 	Do(*http.Request) (*http.Response, error)
 	Get(string) (*http.Response, error)
 	Head(string) (*http.Response, error)
@@ -28,56 +77,237 @@ type HTTPClient interface {
 // Client is a wrapper around http.Client that provides circuit breaker capabilities.
 type Client struct {
 	client         HTTPClient
-	errOnBadStatus bool
+	clock          breaker.Clock
+	drainOnFailure bool
+	hedgeDelay     time.Duration
 	// BreakerTripped func()
 	// BreakerReset   func()
-	// BreakerLookup  func(*HTTPClient, interface{}) *breaker.Breaker
 	// Panel          *Panel
-	lookup  BreakerLookupper
-	timeout time.Duration
+	lookup              BreakerLookupper
+	recorder            RequestRecorder
+	retryAfter          bool
+	timeout             time.Duration
+	timeoutIncludesBody bool
+	validator           StatusValidator
+}
+
+// Decision tells what a breaker did with a request
+type Decision int
+
+// The decisions recorded in RequestRecord
+const (
+	// Allowed means that the request was let through by the breaker
+	Allowed Decision = iota
+	// Rejected means that the request was rejected by the breaker,
+	// because it was open or had too many concurrent requests
+	Rejected
+	// Forced means that the request was sent regardless of the state
+	// of the breaker, because of ForceContext
+	Forced
+)
+
+// DefaultRecorderSize is the number of requests kept per breaker by
+// NewRecorder when the given number is not positive
+const DefaultRecorderSize = 100
+
+// RequestRecord describes a request sent by a Client or a Transport
+// through a breaker
+type RequestRecord struct {
+	// Time is the time the request was sent
+	Time time.Time
+	// Method is the method of the request
+	Method string
+	// URL is the URL of the request
+	URL string
+	// StatusCode is the status code of the response, or 0 if there
+	// was no response
+	StatusCode int
+	// Err is the error returned to the caller, if any
+	Err error
+	// Latency is the time it took to receive the response headers, or
+	// to give up on the request
+	Latency time.Duration
+	// Decision tells what the breaker did with the request
+	Decision Decision
+}
+
+// RequestRecorder is notified of the requests sent by a Client or a
+// Transport through a breaker, along with the name of the breaker
+type RequestRecorder interface {
+	Record(string, RequestRecord)
+}
+
+// Recorder is a RequestRecorder that keeps the last requests of each
+// breaker, so that they can be inspected when diagnosing why a breaker
+// tripped, e.g. with the admin package
+type Recorder struct {
+	logs  map[string]*requestLog
+	mutex sync.RWMutex
+	size  int
+}
+
+type requestLog struct {
+	entries []RequestRecord
+	mutex   sync.Mutex
+	next    int
+	size    int
+}
+
+// Transport is an http.RoundTripper that provides circuit breaker capabilities.
+type Transport struct {
+	clock      breaker.Clock
+	lookup     BreakerLookupper
+	recorder   RequestRecorder
+	retryAfter bool
+	timeout    time.Duration
+	transport  http.RoundTripper
+	validator  StatusValidator
+}
+
+// ctxCommon holds the fields shared by the pooled circuits. The state
+// tells who owns the circuit: the caller gives up ownership if it stops
+// waiting for the circuit (e.g. on timeout), in which case the circuit
+// cleans up after itself once the request completes
+type ctxCommon struct {
+	Breaker        breaker.Breaker
+	Clock          breaker.Clock
+	DrainOnFailure bool
+	Error          error
+	Response       *http.Response
+	Validator      StatusValidator
+	state          int32
 }
 
 type doCtx struct {
-	Client           HTTPClient
-	Error            error
-	ErrorOnBadStatus bool
-	Request          *http.Request
-	Response         *http.Response
+	ctxCommon
+	Client  HTTPClient
+	Request *http.Request
+}
+
+type roundTripCtx struct {
+	ctxCommon
+	Request   *http.Request
+	Transport http.RoundTripper
 }
 
 type getCtx struct {
-	Client           HTTPClient
-	Error            error
-	ErrorOnBadStatus bool
-	URL              string
-	Response         *http.Response
+	ctxCommon
+	Client HTTPClient
+	URL    string
 }
 
 type headCtx getCtx
 
 type postCtx struct {
-	Body             io.Reader
-	BodyType         string
-	Client           HTTPClient
-	Error            error
-	ErrorOnBadStatus bool
-	URL              string
-	Response         *http.Response
+	ctxCommon
+	Body     io.Reader
+	BodyType string
+	Client   HTTPClient
+	URL      string
 }
 
 type postFormCtx struct {
-	Client           HTTPClient
-	Data             url.Values
-	Error            error
-	ErrorOnBadStatus bool
-	URL              string
-	Response         *http.Response
+	ctxCommon
+	Client HTTPClient
+	Data   url.Values
+	URL    string
+}
+
+// watchedBody reports when the caller is done with a response body,
+// either because it was read until the end, or because it was closed
+type watchedBody struct {
+	io.ReadCloser
+	done chan error
+	once sync.Once
 }
 
+// BreakerLookupper is used to find the breaker for a request. The
+// Client and the Transport pass the request that is about to be sent,
+// so that the method, the URL and the headers can all be used to pick
+// a breaker. nil means that the request is not protected
 type BreakerLookupper interface {
-	BreakerLookup(interface{}) breaker.Breaker
+	BreakerLookup(*http.Request) breaker.Breaker
+}
+
+// BreakerNamer is implemented by BreakerLookuppers that can tell the
+// name of the breaker used for a request. The name is reported in
+// BreakerOpenError
+type BreakerNamer interface {
+	BreakerName(*http.Request) string
+}
+
+// NamedBreakerLookupper is implemented by BreakerLookuppers that can
+// find a breaker by its name. It is used for requests made with a
+// context returned by WithBreakerName. The lookups created by
+// NewPerHostLookup and its siblings implement it
+type NamedBreakerLookupper interface {
+	BreakerByName(string) breaker.Breaker
+}
+
+// BreakerOpenError is returned when a request is rejected because
+// its breaker is open. breaker.IsOpen returns true for this error.
+type BreakerOpenError struct {
+	// Host is the host that the request was sent to
+	Host string
+	// Name is the name of the breaker, if the BreakerLookupper
+	// implements BreakerNamer, or if it was given by WithBreakerName
+	Name string
+	// RetryAt is the estimated time when the breaker will let
+	// requests through again. It is the zero time if unknown
+	RetryAt time.Time
+
+	err error
+}
+
+// BreakerLookupFunc is a BreakerLookupper represented as a standalone
+// function
+type BreakerLookupFunc func(*http.Request) breaker.Breaker
+
+// singleLookup is a BreakerLookupper that uses the same breaker for
+// all requests
+type singleLookup struct {
+	breaker breaker.Breaker
+}
+
+// keyedLookup looks up breakers in a map using a key derived from the
+// request. If a factory is given, breakers are created on demand, and
+// optionally evicted once they have been idle for too long.
+type keyedLookup struct {
+	breakers    breaker.Map
+	clock       breaker.Clock
+	factory     breaker.BreakerFactory
+	idleTimeout time.Duration
+	lastAccess  map[string]time.Time
+	lastSweep   time.Time
+	mutex       sync.Mutex
 }
 
+// PerHostLookup looks up breakers using the host of the request URL
 type PerHostLookup struct {
-	hosts breaker.Map
+	keyedLookup
+}
+
+// PerPathLookup looks up breakers using the host and the path of the
+// request URL
+type PerPathLookup struct {
+	keyedLookup
+}
+
+// PerHostAndMethodLookup looks up breakers using the method of the
+// request and the host of the request URL
+type PerHostAndMethodLookup struct {
+	keyedLookup
+}
+
+// PatternLookup looks up breakers using the first pattern that matches
+// the request
+type PatternLookup struct {
+	keyedLookup
+	patterns     []lookupPattern
+	patternsLock sync.RWMutex
+}
+
+type lookupPattern struct {
+	name    string
+	pattern *regexp.Regexp
 }
@@ -1,34 +1,264 @@
 package http
 
 import (
-	"net/url"
+	"net/http"
+	"regexp"
+	"time"
 
 	"github.com/lestrrat/go-circuit-breaker/breaker"
 )
 
-func NewPerHostLookup(hosts breaker.Map) *PerHostLookup {
-	return &PerHostLookup{
-		hosts: hosts,
-	}
+// NewPerHostLookup creates a BreakerLookupper that looks up breakers
+// in the given map using the host name of the request URL as the key.
+//
+// By default, nil is returned for hosts that do not have a breaker
+// associated with them, which means requests to those hosts are not
+// protected. If a factory is specified via `WithBreakerFactory`,
+// breakers are created on demand for such hosts.
+//
+// Possible optional parameters:
+// * WithBreakerFactory: specify the function used to create breakers for unknown hosts
+// * WithIdleTimeout: specify the duration after which breakers created by the factory are evicted if they are not used
+// * WithClock: specify the clock used to determine idle breakers
+func NewPerHostLookup(hosts breaker.Map, options ...Option) *PerHostLookup {
+	var l PerHostLookup
+	l.init(hosts, options...)
+	return &l
+}
+
+// NewPerPathLookup creates a BreakerLookupper that looks up breakers
+// in the given map using the host name and the path of the request URL
+// as the key, e.g. "example.com/api/users". This allows endpoints on the
+// same host to fail independently of each other.
+//
+// It accepts the same optional parameters as NewPerHostLookup.
+func NewPerPathLookup(paths breaker.Map, options ...Option) *PerPathLookup {
+	var l PerPathLookup
+	l.init(paths, options...)
+	return &l
+}
+
+// NewPerHostAndMethodLookup creates a BreakerLookupper that looks up
+// breakers in the given map using the method of the request and the
+// host name of the request URL as the key, e.g. "POST example.com".
+//
+// It accepts the same optional parameters as NewPerHostLookup.
+func NewPerHostAndMethodLookup(breakers breaker.Map, options ...Option) *PerHostAndMethodLookup {
+	var l PerHostAndMethodLookup
+	l.init(breakers, options...)
+	return &l
+}
+
+// NewPatternLookup creates a BreakerLookupper that looks up breakers
+// in the given map using patterns registered with Handle. Requests
+// that do not match any pattern are not protected.
+//
+// It accepts the same optional parameters as NewPerHostLookup.
+func NewPatternLookup(breakers breaker.Map, options ...Option) *PatternLookup {
+	var l PatternLookup
+	l.init(breakers, options...)
+	return &l
+}
+
+// BreakerLookup calls the function to find the breaker for the request
+func (f BreakerLookupFunc) BreakerLookup(req *http.Request) breaker.Breaker {
+	return f(req)
+}
+
+// BreakerLookup returns the breaker, whatever the request
+func (l singleLookup) BreakerLookup(*http.Request) breaker.Breaker {
+	return l.breaker
+}
+
+// BreakerName returns the name of the breaker
+func (l singleLookup) BreakerName(*http.Request) string {
+	return l.breaker.Name()
 }
 
 const defaultBreakerName = "_default"
-func (l *PerHostLookup) BreakerLookup(v interface{}) breaker.Breaker {
-	rawURL := v.(string)
-	parsedURL, err := url.Parse(rawURL)
+
+func (l *keyedLookup) init(breakers breaker.Map, options ...Option) {
+	cfg := newConfig(options)
+	l.breakers = breakers
+	l.clock = cfg.clock
+	l.factory = cfg.breakerFactory
+	l.idleTimeout = cfg.idleTimeout
+	l.lastAccess = make(map[string]time.Time)
+	l.lastSweep = cfg.clock.Now()
+}
+
+func (l *PerHostLookup) BreakerLookup(req *http.Request) breaker.Breaker {
+	return l.get(l.BreakerName(req))
+}
+
+// BreakerName returns the key used to look up the breaker for the request
+func (l *PerHostLookup) BreakerName(req *http.Request) string {
+	if req == nil || req.URL == nil {
+		return defaultBreakerName
+	}
+	return req.URL.Host
+}
+
+func (l *PerPathLookup) BreakerLookup(req *http.Request) breaker.Breaker {
+	return l.get(l.BreakerName(req))
+}
+
+// BreakerName returns the key used to look up the breaker for the request
+func (l *PerPathLookup) BreakerName(req *http.Request) string {
+	if req == nil || req.URL == nil {
+		return defaultBreakerName
+	}
+	return req.URL.Host + req.URL.Path
+}
+
+func (l *PerHostAndMethodLookup) BreakerLookup(req *http.Request) breaker.Breaker {
+	return l.get(l.BreakerName(req))
+}
+
+// BreakerName returns the key used to look up the breaker for the request
+func (l *PerHostAndMethodLookup) BreakerName(req *http.Request) string {
+	if req == nil || req.URL == nil {
+		return defaultBreakerName
+	}
+	return requestMethod(req) + " " + req.URL.Host
+}
+
+// Handle registers a pattern, and the name of the breaker to be used
+// for requests matching it. The pattern is a regular expression that
+// is matched against the method of the request followed by the host
+// name and the path of the request URL, e.g. "GET example.com/api/users".
+// Patterns are tried in the order that they were registered.
+func (l *PatternLookup) Handle(pattern, name string) error {
+	re, err := regexp.Compile(pattern)
 	if err != nil {
-		b, _ := l.hosts.Get(defaultBreakerName)
-		return b
+		return err
 	}
 
-	host := parsedURL.Host
-	cb, ok := l.hosts.Get(host)
-	if !ok {
+	l.patternsLock.Lock()
+	l.patterns = append(l.patterns, lookupPattern{name: name, pattern: re})
+	l.patternsLock.Unlock()
+	return nil
+}
+
+func (l *PatternLookup) BreakerLookup(req *http.Request) breaker.Breaker {
+	name := l.BreakerName(req)
+	if name == "" {
 		return nil
-/*
-		cb = breaker.New(breaker.WithTripper(breaker.ThresholdTripper(l.threshold)))
-		l.hosts.Set(host, cb)
-*/
+	}
+	return l.get(name)
+}
+
+// BreakerName returns the name associated with the first pattern that
+// matches the request, or an empty string if no pattern matches
+func (l *PatternLookup) BreakerName(req *http.Request) string {
+	if req == nil || req.URL == nil {
+		return defaultBreakerName
+	}
+
+	s := requestMethod(req) + " " + req.URL.Host + req.URL.Path
+
+	l.patternsLock.RLock()
+	defer l.patternsLock.RUnlock()
+	for _, p := range l.patterns {
+		if p.pattern.MatchString(s) {
+			return p.name
+		}
+	}
+	return ""
+}
+
+// requestMethod returns the method of the request, where an empty
+// method means GET
+func requestMethod(req *http.Request) string {
+	if req.Method == "" {
+		return http.MethodGet
+	}
+	return req.Method
+}
+
+// BreakerByName returns the breaker with the given name in the map of
+// the lookup, creating it if a factory was given
+func (l *keyedLookup) BreakerByName(name string) breaker.Breaker {
+	return l.get(name)
+}
+
+// lookupBreaker finds the breaker for the request. The breaker named
+// by WithBreakerName takes precedence over the lookup, if the lookup
+// can find breakers by name
+func lookupBreaker(l BreakerLookupper, req *http.Request) breaker.Breaker {
+	if name, ok := BreakerNameFromContext(req.Context()); ok {
+		if nl, ok := l.(NamedBreakerLookupper); ok {
+			return nl.BreakerByName(name)
+		}
+	}
+	return l.BreakerLookup(req)
+}
+
+// lookupBreakerName returns the name of the breaker used for the
+// request, or an empty string if unknown
+func lookupBreakerName(l BreakerLookupper, req *http.Request) string {
+	if name, ok := BreakerNameFromContext(req.Context()); ok {
+		if _, ok := l.(NamedBreakerLookupper); ok {
+			return name
+		}
+	}
+	if n, ok := l.(BreakerNamer); ok {
+		return n.BreakerName(req)
+	}
+	return ""
+}
+
+// get returns the breaker associated with the key, creating it if
+// a factory was given
+func (l *keyedLookup) get(key string) breaker.Breaker {
+	if l.factory == nil {
+		cb, ok := l.breakers.Get(key)
+		if !ok {
+			return nil
+		}
+		return cb
+	}
+
+	cb, ok := l.breakers.Get(key)
+	if !ok {
+		cb = l.breakers.GetOrCreate(key, l.factory)
+	}
+
+	if l.idleTimeout > 0 {
+		l.touch(key, !ok)
 	}
 	return cb
 }
+
+// touch records the access time of breakers created by the factory,
+// and evicts those that have been idle for too long
+func (l *keyedLookup) touch(key string, created bool) {
+	now := l.clock.Now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.lastAccess[key]; ok || created {
+		l.lastAccess[key] = now
+	}
+
+	if now.Sub(l.lastSweep) < l.idleTimeout {
+		return
+	}
+	l.lastSweep = now
+
+	for name, t := range l.lastAccess {
+		if now.Sub(t) < l.idleTimeout {
+			continue
+		}
+
+		// Keep tripped breakers around, or else we would forget
+		// that the host is down
+		if cb, ok := l.breakers.Get(name); ok && cb.Tripped() {
+			continue
+		}
+
+		delete(l.lastAccess, name)
+		l.breakers.Delete(name)
+	}
+}
@@ -2,14 +2,157 @@ package http
 
 import (
 	"net/http"
+	"time"
 
-	"github.com/lestrrat/go-circuit-breaker/internal/option"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
 )
 
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// newConfig applies the options over the defaults
+func newConfig(options []Option) *config {
+	c := &config{
+		drainOnFailure: true,
+		validator:      DefaultStatusValidator,
+	}
+	for _, option := range options {
+		option.apply(c)
+	}
+	if c.clock == nil {
+		c.clock = breaker.SystemClock
+	}
+	return c
+}
+
 func WithClient(c *http.Client) Option {
-	return option.NewValue("Client", c)
+	return optionFunc(func(cfg *config) {
+		cfg.client = c
+	})
+}
+
+// WithStatusValidator specifies the function that decides whether a
+// response should be recorded as a failure. When combined with
+// WithErrorOnBadStatus, whichever option is given last takes effect.
+func WithStatusValidator(v StatusValidator) Option {
+	return optionFunc(func(c *config) {
+		c.validator = v
+	})
+}
+
+// WithRetryAfter specifies if the Retry-After header of 429 and 503
+// responses should keep the breaker open until the given time, so
+// that no requests are sent before the server is ready for them.
+func WithRetryAfter(b bool) Option {
+	return optionFunc(func(c *config) {
+		c.retryAfter = b
+	})
+}
+
+// WithCheckRedirect specifies the redirect policy of the underlying
+// http.Client, as its CheckRedirect field does. The http.Client given
+// to WithClient is copied, not modified.
+func WithCheckRedirect(f func(*http.Request, []*http.Request) error) Option {
+	return optionFunc(func(c *config) {
+		c.checkRedirect = f
+	})
+}
+
+// WithJar specifies the cookie jar of the underlying http.Client, as
+// its Jar field does. The http.Client given to WithClient is copied,
+// not modified.
+func WithJar(j http.CookieJar) Option {
+	return optionFunc(func(c *config) {
+		c.jar = j
+	})
+}
+
+// WithDrainOnFailure specifies if the Client should drain and close
+// the body of responses that are recorded as failures, such as 5XX
+// responses, before returning the error, so that the connection can
+// be reused. This is the default. Otherwise, the response is returned
+// along with the error, so that the error body can be read, and the
+// caller is responsible for closing it. The Transport always returns
+// such responses without an error, as required by http.RoundTripper.
+func WithDrainOnFailure(b bool) Option {
+	return optionFunc(func(c *config) {
+		c.drainOnFailure = b
+	})
+}
+
+// WithRecorder specifies a RequestRecorder that is notified of every
+// request sent through a breaker by the Client or the Transport, along
+// with what the breaker did with it, e.g. a Recorder created by
+// NewRecorder
+func WithRecorder(r RequestRecorder) Option {
+	return optionFunc(func(c *config) {
+		c.recorder = r
+	})
 }
 
 func WithErrorOnBadStatus(b bool) Option {
-	return option.NewValue("ErrorOnBadStatus", b)
+	return optionFunc(func(c *config) {
+		if b {
+			c.validator = DefaultStatusValidator
+		} else {
+			c.validator = nil
+		}
+	})
+}
+
+func WithTransport(t http.RoundTripper) Option {
+	return optionFunc(func(c *config) {
+		c.transport = t
+	})
+}
+
+func WithBreakerFactory(f breaker.BreakerFactory) Option {
+	return optionFunc(func(c *config) {
+		c.breakerFactory = f
+	})
+}
+
+func WithIdleTimeout(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.idleTimeout = d
+	})
+}
+
+func WithClock(c breaker.Clock) Option {
+	return optionFunc(func(cfg *config) {
+		cfg.clock = c
+	})
+}
+
+// WithHedgeDelay specifies the delay after which a Client sends a
+// second request to the same host if the first one has not completed.
+// The first successful response is returned, and the other request is
+// canceled. Only requests that can be replayed are hedged, i.e. those
+// without a body or with GetBody set.
+func WithHedgeDelay(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.hedgeDelay = d
+	})
+}
+
+// WithTimeout specifies the duration after which a request is given up
+// on and recorded as a failure by the breaker. By default, the timeout
+// covers the request until the response headers are received.
+func WithTimeout(d time.Duration) Option {
+	return optionFunc(func(c *config) {
+		c.timeout = d
+	})
+}
+
+// WithTimeoutIncludesBody specifies if the Client should wait until
+// the response body has been read (or closed) before recording the
+// outcome of a request. Reading the body then counts towards the
+// timeout, and errors while reading the body are recorded as failures.
+// The response is still returned as soon as the headers are received,
+// so that the body can be streamed.
+func WithTimeoutIncludesBody(b bool) Option {
+	return optionFunc(func(c *config) {
+		c.timeoutIncludesBody = b
+	})
 }
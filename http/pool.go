@@ -1,11 +1,198 @@
 package http
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/lestrrat/go-circuit-breaker/breaker"
 	"github.com/pkg/errors"
 )
 
+// DefaultStatusValidator is the StatusValidator used unless another
+// one is specified. It treats 5XX status codes as failures.
+func DefaultStatusValidator(res *http.Response) error {
+	if res.StatusCode > 499 {
+		return errors.Wrapf(ErrBadStatus, "received bad status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// validate runs the validator against a response. Responses are only
+// validated if the request succeeded, as there is no response otherwise
+func validate(res *http.Response, err error, v StatusValidator) error {
+	if err != nil || res == nil || v == nil {
+		return err
+	}
+	return v(res)
+}
+
+// holdOff keeps the breaker open until the time given in the
+// Retry-After header of 429 and 503 responses. b is nil unless
+// the client was asked to respect the header.
+func holdOff(b breaker.Breaker, c breaker.Clock, res *http.Response, err error) {
+	if b == nil || err != nil || res == nil {
+		return
+	}
+
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return
+	}
+
+	if t, ok := parseRetryAfter(res.Header.Get("Retry-After"), c.Now()); ok {
+		b.TripUntil(t)
+	}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, which is
+// either a number of seconds or an HTTP date
+func parseRetryAfter(v string, now time.Time) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return time.Time{}, false
+		}
+		return now.Add(time.Duration(secs) * time.Second), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+const (
+	ctxIdle int32 = iota
+	ctxRunning
+	ctxDone
+	ctxAbandoned
+)
+
+// pooledCtx is implemented by the pooled circuits
+type pooledCtx interface {
+	breaker.Circuit
+	common() *ctxCommon
+	release()
+	roundTrip() (*http.Response, error)
+}
+
+func (c *ctxCommon) common() *ctxCommon {
+	return c
+}
+
+// execute runs the request of a pooled circuit, and records its
+// outcome. If the caller stopped waiting for the circuit while the
+// request was running, nobody is going to read the response, so the
+// circuit releases it along with itself
+func execute(c pooledCtx) error {
+	cc := c.common()
+	if !atomic.CompareAndSwapInt32(&cc.state, ctxIdle, ctxRunning) {
+		// The caller gave up before the circuit got to run
+		c.release()
+		return context.Canceled
+	}
+
+	res, err := c.roundTrip()
+	holdOff(cc.Breaker, cc.Clock, res, err)
+	err = validate(res, err, cc.Validator)
+	cc.Response, cc.Error = res, err
+
+	if !atomic.CompareAndSwapInt32(&cc.state, ctxRunning, ctxDone) {
+		closeResponse(res)
+		c.release()
+	}
+	return err
+}
+
+// abandon gives up the ownership of the circuit if it has not
+// completed yet. It returns false if the circuit has completed, in
+// which case the caller is responsible for releasing it
+func (c *ctxCommon) abandon() bool {
+	for {
+		st := atomic.LoadInt32(&c.state)
+		if st == ctxDone {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&c.state, st, ctxAbandoned) {
+			return true
+		}
+	}
+}
+
+// callPooled calls a pooled circuit through the breaker. The circuit
+// is released once the call completes, or once the request completes
+// if the call did not wait for it, e.g. because it timed out. In the
+// latter case, the response is discarded. The status code of the
+// response is returned even if the response is not.
+func callPooled(b breaker.Breaker, c pooledCtx, ctx context.Context, timeout time.Duration) (*http.Response, int, error) {
+	err := b.Call(c, callOptions(ctx, timeout)...)
+
+	cc := c.common()
+	ran := atomic.LoadInt32(&cc.state) != ctxIdle
+	if cc.abandon() {
+		if err == nil && !ran {
+			// The breaker rejected the call, and its fallback
+			// succeeded. There is no response to return, so the
+			// rejection is reported anyway
+			err = errors.Wrap(breaker.ErrBreakerOpen, "circuit was not executed")
+		}
+		return nil, 0, err
+	}
+
+	res, resErr, drain := cc.Response, cc.Error, cc.DrainOnFailure
+	c.release()
+	status := statusCode(res)
+	if err != nil {
+		if res != nil && resErr != nil && !drain {
+			// The response was rejected by the validator, and the
+			// caller wants to read it
+			return res, status, err
+		}
+		// The response, if any, is not returned to the caller, so
+		// it must be closed here
+		drainResponse(res)
+		return nil, status, err
+	}
+	return res, status, resErr
+}
+
+// statusCode returns the status code of the response, or 0 if there
+// is no response
+func statusCode(res *http.Response) int {
+	if res == nil {
+		return 0
+	}
+	return res.StatusCode
+}
+
+func closeResponse(res *http.Response) {
+	if res != nil {
+		res.Body.Close()
+	}
+}
+
+// maxDrainSize is the maximum number of bytes read from the body of
+// a response that is drained. Larger bodies are merely closed, as
+// reusing the connection is not worth reading them. It is larger than
+// the amount that net/http drains on its own when a body is closed
+const maxDrainSize = 1 << 20
+
+// drainResponse reads what is left of the body of a response, so that
+// its connection can be reused, and closes it
+func drainResponse(res *http.Response) {
+	if res != nil {
+		io.CopyN(io.Discard, res.Body, maxDrainSize)
+		res.Body.Close()
+	}
+}
+
 var doCtxPool = sync.Pool{New: allocDoCtx}
 
 // return a doCtx type
@@ -18,20 +205,50 @@ func allocDoCtx() interface{} {
 }
 
 func releaseDoCtx(c *doCtx) {
-	c.Error = nil
-	c.ErrorOnBadStatus = false
-	c.Request = nil
-	c.Response = nil
+	*c = doCtx{}
 	doCtxPool.Put(c)
 }
 
 // Execute fulfills the Circuit interface
 func (c *doCtx) Execute() error {
-	c.Response, c.Error = c.Client.Do(c.Request)
-	if c.ErrorOnBadStatus && c.Response.StatusCode > 499 {
-		c.Error = errors.Wrapf(ErrBadStatus, "received bad status %d", c.Response.StatusCode)
-	}
-	return c.Error
+	return execute(c)
+}
+
+func (c *doCtx) release() {
+	releaseDoCtx(c)
+}
+
+func (c *doCtx) roundTrip() (*http.Response, error) {
+	return c.Client.Do(c.Request)
+}
+
+var roundTripCtxPool = sync.Pool{New: allocRoundTripCtx}
+
+// return a roundTripCtx type
+func getRoundTripCtx() *roundTripCtx {
+	return roundTripCtxPool.Get().(*roundTripCtx)
+}
+
+func allocRoundTripCtx() interface{} {
+	return &roundTripCtx{}
+}
+
+func releaseRoundTripCtx(c *roundTripCtx) {
+	*c = roundTripCtx{}
+	roundTripCtxPool.Put(c)
+}
+
+// Execute fulfills the Circuit interface
+func (c *roundTripCtx) Execute() error {
+	return execute(c)
+}
+
+func (c *roundTripCtx) release() {
+	releaseRoundTripCtx(c)
+}
+
+func (c *roundTripCtx) roundTrip() (*http.Response, error) {
+	return c.Transport.RoundTrip(c.Request)
 }
 
 var getCtxPool = sync.Pool{New: allocGetCtx}
@@ -46,20 +263,21 @@ func allocGetCtx() interface{} {
 }
 
 func releaseGetCtx(c *getCtx) {
-	c.Error = nil
-	c.ErrorOnBadStatus = false
-	c.URL = ""
-	c.Response = nil
+	*c = getCtx{}
 	getCtxPool.Put(c)
 }
 
 // Execute fulfills the Circuit interface
 func (c *getCtx) Execute() error {
-	c.Response, c.Error = c.Client.Get(c.URL)
-	if c.ErrorOnBadStatus && c.Response.StatusCode > 499 {
-		c.Error = errors.Wrapf(ErrBadStatus, "received bad status %d", c.Response.StatusCode)
-	}
-	return c.Error
+	return execute(c)
+}
+
+func (c *getCtx) release() {
+	releaseGetCtx(c)
+}
+
+func (c *getCtx) roundTrip() (*http.Response, error) {
+	return c.Client.Get(c.URL)
 }
 
 var headCtxPool = sync.Pool{New: allocHeadCtx}
@@ -74,20 +292,21 @@ func allocHeadCtx() interface{} {
 }
 
 func releaseHeadCtx(c *headCtx) {
-	c.Error = nil
-	c.ErrorOnBadStatus = false
-	c.URL = ""
-	c.Response = nil
+	*c = headCtx{}
 	headCtxPool.Put(c)
 }
 
 // Execute fulfills the Circuit interface
 func (c *headCtx) Execute() error {
-	c.Response, c.Error = c.Client.Head(c.URL)
-	if c.ErrorOnBadStatus && c.Response.StatusCode > 499 {
-		c.Error = errors.Wrapf(ErrBadStatus, "received bad status %d", c.Response.StatusCode)
-	}
-	return c.Error
+	return execute(c)
+}
+
+func (c *headCtx) release() {
+	releaseHeadCtx(c)
+}
+
+func (c *headCtx) roundTrip() (*http.Response, error) {
+	return c.Client.Head(c.URL)
 }
 
 var postCtxPool = sync.Pool{New: allocPostCtx}
@@ -102,22 +321,21 @@ func allocPostCtx() interface{} {
 }
 
 func releasePostCtx(c *postCtx) {
-	c.Body = nil
-	c.BodyType = ""
-	c.Error = nil
-	c.ErrorOnBadStatus = false
-	c.URL = ""
-	c.Response = nil
+	*c = postCtx{}
 	postCtxPool.Put(c)
 }
 
 // Execute fulfills the Circuit interface
 func (c *postCtx) Execute() error {
-	c.Response, c.Error = c.Client.Post(c.URL, c.BodyType, c.Body)
-	if c.ErrorOnBadStatus && c.Response.StatusCode > 499 {
-		c.Error = errors.Wrapf(ErrBadStatus, "received bad status %d", c.Response.StatusCode)
-	}
-	return c.Error
+	return execute(c)
+}
+
+func (c *postCtx) release() {
+	releasePostCtx(c)
+}
+
+func (c *postCtx) roundTrip() (*http.Response, error) {
+	return c.Client.Post(c.URL, c.BodyType, c.Body)
 }
 
 var postFormCtxPool = sync.Pool{New: allocPostFormCtx}
@@ -132,18 +350,19 @@ func allocPostFormCtx() interface{} {
 }
 
 func releasePostFormCtx(c *postFormCtx) {
-	c.Error = nil
-	c.ErrorOnBadStatus = false
-	c.URL = ""
-	c.Response = nil
+	*c = postFormCtx{}
 	postFormCtxPool.Put(c)
 }
 
 // Execute fulfills the Circuit interface
 func (c *postFormCtx) Execute() error {
-	c.Response, c.Error = c.Client.PostForm(c.URL, c.Data)
-	if c.ErrorOnBadStatus && c.Response.StatusCode > 499 {
-		c.Error = errors.Wrapf(ErrBadStatus, "received bad status %d", c.Response.StatusCode)
-	}
-	return c.Error
+	return execute(c)
+}
+
+func (c *postFormCtx) release() {
+	releasePostFormCtx(c)
+}
+
+func (c *postFormCtx) roundTrip() (*http.Response, error) {
+	return c.Client.PostForm(c.URL, c.Data)
 }
@@ -0,0 +1,123 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// NewRecorder creates a Recorder that keeps the last size requests
+// of each breaker
+func NewRecorder(size int) *Recorder {
+	if size <= 0 {
+		size = DefaultRecorderSize
+	}
+	return &Recorder{
+		logs: make(map[string]*requestLog),
+		size: size,
+	}
+}
+
+// Record adds a request to the requests of the named breaker,
+// replacing the oldest one if there are too many
+func (r *Recorder) Record(name string, rec RequestRecord) {
+	r.mutex.RLock()
+	l, ok := r.logs[name]
+	r.mutex.RUnlock()
+	if !ok {
+		r.mutex.Lock()
+		if l, ok = r.logs[name]; !ok {
+			l = &requestLog{size: r.size}
+			r.logs[name] = l
+		}
+		r.mutex.Unlock()
+	}
+	l.add(rec)
+}
+
+// Requests returns the last requests of the named breaker, from the
+// oldest to the most recent one
+func (r *Recorder) Requests(name string) []RequestRecord {
+	r.mutex.RLock()
+	l, ok := r.logs[name]
+	r.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+	return l.list()
+}
+
+// Delete forgets the requests of the named breaker, e.g. once the
+// breaker is removed from its map
+func (r *Recorder) Delete(name string) {
+	r.mutex.Lock()
+	delete(r.logs, name)
+	r.mutex.Unlock()
+}
+
+func (l *requestLog) add(rec RequestRecord) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.entries) < l.size {
+		l.entries = append(l.entries, rec)
+		return
+	}
+	l.entries[l.next] = rec
+	l.next = (l.next + 1) % l.size
+}
+
+func (l *requestLog) list() []RequestRecord {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	list := make([]RequestRecord, 0, len(l.entries))
+	list = append(list, l.entries[l.next:]...)
+	return append(list, l.entries[:l.next]...)
+}
+
+func (d Decision) String() string {
+	switch d {
+	case Allowed:
+		return "allowed"
+	case Rejected:
+		return "rejected"
+	case Forced:
+		return "forced"
+	default:
+		return "unknown"
+	}
+}
+
+// recordRequest notifies the recorder, if any, of a request sent
+// through the breaker b. start is the time the request was sent, and
+// status the status code of the response, even if it was discarded
+func recordRequest(rr RequestRecorder, l BreakerLookupper, c breaker.Clock, b breaker.Breaker, req *http.Request, start time.Time, status int, err error) {
+	if rr == nil {
+		return
+	}
+
+	rec := RequestRecord{
+		Time:       start,
+		Method:     requestMethod(req),
+		StatusCode: status,
+		Latency:    c.Now().Sub(start),
+		Err:        err,
+	}
+	if req.URL != nil {
+		rec.URL = req.URL.String()
+	}
+	switch {
+	case breaker.IsOpen(err), breaker.IsTooManyConcurrent(err):
+		rec.Decision = Rejected
+	case isForced(req.Context()):
+		rec.Decision = Forced
+	}
+
+	name := lookupBreakerName(l, req)
+	if name == "" {
+		name = b.Name()
+	}
+	rr.Record(name, rec)
+}
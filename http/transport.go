@@ -0,0 +1,69 @@
+package http
+
+import (
+	"net/http"
+)
+
+// NewTransport creates a new http.RoundTripper where requests are
+// controlled via the provided circuit breaker(s). This allows existing
+// http.Client objects (including those used in third-party libraries)
+// to be protected by breakers. The mandatory argument `l` is an object
+// that provides the breaker to be used for the given request.
+//
+// As required by http.RoundTripper, responses are returned without an
+// error whatever their status code, even if they are recorded as
+// failures by the breaker. RoundTrip fails with a *BreakerOpenError
+// when the breaker rejects the request.
+//
+// Possible optional parameters:
+// * WithTransport: specify the underlying http.RoundTripper
+// * WithErrorOnBadStatus: specify if you want the breaker to consider 5XX status codes as errors
+// * WithStatusValidator: specify how responses are mapped to failures
+// * WithRetryAfter: specify if you want the breaker to honor Retry-After headers
+// * WithClock: specify the clock used to compute the time given by Retry-After headers
+// * WithTimeout: specify the timeout of requests, after which they are recorded as failures
+// * WithRecorder: specify the recorder notified of the requests sent through breakers
+func NewTransport(l BreakerLookupper, options ...Option) *Transport {
+	cfg := newConfig(options)
+	t := cfg.transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+
+	return &Transport{
+		clock:      cfg.clock,
+		retryAfter: cfg.retryAfter,
+		lookup:     l,
+		recorder:   cfg.recorder,
+		timeout:    cfg.timeout,
+		validator:  cfg.validator,
+		transport:  t,
+	}
+}
+
+// RoundTrip fulfills the http.RoundTripper interface
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := lookupBreaker(t.lookup, req)
+	if b == nil {
+		return t.transport.RoundTrip(req)
+	}
+
+	ctx := getRoundTripCtx()
+	if t.retryAfter {
+		ctx.Breaker = b
+	}
+	ctx.Clock = t.clock
+	ctx.DrainOnFailure = false
+	ctx.Validator = t.validator
+	ctx.Request = req
+	ctx.Transport = t.transport
+	start := t.clock.Now()
+	res, status, err := callPooled(b, ctx, req.Context(), t.timeout)
+	err = openError(t.lookup, b, req, err)
+	recordRequest(t.recorder, t.lookup, t.clock, b, req, start, status, err)
+	if res != nil {
+		// The failure, if any, has been recorded by the breaker
+		return res, nil
+	}
+	return nil, err
+}
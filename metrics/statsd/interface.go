@@ -0,0 +1,47 @@
+package statsd
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// DefaultPrefix is the prefix of the metric names when no prefix
+// is specified
+const DefaultPrefix = "breaker."
+
+// DefaultFlushInterval is the interval at which metrics are sent
+// when no interval is specified
+const DefaultFlushInterval = 10 * time.Second
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*Reporter)
+}
+
+type optionFunc func(*Reporter)
+
+// Subscriber is implemented by breaker.EventEmitter and
+// breaker.MapEmitter
+type Subscriber interface {
+	Subscribe(context.Context, ...breaker.SubscribeOption) *breaker.EventSubscription
+}
+
+// Reporter sends breaker events and periodic snapshots of breaker
+// counters to a statsd server
+type Reporter struct {
+	breakers    map[string]breaker.Breaker
+	buf         []byte
+	clock       breaker.Clock
+	dogstatsd   bool
+	handleError func(error)
+	interval    time.Duration
+	maps        []breaker.Map
+	mutex       sync.Mutex
+	prefix      string
+	tags        []string
+	w           io.WriteCloser
+}
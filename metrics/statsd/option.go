@@ -0,0 +1,64 @@
+package statsd
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(r *Reporter) {
+	f(r)
+}
+
+// WithPrefix is used to specify the prefix of the metric names.
+// The default is DefaultPrefix
+func WithPrefix(v string) Option {
+	return optionFunc(func(r *Reporter) {
+		r.prefix = v
+	})
+}
+
+// WithDogStatsD is used to specify if the breaker names are sent as
+// a "breaker" DogStatsD tag. By default, the breaker names are part
+// of the metric names, as plain statsd does not support tags. The
+// labels of the breakers (see breaker.WithLabels) are sent as tags
+// too, and are not sent at all with plain statsd
+func WithDogStatsD(v bool) Option {
+	return optionFunc(func(r *Reporter) {
+		r.dogstatsd = v
+	})
+}
+
+// WithTags is used to specify DogStatsD tags, such as "env:prod",
+// added to every metric. The tags are only sent if WithDogStatsD
+// is enabled
+func WithTags(v ...string) Option {
+	return optionFunc(func(r *Reporter) {
+		r.tags = v
+	})
+}
+
+// WithFlushInterval is used to specify the interval at which Run
+// sends metrics. The default is DefaultFlushInterval
+func WithFlushInterval(v time.Duration) Option {
+	return optionFunc(func(r *Reporter) {
+		r.interval = v
+	})
+}
+
+// WithClock is used to specify the clock used by Run to schedule
+// flushes
+func WithClock(v breaker.Clock) Option {
+	return optionFunc(func(r *Reporter) {
+		r.clock = v
+	})
+}
+
+// WithErrorHandler is used to specify a function that is called with
+// the errors that occur while sending metrics in the background. By
+// default, errors are ignored
+func WithErrorHandler(v func(error)) Option {
+	return optionFunc(func(r *Reporter) {
+		r.handleError = v
+	})
+}
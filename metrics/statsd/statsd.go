@@ -0,0 +1,270 @@
+// Package statsd sends circuit breaker events and counters to a
+// statsd server, optionally using DogStatsD tags.
+//
+//	r, err := statsd.NewReporter("127.0.0.1:8125", statsd.WithDogStatsD(true))
+//	if err != nil {
+//		...
+//	}
+//	defer r.Close()
+//
+//	r.AddMap(m)
+//	go r.Watch(ctx, breaker.NewMapEmitter(m))
+//	go r.Run(ctx)
+//
+// Events are sent as counters, and snapshots of the counters of the
+// registered breakers are sent as gauges at every flush.
+package statsd
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/pkg/errors"
+)
+
+// eventBufferSize is the number of events that may be waiting to be
+// counted. Further events are dropped
+const eventBufferSize = 64
+
+// maxPacketSize is the size above which metrics are split into
+// several packets, so that they fit in the MTU of most networks
+const maxPacketSize = 1432
+
+// sanitizer replaces the characters that have a special meaning in
+// the statsd protocol
+var sanitizer = strings.NewReplacer(":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "\n", "_")
+
+// NewReporter creates a Reporter that sends metrics over UDP to
+// the statsd server listening on addr.
+//
+// Possible optional parameters:
+// * WithPrefix: specify the prefix of the metric names
+// * WithDogStatsD: specify if breaker names and labels are sent as DogStatsD tags
+// * WithTags: specify DogStatsD tags added to every metric
+// * WithFlushInterval: specify the interval at which Run sends metrics
+// * WithClock: specify the clock used by Run
+// * WithErrorHandler: specify a function that receives the errors that occur while sending metrics
+func NewReporter(addr string, options ...Option) (*Reporter, error) {
+	r := &Reporter{
+		breakers: make(map[string]breaker.Breaker),
+		clock:    breaker.SystemClock,
+		interval: DefaultFlushInterval,
+		prefix:   DefaultPrefix,
+	}
+	for _, option := range options {
+		option.apply(r)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to statsd")
+	}
+	r.w = conn
+	return r, nil
+}
+
+// Add registers a breaker, whose counters are sent at every flush
+// under the given name
+func (r *Reporter) Add(name string, cb breaker.Breaker) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.breakers[name] = cb
+}
+
+// AddMap registers all breakers of the Map, including those added
+// to it later on
+func (r *Reporter) AddMap(m breaker.Map) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.maps = append(r.maps, m)
+}
+
+// Remove unregisters the breaker registered under the given name
+func (r *Reporter) Remove(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.breakers, name)
+}
+
+// Watch subscribes to s, and counts its events until ctx is canceled.
+// The counts are sent at the next flush.
+func (r *Reporter) Watch(ctx context.Context, s Subscriber) {
+	sub := s.Subscribe(ctx, breaker.WithBufferSize(eventBufferSize))
+	defer sub.Stop()
+
+	for {
+		select {
+		case <-sub.Done():
+			return
+		case data := <-sub.Data:
+			r.count(data)
+		}
+	}
+}
+
+// count adds the counter for an event
+func (r *Reporter) count(data breaker.EventData) {
+	var metric string
+	switch data.Event {
+	case breaker.TrippedEvent:
+		metric = "tripped"
+	case breaker.ResetEvent:
+		metric = "reset"
+	case breaker.FailEvent:
+		metric = "failed"
+	case breaker.HalfopenEvent:
+		metric = "halfopen"
+	default:
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.add(data.Name, data.Labels, metric, "1", "c")
+}
+
+// Run flushes the metrics at every flush interval, until ctx is
+// canceled. The pending metrics are flushed before Run returns.
+func (r *Reporter) Run(ctx context.Context) {
+	t := breaker.NewTicker(r.clock, r.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.flush()
+			return
+		case <-t.C():
+			r.flush()
+		}
+	}
+}
+
+// flush flushes the metrics, passing errors to the error handler
+func (r *Reporter) flush() {
+	if err := r.Flush(); err != nil && r.handleError != nil {
+		r.handleError(err)
+	}
+}
+
+// Flush adds the gauges of the registered breakers to the pending
+// metrics, and sends them
+func (r *Reporter) Flush() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for name, cb := range r.breakers {
+		r.snapshot(name, cb)
+	}
+	for _, m := range r.maps {
+		m.Range(func(name string, cb breaker.Breaker) bool {
+			r.snapshot(name, cb)
+			return true
+		})
+	}
+	return r.send()
+}
+
+// snapshot adds the gauges of a breaker. snapshot assumes that the
+// caller has locked the mutex
+func (r *Reporter) snapshot(name string, cb breaker.Breaker) {
+	s := cb.Snapshot()
+	labels := cb.Labels()
+	open := "0"
+	if s.State != breaker.Closed {
+		open = "1"
+	}
+	r.add(name, labels, "open", open, "g")
+	r.add(name, labels, "failures", strconv.FormatInt(s.Failures, 10), "g")
+	r.add(name, labels, "successes", strconv.FormatInt(s.Successes, 10), "g")
+	r.add(name, labels, "consecutive_failures", strconv.FormatInt(s.ConsecFailures, 10), "g")
+	r.add(name, labels, "error_rate", strconv.FormatFloat(s.ErrorRate, 'f', -1, 64), "g")
+	r.add(name, labels, "trips", strconv.FormatInt(s.Trips, 10), "g")
+	r.add(name, labels, "open_time", strconv.FormatFloat(s.OpenTime.Seconds(), 'f', -1, 64), "g")
+	r.add(name, labels, "in_flight", strconv.FormatInt(cb.InFlight(), 10), "g")
+}
+
+// add formats a metric and adds it to the pending metrics, sending
+// them first if the packet would grow too large. add assumes that
+// the caller has locked the mutex
+func (r *Reporter) add(name string, labels map[string]string, metric, value, typ string) {
+	name = sanitizer.Replace(name)
+
+	line := r.prefix
+	if !r.dogstatsd && name != "" {
+		line += name + "."
+	}
+	line += metric + ":" + value + "|" + typ
+
+	if r.dogstatsd {
+		tags := r.tags
+		if name != "" {
+			tags = append(tags[:len(tags):len(tags)], "breaker:"+name)
+		}
+		tags = appendLabels(tags, labels)
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+	}
+
+	if len(r.buf) > 0 && len(r.buf)+1+len(line) > maxPacketSize {
+		if err := r.send(); err != nil && r.handleError != nil {
+			r.handleError(err)
+		}
+	}
+	if len(r.buf) > 0 {
+		r.buf = append(r.buf, '\n')
+	}
+	r.buf = append(r.buf, line...)
+}
+
+// appendLabels appends labels to tags as "key:value" tags, sorted by
+// key. tags is never modified in place
+func appendLabels(tags []string, labels map[string]string) []string {
+	if len(labels) == 0 {
+		return tags
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tags = tags[:len(tags):len(tags)]
+	for _, k := range keys {
+		tags = append(tags, sanitizer.Replace(k)+":"+sanitizer.Replace(labels[k]))
+	}
+	return tags
+}
+
+// send sends the pending metrics. send assumes that the caller has
+// locked the mutex
+func (r *Reporter) send() error {
+	if len(r.buf) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(r.buf)
+	r.buf = r.buf[:0]
+	if err != nil {
+		return errors.Wrap(err, "failed to send metrics")
+	}
+	return nil
+}
+
+// Close sends the pending metrics, and closes the connection to the
+// statsd server
+func (r *Reporter) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.send()
+	if cerr := r.w.Close(); err == nil && cerr != nil {
+		err = errors.Wrap(cerr, "failed to close connection")
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package statsd_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/metrics/statsd"
+	"github.com/stretchr/testify/assert"
+)
+
+// listen starts a fake statsd server, and returns its address and a
+// function that reads the lines of the next packet
+func listen(t *testing.T) (string, func() []string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	read := func() []string {
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Errorf("failed to read packet: %s", err)
+			return nil
+		}
+		return strings.Split(string(buf[:n]), "\n")
+	}
+	return conn.LocalAddr().String(), read, func() { conn.Close() }
+}
+
+func TestFlush(t *testing.T) {
+	addr, read, stop := listen(t)
+	defer stop()
+
+	r, err := statsd.NewReporter(addr)
+	if !assert.NoError(t, err, "NewReporter should succeed") {
+		return
+	}
+	defer r.Close()
+
+	cb := breaker.New()
+	cb.Trip()
+	r.Add("payments", cb)
+	if !assert.NoError(t, r.Flush(), "Flush should succeed") {
+		return
+	}
+
+	lines := read()
+	if !assert.Contains(t, lines, "breaker.payments.open:1|g", "open state should be sent") {
+		return
+	}
+	if !assert.Contains(t, lines, "breaker.payments.trips:1|g", "trips should be sent") {
+		return
+	}
+}
+
+func TestDogStatsD(t *testing.T) {
+	addr, read, stop := listen(t)
+	defer stop()
+
+	r, err := statsd.NewReporter(addr, statsd.WithDogStatsD(true), statsd.WithTags("env:test"), statsd.WithPrefix("cb."))
+	if !assert.NoError(t, err, "NewReporter should succeed") {
+		return
+	}
+	defer r.Close()
+
+	m := breaker.NewMap()
+	m.Set("payments", breaker.New())
+	m.Set("search", breaker.New(breaker.WithLabels(map[string]string{"tier": "1", "region": "eu"})))
+	r.AddMap(m)
+	if !assert.NoError(t, r.Flush(), "Flush should succeed") {
+		return
+	}
+
+	lines := read()
+	if !assert.Contains(t, lines, "cb.open:0|g|#env:test,breaker:payments", "breaker names should be sent as tags") {
+		return
+	}
+	if !assert.Contains(t, lines, "cb.open:0|g|#env:test,breaker:search,region:eu,tier:1", "breaker labels should be sent as tags") {
+		return
+	}
+}
+
+func TestWatch(t *testing.T) {
+	addr, read, stop := listen(t)
+	defer stop()
+
+	r, err := statsd.NewReporter(addr, statsd.WithFlushInterval(10*time.Millisecond))
+	if !assert.NoError(t, err, "NewReporter should succeed") {
+		return
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cb := breaker.NewEventEmitter(breaker.New(breaker.WithName("payments")))
+	r.Add("payments", cb)
+	go r.Watch(ctx, cb)
+	go r.Run(ctx)
+
+	// Watch subscribes asynchronously, so keep tripping the breaker
+	// until the event is counted. The gauges of the breaker are sent
+	// at every flush, so reading does not block
+	timeout := time.After(5 * time.Second)
+	for {
+		cb.Trip()
+		for _, line := range read() {
+			if line == "breaker.payments.tripped:1|c" {
+				return
+			}
+		}
+		select {
+		case <-timeout:
+			t.Errorf("timed out waiting for the tripped event")
+			return
+		default:
+		}
+		cb.Reset()
+	}
+}
@@ -0,0 +1,30 @@
+package netbreaker
+
+import (
+	"context"
+	"net"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*Dialer)
+}
+
+type optionFunc func(*Dialer)
+
+// ContextDialer is the interface implemented by *net.Dialer and other
+// objects that dial connections
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// Dialer dials connections through breakers keyed by the address being
+// dialed. Its DialContext method can be used as the DialContext field
+// of http.Transport and similar connection pools.
+type Dialer struct {
+	addrs   breaker.Map
+	dialer  ContextDialer
+	factory breaker.BreakerFactory
+}
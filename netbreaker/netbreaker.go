@@ -0,0 +1,102 @@
+// Package netbreaker provides a dialer that stops dialing hosts whose
+// connections repeatedly fail or time out.
+package netbreaker
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/pkg/errors"
+)
+
+// NewDialer creates a Dialer that looks up breakers in the given map
+// using the address being dialed, e.g. "example.com:443", as the key.
+// Dial errors, including timeouts, are recorded as failures. Once the
+// breaker for an address is open, dialing fails immediately with an
+// error for which breaker.IsOpen returns true, without resolving the
+// address or creating a socket.
+//
+// Possible optional parameters:
+// * WithBreakerFactory: specify the function used to create breakers for unknown addresses
+// * WithDialer: specify the dialer used to dial connections
+func NewDialer(addrs breaker.Map, options ...Option) *Dialer {
+	d := &Dialer{
+		addrs:  addrs,
+		dialer: &net.Dialer{},
+	}
+	for _, option := range options {
+		option.apply(d)
+	}
+	return d
+}
+
+// Dial connects to the address on the named network
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the address on the named network using the
+// provided context. If the context is canceled while dialing, the
+// error is not recorded as a failure, as the caller gave up on the
+// connection.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	cb := d.breakerLookup(addr)
+	if cb == nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+
+	// The breaker records nothing if ctx is canceled, so dial errors
+	// are returned as is
+	var mu sync.Mutex
+	var conn net.Conn
+	var dialErr error
+	var abandoned bool
+	err := cb.Call(breaker.CircuitFunc(func() error {
+		c, err := d.dialer.DialContext(ctx, network, addr)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if abandoned {
+			// The call timed out, and nobody is going to use the
+			// connection
+			if c != nil {
+				c.Close()
+			}
+			return err
+		}
+		conn, dialErr = c, err
+		return err
+	}), breaker.WithContext(ctx))
+
+	mu.Lock()
+	defer mu.Unlock()
+	abandoned = true
+	switch {
+	case err != nil:
+		if conn != nil {
+			// The dial completed after the call gave up on it
+			conn.Close()
+		}
+		return nil, err
+	case conn == nil && dialErr != nil:
+		// The fallback of the breaker handled the dial error
+		return nil, dialErr
+	case conn == nil:
+		// The fallback of the breaker handled the rejection
+		return nil, errors.Wrap(breaker.ErrBreakerOpen, "circuit was not executed")
+	}
+	return conn, nil
+}
+
+func (d *Dialer) breakerLookup(addr string) breaker.Breaker {
+	if d.factory != nil {
+		return d.addrs.GetOrCreate(addr, d.factory)
+	}
+	cb, ok := d.addrs.Get(addr)
+	if !ok {
+		return nil
+	}
+	return cb
+}
@@ -0,0 +1,170 @@
+package netbreaker_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/netbreaker"
+)
+
+type countingDialer struct {
+	dialed int
+	dialer net.Dialer
+}
+
+func (d *countingDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.dialed++
+	return d.dialer.DialContext(ctx, network, addr)
+}
+
+func TestDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err, "net.Listen should succeed") {
+		return
+	}
+	defer l.Close()
+	alive := l.Addr().String()
+
+	// Grab an address where nobody is listening
+	dl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err, "net.Listen should succeed") {
+		return
+	}
+	dead := dl.Addr().String()
+	dl.Close()
+
+	addrs := breaker.NewMap()
+	var cd countingDialer
+	d := netbreaker.NewDialer(addrs,
+		netbreaker.WithDialer(&cd),
+		netbreaker.WithBreakerFactory(func() breaker.Breaker {
+			return breaker.New(
+				breaker.WithClock(clock.NewMock()),
+				breaker.WithTripper(breaker.ConsecutiveTripper(2)),
+			)
+		}),
+	)
+
+	for i := 0; i < 2; i++ {
+		_, err := d.Dial("tcp", dead)
+		if !assert.Error(t, err, "dialing a dead address should fail") {
+			return
+		}
+	}
+	cb, ok := addrs.Get(dead)
+	if !assert.True(t, ok, "breaker should be created for the address") {
+		return
+	}
+	if !assert.True(t, cb.Tripped(), "dial errors should trip the breaker") {
+		return
+	}
+
+	cd.dialed = 0
+	_, err = d.Dial("tcp", dead)
+	if !assert.True(t, breaker.IsOpen(err), "dialing should be rejected while the breaker is open") {
+		return
+	}
+	if !assert.Equal(t, 0, cd.dialed, "address should not be dialed while the breaker is open") {
+		return
+	}
+
+	conn, err := d.Dial("tcp", alive)
+	if !assert.NoError(t, err, "other addresses should not be affected") {
+		return
+	}
+	conn.Close()
+}
+
+func TestDialerCanceled(t *testing.T) {
+	cb := breaker.New(
+		breaker.WithClock(clock.NewMock()),
+		breaker.WithTripper(breaker.ConsecutiveTripper(1)),
+	)
+	addrs := breaker.NewMap()
+	addrs.Set("192.0.2.1:80", cb)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := netbreaker.NewDialer(addrs).DialContext(ctx, "tcp", "192.0.2.1:80")
+	if !assert.Error(t, err, "dialing with a canceled context should fail") {
+		return
+	}
+	if !assert.False(t, cb.Tripped(), "canceled dials should not trip the breaker") {
+		return
+	}
+
+	// Canceled dials are not recorded as successes either
+	cb = breaker.New(
+		breaker.WithClock(clock.NewMock()),
+		breaker.WithTripper(breaker.ConsecutiveTripper(2)),
+	)
+	addrs.Set("192.0.2.1:80", cb)
+	cb.MarkFailure(nil)
+	_, err = netbreaker.NewDialer(addrs).DialContext(ctx, "tcp", "192.0.2.1:80")
+	if !assert.Error(t, err, "dialing with a canceled context should fail") {
+		return
+	}
+	if !assert.Equal(t, int64(1), cb.ConsecFailures(), "canceled dials should not reset consecutive failures") {
+		return
+	}
+	if !assert.Equal(t, int64(0), cb.Successes(), "canceled dials should not be recorded as successes") {
+		return
+	}
+}
+
+// slowDialer waits before connecting, and keeps track of the
+// connections that were closed
+type slowDialer struct {
+	closed chan struct{}
+	delay  time.Duration
+	dialer net.Dialer
+}
+
+type trackedConn struct {
+	net.Conn
+	closed chan struct{}
+}
+
+func (c *trackedConn) Close() error {
+	close(c.closed)
+	return c.Conn.Close()
+}
+
+func (d *slowDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	time.Sleep(d.delay)
+	conn, err := d.dialer.DialContext(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{Conn: conn, closed: d.closed}, nil
+}
+
+func TestDialerTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err, "net.Listen should succeed") {
+		return
+	}
+	defer l.Close()
+
+	addrs := breaker.NewMap()
+	addrs.Set(l.Addr().String(), breaker.New(breaker.WithTimeout(10*time.Millisecond)))
+	sd := &slowDialer{closed: make(chan struct{}), delay: 100 * time.Millisecond}
+	d := netbreaker.NewDialer(addrs, netbreaker.WithDialer(sd))
+
+	_, err = d.Dial("tcp", l.Addr().String())
+	if !assert.True(t, breaker.IsTimeout(err), "slow dial should time out") {
+		return
+	}
+
+	select {
+	case <-sd.closed:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "connection dialed after the timeout should be closed")
+	}
+}
@@ -0,0 +1,27 @@
+package netbreaker
+
+import (
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(d *Dialer) {
+	f(d)
+}
+
+// WithBreakerFactory is used to specify the function that creates
+// breakers for addresses that do not have a breaker associated with
+// them. Without a factory, connections to such addresses are not
+// protected.
+func WithBreakerFactory(v breaker.BreakerFactory) Option {
+	return optionFunc(func(d *Dialer) {
+		d.factory = v
+	})
+}
+
+// WithDialer is used to specify the dialer that actually dials the
+// connections. The default is a zero net.Dialer.
+func WithDialer(v ContextDialer) Option {
+	return optionFunc(func(d *Dialer) {
+		d.dialer = v
+	})
+}
@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*config)
+}
+
+type optionFunc func(*config)
+
+// config holds the values given by the options
+type config struct {
+	events      []breaker.Event
+	handleError func(error)
+	httpClient  HTTPClient
+}
+
+// Notifier sends a notification about an event
+type Notifier interface {
+	Notify(context.Context, breaker.EventData) error
+}
+
+// NotifierFunc is a Notifier represented as a function
+type NotifierFunc func(context.Context, breaker.EventData) error
+
+// Subscriber is implemented by breaker.EventEmitter and
+// breaker.MapEmitter
+type Subscriber interface {
+	Subscribe(context.Context, ...breaker.SubscribeOption) *breaker.EventSubscription
+}
+
+// HTTPClient is the interface used to send notifications over HTTP.
+// *http.Client satisfies it
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Webhook is a Notifier that posts events as JSON encoded Payloads
+type Webhook struct {
+	client HTTPClient
+	url    string
+}
+
+// Slack is a Notifier that posts events as messages to a Slack
+// incoming webhook
+type Slack struct {
+	client HTTPClient
+	url    string
+}
+
+// Payload is the body posted by Webhook
+type Payload struct {
+	Breaker        string    `json:"breaker"`
+	Event          string    `json:"event"`
+	From           string    `json:"from"`
+	To             string    `json:"to"`
+	Time           time.Time `json:"time"`
+	Failures       int64     `json:"failures"`
+	Successes      int64     `json:"successes"`
+	ConsecFailures int64     `json:"consecutive_failures"`
+	ErrorRate      float64   `json:"error_rate"`
+	Error          string    `json:"error,omitempty"`
+}
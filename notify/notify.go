@@ -0,0 +1,201 @@
+// Package notify sends notifications about breaker events, such as
+// trips and resets, to webhooks and chat services. This allows alerting
+// on breakers without setting up a metrics pipeline.
+//
+//	cb := breaker.NewEventEmitter(breaker.New(breaker.WithName("payments")))
+//	go notify.Watch(ctx, cb, notify.NewSlack(slackURL))
+//
+// Watch also accepts a breaker.MapEmitter, to be notified about all
+// breakers of a Map.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/pkg/errors"
+)
+
+// eventBufferSize is the number of events that may be waiting for
+// notifications to be sent. Further events are dropped, as waiting
+// for them would slow down the breakers
+const eventBufferSize = 64
+
+// Notify calls the function
+func (f NotifierFunc) Notify(ctx context.Context, data breaker.EventData) error {
+	return f(ctx, data)
+}
+
+// Watch subscribes to s, and calls n for each event until ctx is
+// canceled. Notifications are sent one at a time, and events that
+// arrive while too many notifications are pending are dropped.
+//
+// Possible optional parameters:
+// * WithEvents: specify the events to send notifications for
+// * WithErrorHandler: specify a function that receives the errors returned by n
+func Watch(ctx context.Context, s Subscriber, n Notifier, options ...Option) {
+	cfg := newConfig(options)
+
+	sub := s.Subscribe(ctx, breaker.WithBufferSize(eventBufferSize))
+	defer sub.Stop()
+
+	for {
+		select {
+		case <-sub.Done():
+			return
+		case data := <-sub.Data:
+			if !contains(cfg.events, data.Event) {
+				continue
+			}
+			if err := n.Notify(ctx, data); err != nil && cfg.handleError != nil {
+				cfg.handleError(err)
+			}
+		}
+	}
+}
+
+func contains(events []breaker.Event, ev breaker.Event) bool {
+	for _, e := range events {
+		if e == ev {
+			return true
+		}
+	}
+	return false
+}
+
+// eventName returns the name of the event used in notifications
+func eventName(ev breaker.Event) string {
+	switch ev {
+	case breaker.TrippedEvent:
+		return "tripped"
+	case breaker.ResetEvent:
+		return "reset"
+	case breaker.FailEvent:
+		return "failed"
+	case breaker.HalfopenEvent:
+		return "halfopen"
+	case breaker.StateChangeEvent:
+		return "state_change"
+	case breaker.AddedEvent:
+		return "added"
+	case breaker.RemovedEvent:
+		return "removed"
+	}
+	return fmt.Sprintf("(unknown:%d)", int(ev))
+}
+
+// NewPayload creates the Payload posted by Webhook for an event
+func NewPayload(data breaker.EventData) Payload {
+	p := Payload{
+		Breaker:        data.Name,
+		Event:          eventName(data.Event),
+		From:           data.From.String(),
+		To:             data.To.String(),
+		Time:           data.Time,
+		Failures:       data.Counts.Failures,
+		Successes:      data.Counts.Successes,
+		ConsecFailures: data.Counts.ConsecFailures,
+		ErrorRate:      data.Counts.ErrorRate,
+	}
+	if data.Err != nil {
+		p.Error = data.Err.Error()
+	}
+	return p
+}
+
+// NewWebhook creates a Notifier that posts a JSON encoded Payload to
+// url for each event.
+//
+// Possible optional parameters:
+// * WithHTTPClient: specify the client used to post the payloads
+func NewWebhook(url string, options ...Option) *Webhook {
+	return &Webhook{
+		client: httpClient(options),
+		url:    url,
+	}
+}
+
+// Notify posts the payload of the event
+func (w *Webhook) Notify(ctx context.Context, data breaker.EventData) error {
+	return post(ctx, w.client, w.url, NewPayload(data))
+}
+
+// NewSlack creates a Notifier that posts a message for each event to
+// a Slack incoming webhook, whose URL is given.
+//
+// Possible optional parameters:
+// * WithHTTPClient: specify the client used to post the messages
+func NewSlack(url string, options ...Option) *Slack {
+	return &Slack{
+		client: httpClient(options),
+		url:    url,
+	}
+}
+
+// Notify posts a message describing the event
+func (s *Slack) Notify(ctx context.Context, data breaker.EventData) error {
+	return post(ctx, s.client, s.url, map[string]string{"text": Message(data)})
+}
+
+// Message returns a human readable description of the event, as posted
+// by Slack
+func Message(data breaker.EventData) string {
+	name := data.Name
+	if name == "" {
+		name = "(unnamed)"
+	}
+
+	var msg string
+	switch data.Event {
+	case breaker.TrippedEvent:
+		msg = fmt.Sprintf(":rotating_light: breaker `%s` tripped", name)
+	case breaker.ResetEvent:
+		msg = fmt.Sprintf(":white_check_mark: breaker `%s` reset", name)
+	default:
+		msg = fmt.Sprintf("breaker `%s` %s (%s -> %s)", name, eventName(data.Event), data.From, data.To)
+	}
+
+	c := data.Counts
+	msg += fmt.Sprintf(" (error rate %.1f%%, %d failures, %d successes, %d consecutive failures)",
+		c.ErrorRate*100, c.Failures, c.Successes, c.ConsecFailures)
+	if data.Err != nil {
+		msg += ": " + data.Err.Error()
+	}
+	return msg
+}
+
+func httpClient(options []Option) HTTPClient {
+	return newConfig(options).httpClient
+}
+
+// post sends v as JSON to url, and checks that the request succeeded
+func post(ctx context.Context, client HTTPClient, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode notification")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "failed to send notification")
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Errorf("notification rejected with status %d", res.StatusCode)
+	}
+	return nil
+}
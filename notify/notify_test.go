@@ -0,0 +1,133 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/notify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	payloads := make(chan notify.Payload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p notify.Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		payloads <- p
+	}))
+	defer srv.Close()
+
+	data := breaker.EventData{
+		Event:  breaker.TrippedEvent,
+		Name:   "payments",
+		From:   breaker.Closed,
+		To:     breaker.Open,
+		Counts: breaker.Counts{Failures: 3, Successes: 1, ConsecFailures: 3, ErrorRate: 0.75},
+		Err:    errors.New("connection refused"),
+	}
+	if !assert.NoError(t, notify.NewWebhook(srv.URL).Notify(context.Background(), data), "Notify should succeed") {
+		return
+	}
+
+	p := <-payloads
+	if !assert.Equal(t, "payments", p.Breaker, "breaker name should be posted") {
+		return
+	}
+	if !assert.Equal(t, "tripped", p.Event, "event should be posted") {
+		return
+	}
+	if !assert.Equal(t, int64(3), p.Failures, "failures should be posted") {
+		return
+	}
+	if !assert.Equal(t, 0.75, p.ErrorRate, "error rate should be posted") {
+		return
+	}
+	if !assert.Equal(t, "connection refused", p.Error, "error should be posted") {
+		return
+	}
+}
+
+func TestWebhookRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	err := notify.NewWebhook(srv.URL).Notify(context.Background(), breaker.EventData{Event: breaker.TrippedEvent})
+	if !assert.Error(t, err, "non-2xx responses should be errors") {
+		return
+	}
+}
+
+func TestSlack(t *testing.T) {
+	messages := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		messages <- body["text"]
+	}))
+	defer srv.Close()
+
+	data := breaker.EventData{
+		Event:  breaker.ResetEvent,
+		Name:   "payments",
+		Counts: breaker.Counts{Failures: 1, Successes: 9, ErrorRate: 0.1},
+	}
+	if !assert.NoError(t, notify.NewSlack(srv.URL).Notify(context.Background(), data), "Notify should succeed") {
+		return
+	}
+
+	msg := <-messages
+	if !assert.True(t, strings.Contains(msg, "`payments` reset"), "message should name the breaker") {
+		return
+	}
+	if !assert.True(t, strings.Contains(msg, "error rate 10.0%"), "message should contain the error rate") {
+		return
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	received := make(chan breaker.EventData, 16)
+	n := notify.NotifierFunc(func(_ context.Context, data breaker.EventData) error {
+		received <- data
+		return nil
+	})
+
+	cb := breaker.NewEventEmitter(breaker.New(breaker.WithName("payments")))
+	go notify.Watch(ctx, cb, n)
+
+	// Watch subscribes asynchronously, so keep tripping the breaker
+	// until a notification is sent
+	timeout := time.After(5 * time.Second)
+	for {
+		cb.Trip()
+		cb.Reset()
+		select {
+		case data := <-received:
+			if !assert.Equal(t, "payments", data.Name, "events should carry the breaker name") {
+				return
+			}
+			if !assert.Contains(t, []breaker.Event{breaker.TrippedEvent, breaker.ResetEvent}, data.Event, "only trips and resets should be notified") {
+				return
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			t.Errorf("timed out waiting for a notification")
+			return
+		}
+	}
+}
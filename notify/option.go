@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"net/http"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// newConfig applies the options over the defaults
+func newConfig(options []Option) *config {
+	c := &config{
+		events:     []breaker.Event{breaker.TrippedEvent, breaker.ResetEvent},
+		httpClient: http.DefaultClient,
+	}
+	for _, option := range options {
+		option.apply(c)
+	}
+	return c
+}
+
+// WithHTTPClient is used to specify the client used by Webhook and
+// Slack to post notifications. The default is http.DefaultClient
+func WithHTTPClient(v HTTPClient) Option {
+	return optionFunc(func(c *config) {
+		c.httpClient = v
+	})
+}
+
+// WithEvents is used to specify the events that Watch sends
+// notifications for. The default is breaker.TrippedEvent and
+// breaker.ResetEvent
+func WithEvents(v ...breaker.Event) Option {
+	return optionFunc(func(c *config) {
+		c.events = v
+	})
+}
+
+// WithErrorHandler is used to specify a function that is called with
+// the errors returned by the Notifier given to Watch. By default,
+// errors are ignored
+func WithErrorHandler(v func(error)) Option {
+	return optionFunc(func(c *config) {
+		c.handleError = v
+	})
+}
@@ -0,0 +1,50 @@
+package otel
+
+import (
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// InstrumentationName is the name reported as the instrumentation
+// scope for tracers and meters created by this package
+const InstrumentationName = "github.com/lestrrat/go-circuit-breaker/otel"
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*config)
+}
+
+type optionFunc func(*config)
+
+// config holds the values given by the options
+type config struct {
+	clock breaker.Clock
+	mp    metric.MeterProvider
+	name  string
+	tp    trace.TracerProvider
+}
+
+// tripObserver records the trips of the breaker created by New()
+// underneath an otelBreaker
+type tripObserver struct {
+	breaker *otelBreaker
+}
+
+type otelBreaker struct {
+	breaker.Breaker
+	clock        breaker.Clock
+	labels       []attribute.KeyValue
+	name         string
+	openDuration metric.Float64Histogram
+	mutex        sync.Mutex
+	openedAt     time.Time
+	rejections   metric.Int64Counter
+	tracer       trace.Tracer
+	trips        metric.Int64Counter
+}
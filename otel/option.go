@@ -0,0 +1,44 @@
+package otel
+
+import (
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// WithTracerProvider is used to specify the TracerProvider used to
+// create spans. By default the global TracerProvider is used.
+func WithTracerProvider(v trace.TracerProvider) Option {
+	return optionFunc(func(c *config) {
+		c.tp = v
+	})
+}
+
+// WithMeterProvider is used to specify the MeterProvider used to
+// create metric instruments. By default the global MeterProvider is used.
+func WithMeterProvider(v metric.MeterProvider) Option {
+	return optionFunc(func(c *config) {
+		c.mp = v
+	})
+}
+
+// WithName is used to specify the name of the breaker, which is
+// recorded as the "breaker.name" attribute in spans and metrics.
+func WithName(v string) Option {
+	return optionFunc(func(c *config) {
+		c.name = v
+	})
+}
+
+// WithClock is used to specify the clock used to compute the
+// duration that the breaker stayed open.
+func WithClock(v breaker.Clock) Option {
+	return optionFunc(func(c *config) {
+		c.clock = v
+	})
+}
@@ -0,0 +1,201 @@
+// Package otel provides a Breaker decorator that instruments calls
+// using OpenTelemetry traces and metrics.
+package otel
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	gootel "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// NewBreaker wraps a Breaker and creates a new Breaker that records
+// a span around every `Call`, and emits metrics for the number of
+// trips, the number of rejected calls, and the duration that the
+// breaker stayed open.
+//
+// Like `breaker.NewEventEmitter`, the returned Breaker may be wrapped
+// by (or wrap) other decorators.
+//
+// Possible optional parameters:
+// * WithTracerProvider: specify the TracerProvider
+// * WithMeterProvider: specify the MeterProvider
+// * WithName: specify the name of the breaker used in attributes, which defaults to cb.Name()
+// * WithClock: specify the clock used to measure open durations
+//
+// The labels of cb (see breaker.WithLabels) are recorded as attributes
+// prefixed with "breaker.label.", e.g. "breaker.label.region".
+//
+// Trips and open durations are taken from the state changes of the
+// breaker created by breaker.New() that cb is or wraps, whatever
+// caused them, e.g. MarkFailure or TripUntil. They are not recorded
+// for other breakers.
+func NewBreaker(cb breaker.Breaker, options ...Option) breaker.Breaker {
+	var cfg config
+	for _, option := range options {
+		option.apply(&cfg)
+	}
+	tp, mp, c, name := cfg.tp, cfg.mp, cfg.clock, cfg.name
+
+	if name == "" {
+		name = cb.Name()
+	}
+
+	if tp == nil {
+		tp = gootel.GetTracerProvider()
+	}
+	if mp == nil {
+		mp = gootel.GetMeterProvider()
+	}
+	if c == nil {
+		c = breaker.SystemClock
+	}
+
+	meter := mp.Meter(InstrumentationName)
+	// Errors are only returned for invalid instrument names, in which
+	// case a no-op instrument is returned. Both are safe to ignore.
+	trips, _ := meter.Int64Counter(
+		"breaker.trips",
+		metric.WithDescription("Number of times the breaker tripped"),
+	)
+	rejections, _ := meter.Int64Counter(
+		"breaker.rejections",
+		metric.WithDescription("Number of calls rejected because the breaker was open"),
+	)
+	openDuration, _ := meter.Float64Histogram(
+		"breaker.open.duration",
+		metric.WithDescription("Duration that the breaker stayed open"),
+		metric.WithUnit("s"),
+	)
+
+	ob := &otelBreaker{
+		Breaker:      cb,
+		clock:        c,
+		labels:       labelAttributes(cb.Labels()),
+		name:         name,
+		openDuration: openDuration,
+		rejections:   rejections,
+		tracer:       tp.Tracer(InstrumentationName),
+		trips:        trips,
+	}
+	if cb.Tripped() {
+		ob.opened()
+	}
+	breaker.Observe(cb, tripObserver{breaker: ob})
+	return ob
+}
+
+// Decorator creates a breaker.Decorator that wraps breakers with
+// NewBreaker, so that instrumented breakers can be composed with
+// breaker.Chain
+func Decorator(options ...Option) breaker.Decorator {
+	return func(cb breaker.Breaker) breaker.Breaker {
+		return NewBreaker(cb, options...)
+	}
+}
+
+func (b *otelBreaker) attributes() []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(b.labels)+1)
+	if b.name != "" {
+		attrs = append(attrs, attribute.String("breaker.name", b.name))
+	}
+	return append(attrs, b.labels...)
+}
+
+// labelAttributes converts labels to attributes, sorted by key
+func labelAttributes(labels map[string]string) []attribute.KeyValue {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]attribute.KeyValue, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, attribute.String("breaker.label."+k, labels[k]))
+	}
+	return attrs
+}
+
+func stateAttribute(key string, tripped bool) attribute.KeyValue {
+	if tripped {
+		return attribute.String(key, breaker.Open.String())
+	}
+	return attribute.String(key, breaker.Closed.String())
+}
+
+// Call wraps the underlying breaker's Call in a span. If the context is
+// given via `breaker.WithContext`, it is used as the parent of the span.
+func (b *otelBreaker) Call(c breaker.Circuit, options ...breaker.CallOption) error {
+	ctx := breaker.CallContext(options...)
+
+	wasTripped := b.Breaker.Tripped()
+	attrs := append(b.attributes(), stateAttribute("breaker.state", wasTripped))
+	_, span := b.tracer.Start(ctx, "breaker.Call", trace.WithAttributes(attrs...))
+	defer span.End()
+
+	err := b.Breaker.Call(c, options...)
+
+	isTripped := b.Breaker.Tripped()
+	span.SetAttributes(stateAttribute("breaker.state.after", isTripped))
+	if err != nil {
+		if breaker.IsOpen(err) {
+			span.SetAttributes(attribute.Bool("breaker.rejected", true))
+			b.rejections.Add(ctx, 1, metric.WithAttributes(b.attributes()...))
+		}
+		if breaker.IsTimeout(err) {
+			span.SetAttributes(attribute.Bool("breaker.timeout", true))
+		}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// OnFail fulfills the breaker.Observer interface
+func (o tripObserver) OnFail(breaker.State, error) {}
+
+// OnSuccess fulfills the breaker.Observer interface
+func (o tripObserver) OnSuccess(breaker.State) {}
+
+// OnStateChange records metrics when the breaker goes from closed to
+// open, or back to closed
+func (o tripObserver) OnStateChange(from, to breaker.State, _ error) {
+	b := o.breaker
+	switch {
+	case from == breaker.Closed && to == breaker.Open:
+		b.opened()
+		b.trips.Add(context.Background(), 1, metric.WithAttributes(b.attributes()...))
+	case to == breaker.Closed:
+		if d, ok := b.closed(); ok {
+			b.openDuration.Record(context.Background(), d, metric.WithAttributes(b.attributes()...))
+		}
+	}
+}
+
+func (b *otelBreaker) opened() {
+	b.mutex.Lock()
+	b.openedAt = b.clock.Now()
+	b.mutex.Unlock()
+}
+
+// closed returns the number of seconds since the breaker was opened
+func (b *otelBreaker) closed() (float64, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.openedAt.IsZero() {
+		return 0, false
+	}
+	d := b.clock.Now().Sub(b.openedAt)
+	b.openedAt = time.Time{}
+	return d.Seconds(), true
+}
@@ -0,0 +1,103 @@
+package otel_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	botel "github.com/lestrrat/go-circuit-breaker/otel"
+)
+
+func TestOtelBreaker(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	c := clock.NewMock()
+	cb := botel.NewBreaker(
+		breaker.New(
+			breaker.WithClock(c),
+			breaker.WithTripper(breaker.ThresholdTripper(1)),
+			breaker.WithLabels(map[string]string{"region": "eu"}),
+		),
+		botel.WithTracerProvider(tp),
+		botel.WithMeterProvider(mp),
+		botel.WithName("example"),
+		botel.WithClock(c),
+	)
+
+	failing := breaker.CircuitFunc(func() error {
+		return errors.New("error")
+	})
+
+	if !assert.Error(t, cb.Call(failing), "Call should fail") {
+		return
+	}
+	if !assert.True(t, breaker.IsOpen(cb.Call(failing)), "Call should be rejected") {
+		return
+	}
+
+	c.Add(3 * time.Second)
+	cb.Reset()
+
+	// Trips that do not go through Call are recorded too
+	cb.TripUntil(c.Now().Add(time.Minute))
+
+	spans := sr.Ended()
+	if !assert.Len(t, spans, 2, "2 spans should have been recorded") {
+		return
+	}
+	for _, span := range spans {
+		if !assert.Equal(t, "breaker.Call", span.Name(), "span name should match") {
+			return
+		}
+		if !assert.Contains(t, span.Attributes(), attribute.String("breaker.name", "example"), "span should have breaker name") {
+			return
+		}
+		if !assert.Contains(t, span.Attributes(), attribute.String("breaker.label.region", "eu"), "span should have breaker labels") {
+			return
+		}
+	}
+	if !assert.Contains(t, spans[0].Attributes(), attribute.String("breaker.state.after", "open"), "breaker should be open after first span") {
+		return
+	}
+	if !assert.Contains(t, spans[1].Attributes(), attribute.Bool("breaker.rejected", true), "second call should be rejected") {
+		return
+	}
+
+	var rm metricdata.ResourceMetrics
+	if !assert.NoError(t, reader.Collect(context.Background(), &rm), "Collect should succeed") {
+		return
+	}
+
+	metrics := map[string]metricdata.Metrics{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			metrics[m.Name] = m
+		}
+	}
+
+	trips := metrics["breaker.trips"].Data.(metricdata.Sum[int64])
+	if !assert.Equal(t, int64(2), trips.DataPoints[0].Value, "trips should be 2") {
+		return
+	}
+	rejections := metrics["breaker.rejections"].Data.(metricdata.Sum[int64])
+	if !assert.Equal(t, int64(1), rejections.DataPoints[0].Value, "rejections should be 1") {
+		return
+	}
+	openDuration := metrics["breaker.open.duration"].Data.(metricdata.Histogram[float64])
+	if !assert.Equal(t, 3.0, openDuration.DataPoints[0].Sum, "open duration should be 3 seconds") {
+		return
+	}
+}
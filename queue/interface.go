@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// DefaultKey is the key used for all messages when no KeyFunc is
+// specified
+const DefaultKey = "_default"
+
+// DefaultPollInterval is the interval at which breakers that will not
+// retry on their own (e.g. after Break()) are checked while consumption
+// is paused
+const DefaultPollInterval = time.Second
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*Consumer)
+}
+
+type optionFunc func(*Consumer)
+
+// Handler handles a message received from a queue, such as a Kafka
+// partition or topic
+type Handler interface {
+	Handle(context.Context, interface{}) error
+}
+
+// HandlerFunc is a Handler represented as a standalone function
+type HandlerFunc func(context.Context, interface{}) error
+
+// KeyFunc returns the key of the breaker used to handle a message,
+// e.g. its topic or its partition
+type KeyFunc func(interface{}) string
+
+// ProbeFunc checks if the downstream dependency for the given key is
+// available again. It returns nil if it is
+type ProbeFunc func(key string) error
+
+// Consumer wraps a Handler so that messages are handled through
+// breakers, and consumption is paused while a breaker is open
+type Consumer struct {
+	breakers     breaker.Map
+	clock        breaker.Clock
+	closeOnce    sync.Once
+	done         chan struct{}
+	factory      breaker.BreakerFactory
+	handler      Handler
+	key          KeyFunc
+	mutex        sync.Mutex
+	pause        func(string)
+	paused       map[string]struct{}
+	pollInterval time.Duration
+	probe        ProbeFunc
+	resume       func(string)
+}
@@ -0,0 +1,74 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(c *Consumer) {
+	f(c)
+}
+
+// WithBreakerFactory is used to specify the function that creates
+// breakers for keys that do not have a breaker associated with them.
+// Without a factory, messages for such keys are not protected.
+func WithBreakerFactory(v breaker.BreakerFactory) Option {
+	return optionFunc(func(c *Consumer) {
+		c.factory = v
+	})
+}
+
+// WithClock is used to specify the clock used to wait for the breakers
+// to allow retries
+func WithClock(v breaker.Clock) Option {
+	return optionFunc(func(c *Consumer) {
+		c.clock = v
+	})
+}
+
+// WithKeyFunc is used to specify the function that determines the key
+// of the breaker used for a message. By default, DefaultKey is used for
+// all messages.
+func WithKeyFunc(v KeyFunc) Option {
+	return optionFunc(func(c *Consumer) {
+		c.key = v
+	})
+}
+
+// WithPauseFunc is used to specify the function called with the key of
+// a breaker when it opens. It should stop fetching the messages for
+// that key, e.g. by pausing the partition.
+func WithPauseFunc(v func(string)) Option {
+	return optionFunc(func(c *Consumer) {
+		c.pause = v
+	})
+}
+
+// WithPollInterval is used to specify the interval at which breakers
+// that will not retry on their own are checked while consumption is
+// paused. The default is DefaultPollInterval.
+func WithPollInterval(v time.Duration) Option {
+	return optionFunc(func(c *Consumer) {
+		c.pollInterval = v
+	})
+}
+
+// WithProbe is used to specify a function that checks if the downstream
+// dependency is available again. When specified, consumption is resumed
+// only after the probe succeeds while the breaker is half-open.
+// Otherwise, consumption is resumed as soon as the breaker becomes
+// half-open, and the next message acts as the probe.
+func WithProbe(v ProbeFunc) Option {
+	return optionFunc(func(c *Consumer) {
+		c.probe = v
+	})
+}
+
+// WithResumeFunc is used to specify the function called with the key
+// of a breaker when consumption for that key may resume.
+func WithResumeFunc(v func(string)) Option {
+	return optionFunc(func(c *Consumer) {
+		c.resume = v
+	})
+}
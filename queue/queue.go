@@ -0,0 +1,159 @@
+// Package queue provides a wrapper for message handlers, such as Kafka
+// consumers, that pauses consumption while the breaker protecting a
+// downstream dependency is open.
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// NewConsumer creates a Consumer that handles messages with h, through
+// the breakers stored in the given map under the key of each message.
+//
+// When a breaker opens, the pause function is called with its key.
+// Once the breaker allows a retry (and the probe succeeds, if any), the
+// resume function is called with the same key. The pause and resume
+// functions are called one at a time, and must not call the methods of
+// the Consumer. Messages that are still delivered while the breaker is
+// open are rejected with an error for which breaker.IsOpen returns
+// true, and should be redelivered later.
+//
+// Possible optional parameters:
+// * WithBreakerFactory: specify the function used to create breakers for unknown keys
+// * WithClock: specify the clock used to wait for retries
+// * WithKeyFunc: specify the function that determines the key of a message
+// * WithPauseFunc: specify the function called when consumption should be paused
+// * WithPollInterval: specify the interval at which breakers that do not retry on their own are checked
+// * WithProbe: specify the function that checks the downstream dependency before resuming
+// * WithResumeFunc: specify the function called when consumption may resume
+func NewConsumer(h Handler, breakers breaker.Map, options ...Option) *Consumer {
+	c := &Consumer{
+		breakers:     breakers,
+		clock:        breaker.SystemClock,
+		done:         make(chan struct{}),
+		handler:      h,
+		key:          func(interface{}) string { return DefaultKey },
+		pause:        func(string) {},
+		paused:       make(map[string]struct{}),
+		pollInterval: DefaultPollInterval,
+		resume:       func(string) {},
+	}
+	for _, option := range options {
+		option.apply(c)
+	}
+	return c
+}
+
+// Handle calls the function
+func (f HandlerFunc) Handle(ctx context.Context, msg interface{}) error {
+	return f(ctx, msg)
+}
+
+// Handle handles the message through the breaker for its key, and
+// pauses consumption for that key if the breaker is open afterwards
+func (c *Consumer) Handle(ctx context.Context, msg interface{}) error {
+	key := c.key(msg)
+	cb := c.breakerLookup(key)
+	if cb == nil {
+		return c.handler.Handle(ctx, msg)
+	}
+
+	err := cb.Call(breaker.CircuitFunc(func() error {
+		return c.handler.Handle(ctx, msg)
+	}), breaker.WithContext(ctx))
+	if cb.Tripped() {
+		c.pauseKey(key, cb)
+	}
+	return err
+}
+
+// Paused returns true if consumption is paused for the given key
+func (c *Consumer) Paused(key string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	_, ok := c.paused[key]
+	return ok
+}
+
+// Close stops waiting for the breakers of paused keys. The resume
+// function is not called for keys that are still paused.
+func (c *Consumer) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *Consumer) breakerLookup(key string) breaker.Breaker {
+	if c.factory != nil {
+		return c.breakers.GetOrCreate(key, c.factory)
+	}
+	cb, ok := c.breakers.Get(key)
+	if !ok {
+		return nil
+	}
+	return cb
+}
+
+func (c *Consumer) pauseKey(key string, cb breaker.Breaker) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.paused[key]; ok {
+		return
+	}
+	c.paused[key] = struct{}{}
+	c.pause(key)
+	go c.watch(key, cb)
+}
+
+func (c *Consumer) resumeKey(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.paused, key)
+	c.resume(key)
+}
+
+// watch waits until consumption for the key may resume
+func (c *Consumer) watch(key string, cb breaker.Breaker) {
+	for {
+		if c.ready(key, cb) {
+			c.resumeKey(key)
+			return
+		}
+
+		wait := c.pollInterval
+		if retryAt := cb.RetryAt(); !retryAt.IsZero() {
+			// Retries are allowed once the retry time has passed
+			if d := retryAt.Sub(c.clock.Now()); d >= 0 {
+				wait = d + time.Nanosecond
+			}
+		}
+
+		timer := breaker.NewTimer(c.clock, wait)
+		select {
+		case <-c.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// ready returns true if the breaker was reset, or if it allows a retry
+// and the probe (if any) succeeds
+func (c *Consumer) ready(key string, cb breaker.Breaker) bool {
+	if !cb.Tripped() {
+		return true
+	}
+	if retryAt := cb.RetryAt(); retryAt.IsZero() || !c.clock.Now().After(retryAt) {
+		return false
+	}
+	if c.probe == nil {
+		return true
+	}
+	return cb.Call(breaker.CircuitFunc(func() error {
+		return c.probe(key)
+	})) == nil
+}
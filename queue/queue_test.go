@@ -0,0 +1,156 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/fbclock"
+	"github.com/lestrrat/go-circuit-breaker/queue"
+)
+
+type message struct {
+	partition string
+	fail      bool
+}
+
+func newConsumer(c breaker.Clock, breakers breaker.Map, paused, resumed chan string, options ...queue.Option) *queue.Consumer {
+	h := queue.HandlerFunc(func(_ context.Context, msg interface{}) error {
+		if msg.(message).fail {
+			return errors.New("downstream unavailable")
+		}
+		return nil
+	})
+	options = append(options,
+		queue.WithClock(c),
+		queue.WithKeyFunc(func(msg interface{}) string { return msg.(message).partition }),
+		queue.WithPauseFunc(func(key string) { paused <- key }),
+		queue.WithResumeFunc(func(key string) { resumed <- key }),
+		queue.WithBreakerFactory(func() breaker.Breaker {
+			return breaker.New(
+				breaker.WithBackOff(backoff.NewConstantBackOff(time.Minute)),
+				breaker.WithClock(c),
+				breaker.WithTripper(breaker.ConsecutiveTripper(2)),
+			)
+		}),
+	)
+	return queue.NewConsumer(h, breakers, options...)
+}
+
+// waitResume advances the clock until consumption is resumed
+func waitResume(t *testing.T, c *clock.Mock, resumed chan string) (string, bool) {
+	timeout := time.After(5 * time.Second)
+	for {
+		c.Add(10 * time.Second)
+		select {
+		case key := <-resumed:
+			return key, true
+		case <-time.After(time.Millisecond):
+		case <-timeout:
+			t.Errorf("timed out waiting for consumption to resume")
+			return "", false
+		}
+	}
+}
+
+func TestConsumer(t *testing.T) {
+	c := clock.NewMock()
+	paused := make(chan string, 1)
+	resumed := make(chan string, 1)
+	breakers := breaker.NewMap()
+	consumer := newConsumer(fbclock.New(c), breakers, paused, resumed)
+	defer consumer.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		consumer.Handle(ctx, message{partition: "p0", fail: true})
+	}
+	if !assert.Equal(t, "p0", <-paused, "partition should be paused") {
+		return
+	}
+	if !assert.True(t, consumer.Paused("p0"), "partition should be reported as paused") {
+		return
+	}
+	if !assert.False(t, consumer.Paused("p1"), "other partitions should not be paused") {
+		return
+	}
+
+	err := consumer.Handle(ctx, message{partition: "p0"})
+	if !assert.True(t, breaker.IsOpen(err), "messages should be rejected while the breaker is open") {
+		return
+	}
+	if !assert.NoError(t, consumer.Handle(ctx, message{partition: "p1"}), "other partitions should not be affected") {
+		return
+	}
+
+	key, ok := waitResume(t, c, resumed)
+	if !ok {
+		return
+	}
+	if !assert.Equal(t, "p0", key, "partition should be resumed") {
+		return
+	}
+
+	if !assert.NoError(t, consumer.Handle(ctx, message{partition: "p0"}), "probe message should be handled") {
+		return
+	}
+	cb, _ := breakers.Get("p0")
+	if !assert.False(t, cb.Tripped(), "successful probe should reset the breaker") {
+		return
+	}
+}
+
+func TestConsumerProbe(t *testing.T) {
+	c := clock.NewMock()
+	paused := make(chan string, 1)
+	resumed := make(chan string, 1)
+	probes := make(chan string, 16)
+	healthy := make(chan struct{})
+	consumer := newConsumer(fbclock.New(c), breaker.NewMap(), paused, resumed,
+		queue.WithProbe(func(key string) error {
+			probes <- key
+			select {
+			case <-healthy:
+				return nil
+			default:
+				return errors.New("still down")
+			}
+		}),
+	)
+	defer consumer.Close()
+
+	for i := 0; i < 2; i++ {
+		consumer.Handle(context.Background(), message{partition: "p0", fail: true})
+	}
+	<-paused
+
+	// Wait for the first probe, which fails
+	timeout := time.After(5 * time.Second)
+	for len(probes) == 0 {
+		c.Add(10 * time.Second)
+		select {
+		case <-time.After(time.Millisecond):
+		case <-timeout:
+			t.Errorf("timed out waiting for a probe")
+			return
+		}
+	}
+	if !assert.Len(t, resumed, 0, "consumption should not resume after a failed probe") {
+		return
+	}
+
+	close(healthy)
+	key, ok := waitResume(t, c, resumed)
+	if !ok {
+		return
+	}
+	if !assert.Equal(t, "p0", key, "partition should be resumed after a successful probe") {
+		return
+	}
+}
@@ -0,0 +1,33 @@
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// ErrRateLimited is returned when a call is rejected because the rate
+// limit is exceeded
+var ErrRateLimited error = rateLimitedErr{}
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*config)
+}
+
+type optionFunc func(*config)
+
+// config holds the values given by the options
+type config struct {
+	burst int
+	clock breaker.Clock
+	limit rate.Limit
+}
+
+type rateLimitedErr struct{}
+
+type limitedBreaker struct {
+	breaker.Breaker
+	clock   breaker.Clock
+	limiter *rate.Limiter
+}
@@ -0,0 +1,28 @@
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// WithRateLimit is used to specify the number of calls allowed per
+// second, and the number of calls that may be made at once after a
+// period of inactivity. By default, the rate is not limited
+func WithRateLimit(r rate.Limit, burst int) Option {
+	return optionFunc(func(c *config) {
+		c.limit, c.burst = r, burst
+	})
+}
+
+// WithClock is used to specify the clock used to refill the token
+// bucket
+func WithClock(v breaker.Clock) Option {
+	return optionFunc(func(c *config) {
+		c.clock = v
+	})
+}
@@ -0,0 +1,138 @@
+// Package ratelimit provides a Breaker decorator that limits the rate
+// of calls with a token bucket, so that a single wrapper protects the
+// service from overload both before and after it starts failing.
+//
+//	cb := ratelimit.NewBreaker(breaker.New(), ratelimit.WithRateLimit(100, 10))
+//	err := cb.Call(circuit)
+//	if ratelimit.IsRateLimited(err) {
+//		...
+//	}
+package ratelimit
+
+import (
+	"golang.org/x/time/rate"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/pkg/errors"
+)
+
+func (e rateLimitedErr) Error() string {
+	return "rate limit exceeded"
+}
+
+func (e rateLimitedErr) IsRateLimited() bool {
+	return true
+}
+
+type isRateLimiteder interface {
+	IsRateLimited() bool
+}
+
+type causer interface {
+	Cause() error
+}
+
+type unwrapper interface {
+	Unwrap() error
+}
+
+// IsRateLimited returns true if the error is caused by a call being
+// rejected by the rate limit
+func IsRateLimited(err error) bool {
+	for err != nil {
+		if rlerr, ok := err.(isRateLimiteder); ok {
+			return rlerr.IsRateLimited()
+		}
+		switch cerr := err.(type) {
+		case causer:
+			err = cerr.Cause()
+			continue
+		case unwrapper:
+			err = cerr.Unwrap()
+			continue
+		}
+		break
+	}
+	return false
+}
+
+// NewBreaker wraps a Breaker and creates a new Breaker that rejects
+// calls with ErrRateLimited once the rate limit is exceeded. Calls
+// that are rejected by the rate limit are not seen by the wrapped
+// breaker, and calls that are rejected by the wrapped breaker give
+// their token back, so that the limit only applies to the calls that
+// reach the service.
+//
+// Possible optional parameters:
+// * WithRateLimit: specify the rate limit, which defaults to no limit
+// * WithClock: specify the clock used to refill the token bucket
+func NewBreaker(cb breaker.Breaker, options ...Option) breaker.Breaker {
+	cfg := config{limit: rate.Inf}
+	for _, option := range options {
+		option.apply(&cfg)
+	}
+	if cfg.clock == nil {
+		cfg.clock = breaker.SystemClock
+	}
+
+	return &limitedBreaker{
+		Breaker: cb,
+		clock:   cfg.clock,
+		limiter: rate.NewLimiter(cfg.limit, cfg.burst),
+	}
+}
+
+// Decorator creates a breaker.Decorator that wraps breakers with
+// NewBreaker, so that rate limited breakers can be composed with
+// breaker.Chain
+func Decorator(options ...Option) breaker.Decorator {
+	return func(cb breaker.Breaker) breaker.Breaker {
+		return NewBreaker(cb, options...)
+	}
+}
+
+// reserve takes a token from the bucket. It returns nil if there is
+// no token left
+func (b *limitedBreaker) reserve() *rate.Reservation {
+	now := b.clock.Now()
+	r := b.limiter.ReserveN(now, 1)
+	if !r.OK() {
+		return nil
+	}
+	if r.DelayFrom(now) > 0 {
+		r.CancelAt(now)
+		return nil
+	}
+	return r
+}
+
+func (b *limitedBreaker) rejected() error {
+	return errors.WithMessage(ErrRateLimited, "failed to execute circuit")
+}
+
+func (b *limitedBreaker) Allow() (func(bool), error) {
+	r := b.reserve()
+	if r == nil {
+		return nil, b.rejected()
+	}
+
+	done, err := b.Breaker.Allow()
+	if err != nil {
+		r.CancelAt(b.clock.Now())
+		return nil, err
+	}
+	return done, nil
+}
+
+func (b *limitedBreaker) Call(c breaker.Circuit, options ...breaker.CallOption) error {
+	r := b.reserve()
+	if r == nil {
+		return b.rejected()
+	}
+
+	err := b.Breaker.Call(c, options...)
+	if breaker.IsOpen(err) || breaker.IsTooManyConcurrent(err) {
+		r.CancelAt(b.clock.Now())
+	}
+	return err
+}
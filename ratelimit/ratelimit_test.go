@@ -0,0 +1,69 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	inner := breaker.New()
+	cb := ratelimit.NewBreaker(inner, ratelimit.WithRateLimit(1, 2), ratelimit.WithClock(c))
+
+	circuit := breaker.CircuitFunc(func() error { return nil })
+	for i := 0; i < 2; i++ {
+		if !assert.NoError(t, cb.Call(circuit), "calls within the burst should succeed") {
+			return
+		}
+	}
+
+	err := cb.Call(circuit)
+	if !assert.True(t, ratelimit.IsRateLimited(err), "calls beyond the burst should be rate limited") {
+		return
+	}
+	if !assert.False(t, breaker.IsOpen(err), "rate limited calls should not look like open breakers") {
+		return
+	}
+	if !assert.Equal(t, int64(2), inner.Successes(), "rate limited calls should not reach the breaker") {
+		return
+	}
+
+	c.Add(time.Second)
+	if !assert.NoError(t, cb.Call(circuit), "tokens should be refilled over time") {
+		return
+	}
+
+	// Calls rejected by the breaker give their token back
+	c.Add(time.Minute)
+	inner.Break()
+	for i := 0; i < 5; i++ {
+		if !assert.True(t, breaker.IsOpen(cb.Call(circuit)), "open breaker should reject calls") {
+			return
+		}
+	}
+	inner.Reset()
+	if !assert.NoError(t, cb.Call(circuit), "rejected calls should not use up tokens") {
+		return
+	}
+}
+
+func TestDecorator(t *testing.T) {
+	c := clock.NewMock()
+	c.Add(time.Hour)
+	inner := breaker.New()
+	cb := breaker.Chain(ratelimit.Decorator(ratelimit.WithRateLimit(1, 1), ratelimit.WithClock(c)))(inner)
+
+	circuit := breaker.CircuitFunc(func() error { return nil })
+	if !assert.NoError(t, cb.Call(circuit), "calls within the burst should succeed") {
+		return
+	}
+	if !assert.True(t, ratelimit.IsRateLimited(cb.Call(circuit)), "calls beyond the burst should be rate limited") {
+		return
+	}
+}
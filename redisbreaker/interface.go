@@ -0,0 +1,31 @@
+package redisbreaker
+
+import (
+	"github.com/redis/go-redis/v9"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*config)
+}
+
+type optionFunc func(*config)
+
+// config holds the values given by the options
+type config struct {
+	factory   breaker.BreakerFactory
+	isFailure breaker.ErrorClassifier
+}
+
+type hook struct {
+	cb        breaker.Breaker
+	isFailure breaker.ErrorClassifier
+}
+
+// NodeNotifier is implemented by clients that talk to several Redis
+// nodes, such as *redis.ClusterClient and *redis.Ring
+type NodeNotifier interface {
+	OnNewNode(func(*redis.Client))
+}
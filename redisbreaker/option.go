@@ -0,0 +1,27 @@
+package redisbreaker
+
+import (
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+func (f optionFunc) apply(c *config) {
+	f(c)
+}
+
+// WithBreakerFactory is used to specify the function that creates
+// breakers for nodes that do not have a breaker associated with them.
+// Without a factory, commands sent to such nodes are not protected.
+func WithBreakerFactory(v breaker.BreakerFactory) Option {
+	return optionFunc(func(c *config) {
+		c.factory = v
+	})
+}
+
+// WithErrorClassifier is used to specify the function that determines
+// if an error returned by a node should be recorded as a failure.
+// The default is IsFailure.
+func WithErrorClassifier(v breaker.ErrorClassifier) Option {
+	return optionFunc(func(c *config) {
+		c.isFailure = v
+	})
+}
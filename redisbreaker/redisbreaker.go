@@ -0,0 +1,162 @@
+// Package redisbreaker provides go-redis hooks that protect the commands
+// sent to each Redis node using circuit breakers.
+package redisbreaker
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// failurePrefixes lists the error replies that indicate that the node
+// can't serve commands at the moment
+var failurePrefixes = []string{
+	"LOADING ",
+	"MASTERDOWN ",
+	"READONLY ",
+}
+
+// IsFailure is the default ErrorClassifier. It returns true for
+// connection errors, timeouts and the READONLY, LOADING and MASTERDOWN
+// error replies. Other error replies, including MOVED and ASK redirects
+// and redis.Nil, mean that the node is healthy and are recorded as
+// successes.
+func IsFailure(err error) bool {
+	if err == nil || err == redis.Nil || err == context.Canceled {
+		return false
+	}
+
+	rerr, ok := errors.Cause(err).(redis.Error)
+	if !ok {
+		return true
+	}
+
+	msg := rerr.Error()
+	for _, prefix := range failurePrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewHook creates a redis.Hook that sends commands and pipelines
+// through the given breaker. It should be added to a client that talks
+// to a single node. When the breaker is open, commands are not sent,
+// and fail with an error for which breaker.IsOpen returns true.
+//
+// Possible optional parameters:
+// * WithErrorClassifier: specify the function that determines which errors are failures
+func NewHook(cb breaker.Breaker, options ...Option) redis.Hook {
+	cfg := config{isFailure: IsFailure}
+	for _, option := range options {
+		option.apply(&cfg)
+	}
+	return &hook{
+		cb:        cb,
+		isFailure: cfg.isFailure,
+	}
+}
+
+// Instrument adds a hook to the client, using the breaker stored in
+// nodes under the address of the client. If there is no such breaker
+// and no factory is specified via WithBreakerFactory, the client is
+// left untouched.
+//
+// Possible optional parameters:
+// * WithBreakerFactory: specify the function used to create breakers for unknown nodes
+// * WithErrorClassifier: specify the function that determines which errors are failures
+func Instrument(c *redis.Client, nodes breaker.Map, options ...Option) {
+	var cfg config
+	for _, option := range options {
+		option.apply(&cfg)
+	}
+
+	addr := c.Options().Addr
+	var cb breaker.Breaker
+	if cfg.factory != nil {
+		cb = nodes.GetOrCreate(addr, cfg.factory)
+	} else {
+		var ok bool
+		if cb, ok = nodes.Get(addr); !ok {
+			return
+		}
+	}
+	c.AddHook(NewHook(cb, options...))
+}
+
+// InstrumentNodes arranges for every node client created by c to be
+// instrumented using Instrument, so that each node gets its own breaker.
+// As only nodes created after this call are instrumented, it should be
+// called right after c is created.
+//
+// It accepts the same optional parameters as Instrument.
+func InstrumentNodes(c NodeNotifier, nodes breaker.Map, options ...Option) {
+	c.OnNewNode(func(rdb *redis.Client) {
+		Instrument(rdb, nodes, options...)
+	})
+}
+
+func (h *hook) DialHook(next redis.DialHook) redis.DialHook {
+	// Connections are dialed while a command is being processed, so
+	// dial errors are already recorded by ProcessHook
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h *hook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		var processErr error
+		err := h.cb.Call(breaker.CircuitFunc(func() error {
+			processErr = next(ctx, cmd)
+			return h.failure(processErr)
+		}), breaker.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		return processErr
+	}
+}
+
+func (h *hook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		var processErr error
+		err := h.cb.Call(breaker.CircuitFunc(func() error {
+			processErr = next(ctx, cmds)
+			if err := h.failure(processErr); err != nil {
+				return err
+			}
+			for _, cmd := range cmds {
+				if err := h.failure(cmd.Err()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}), breaker.WithContext(ctx))
+		if err != nil {
+			if breaker.IsOpen(err) || breaker.IsTooManyConcurrent(err) {
+				// The pipeline was not sent
+				for _, cmd := range cmds {
+					cmd.SetErr(err)
+				}
+			}
+			return err
+		}
+		return processErr
+	}
+}
+
+// failure returns the error if it should be recorded as a failure,
+// or nil otherwise
+func (h *hook) failure(err error) error {
+	if err == nil || !h.isFailure(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package redisbreaker_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/facebookgo/clock"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/redisbreaker"
+)
+
+type replyErr string
+
+func (e replyErr) Error() string { return string(e) }
+func (e replyErr) RedisError()   {}
+
+// newServer starts a server speaking just enough of the Redis protocol
+// to answer commands with the replies returned by the given function
+func newServer(t *testing.T, reply func(args []string) string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn, reply)
+		}
+	}()
+	return l
+}
+
+func serve(conn net.Conn, reply func(args []string) string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			arg, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			args = append(args, strings.TrimSpace(arg))
+		}
+
+		var s string
+		switch strings.ToUpper(args[0]) {
+		case "HELLO":
+			s = "-ERR unknown command 'HELLO'\r\n"
+		default:
+			s = reply(args)
+		}
+		if _, err := fmt.Fprint(conn, s); err != nil {
+			return
+		}
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err     error
+		failure bool
+	}{
+		{nil, false},
+		{redis.Nil, false},
+		{context.Canceled, false},
+		{replyErr("MOVED 3999 127.0.0.1:6381"), false},
+		{replyErr("ASK 3999 127.0.0.1:6381"), false},
+		{replyErr("WRONGTYPE Operation against a key holding the wrong kind of value"), false},
+		{replyErr("READONLY You can't write against a read only replica."), true},
+		{replyErr("LOADING Redis is loading the dataset in memory"), true},
+		{errors.New("dial tcp 127.0.0.1:6379: connect: connection refused"), true},
+		{context.DeadlineExceeded, true},
+	}
+	for _, test := range tests {
+		if !assert.Equal(t, test.failure, redisbreaker.IsFailure(test.err), "IsFailure(%v)", test.err) {
+			return
+		}
+	}
+}
+
+func TestHook(t *testing.T) {
+	sent := make(chan string, 16)
+	l := newServer(t, func(args []string) string {
+		sent <- args[0]
+		switch args[0] {
+		case "get":
+			return "-MOVED 3999 127.0.0.1:6381\r\n"
+		default:
+			return "-READONLY You can't write against a read only replica.\r\n"
+		}
+	})
+	defer l.Close()
+
+	cb := breaker.New(
+		breaker.WithClock(clock.NewMock()),
+		breaker.WithTripper(breaker.ConsecutiveTripper(2)),
+	)
+	c := redis.NewClient(&redis.Options{
+		Addr:             l.Addr().String(),
+		DisableIndentity: true,
+		MaxRetries:       -1,
+	})
+	defer c.Close()
+	c.AddHook(redisbreaker.NewHook(cb))
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		err := c.Get(ctx, "foo").Err()
+		if !assert.True(t, strings.HasPrefix(err.Error(), "MOVED"), "redirect should be returned") {
+			return
+		}
+	}
+	if !assert.False(t, cb.Tripped(), "redirects should not trip the breaker") {
+		return
+	}
+
+	for i := 0; i < 2; i++ {
+		c.Set(ctx, "foo", "bar", 0)
+	}
+	if !assert.True(t, cb.Tripped(), "READONLY errors should trip the breaker") {
+		return
+	}
+	for len(sent) > 0 {
+		<-sent
+	}
+
+	err := c.Get(ctx, "foo").Err()
+	if !assert.True(t, breaker.IsOpen(err), "commands should be rejected while the breaker is open") {
+		return
+	}
+
+	pipe := c.Pipeline()
+	get := pipe.Get(ctx, "foo")
+	pipe.Exec(ctx)
+	if !assert.True(t, breaker.IsOpen(get.Err()), "pipelined commands should be rejected while the breaker is open") {
+		return
+	}
+	if !assert.Len(t, sent, 0, "no commands should be sent while the breaker is open") {
+		return
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	l := newServer(t, func(args []string) string {
+		return "$-1\r\n"
+	})
+	defer l.Close()
+
+	addr := l.Addr().String()
+	newClient := func() *redis.Client {
+		return redis.NewClient(&redis.Options{
+			Addr:             addr,
+			DisableIndentity: true,
+		})
+	}
+
+	nodes := breaker.NewMap()
+	c := newClient()
+	defer c.Close()
+	redisbreaker.Instrument(c, nodes)
+	if !assert.Equal(t, 0, nodes.Len(), "breakers should not be created without a factory") {
+		return
+	}
+
+	c = newClient()
+	defer c.Close()
+	redisbreaker.Instrument(c, nodes, redisbreaker.WithBreakerFactory(func() breaker.Breaker {
+		return breaker.New(breaker.WithName(addr))
+	}))
+	cb, ok := nodes.Get(addr)
+	if !assert.True(t, ok, "breaker should be created for the node") {
+		return
+	}
+
+	for i := 0; i < 3; i++ {
+		if !assert.Equal(t, redis.Nil, c.Get(context.Background(), "foo").Err(), "redis.Nil should be returned") {
+			return
+		}
+	}
+	if !assert.Equal(t, int64(3), cb.Successes(), "commands should be recorded as successes") {
+		return
+	}
+}
@@ -0,0 +1,68 @@
+package simulation
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+)
+
+// Sample is the outcome of a single call, as recorded in a trace
+type Sample struct {
+	// At is the time at which the call was made, relative to the
+	// start of the trace
+	At time.Duration
+	// Latency is how long the call took
+	Latency time.Duration
+	// Err is the error returned by the call, or nil if it succeeded
+	Err error
+}
+
+// Transition is a change of state of the simulated breaker
+type Transition struct {
+	// Sample is the index of the sample being replayed when the
+	// transition happened
+	Sample int
+	// At is the time of the transition, relative to the start of the
+	// trace
+	At time.Duration
+	// From is the state of the breaker before the transition
+	From breaker.State
+	// To is the state of the breaker after the transition
+	To breaker.State
+	// Err is the error that caused the transition, if any
+	Err error
+}
+
+// Report describes how a breaker behaved while a trace was replayed
+type Report struct {
+	// Calls is the number of calls that were let through
+	Calls int
+	// Rejected is the number of calls that the breaker rejected
+	Rejected int
+	// Failures is the number of calls that were recorded as failures,
+	// including those that timed out. Errors that the breaker does not
+	// consider failures (see WithErrorClassifier) are not counted
+	Failures int
+	// Successes is the number of calls that were recorded as successes
+	Successes int
+	// TimedOut is the number of calls whose latency exceeded the
+	// timeout of the breaker
+	TimedOut int
+	// OpenTime is the total time that the breaker spent open or
+	// half-open
+	OpenTime time.Duration
+	// Duration is the time covered by the replay, from the start of
+	// the trace to the end of the last call
+	Duration time.Duration
+	// Transitions lists the state changes of the breaker, in order
+	Transitions []Transition
+}
+
+// recorder is the breaker.Observer that builds the transitions of a
+// Report
+type recorder struct {
+	clock   breaker.Clock
+	current int
+	report  *Report
+	start   time.Time
+}
@@ -0,0 +1,141 @@
+// Package simulation replays recorded outcomes and latencies of calls
+// through a breaker configuration, and reports when the breaker would
+// have tripped and closed, so that thresholds can be tuned offline
+// against production traces.
+//
+//	samples, err := simulation.ReadCSV(f)
+//	if err != nil {
+//		...
+//	}
+//	report := simulation.Run(samples,
+//		breaker.WithTripper(breaker.RateTripper(0.5, 20)),
+//		breaker.WithTimeout(time.Second),
+//	)
+//	for _, t := range report.Trips() {
+//		fmt.Printf("tripped at %s\n", t.At)
+//	}
+//
+// Replays are deterministic: the breaker runs on a mock clock, which
+// is moved to the time of each sample before it is replayed, and
+// forward by its latency while it runs.
+package simulation
+
+import (
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/breakertest"
+	"github.com/pkg/errors"
+)
+
+// Run replays the samples in order, one call at a time, through a
+// breaker created with the given options, and reports how it behaved.
+// The breaker uses a mock clock and the backoff of
+// breakertest.NewBackOff unless WithBackOff is given: WithClock must
+// not be given. Calls whose latency reaches the timeout of the breaker
+// fail with breaker.ErrBreakerTimeout once the timeout has elapsed.
+func Run(samples []Sample, options ...breaker.BreakerOption) *Report {
+	c := breakertest.NewClock()
+	report := &Report{}
+	rec := &recorder{
+		clock:  c,
+		report: report,
+		start:  c.Now(),
+	}
+
+	options = append(options, breaker.WithObserver(rec))
+	cb := breakertest.NewBreaker(c, options...)
+	timeout := cb.DefaultTimeout()
+
+	for i, s := range samples {
+		rec.current = i
+		if d := rec.start.Add(s.At).Sub(c.Now()); d > 0 {
+			c.Add(d)
+		}
+
+		admitted := false
+		cb.Call(breaker.CircuitFunc(func() error {
+			admitted = true
+			if timeout > 0 && s.Latency >= timeout {
+				c.Add(timeout)
+				report.TimedOut++
+				return errors.Wrap(breaker.ErrBreakerTimeout, "timeout reached while executing circuit")
+			}
+			c.Add(s.Latency)
+			return s.Err
+		}), breaker.WithTimeout(0))
+
+		if admitted {
+			report.Calls++
+		} else {
+			report.Rejected++
+		}
+	}
+
+	report.Duration = c.Now().Sub(rec.start)
+	report.OpenTime = openTime(report.Transitions, report.Duration)
+	return report
+}
+
+// Trips returns the transitions in which the breaker tripped, i.e.
+// went from closed to open
+func (r *Report) Trips() []Transition {
+	var list []Transition
+	for _, t := range r.Transitions {
+		if t.From == breaker.Closed && t.To == breaker.Open {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// Closes returns the transitions in which the breaker closed again
+func (r *Report) Closes() []Transition {
+	var list []Transition
+	for _, t := range r.Transitions {
+		if t.To == breaker.Closed {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// openTime sums the time spent out of the closed state, counting the
+// breaker as open until end if it did not close
+func openTime(transitions []Transition, end time.Duration) time.Duration {
+	var total time.Duration
+	var since time.Duration
+	open := false
+	for _, t := range transitions {
+		switch {
+		case !open && t.To != breaker.Closed:
+			open = true
+			since = t.At
+		case open && t.To == breaker.Closed:
+			open = false
+			total += t.At - since
+		}
+	}
+	if open {
+		total += end - since
+	}
+	return total
+}
+
+func (r *recorder) OnFail(breaker.State, error) {
+	r.report.Failures++
+}
+
+func (r *recorder) OnSuccess(breaker.State) {
+	r.report.Successes++
+}
+
+func (r *recorder) OnStateChange(from, to breaker.State, err error) {
+	r.report.Transitions = append(r.report.Transitions, Transition{
+		Sample: r.current,
+		At:     r.clock.Now().Sub(r.start),
+		From:   from,
+		To:     to,
+		Err:    err,
+	})
+}
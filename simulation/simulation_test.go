@@ -0,0 +1,113 @@
+package simulation_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/backoff"
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/simulation"
+	"github.com/stretchr/testify/assert"
+)
+
+const trace = `# at,latency,error
+0s,10ms
+1s,10ms,connection refused
+2s,10ms,connection refused
+3s,2s
+4500ms,10ms
+5s,10ms
+`
+
+func TestRun(t *testing.T) {
+	samples, err := simulation.ReadCSV(strings.NewReader(trace))
+	if !assert.NoError(t, err, "ReadCSV should succeed") {
+		return
+	}
+	if !assert.Len(t, samples, 6, "all samples should be read") {
+		return
+	}
+	if !assert.EqualError(t, samples[1].Err, "connection refused", "error should be read") {
+		return
+	}
+
+	report := simulation.Run(samples,
+		breaker.WithTripper(breaker.ConsecutiveTripper(3)),
+		breaker.WithTimeout(time.Second),
+	)
+
+	trips := report.Trips()
+	if !assert.Len(t, trips, 1, "breaker should trip once") {
+		return
+	}
+	if !assert.Equal(t, 3, trips[0].Sample, "the slow call should trip the breaker") {
+		return
+	}
+	if !assert.Equal(t, 3*time.Second+time.Second, trips[0].At, "breaker should trip when the call times out") {
+		return
+	}
+	if !assert.True(t, breaker.IsTimeout(trips[0].Err), "trip should be caused by the timeout") {
+		return
+	}
+
+	closes := report.Closes()
+	if !assert.Len(t, closes, 1, "breaker should close again") {
+		return
+	}
+	if !assert.Equal(t, 4, closes[0].Sample, "the next call should probe and close the breaker") {
+		return
+	}
+
+	expected := simulation.Report{
+		Calls:       6,
+		Failures:    3,
+		Successes:   3,
+		TimedOut:    1,
+		OpenTime:    closes[0].At - trips[0].At,
+		Duration:    5*time.Second + 10*time.Millisecond,
+		Transitions: report.Transitions,
+	}
+	if !assert.Equal(t, expected, *report, "report should count the calls") {
+		return
+	}
+
+	// The same trace with a lower threshold trips earlier, and
+	// rejects calls while the breaker is open
+	report = simulation.Run(samples,
+		breaker.WithTripper(breaker.ConsecutiveTripper(1)),
+		breaker.WithBackOff(backoff.NewConstantBackOff(10*time.Second)),
+	)
+	if !assert.Len(t, report.Trips(), 1, "breaker should trip once") {
+		return
+	}
+	if !assert.Equal(t, 1, report.Trips()[0].Sample, "first failure should trip the breaker") {
+		return
+	}
+	if !assert.Equal(t, 4, report.Rejected, "calls should be rejected while the breaker is open") {
+		return
+	}
+	if !assert.Empty(t, report.Closes(), "breaker should not close") {
+		return
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	_, err := simulation.ReadCSV(strings.NewReader("0s,10ms\nsoon,10ms\n"))
+	if !assert.Error(t, err, "invalid durations should be rejected") {
+		return
+	}
+	if !assert.Contains(t, err.Error(), "line 2", "error should tell the line") {
+		return
+	}
+
+	_, err = simulation.ReadCSV(strings.NewReader("0s\n"))
+	if !assert.Error(t, err, "missing fields should be rejected") {
+		return
+	}
+
+	samples, err := simulation.ReadCSV(strings.NewReader(""))
+	if !assert.NoError(t, err, "empty trace should be read") || !assert.Empty(t, samples, "no samples should be read") {
+		return
+	}
+}
@@ -0,0 +1,52 @@
+package simulation
+
+import (
+	"encoding/csv"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReadCSV reads samples from CSV records of the form
+//
+//	at,latency[,error]
+//
+// where at and latency are durations as understood by
+// time.ParseDuration (e.g. "1.5s"), and error is the error message of
+// a failed call, which is empty or omitted for successful calls. Lines
+// starting with '#' are ignored.
+func ReadCSV(r io.Reader) ([]Sample, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var samples []Sample
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return samples, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read trace")
+		}
+
+		line, _ := cr.FieldPos(0)
+		if len(record) < 2 || len(record) > 3 {
+			return nil, errors.Errorf("line %d: expected 2 or 3 fields, got %d", line, len(record))
+		}
+
+		var s Sample
+		if s.At, err = time.ParseDuration(record[0]); err != nil {
+			return nil, errors.Wrapf(err, "line %d: invalid time", line)
+		}
+		if s.Latency, err = time.ParseDuration(record[1]); err != nil {
+			return nil, errors.Wrapf(err, "line %d: invalid latency", line)
+		}
+		if len(record) == 3 && record[2] != "" {
+			s.Err = errors.New(record[2])
+		}
+		samples = append(samples, s)
+	}
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultRedisTimeout is the default timeout for each Redis operation
+const DefaultRedisTimeout = time.Second
+
+// Option is the interface used to provide optional arguments
+type Option interface {
+	apply(*Redis)
+}
+
+type optionFunc func(*Redis)
+
+// File stores the breaker state as JSON in a file
+type File struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// RedisClient is the subset of a Redis client used by Redis. It is
+// kept small so that any client library can be adapted with a few
+// lines of code.
+type RedisClient interface {
+	// Get returns the value stored under key. If the key does not
+	// exist, it should return an empty string and no error
+	Get(ctx context.Context, key string) (string, error)
+
+	// Set stores the value under key
+	Set(ctx context.Context, key string, value string) error
+}
+
+// Redis stores the breaker state as JSON in a Redis key
+type Redis struct {
+	client  RedisClient
+	key     string
+	timeout time.Duration
+}
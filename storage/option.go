@@ -0,0 +1,17 @@
+package storage
+
+import (
+	"time"
+)
+
+func (f optionFunc) apply(r *Redis) {
+	f(r)
+}
+
+// WithTimeout is used to specify the timeout for each Redis operation.
+// The default is DefaultRedisTimeout
+func WithTimeout(v time.Duration) Option {
+	return optionFunc(func(r *Redis) {
+		r.timeout = v
+	})
+}
@@ -0,0 +1,129 @@
+// Package storage provides implementations of breaker.Storage, which
+// are used to persist the state of breakers across restarts.
+//
+//	cb := breaker.New(
+//		breaker.WithStorage(storage.NewFile("/var/lib/myapp/backend.json")),
+//	)
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/pkg/errors"
+)
+
+// NewFile creates a new File storage, which stores the breaker
+// state in the file at path
+func NewFile(path string) *File {
+	return &File{
+		path: path,
+	}
+}
+
+// Load reads the breaker state from the file. If the file does
+// not exist, nil is returned
+func (f *File) Load() (*breaker.StoredState, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	buf, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read breaker state")
+	}
+
+	var st breaker.StoredState
+	if err := json.Unmarshal(buf, &st); err != nil {
+		return nil, errors.Wrap(err, "failed to decode breaker state")
+	}
+	return &st, nil
+}
+
+// Save writes the breaker state to the file. The state is written
+// to a temporary file first, so that the file is never left half
+// written
+func (f *File) Save(st *breaker.StoredState) error {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode breaker state")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write breaker state")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write breaker state")
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return errors.Wrap(err, "failed to rename temporary file")
+	}
+	return nil
+}
+
+// NewRedis creates a new Redis storage, which stores the breaker
+// state under the given key
+func NewRedis(client RedisClient, key string, options ...Option) *Redis {
+	r := &Redis{
+		client:  client,
+		key:     key,
+		timeout: DefaultRedisTimeout,
+	}
+	for _, o := range options {
+		o.apply(r)
+	}
+	return r
+}
+
+// Load reads the breaker state from Redis. If the key does not
+// exist, nil is returned
+func (r *Redis) Load() (*breaker.StoredState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	v, err := r.client.Get(ctx, r.key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read breaker state")
+	}
+	if v == "" {
+		return nil, nil
+	}
+
+	var st breaker.StoredState
+	if err := json.Unmarshal([]byte(v), &st); err != nil {
+		return nil, errors.Wrap(err, "failed to decode breaker state")
+	}
+	return &st, nil
+}
+
+// Save writes the breaker state to Redis
+func (r *Redis) Save(st *breaker.StoredState) error {
+	buf, err := json.Marshal(st)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode breaker state")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	if err := r.client.Set(ctx, r.key, string(buf)); err != nil {
+		return errors.Wrap(err, "failed to write breaker state")
+	}
+	return nil
+}
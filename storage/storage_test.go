@@ -0,0 +1,109 @@
+package storage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lestrrat/go-circuit-breaker/breaker"
+	"github.com/lestrrat/go-circuit-breaker/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryRedis struct {
+	mutex  sync.Mutex
+	values map[string]string
+}
+
+func (r *memoryRedis) Get(_ context.Context, key string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.values[key], nil
+}
+
+func (r *memoryRedis) Set(_ context.Context, key, value string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.values[key] = value
+	return nil
+}
+
+// waitSaved waits until the breakers have saved a state with the
+// given tripped flag, as states are saved in the background
+func waitSaved(t *testing.T, s breaker.Storage, tripped bool) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		st, err := s.Load()
+		if err == nil && st != nil && st.Tripped == tripped {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return assert.Fail(t, "the state should have been saved")
+}
+
+func testRestore(t *testing.T, s breaker.Storage) {
+	st, err := s.Load()
+	if !assert.NoError(t, err, "Load should succeed") {
+		return
+	}
+	if !assert.Nil(t, st, "nothing should have been stored yet") {
+		return
+	}
+
+	cb := breaker.New(
+		breaker.WithStorage(s),
+		breaker.WithTripper(breaker.ThresholdTripper(1)),
+	)
+	cb.Call(breaker.CircuitFunc(func() error {
+		return os.ErrNotExist
+	}))
+	if !assert.True(t, cb.Tripped(), "breaker should be tripped") {
+		return
+	}
+	if !waitSaved(t, s, true) {
+		return
+	}
+
+	// A new breaker using the same storage should start out tripped
+	restored := breaker.New(breaker.WithStorage(s))
+	if !assert.True(t, restored.Tripped(), "restored breaker should be tripped") {
+		return
+	}
+	if !assert.Equal(t, int64(1), restored.Failures(), "restored breaker should have the failure count") {
+		return
+	}
+	if !assert.Equal(t, int64(1), restored.Snapshot().Trips, "restored breaker should have the trip count") {
+		return
+	}
+	if !assert.Equal(t, cb.Snapshot().LastTrip, restored.Snapshot().LastTrip, "restored breaker should have the last trip time") {
+		return
+	}
+
+	restored.Reset()
+	if !waitSaved(t, s, false) {
+		return
+	}
+	if !assert.False(t, breaker.New(breaker.WithStorage(s)).Tripped(), "reset should be persisted") {
+		return
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breaker-storage")
+	if !assert.NoError(t, err, "creating a temporary directory should succeed") {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	testRestore(t, storage.NewFile(filepath.Join(dir, "state.json")))
+}
+
+func TestRedis(t *testing.T) {
+	client := &memoryRedis{values: make(map[string]string)}
+	testRestore(t, storage.NewRedis(client, "breaker:example.com"))
+}